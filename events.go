@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"httpcli-contentlen-example/observe"
+)
+
+// logEvents prints every event delivered to o to w, one per line (-events).
+func logEvents(o *observe.Observer, w io.Writer) {
+	o.OnEvent(func(e observe.Event) {
+		fmt.Fprintf(w, "event %s %s\n", e.Time().Format("15:04:05.000000"), describeEvent(e))
+	})
+}
+
+func describeEvent(e observe.Event) string {
+	switch e := e.(type) {
+	case *observe.PatternStarted:
+		return fmt.Sprintf("PatternStarted pattern=%q run=%d", e.Pattern, e.Run)
+	case *observe.PatternFinished:
+		return fmt.Sprintf("PatternFinished pattern=%q run=%d duration=%v err=%v", e.Pattern, e.Run, e.Duration, e.Err)
+	case *observe.ConnAccepted:
+		return fmt.Sprintf("ConnAccepted remote=%s", e.RemoteAddr)
+	case *observe.HeaderParsed:
+		return fmt.Sprintf("HeaderParsed remote=%s %s %s headers=%d content-length=%d", e.RemoteAddr, e.Method, e.RequestURI, len(e.Header), e.ContentLength)
+	case *observe.ChunkReceived:
+		return fmt.Sprintf("ChunkReceived remote=%s size=%d total=%d", e.RemoteAddr, e.Size, e.Total)
+	case *observe.FaultInjected:
+		return fmt.Sprintf("FaultInjected remote=%s fault=%q", e.RemoteAddr, e.Fault)
+	case *observe.ResponseSent:
+		return fmt.Sprintf("ResponseSent remote=%s status=%d keep-alive=%v err=%v", e.RemoteAddr, e.StatusCode, e.KeepAlive, e.Err)
+	case *observe.FindingReported:
+		return fmt.Sprintf("FindingReported severity=%s message=%q", e.Severity, e.Message)
+	default:
+		return fmt.Sprintf("%T", e)
+	}
+}
+
+// severities of findings in observe.FindingReported, in increasing order
+var severities = [...]string{"info", "warn", "error"}
+
+// exit status when findings at or above the -fail-on severity were reported
+const exitFindings = 3
+
+func parseSeverity(s string) (int, error) {
+	for sev, name := range severities {
+		if s == name {
+			return sev, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown severity: %q (must be one of %s)", s, strings.Join(severities[:], ", "))
+}
+
+// findingCounter counts findings reported to an observer by severity, for -fail-on.
+type findingCounter struct {
+	mu sync.Mutex
+	n  [len(severities)]int
+}
+
+// countFindings returns a counter of the findings emitted to o from now on.
+func countFindings(o *observe.Observer) *findingCounter {
+	c := &findingCounter{}
+	o.OnEvent(func(e observe.Event) {
+		f, ok := e.(*observe.FindingReported)
+		if !ok {
+			return
+		}
+		sev, err := parseSeverity(f.Severity)
+		if err != nil {
+			return
+		}
+		c.mu.Lock()
+		c.n[sev]++
+		c.mu.Unlock()
+	})
+	return c
+}
+
+// errFindings is returned by run if findings at or above the -fail-on severity were reported, for main to exit with exitFindings.
+var errFindings = errors.New("failing on findings")
+
+// failure returns an error wrapping errFindings if findings at or above failOn were counted.
+func (c *findingCounter) failure(failOn int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var counts []string
+	total := 0
+	for sev := failOn; sev < len(severities); sev++ {
+		if n := c.n[sev]; n > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", n, severities[sev]))
+			total += n
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w at or above %s: %s", errFindings, severities[failOn], strings.Join(counts, ", "))
+}
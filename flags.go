@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"httpcli-contentlen-example/observation"
+)
+
+// headerFlag is a flag.Value collecting "Name: value" headers from repeated flags.
+type headerFlag http.Header
+
+func (h headerFlag) String() string {
+	var b strings.Builder
+	_ = http.Header(h).Write(&b)
+	return strings.TrimSpace(strings.ReplaceAll(b.String(), "\r\n", ", "))
+}
+
+func (h headerFlag) Set(s string) error {
+	name, value, ok := strings.Cut(s, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf(`header must be in the form "Name: value": %q`, s)
+	}
+	http.Header(h).Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	return nil
+}
+
+// parseFilePart parses a file part given by -f: a path, optionally followed by ";type=<media type>" as in curl -F.
+func parseFilePart(s string) (observation.MultipartPart, error) {
+	path, ct, _ := strings.Cut(s, ";type=")
+	if path == "" {
+		return observation.MultipartPart{}, fmt.Errorf("empty file name: %q", s)
+	}
+	return observation.MultipartPart{Name: "file", Path: path, ContentType: ct}, nil
+}
+
+// parseFormField parses a form field given by -form: "key=value".
+func parseFormField(s string) (observation.MultipartPart, error) {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || key == "" {
+		return observation.MultipartPart{}, fmt.Errorf("form field must be key=value: %q", s)
+	}
+	return observation.MultipartPart{Name: key, Value: value}, nil
+}
+
+// parseListenIP parses the value of -addr: an IP address, optionally in brackets (e.g. [::1]).
+func parseListenIP(s string) (net.IP, error) {
+	ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+	if ip == nil {
+		return nil, fmt.Errorf("not an IP address: %q", s)
+	}
+	return ip, nil
+}
+
+const unixListenPrefix = "unix:"
+
+// parseListenAddr parses the value of -listen, returning the path of the Unix domain socket.
+func parseListenAddr(s string) (string, error) {
+	path := strings.TrimPrefix(s, unixListenPrefix)
+	if path == s || path == "" {
+		return "", fmt.Errorf(`must be in the form "unix:<path>" (use -port to listen on TCP): %q`, s)
+	}
+	return path, nil
+}
+
+func serverBehaviorUsage() string {
+	var b strings.Builder
+	b.WriteString("server behavior (empty: log first -capture-bytes of the connection, then disconnect)")
+	for _, name := range observation.ServerBehaviors() {
+		fmt.Fprintf(&b, "\n  %s: %s", name, observation.DescribeServerBehavior(name))
+	}
+	return b.String()
+}
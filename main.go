@@ -1,235 +1,494 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
-	"mime/multipart"
 	"net"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
-)
-
-type reqPattern int
 
-const (
-	reqSinglePartWithLen reqPattern = iota + 1
-	reqSinglePartWithoutLen
-	reqSinglePartWithLen_wrong
-	reqSinglePartWithBuffer
-	reqSinglePartExplicitlyChunked
-	reqMultipart
-	reqPatternBound // sentinel value, invalid by itself
+	"httpcli-contentlen-example/observation"
+	"httpcli-contentlen-example/observe"
 )
 
-func (p reqPattern) String() string {
-	switch p {
-	case reqSinglePartWithLen:
-		return "single-part with Content-Length"
-	case reqSinglePartWithoutLen:
-		return "single-part without Content-Length"
-	case reqSinglePartWithLen_wrong:
-		return "single-part with wrong Content-Length (setting the header directly)"
-	case reqSinglePartWithBuffer:
-		return "single-part without Content-Length, using *byets.Buffer"
-	case reqSinglePartExplicitlyChunked:
-		return "single-part using *bytes.Buffer, setting 'Transfer-Encding: chunked' explicitly"
-	case reqMultipart:
-		return "multipart"
-	default:
-		return ""
-	}
-}
+// Packages contributing patterns with observation.RegisterPattern are linked in here by blank imports, e.g.
+//
+//	import _ "example.com/yourmodule/patterns"
 
-func (p reqPattern) NeedsLen() bool {
-	return p == reqSinglePartWithLen || p == reqSinglePartWithLen_wrong
+func main() {
+	if len(os.Args) > 1 {
+		var cmd func([]string) error
+		switch os.Args[1] {
+		case "patterns":
+			cmd = runPatternsCommand
+		case "compare":
+			cmd = runCompareCommand
+		case "diff":
+			cmd = runDiffCommand
+		}
+		if cmd != nil {
+			if err := cmd(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+	if err := run(); err != nil {
+		if errors.Is(err, errFindings) {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitFindings)
+		}
+		log.Fatal(err)
+	}
 }
 
-const serverPort = 8080
-
-var serverURL = fmt.Sprintf("http://localhost:%d", serverPort)
-
-func main() {
+// run runs the CLI without a subcommand, returning once deferred files are closed, so that main exits only after they're written.
+func run() (err error) {
 	var (
-		filename string
+		filename     string
+		parts        []observation.MultipartPart
+		behavior     string
+		trackClose   bool
+		npipe        string
+		unixSock     string
+		serverIP     = net.IPv4(127, 0, 0, 1)
+		serverPort   int
+		addrSet      bool
+		pcapFile     string
+		harFile      string
+		repeat       int
+		saveStats    string
+		compareStats string
+		redactNames  string
+		microSweep   bool
+		logWrites    bool
+		eyeballs     bool
+		boundary     string
+		viaRevProxy  bool
+		viaProxy     bool
+		obsFile      string
+		printSchema  bool
+		bodyDelay    time.Duration
+		connectHd    = make(headerFlag)
+		fanOut       int
+		trackLeaks   bool
+		explain      string
+		maxRequests  int
+		maxDuration  time.Duration
+		hdrStages    bool
+		mpPerf       bool
+		jsonPerf     bool
+		rcvBuf       int
+		readSize     int
+		readInterval time.Duration
+		logEvt       bool
+		cmpCurl      bool
+		curlCmds     bool
+		failOn       string
+		stuckAfter   time.Duration
+		memBudget    int64
+		timeBudget   time.Duration
+		captureMem   int64
+		captureDir   string
+		reproDir     string
+		dualCapture  bool
+		canned       []byte
+		hexOut       bool
+		hdrSizes     bool
+		jsonOut      bool
+		http2        bool
+		h2c          bool
+		tlsOn        bool
+		lifecycle    bool
+		tlsCert      string
+		tlsKey       string
+		sweep        string
+		sweepSteps   int
+		sweepPattern string
+		patternList  string
+		scenarioFile string
+		listPats     bool
+		sweepSVGFile string
+		captureBytes int64 // the default of the observation package if zero
 	)
 
-	flag.StringVar(&filename, "f", "", "file name")
+	flag.Func("f", "file to upload (default photo.jpg). Repeatable: files after the first one are added as parts of multipart patterns. Append ;type=<media type> to set the Content-Type of the part", func(s string) error {
+		p, err := parseFilePart(s)
+		if err != nil {
+			return err
+		}
+		if filename == "" {
+			filename, p.Body = p.Path, true
+		}
+		parts = append(parts, p)
+		return nil
+	})
+	flag.Func("form", "form field key=value added as a part of multipart patterns, ordered among -f as given, and to the form of urlencoded-form. Repeatable", func(s string) error {
+		p, err := parseFormField(s)
+		if err != nil {
+			return err
+		}
+		parts = append(parts, p)
+		return nil
+	})
+	flag.StringVar(&scenarioFile, "scenario", "", "file of requests described in a subset of TOML, added as patterns after the built-in ones (see scenarios/examples.toml)")
+	flag.StringVar(&patternList, "pattern", "", "comma-separated names or IDs of the patterns to run, in the order given (default: all patterns; see -list)")
+	flag.BoolVar(&listPats, "list", false, "print the ID, name and description of each pattern and exit")
+	flag.StringVar(&behavior, "server", "", serverBehaviorUsage())
+	flag.StringVar(&npipe, "npipe", "", `listen on the Windows named pipe (e.g. \\.\pipe\observation, or just "observation") instead of TCP`)
+	flag.Func("listen", `listen on the Unix domain socket given as unix:<path> instead of TCP, sending requests over it as clients of e.g. the Docker API do`, func(s string) (err error) {
+		unixSock, err = parseListenAddr(s)
+		return err
+	})
+	flag.BoolVar(&trackClose, "track-close", false, "track when Request.Body is read to the end and closed, relative to wire events")
+	flag.IntVar(&repeat, "repeat", 1, "number of times to run each pattern. Requests are dumped only on the first run, and timing statistics are reported if more than 1")
+	flag.StringVar(&saveStats, "save-stats", "", "save timing samples of this run to the file, for later comparison")
+	flag.StringVar(&compareStats, "compare-stats", "", "compare timing samples of this run against ones saved by -save-stats, flagging statistically significant differences")
+	flag.BoolVar(&eyeballs, "happy-eyeballs", false, "instead of running patterns, observe Happy Eyeballs fallback from a black-holed IPv6 address to IPv4 with several Dialer.FallbackDelay settings")
+	flag.BoolVar(&hdrStages, "header-stages", false, "diff request headers as built, as passed to the Transport and as written on the wire, attributing changes to http.Client or the Transport")
+	flag.BoolVar(&logWrites, "log-writes", false, "log every Write call on the client connection and summarize write sizes")
+	flag.StringVar(&boundary, "boundary", "", "fixed multipart boundary, making captures of multipart requests byte-stable across runs (random if empty)")
+	flag.DurationVar(&bodyDelay, "body-delay", 0, "hold back the request body until this long after the Transport writes headers, to observe header timeouts and eager forwarding of headers (e.g. with -server header-timeout)")
+	flag.Var(connectHd, "proxy-connect-header", `header sent to the proxy in CONNECT via Transport.ProxyConnectHeader, in the form "Name: value" (repeatable). Defaults to a Basic Proxy-Authorization`)
+	flag.IntVar(&fanOut, "fan-out", 0, "send each pattern to this many capture servers concurrently, reporting per-target captures and timings in one correlated report")
+	flag.BoolVar(&trackLeaks, "track-leaks", false, "report goroutines (by creator) and file descriptors (by kind) left behind by each pattern, and summarize them at the end")
+	flag.BoolVar(&viaProxy, "via-proxy", false, "send requests through a capturing forward proxy set as Transport.Proxy with credentials, showing the absolute-form request line or the CONNECT tunnel (with -tls) the client sends to it")
+	flag.BoolVar(&viaRevProxy, "via-reverse-proxy", false, "send requests through httputil.ReverseProxy in front of the capture server, and diff the client request against the forwarded one")
+	flag.BoolVar(&http2, "http2", false, "send patterns built by request() over HTTP/2 with TLS, reporting the frames they arrive in and the header fields decoded from HPACK")
+	flag.BoolVar(&h2c, "h2c", false, "like -http2, but over cleartext HTTP/2 with prior knowledge (h2c) instead of TLS; needs a binary built with Go 1.24 or later")
+	flag.BoolVar(&lifecycle, "lifecycle", false, "print httptrace timestamps of each request sent by the client: GetConn, DNS, connect, TLS, WroteHeaders, Wait100Continue, WroteRequest and the first response byte")
+	flag.Func("addr", "IP address the capture server listens on, e.g. ::1 or :: (dual-stack); also reports the connect attempts of each request and the address family carrying it (default 127.0.0.1)", func(s string) (err error) {
+		serverIP, err = parseListenIP(s)
+		addrSet = err == nil
+		return err
+	})
+	flag.IntVar(&serverPort, "port", 0, "port the capture server listens on (default: an ephemeral port)")
+	flag.BoolVar(&tlsOn, "tls", false, "terminate TLS at the capture server and send requests to https://, capturing the plaintext inside TLS (HTTP/1.1 only is offered with ALPN)")
+	flag.StringVar(&tlsCert, "tls-cert", "", "PEM certificate of the capture server with -tls (default: a generated self-signed certificate for localhost)")
+	flag.StringVar(&tlsKey, "tls-key", "", "PEM private key of the certificate given to -tls-cert")
+	flag.BoolVar(&hexOut, "hex", false, "print bodies of captured requests in hexdump -C style (offset, hex bytes and ASCII gutter), so that binary bodies are readable")
+	flag.BoolVar(&hdrSizes, "header-sizes", false, "after the dump of each captured request, rank its headers by on-wire size (CRLF included) with cumulative totals")
+	flag.StringVar(&redactNames, "redact", "", "comma-separated names of headers whose values are masked in all outputs, preserving lengths (e.g. Authorization,Cookie)")
+	flag.StringVar(&obsFile, "observations", "", "write a machine-readable record of each captured request to the file as JSON Lines, validated against the schema printed by -print-schema")
+	flag.StringVar(&harFile, "har", "", "write each captured request, and the response the client received with -server canned, to the file as a HAR 1.2 entry, for browser devtools and HAR tooling")
+	flag.StringVar(&pcapFile, "pcap", "", "write bytes read and written on connections of the capture server to the file as pcap with synthetic TCP/IP framing, for Wireshark (ciphertext with -tls)")
+	flag.BoolVar(&jsonOut, "json", false, "print a machine-readable record of each captured request (as written by -observations, plus timing) to the standard output as JSON Lines, instead of the human-readable output")
+	flag.BoolVar(&printSchema, "print-schema", false, "print the JSON Schema of records written by -observations and exit")
+	flag.StringVar(&explain, "explain", "", `instead of running patterns, analyze requests: "net" waits for ones sent by a program using the observe package, "-" reads a raw HTTP/1.1 request from stdin`)
+	flag.IntVar(&maxRequests, "max-requests", 1, "with -explain net, exit after analyzing this many requests (0: unlimited); rejected in other modes")
+	flag.DurationVar(&maxDuration, "max-duration", 0, "with -explain net, exit after this long even if fewer requests than -max-requests arrived (0: unlimited); rejected in other modes")
+	flag.BoolVar(&mpPerf, "multipart-perf", false, "instead of running patterns, upload the file as buffered and as io.Pipe-streamed multipart, comparing peak memory, time to first byte, duration and framing (use a large file with -f)")
+	flag.BoolVar(&jsonPerf, "json-perf", false, "instead of running patterns, upload the file embedded in JSON, marshaled into bytes.Buffer and encoded into io.Pipe, comparing peak memory, time to first byte, duration and framing")
+	flag.IntVar(&rcvBuf, "server-rcvbuf", 0, "socket receive buffer size (SO_RCVBUF) of connections accepted by the capture server with -server (0: OS default)")
+	flag.IntVar(&readSize, "server-read-size", 0, "maximum bytes the capture server with -server reads per Read call (0: unlimited)")
+	flag.DurationVar(&readInterval, "server-read-interval", 0, "pause of the capture server with -server before each Read call, back-pressuring the client (see -log-writes)")
+	flag.BoolVar(&curlCmds, "curl", false, "print the closest equivalent curl command of each pattern sending to the capture server, noting what curl can't express (see -compare-curl to run and diff them)")
+	flag.BoolVar(&cmpCurl, "compare-curl", false, "instead of running patterns, send patterns having a curl equivalent with both Go and curl, diffing curl's request against Go's (needs curl in PATH)")
+	flag.StringVar(&failOn, "fail-on", "", fmt.Sprintf("exit with status %d if findings of this severity or higher (info, warn or error) are reported, for use as a gating check", exitFindings))
+	flag.DurationVar(&stuckAfter, "stuck-after", 2*time.Second, "time after which a request whose body reader is stuck is reported as not rescued by any timeout, and canceled")
+	flag.Int64Var(&memBudget, "mem-budget", 0, "bytes the heap may grow by while running each pattern, also set as the GC's soft memory limit; overruns are reported as error findings (0: unlimited)")
+	flag.DurationVar(&timeBudget, "time-budget", 0, "time each pattern may take over all its runs; overruns are reported as warn findings (0: unlimited)")
+	flag.Int64Var(&captureMem, "capture-mem", observation.DefaultCaptureMem, "bytes of each request the capture server with -server keeps in memory; larger requests are streamed whole to a file in -capture-dir")
+	flag.Func("capture-bytes", `bytes of each connection the default server reads and logs, and of each request servers with -server log, with binary size units (e.g. 4KiB), or "all" to have the default server read and log whole requests and reply 200 (default 1KiB)`, func(s string) (err error) {
+		captureBytes, err = observation.ParseCaptureBytes(s)
+		return err
+	})
+	flag.StringVar(&captureDir, "capture-dir", "", "directory for files of requests larger than -capture-mem, which are left there (default: the system temporary directory)")
+	flag.StringVar(&reproDir, "repro-dir", "", "for each pattern with warn or error findings, write a golang/go bug report template with the wire evidence, and a program reproducing the request if possible, into a directory under this one")
+	flag.BoolVar(&dualCapture, "dual-capture", false, "record bytes the client wrote to the connection alongside the ones the capture server read, and byte-diff the two, reporting the offset of any divergence")
+	flag.StringVar(&sweep, "sweep", "", fmt.Sprintf("instead of running patterns, run -sweep-pattern across a parameter range given as name=from..to with binary size units (e.g. body-size=1KiB..100MiB), charting latency, throughput and wire overhead. Parameters: %s", strings.Join(observation.SweepParams(), ", ")))
+	flag.IntVar(&sweepSteps, "sweep-steps", 8, "number of parameter values of -sweep, spaced evenly on a log scale")
+	flag.StringVar(&sweepPattern, "sweep-pattern", "with-len", "name or ID of the pattern run by -sweep (see -list); must be one built by request()")
+	flag.StringVar(&sweepSVGFile, "sweep-svg", "", "also write the charts of -sweep to this file as SVG")
+	flag.BoolVar(&logEvt, "events", false, "log lifecycle events (patterns started and finished, connections accepted, headers parsed, body chunks received, faults injected, responses sent) to stderr")
+	flag.Func("response", "file of a raw HTTP response the capture server replies with byte-for-byte, implying -server canned (default: a gzip-encoded chunked response with a trailer)", func(path string) (err error) {
+		canned, err = observation.LoadCannedResponse(path)
+		return err
+	})
+	flag.BoolVar(&microSweep, "micro-sweep", false, "instead of running patterns, send 0, 1 and 2 byte bodies with each framing option and report framing and connection reuse")
 	flag.Parse()
 
+	var obs *observe.Observer
+	if failOn != "" || logEvt {
+		obs = &observe.Observer{}
+	}
+	if failOn != "" {
+		var sev int
+		if sev, err = parseSeverity(failOn); err != nil {
+			return fmt.Errorf("invalid -fail-on: %w", err)
+		}
+		// deferred first, so that the findings are checked after the other deferred cleanups
+		counter := countFindings(obs)
+		defer func() {
+			if err == nil {
+				err = counter.failure(sev)
+			}
+		}()
+	}
+	if logEvt {
+		logEvents(obs, os.Stderr)
+	}
+
+	var scenarioFiles []string
+	if scenarioFile != "" {
+		scenarioFiles = append(scenarioFiles, scenarioFile)
+	}
+	if listPats {
+		return observation.ListPatterns(os.Stdout, scenarioFiles...)
+	}
+	if printSchema {
+		_, _ = os.Stdout.Write(observation.ObservationSchema())
+		return nil
+	}
 	if filename == "" {
 		filename = "photo.jpg"
 	}
-
-	l, err := startServer()
-	if err != nil {
-		log.Fatal(err)
+	if canned != nil {
+		if behavior != "" && behavior != "canned" {
+			return fmt.Errorf("-response can't be used with -server %s", behavior)
+		}
+		behavior = "canned"
 	}
-
-	for p := reqSinglePartWithLen; p < reqPatternBound; p++ {
-		go serve(l)
-		time.Sleep(100 * time.Millisecond)
-
-		if err := request(p, filename); err != nil {
-			msg := err.Error()
-			if !strings.Contains(msg, "connection reset by peer") {
-				log.Fatal(err)
+	if explain != "net" {
+		// the other modes run patterns and return, so there's no server to stop
+		var limits []string
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "max-requests" || f.Name == "max-duration" {
+				limits = append(limits, "-"+f.Name)
 			}
+		})
+		if len(limits) > 0 {
+			return fmt.Errorf("%s can only be used with -explain net, the only mode serving requests until stopped", strings.Join(limits, " and "))
 		}
-		fmt.Println()
-		fmt.Println("------")
-		fmt.Println()
+	} else if maxRequests == 0 && maxDuration == 0 {
+		log.Print("neither -max-requests nor -max-duration is set; analyzing requests until interrupted")
 	}
-}
-
-func request(pat reqPattern, filename string) error {
-	fmt.Printf("Request pattern: %v\n\n", pat)
-
-	f, err := os.Open(filename)
-	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
+	var patterns []string
+	if patternList != "" {
+		patterns = strings.Split(patternList, ",")
 	}
-
-	var size int
-	if pat.NeedsLen() {
-		stat, err := os.Stat(filename)
-		if err != nil {
-			return fmt.Errorf("failed to stat file: %w", err)
-		}
-		size = int(stat.Size())
-	}
-
-	var req *http.Request
-	switch pat {
-	case reqSinglePartWithLen:
-		req, err = singlepartWithLen(f, size)
-	case reqSinglePartWithLen_wrong:
-		req, err = singlepartWithLen_wrong(f, size)
-	case reqSinglePartWithoutLen:
-		req, err = singlepartWithoutLen(f)
-	case reqSinglePartWithBuffer:
-		req, err = singlepartWithBuffer(f)
-	case reqSinglePartExplicitlyChunked:
-		req, err = singlepartExplicitlyChunked(f)
-	case reqMultipart:
-		req, err = multipartReq(f, filename)
-	}
-	if err != nil {
-		return err
+	var baseStats *observation.Stats
+	if compareStats != "" {
+		if baseStats, err = observation.LoadStats(compareStats); err != nil {
+			return err
+		}
 	}
 
-	return sendReq(req)
-}
-
-func sendReq(req *http.Request) error {
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("HTTP request failed: %w", err)
+	opts := []observation.Option{
+		observation.WithRedactedHeaders(strings.Split(redactNames, ",")...),
+		observation.WithScenarioFiles(scenarioFiles...),
+		observation.WithPatterns(patterns...),
+		observation.WithBodySource(filename),
+		observation.WithServerBehavior(behavior),
+		observation.WithCaptureLimits(captureMem, captureDir),
+		observation.WithReadThrottle(rcvBuf, readSize, readInterval),
+		observation.WithRepeat(repeat),
+		observation.WithCloseTracking(trackClose),
+		observation.WithWriteLogging(logWrites),
+		observation.WithHeaderStages(hdrStages),
+		observation.WithBoundary(boundary),
+		observation.WithMultipartParts(parts),
+		observation.WithBodyDelay(bodyDelay),
+		observation.WithStuckAfter(stuckAfter),
+		observation.WithProxyConnectHeader(http.Header(connectHd)),
+		observation.WithFanOut(fanOut),
+		observation.WithReverseProxy(viaRevProxy),
+		observation.WithForwardProxy(viaProxy),
+		observation.WithLeakTracking(trackLeaks),
+		observation.WithBudget(memBudget, timeBudget),
+		observation.WithCurlCommands(curlCmds),
+		observation.WithReproDir(reproDir),
+		observation.WithDualCapture(dualCapture),
+		observation.WithLifecycleTrace(lifecycle),
+		observation.WithDialTrace(addrSet),
+		observation.WithHexDump(hexOut),
+		observation.WithHeaderSizes(hdrSizes),
+		observation.WithHTTP2(http2),
+		observation.WithH2C(h2c),
+		observation.WithObserver(obs),
+	}
+	if captureBytes != 0 {
+		opts = append(opts, observation.WithCaptureBytes(captureBytes))
+	}
+	if canned != nil {
+		opts = append(opts, observation.WithCannedResponse(canned))
+	}
+	switch {
+	case npipe != "":
+		opts = append(opts, observation.WithNamedPipe(npipe))
+	case unixSock != "":
+		opts = append(opts, observation.WithUnixSocket(unixSock))
+	}
+	if addrSet || serverPort != 0 {
+		opts = append(opts, observation.WithListenAddr(serverIP, serverPort))
+	}
+	if tlsOn {
+		opts = append(opts, observation.WithTLS(tlsCert, tlsKey))
+	}
+	if obsFile != "" && jsonOut {
+		return errors.New("-observations and -json can't be used together")
+	}
+	if obsFile != "" {
+		var f *os.File
+		if f, err = os.Create(obsFile); err != nil {
+			return fmt.Errorf("failed to create observations file: %w", err)
+		}
+		defer closeFile(f, &err)
+		opts = append(opts, observation.WithObservations(f))
+	}
+	if jsonOut {
+		// keep the standard output for the records
+		opts = append(opts, observation.WithObservations(os.Stdout), observation.WithOutput(io.Discard))
+	}
+	if harFile != "" {
+		var f *os.File
+		if f, err = os.Create(harFile); err != nil {
+			return fmt.Errorf("failed to create HAR file: %w", err)
+		}
+		defer closeFile(f, &err)
+		opts = append(opts, observation.WithHAR(f))
+	}
+	if pcapFile != "" {
+		var f *os.File
+		if f, err = os.Create(pcapFile); err != nil {
+			return fmt.Errorf("failed to create pcap file: %w", err)
+		}
+		defer closeFile(f, &err)
+		opts = append(opts, observation.WithPcap(f))
 	}
-	defer func() {
-		_, _ = io.Copy(io.Discard, resp.Body)
-		_ = resp.Body.Close()
+
+	// abort the pattern run in progress on the first interrupt, and restore the default on the next one
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
 	}()
-	return nil
-}
 
-// single-part PUT request, setting ContentLength field explicitly
-func singlepartWithLen(body io.Reader, len int) (*http.Request, error) {
-	req, err := http.NewRequest(http.MethodPut, serverURL, body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	switch {
+	case mpPerf:
+		err = observation.UploadPerf(ctx, "multipart", opts...)
+	case cmpCurl:
+		err = observation.CompareCurl(ctx, opts...)
+	case jsonPerf:
+		err = observation.UploadPerf(ctx, "json", opts...)
+	case sweep != "":
+		err = observation.Sweep(ctx, sweep, sweepSteps, sweepPattern, sweepSVGFile, opts...)
+	case eyeballs:
+		err = observation.HappyEyeballs(ctx, opts...)
+	case explain == "net":
+		err = observation.ExplainServer(ctx, maxRequests, maxDuration, opts...)
+	case explain == "-":
+		err = observation.ExplainRequest(ctx, os.Stdin, opts...)
+	case explain != "":
+		err = fmt.Errorf(`unknown -explain source: %q (must be "net" or "-")`, explain)
+	case microSweep:
+		err = observation.MicroSweep(ctx, opts...)
+	default:
+		var report *observation.Report
+		report, err = observation.Run(ctx, opts...)
+		if harFile != "" && report != nil {
+			warnOmittedFromHAR(report)
+		}
+		if errors.Is(err, context.Canceled) {
+			log.Printf("interrupted after %d patterns", len(report.Patterns))
+			err = nil
+		} else if err != nil {
+			break
+		}
+		if saveStats != "" {
+			if err := report.SaveStats(saveStats); err != nil {
+				return err
+			}
+		}
+		if baseStats != nil {
+			report.CompareStats(os.Stdout, baseStats)
+		}
 	}
-	req.ContentLength = int64(len)
-	return req, nil
+	return err
 }
 
-// single-part PUT request, setting Content-Length header directly (and incorrectly)
-func singlepartWithLen_wrong(body io.Reader, len int) (*http.Request, error) {
-	req, err := http.NewRequest(http.MethodPut, serverURL, body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+// closeFile closes f, setting *err to the error closing it unless *err is already set, for deferring.
+func closeFile(f *os.File, err *error) {
+	if cerr := f.Close(); *err == nil && cerr != nil {
+		*err = cerr
 	}
-	req.Header.Set("Content-Length", strconv.Itoa(len))
-	return req, nil
 }
 
-// single-part PUT request, without setting ContentLength field
-func singlepartWithoutLen(body io.Reader) (*http.Request, error) {
-	req, err := http.NewRequest(http.MethodPut, serverURL, body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+// warnOmittedFromHAR warns of patterns without HAR entries, as their requests didn't reach the capture server.
+func warnOmittedFromHAR(report *observation.Report) {
+	var omitted []string
+	for _, pr := range report.Patterns {
+		if !pr.Captured {
+			omitted = append(omitted, pr.Pattern)
+		}
 	}
-	return req, nil
-}
-
-// single-part PUT request. Copy data to bytes.Buffer first, then send it without setting ContentLength field
-func singlepartWithBuffer(body io.Reader) (*http.Request, error) {
-	buf := new(bytes.Buffer)
-	_, _ = io.Copy(buf, body)
-
-	req, err := http.NewRequest(http.MethodPut, serverURL, buf)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	if len(omitted) > 0 {
+		log.Printf("warning: the HAR file has no entries of %d patterns whose requests didn't reach the capture server (listed in its comment too):\n  %s",
+			len(omitted), strings.Join(omitted, "\n  "))
 	}
-	return req, nil
 }
 
-// single-part PUT request. Copy data to bytes.Buffer first, then send it with setting "Transfer-Encoding: chunked" explicitly
-func singlepartExplicitlyChunked(body io.Reader) (*http.Request, error) {
-	buf := new(bytes.Buffer)
-	_, _ = io.Copy(buf, body)
-
-	req, err := http.NewRequest(http.MethodPut, serverURL, buf)
-	req.TransferEncoding = []string{"chunked"}
-	if err != nil {
-		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+// runPatternsCommand runs "patterns" subcommands.
+func runPatternsCommand(args []string) error {
+	if len(args) == 0 || args[0] != "describe" {
+		return fmt.Errorf("usage: patterns describe [-format text|json|markdown]")
 	}
-	return req, nil
+	fs := flag.NewFlagSet("patterns describe", flag.ExitOnError)
+	format := fs.String("format", "text", "output format: text, json or markdown")
+	_ = fs.Parse(args[1:])
+	return observation.DescribePatterns(os.Stdout, *format)
 }
 
-// multipart request
-func multipartReq(body io.Reader, filename string) (*http.Request, error) {
-	var buf bytes.Buffer
-	mw := multipart.NewWriter(&buf)
-
-	w, err := mw.CreateFormFile("file", filename)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create new part: %w", err)
+// runCompareCommand runs the "compare" subcommand: the observation suite under two Go toolchains, diffing captured requests per pattern.
+func runCompareCommand(args []string) error {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	patternList := fs.String("pattern", "", "comma-separated names or IDs of the patterns to compare (default: all patterns)")
+	filename := fs.String("f", "photo.jpg", "file to upload")
+	redactNames := fs.String("redact", "", "comma-separated names of headers whose values are masked in the diffs, preserving lengths (e.g. Authorization,Cookie)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: compare [-pattern names] [-f file] [-redact names] <Go version> <Go version>")
+		fmt.Fprintln(fs.Output(), `Go versions are values of GOTOOLCHAIN, such as 1.22.0, go1.23.4 or "local"`)
+		fs.PrintDefaults()
 	}
-	_, _ = io.Copy(w, body)
-	_ = mw.Close()
-
-	fmt.Println(buf.Len())
-	req, err := http.NewRequest(http.MethodPost, serverURL, &buf)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	_ = fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("two Go versions are needed, got %d", fs.NArg())
 	}
-
-	return req, nil
-}
-
-/* server */
-func startServer() (*net.TCPListener, error) {
-	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: serverPort})
-	if err != nil {
-		return nil, fmt.Errorf("failed to start listening: %v", err)
+	var patterns []string
+	if *patternList != "" {
+		patterns = strings.Split(*patternList, ",")
 	}
-	return l, nil
+	return observation.CompareToolchains(context.Background(), fs.Arg(0), fs.Arg(1),
+		observation.WithPatterns(patterns...),
+		observation.WithBodySource(*filename),
+		observation.WithRedactedHeaders(strings.Split(*redactNames, ",")...))
 }
 
-func serve(l net.Listener) {
-	conn, err := l.Accept()
-	if err != nil {
-		log.Fatal(err)
+// runDiffCommand runs the "diff" subcommand: two patterns against the in-process capture server, printing a unified diff
+// of their normalized captures.
+func runDiffCommand(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	filename := fs.String("f", "photo.jpg", "file to upload")
+	redactNames := fs.String("redact", "", "comma-separated names of headers whose values are masked in the captures before diffing, preserving lengths (e.g. Authorization,Cookie)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: diff [-f file] [-redact names] <pattern> <pattern>")
+		fs.PrintDefaults()
 	}
-
-	// read and log first 1KiB, then disconnect
-	_, _ = io.CopyN(os.Stdout, conn, 1024)
-	fmt.Println()
-	conn.Close()
+	_ = fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("diff takes 2 patterns, got %d", fs.NArg())
+	}
+	return observation.DiffPatterns(context.Background(), fs.Arg(0), fs.Arg(1),
+		observation.WithBodySource(*filename),
+		observation.WithRedactedHeaders(strings.Split(*redactNames, ",")...))
 }
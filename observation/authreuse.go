@@ -0,0 +1,131 @@
+package observation
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"sync"
+)
+
+// connAuthLog records, on the server side, which connection carried each request and the Authorization it had.
+type connAuthLog struct {
+	mu      sync.Mutex
+	conns   int
+	entries []connAuthEntry
+}
+
+type connAuthEntry struct {
+	conn int // 1-based index of the connection in accept order
+	auth string
+}
+
+// connAuthBehavior replies like keepAliveBehavior, logging Authorization of each request along with its connection.
+type connAuthBehavior struct {
+	keepAliveBehavior
+	conn int
+	log  *connAuthLog
+}
+
+func (b *connAuthBehavior) OnHeaders(req *http.Request) error {
+	b.log.mu.Lock()
+	defer b.log.mu.Unlock()
+	b.log.entries = append(b.log.entries, connAuthEntry{conn: b.conn, auth: req.Header.Get("Authorization")})
+	return nil
+}
+
+// observeAuthChange sends uploads with different Authorization (different users, schemes and none at all) in a row on one Transport,
+// and reports from both ends that the pooled connection is reused regardless of the credentials.
+// Authentication bound to connections (e.g. NTLM, or proxies caching the identity per connection) would apply the first identity to all of them.
+func observeAuthChange(opts runOptions) (*timing, error) {
+	data, err := os.ReadFile(opts.filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	authLog := &connAuthLog{}
+	url, stop, err := opts.startEphemeralServer(func() ServerBehavior {
+		authLog.mu.Lock()
+		defer authLog.mu.Unlock()
+		authLog.conns++
+		return &connAuthBehavior{conn: authLog.conns, log: authLog}
+	}, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	defer tr.CloseIdleConnections()
+
+	steps := []struct {
+		desc string
+		set  func(req *http.Request)
+	}{
+		{"Bearer token of alice", func(req *http.Request) { req.Header.Set("Authorization", "Bearer alice-token") }},
+		{"Bearer token of bob", func(req *http.Request) { req.Header.Set("Authorization", "Bearer bob-token") }},
+		{"Basic auth of carol", func(req *http.Request) { req.SetBasicAuth("carol", "secret") }},
+		{"no Authorization", func(*http.Request) {}},
+	}
+
+	var (
+		first     *timing
+		clients   []string
+		notReused int // requests after the first that didn't reuse the pooled connection
+	)
+	for _, step := range steps {
+		req, err := http.NewRequestWithContext(opts.ctx, http.MethodPut, url, bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+		step.set(req)
+
+		var (
+			client string
+			reused bool
+		)
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				client = fmt.Sprintf("%v (reused: %v)", info.Conn.LocalAddr(), info.Reused)
+				reused = info.Reused
+			},
+		}
+		req, tm := traceTiming(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+		err = opts.sendReq(tr, req)
+		tm.finish()
+		if err != nil {
+			return nil, err
+		}
+		if first == nil {
+			first = tm
+		} else if !reused {
+			notReused++
+		}
+		clients = append(clients, client)
+	}
+	if opts.quiet {
+		return first, nil
+	}
+
+	authLog.mu.Lock()
+	defer authLog.mu.Unlock()
+	for i, step := range steps {
+		fmt.Fprintf(opts.out, "[request %d] %s\n", i+1, step.desc)
+		fmt.Fprintf(opts.out, "  client connection: %s\n", clients[i])
+		if i < len(authLog.entries) {
+			e := authLog.entries[i]
+			auth := "(none)"
+			if e.auth != "" {
+				auth = opts.redactValue("Authorization", e.auth)
+			}
+			fmt.Fprintf(opts.out, "  server: connection #%d, Authorization: %s\n", e.conn, auth)
+		}
+	}
+	fmt.Fprintf(opts.out, "%d requests with different Authorization (including none) carried by %d connection(s)\n", len(steps), authLog.conns)
+	if notReused > 0 {
+		// e.g. the Transport was closed between requests, so nothing was observed about credentials on a pooled connection
+		fmt.Fprintln(opts.out, "=> "+opts.finding(sevError, fmt.Sprintf("%d of the %d requests after the first didn't reuse the pooled connection, so this run doesn't show credentials changing on it", notReused, len(steps)-1)))
+	}
+	return first, nil
+}
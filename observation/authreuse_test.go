@@ -0,0 +1,27 @@
+package observation
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestAuthChangeReusesConnection checks that the requests of auth-change after the first are carried by the pooled connection,
+// which is what the pattern shows.
+func TestAuthChangeReusesConnection(t *testing.T) {
+	var out bytes.Buffer
+	s := newSession(&out)
+	opts := runOptions{session: s, filename: writeTestFile(t, "body", "0123456789abcdef")}
+	if _, err := observeAuthChange(opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range s.findingsSince(0, sevWarn) {
+		t.Errorf("unexpected finding: %v", f)
+	}
+	if got := strings.Count(out.String(), "(reused: true)"); got != 3 {
+		t.Errorf("got %d requests on a reused connection, want 3:\n%s", got, out.String())
+	}
+	if !strings.Contains(out.String(), "carried by 1 connection(s)") {
+		t.Errorf("requests weren't carried by one connection:\n%s", out.String())
+	}
+}
@@ -0,0 +1,107 @@
+package observation
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// time allowed for request headers to arrive by headerTimeoutBehavior, like http.Server.ReadHeaderTimeout
+const headerTimeout = time.Second
+
+func init() {
+	RegisterServerBehavior("header-timeout", fmt.Sprintf("close the connection if request headers don't arrive within %v of accepting it, like http.Server.ReadHeaderTimeout", headerTimeout), func() ServerBehavior {
+		return &headerTimeoutBehavior{}
+	})
+}
+
+// headerTimeoutBehavior enforces a deadline on reading request headers, then reads the body without a deadline.
+type headerTimeoutBehavior struct {
+	BaseBehavior
+	conn     net.Conn
+	accepted time.Time
+	log      io.Writer
+}
+
+func (b *headerTimeoutBehavior) setLog(w io.Writer) { b.log = w }
+
+func (b *headerTimeoutBehavior) OnAccept(conn net.Conn) error {
+	b.conn, b.accepted = conn, time.Now()
+	return conn.SetReadDeadline(b.accepted.Add(headerTimeout))
+}
+
+func (b *headerTimeoutBehavior) OnHeaders(*http.Request) error {
+	fmt.Fprintf(b.log, "server: headers received %v after accept\n", time.Since(b.accepted))
+	return b.conn.SetReadDeadline(time.Time{})
+}
+
+// gatedBody holds back reads of the request body until open is closed, or fails them once ctx is done.
+type gatedBody struct {
+	io.ReadCloser
+	open <-chan struct{}
+	ctx  context.Context
+}
+
+func (b *gatedBody) Read(p []byte) (int, error) {
+	select {
+	case <-b.open:
+	case <-b.ctx.Done():
+		return 0, b.ctx.Err()
+	}
+	return b.ReadCloser.Read(p)
+}
+
+// bodyGate records when the Transport wrote request headers and when the gated body was released.
+type bodyGate struct {
+	mu           sync.Mutex
+	start        time.Time
+	wroteHeaders time.Duration
+	released     time.Duration
+}
+
+// delayBody gates the request body so that the Transport can't read it until delay after it wrote headers (httptrace WroteHeaders).
+// The gated body isn't one of the in-memory readers known to the Transport, so it flushes headers to the network before reading the body.
+func delayBody(req *http.Request, delay time.Duration) (*http.Request, *bodyGate) {
+	g := &bodyGate{start: time.Now()}
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, g
+	}
+
+	open := make(chan struct{})
+	var once sync.Once
+	trace := &httptrace.ClientTrace{
+		WroteHeaders: func() {
+			once.Do(func() {
+				g.mu.Lock()
+				g.wroteHeaders = time.Since(g.start)
+				g.mu.Unlock()
+
+				time.AfterFunc(delay, func() {
+					g.mu.Lock()
+					g.released = time.Since(g.start)
+					g.mu.Unlock()
+					close(open)
+				})
+			})
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	req.Body = &gatedBody{ReadCloser: req.Body, open: open, ctx: req.Context()}
+	return req, g
+}
+
+func (g *bodyGate) print(out io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.wroteHeaders == 0 {
+		fmt.Fprintln(out, "client: headers were never written, so the body wasn't released")
+		return
+	}
+	fmt.Fprintf(out, "client: headers written at %v, body released at %v\n", g.wroteHeaders, g.released)
+}
@@ -0,0 +1,87 @@
+package observation
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// observeBodyOnMethod sends the file as the body of a request with method (GET or DELETE, whose bodies have no defined semantics),
+// with and without Request.ContentLength, and reports whether the body went on the wire, how it was framed,
+// and whether any header set in Request.Header was dropped.
+func observeBodyOnMethod(method string, opts runOptions) (*timing, error) {
+	stat, err := os.Stat(opts.filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	captures := make(chan capturedRequest, 1)
+	url, stop, err := opts.startEphemeralServer(func() ServerBehavior { return BaseBehavior{} }, captures, true)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	var first *timing
+	for _, withLen := range []bool{true, false} {
+		f, err := os.Open(opts.filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %w", err)
+		}
+		req, err := http.NewRequestWithContext(opts.ctx, method, url, f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+		if withLen {
+			req.ContentLength = stat.Size()
+		}
+		req.Header.Set("Content-Type", "image/jpeg")
+		req.Header.Set("X-Upload-Name", filepath.Base(opts.filename))
+		set := make([]string, 0, len(req.Header))
+		for name := range req.Header {
+			set = append(set, name)
+		}
+
+		req, tm := traceTiming(req)
+		err = opts.sendReq(http.DefaultTransport, req)
+		tm.finish()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		if first == nil {
+			first = tm
+		}
+		got := <-captures
+
+		if opts.quiet {
+			continue
+		}
+		if withLen {
+			fmt.Fprintf(opts.out, "[%s with Request.ContentLength = %d]\n", method, stat.Size())
+		} else {
+			fmt.Fprintf(opts.out, "[%s without Request.ContentLength]\n", method)
+		}
+		line, fields, _ := parseRawHead(got.raw)
+		fmt.Fprintf(opts.out, "  request line: %s\n", line)
+		onWire := make(map[string]bool)
+		for _, fld := range fields {
+			fmt.Fprintf(opts.out, "  %s: %s\n", fld.Name, opts.redactValue(fld.Name, fld.Value))
+			onWire[textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(fld.Name))] = true
+		}
+		fmt.Fprintf(opts.out, "  framing: %s, body received by the server: %d of %d bytes\n", framingHeaders(got.req), int64(len(got.body))+got.bodyDropped, stat.Size())
+		for _, name := range set {
+			if !onWire[name] {
+				fmt.Fprintln(opts.out, "  => "+opts.finding(sevWarn, fmt.Sprintf("%s set in Request.Header was dropped by the client", name)))
+			}
+		}
+		if got.bodyDropped == 0 && len(got.body) == 0 {
+			fmt.Fprintln(opts.out, "  => "+opts.finding(sevWarn, fmt.Sprintf("the body of the %s request was not sent", method)))
+		}
+	}
+	return first, nil
+}
@@ -0,0 +1,92 @@
+package observation
+
+import (
+	"fmt"
+	"runtime/debug"
+	"runtime/metrics"
+	"sync"
+	"time"
+)
+
+// patternBudget is the memory and time each pattern may spend, over all its runs. Zero values mean unlimited.
+type patternBudget struct {
+	mem  int64         // peak growth of live heap objects, in bytes
+	time time.Duration // wall-clock time
+}
+
+func (b patternBudget) enabled() bool {
+	return b != patternBudget{}
+}
+
+// live heap objects, readable without stopping the world unlike runtime.ReadMemStats, which would distort timings of patterns
+const heapObjectsMetric = "/memory/classes/heap/objects:bytes"
+
+// budgetRun tracks a pattern against its budget.
+type budgetRun struct {
+	budget   patternBudget
+	start    time.Time
+	oldLimit int64
+	base     uint64
+
+	mu   sync.Mutex
+	peak uint64
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// startBudget starts tracking a pattern. With a memory budget, the GC is also given a soft memory limit of the heap
+// at start plus the budget, so that it collects harder instead of letting the process grow past the budget.
+func startBudget(b patternBudget) *budgetRun {
+	r := &budgetRun{budget: b, start: time.Now(), oldLimit: -1, stop: make(chan struct{})}
+	if b.mem <= 0 {
+		return r
+	}
+	sample := []metrics.Sample{{Name: heapObjectsMetric}}
+	metrics.Read(sample)
+	r.base = sample[0].Value.Uint64()
+	r.peak = r.base
+	r.oldLimit = debug.SetMemoryLimit(int64(r.base) + b.mem)
+
+	r.done.Add(1)
+	go func() {
+		defer r.done.Done()
+		t := time.NewTicker(10 * time.Millisecond)
+		defer t.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-t.C:
+			}
+			metrics.Read(sample)
+			r.mu.Lock()
+			if v := sample[0].Value.Uint64(); v > r.peak {
+				r.peak = v
+			}
+			r.mu.Unlock()
+		}
+	}()
+	return r
+}
+
+// finish stops tracking, prints what the pattern spent against the budget and reports overruns as findings.
+func (r *budgetRun) finish(s *session) {
+	elapsed := time.Since(r.start)
+	if r.budget.mem > 0 {
+		close(r.stop)
+		r.done.Wait()
+		debug.SetMemoryLimit(r.oldLimit)
+
+		grown := int64(r.peak - r.base)
+		fmt.Fprintf(s.out, "memory budget: peak heap growth %d KiB of %d KiB\n", grown>>10, r.budget.mem>>10)
+		if grown > r.budget.mem {
+			fmt.Fprintln(s.out, "=> "+s.finding(sevError, fmt.Sprintf("heap grew by %d KiB, over the budget of %d KiB", grown>>10, r.budget.mem>>10)))
+		}
+	}
+	if r.budget.time > 0 {
+		fmt.Fprintf(s.out, "time budget: %v of %v\n", elapsed.Round(time.Millisecond), r.budget.time)
+		if elapsed > r.budget.time {
+			fmt.Fprintln(s.out, "=> "+s.finding(sevWarn, fmt.Sprintf("took %v, over the budget of %v", elapsed.Round(time.Millisecond), r.budget.time)))
+		}
+	}
+}
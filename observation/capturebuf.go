@@ -0,0 +1,109 @@
+package observation
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// captureLimits bounds the memory the capture server spends on each request, so that observing huge uploads neither distorts
+// measurements of the client in the same process nor crashes the tool.
+type captureLimits struct {
+	mem int64  // bytes of each request kept in memory; beyond that, the raw request is streamed to a file in dir
+	dir string // directory for files of spilled captures, os.TempDir() if empty
+}
+
+// DefaultCaptureMem is the bytes of each request servers of WithServerBehavior keep in memory, unless configured by WithCaptureLimits.
+const DefaultCaptureMem = 64 << 20
+
+// limits of captures by the capture server (with -server), unless configured by -capture-mem and -capture-dir
+var defaultCaptureLimits = captureLimits{mem: DefaultCaptureMem}
+
+// CaptureAll makes the default server read whole requests rather than a prefix of the connection.
+const CaptureAll = -1
+
+// bytes of each connection the default server reads, and of each request capture servers log, unless configured by -capture-bytes
+const defaultCaptureBytes = 1024
+
+// ParseCaptureBytes parses a value of WithCaptureBytes as given to -capture-bytes: a size with binary size units (e.g. 4KiB),
+// or "all" for CaptureAll.
+func ParseCaptureBytes(s string) (int64, error) {
+	if s == "all" {
+		return CaptureAll, nil
+	}
+	n, err := parseSize(s)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("must be positive or \"all\": %q", s)
+	}
+	return n, nil
+}
+
+// spillBuffer keeps up to limits.mem bytes written in memory. Once more is written, it streams everything written so far
+// and afterwards to a file, which is left as the full capture. The memory keeps the first limits.mem bytes.
+// Values of redacted headers are masked in the file, as it's left for sharing like other outputs.
+type spillBuffer struct {
+	limits   captureLimits
+	mem      bytes.Buffer
+	n        int64 // total bytes written
+	file     *os.File
+	redactor headRedactor // of bytes written to the file
+}
+
+func (b *spillBuffer) Write(p []byte) (int, error) {
+	if b.file == nil && int64(b.mem.Len()+len(p)) > b.limits.mem {
+		f, err := os.CreateTemp(b.limits.dir, "capture-*.raw")
+		if err != nil {
+			return 0, fmt.Errorf("failed to create a file for the capture: %w", err)
+		}
+		if _, err := f.Write(b.redactor.next(b.mem.Bytes())); err != nil {
+			_ = f.Close()
+			return 0, fmt.Errorf("failed to spill the capture: %w", err)
+		}
+		b.file = f
+	}
+	if room := b.limits.mem - int64(b.mem.Len()); room > 0 {
+		if int64(len(p)) < room {
+			room = int64(len(p))
+		}
+		b.mem.Write(p[:room])
+	}
+	if b.file != nil {
+		if _, err := b.file.Write(b.redactor.next(p)); err != nil {
+			return 0, fmt.Errorf("failed to spill the capture: %w", err)
+		}
+	}
+	b.n += int64(len(p))
+	return len(p), nil
+}
+
+// Bytes returns the part kept in memory.
+func (b *spillBuffer) Bytes() []byte {
+	return b.mem.Bytes()
+}
+
+// dropped returns the number of bytes written but not kept in memory.
+func (b *spillBuffer) dropped() int64 {
+	return b.n - int64(b.mem.Len())
+}
+
+// spilled returns the name of the file the capture is streamed to, or "" if it fits in memory.
+func (b *spillBuffer) spilled() string {
+	if b.file == nil {
+		return ""
+	}
+	return b.file.Name()
+}
+
+// Reset starts over for the next capture, closing and leaving the file of the current one if any.
+func (b *spillBuffer) Reset() {
+	if b.file != nil {
+		_ = b.file.Close()
+		b.file = nil
+	}
+	b.mem.Reset()
+	b.n = 0
+	b.redactor = headRedactor{names: b.redactor.names}
+}
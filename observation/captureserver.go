@@ -0,0 +1,120 @@
+package observation
+
+import (
+	"net"
+	"net/http"
+	"sync"
+)
+
+// captureServer runs the accept loop of the capture server of a Run, handing connections over to the observation in progress,
+// so that observations neither wait for the server to get ready nor race with each other for connections.
+type captureServer struct {
+	l       net.Listener
+	tr      http.RoundTripper // sending requests to l, whose idle connections are closed at the end of handoffs, or nil if not owned by the Run
+	conns   chan net.Conn
+	stopped chan struct{} // closed once the accept loop returned
+}
+
+// startCaptureServer starts the accept loop on l, to which tr sends requests, returning once it's running. Call stop to shut it down.
+// tr is nil if it isn't owned by the Run, such as one given with WithTransport, which may be shared with code outside of the Run.
+// Connections accepted while no observation is in progress wait for the next one, as they would in the backlog of l.
+func startCaptureServer(l net.Listener, tr http.RoundTripper) *captureServer {
+	s := &captureServer{l: l, tr: tr, conns: make(chan net.Conn), stopped: make(chan struct{})}
+	ready := make(chan struct{})
+	go s.acceptLoop(ready)
+	<-ready
+	return s
+}
+
+func (s *captureServer) acceptLoop(ready chan<- struct{}) {
+	defer close(s.stopped)
+	close(ready)
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		s.conns <- conn
+	}
+}
+
+// stop closes the listener and waits for the accept loop to return, closing a connection no observation took.
+func (s *captureServer) stop() {
+	_ = s.l.Close()
+	for {
+		select {
+		case conn := <-s.conns:
+			conn.Close()
+		case <-s.stopped:
+			return
+		}
+	}
+}
+
+// handoff hands every connection accepted until wait is called over to serve, each in a goroutine of its own,
+// with a channel collecting the requests captured on it. Observations don't overlap, so only one handoff may be in progress.
+func (s *captureServer) handoff(serve func(conn net.Conn, captures chan<- capturedRequest)) *handoff {
+	h := &handoff{tr: s.tr, end: make(chan struct{}), captures: make(chan capturedRequest), done: make(chan struct{})}
+	go func() {
+		var (
+			wg    sync.WaitGroup
+			conns []net.Conn
+		)
+		defer func() {
+			if h.tr == nil {
+				// the idle connections of a transport not owned by the Run are left alone, so the server closes its side instead
+				for _, conn := range conns {
+					conn.Close()
+				}
+			}
+			wg.Wait()
+			close(h.captures)
+		}()
+		for {
+			select {
+			case conn := <-s.conns:
+				h.conns++
+				conns = append(conns, conn)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					serve(conn, h.captures)
+				}()
+			case <-h.end:
+				return
+			case <-s.stopped:
+				return
+			}
+		}
+	}()
+	go func() {
+		defer close(h.done)
+		for c := range h.captures {
+			h.got = append(h.got, c)
+		}
+	}()
+	return h
+}
+
+// handoff is the connections of the capture server handed over to an observation.
+type handoff struct {
+	tr       http.RoundTripper
+	end      chan struct{}
+	captures chan capturedRequest
+	done     chan struct{} // closed once all connections are served and got is complete
+	got      []capturedRequest
+	conns    int // connections handed over, complete once done is closed
+}
+
+// wait ends the handoff once the observation has sent its requests, and returns the requests captured in the order captured
+// after the connections handed over are served. Idle connections of the transport are closed, or the connections are closed
+// by the server if the transport isn't owned by the Run, so that the server finishes serving connections kept alive;
+// connections of other clients must have been closed by the observation.
+func (h *handoff) wait() []capturedRequest {
+	close(h.end)
+	if h.tr != nil {
+		(&http.Client{Transport: h.tr}).CloseIdleConnections()
+	}
+	<-h.done
+	return h.got
+}
@@ -0,0 +1,182 @@
+package observation
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timeline records events around a request with the elapsed time since its start.
+type timeline struct {
+	mu     sync.Mutex
+	start  time.Time
+	events []timelineEvent
+}
+
+type timelineEvent struct {
+	at   time.Duration
+	what string
+}
+
+func newTimeline() *timeline {
+	return &timeline{start: time.Now()}
+}
+
+func (t *timeline) add(format string, args ...any) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	at := time.Since(t.start)
+	t.events = append(t.events, timelineEvent{at: at, what: fmt.Sprintf(format, args...)})
+	return at
+}
+
+func (t *timeline) print(out io.Writer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sort.SliceStable(t.events, func(i, j int) bool {
+		return t.events[i].at < t.events[j].at
+	})
+	for _, e := range t.events {
+		fmt.Fprintf(out, "  %12v  %s\n", e.at, e.what)
+	}
+}
+
+// closeTrackingBody wraps a request body and records when it's read to the end and when it's closed (and by whom).
+type closeTrackingBody struct {
+	rc io.ReadCloser
+	tl *timeline
+
+	mu      sync.Mutex
+	read    int64
+	started bool
+	eofAt   time.Duration // zero if EOF isn't reached
+	closes  []time.Duration
+	closed  chan struct{}
+}
+
+func newCloseTrackingBody(rc io.ReadCloser, tl *timeline) *closeTrackingBody {
+	return &closeTrackingBody{rc: rc, tl: tl, closed: make(chan struct{})}
+}
+
+func (b *closeTrackingBody) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.started {
+		b.started = true
+		b.tl.add("body: first Read")
+	}
+	b.read += int64(n)
+	switch {
+	case errors.Is(err, io.EOF):
+		if b.eofAt == 0 {
+			b.eofAt = b.tl.add("body: Read reached EOF after %d bytes (last byte consumed)", b.read)
+		}
+	case err != nil:
+		b.tl.add("body: Read failed after %d bytes: %v", b.read, err)
+	}
+	return n, err
+}
+
+func (b *closeTrackingBody) Close() error {
+	// skip the Transport's own wrapper to find out who actually decided to close the body
+	caller := "unknown"
+	pcs := make([]uintptr, 8)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		f, more := frames.Next()
+		if !strings.Contains(f.Function, "readTrackingBody") {
+			caller = f.Function
+			break
+		}
+		if !more {
+			break
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	at := b.tl.add("body: Close called (#%d) by %s", len(b.closes)+1, caller)
+	b.closes = append(b.closes, at)
+	if len(b.closes) == 1 {
+		close(b.closed)
+	}
+	return b.rc.Close()
+}
+
+// trackBodyClose replaces the body of req with closeTrackingBody, and attaches httptrace hooks recording wire events to tl.
+func trackBodyClose(req *http.Request, tl *timeline) (*http.Request, *closeTrackingBody) {
+	var body *closeTrackingBody
+	if req.Body != nil {
+		body = newCloseTrackingBody(req.Body, tl)
+		req.Body = body
+	}
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) {
+			tl.add("wire: got connection")
+		},
+		WroteHeaders: func() {
+			tl.add("wire: headers written")
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			if info.Err != nil {
+				tl.add("wire: writing request failed: %v", info.Err)
+				return
+			}
+			tl.add("wire: whole request written")
+		},
+		GotFirstResponseByte: func() {
+			tl.add("wire: got first response byte")
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace)), body
+}
+
+// reportBodyClose waits a while for body to be closed, then prints the timeline and summarizes the close behavior.
+func (s *session) reportBodyClose(body *closeTrackingBody, tl *timeline, reqErr error) {
+	if body == nil {
+		fmt.Fprintln(s.out, "Body close tracking: request has no body")
+		return
+	}
+	select {
+	case <-body.closed:
+	case <-time.After(time.Second):
+	}
+
+	fmt.Fprintln(s.out, "Body close timeline:")
+	tl.print(s.out)
+
+	body.mu.Lock()
+	defer body.mu.Unlock()
+
+	switch {
+	case len(body.closes) == 0:
+		fmt.Fprintln(s.out, "=> "+s.finding(sevError, "Body was NOT closed by the Transport (leak)"))
+		return
+	case len(body.closes) > 1:
+		fmt.Fprintln(s.out, "=> "+s.finding(sevWarn, fmt.Sprintf("Body was closed %d times (double close)", len(body.closes))))
+	}
+	first := body.closes[0]
+	switch {
+	case body.eofAt == 0 || first < body.eofAt:
+		fmt.Fprintln(s.out, "=> "+s.finding(sevWarn, "Body was closed before its last byte was read"))
+	default:
+		fmt.Fprintln(s.out, "=> "+s.finding(sevInfo, fmt.Sprintf("Body was closed %v after its last byte was read", first-body.eofAt)))
+	}
+	if reqErr != nil {
+		fmt.Fprintln(s.out, "=> "+s.finding(sevInfo, "Body was closed on the error path of the request"))
+	}
+}
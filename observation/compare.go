@@ -0,0 +1,177 @@
+package observation
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// fixed boundary of multipart patterns under comparison, so that only differences in net/http show up
+const compareBoundary = "go-toolchain-comparison-boundary"
+
+// toolchainRun is what the observation suite captured under a Go toolchain.
+type toolchainRun struct {
+	goVersion string                       // as reported by the suite, which may differ from the toolchain asked for
+	records   map[string]observationRecord // by pattern name, the first run of each pattern
+	order     []string                     // pattern names in the order run
+}
+
+// CompareToolchains runs the observation suite in the current directory, the root of this module, under two Go toolchains
+// given as values of GOTOOLCHAIN (e.g. 1.22.0, go1.23.4 or "local"), and diffs captured requests per pattern (the compare subcommand).
+// Of the options, the ones of the output, redaction, patterns and body source apply.
+func CompareToolchains(ctx context.Context, a, b string, opts ...Option) error {
+	cfg, s, err := configure(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	// the default server reads whole requests with -capture-bytes all, so body sizes are comparable
+	suiteArgs := []string{"-json", "-capture-bytes", "all", "-boundary", compareBoundary, "-f", cfg.opts.filename}
+	if len(cfg.patternNames) > 0 {
+		suiteArgs = append(suiteArgs, "-pattern", strings.Join(cfg.patternNames, ","))
+	}
+	runs := make([]*toolchainRun, 2)
+	for i, v := range []string{a, b} {
+		fmt.Fprintf(s.out, "running the observation suite with GOTOOLCHAIN=%s...\n", toolchainName(v))
+		r, err := runUnderToolchain(ctx, toolchainName(v), suiteArgs)
+		if err != nil {
+			return err
+		}
+		runs[i] = r
+	}
+	fmt.Fprintf(s.out, "\n%s -> %s\n\n", runs[0].goVersion, runs[1].goVersion)
+
+	names := runs[0].order
+	for _, name := range runs[1].order {
+		if _, ok := runs[0].records[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	differ := 0
+	for _, name := range names {
+		a, inA := runs[0].records[name]
+		b, inB := runs[1].records[name]
+		switch {
+		case !inB:
+			fmt.Fprintf(s.out, "%s: captured only under %s\n", name, runs[0].goVersion)
+			differ++
+		case !inA:
+			fmt.Fprintf(s.out, "%s: captured only under %s\n", name, runs[1].goVersion)
+			differ++
+		case sameObservation(a, b):
+			fmt.Fprintf(s.out, "%s: same\n", name)
+		default:
+			fmt.Fprintf(s.out, "%s: differs\n", name)
+			s.printObservationDiff(a, b)
+			differ++
+		}
+	}
+	fmt.Fprintf(s.out, "\n%d of %d patterns captured differ", differ, len(names))
+	fmt.Fprintln(s.out, " (patterns running experiments of their own aren't captured, and so not compared)")
+	return nil
+}
+
+// toolchainName turns a Go version into a value of GOTOOLCHAIN, adding "go" to bare version numbers.
+func toolchainName(v string) string {
+	if v != "" && v[0] >= '0' && v[0] <= '9' {
+		return "go" + v
+	}
+	return v
+}
+
+// runUnderToolchain runs the observation suite in the current directory with "go run" under the toolchain, reading records it prints with -json.
+func runUnderToolchain(ctx context.Context, toolchain string, args []string) (*toolchainRun, error) {
+	cmd := exec.CommandContext(ctx, "go", append([]string{"run", "."}, args...)...)
+	cmd.Env = append(os.Environ(), "GOTOOLCHAIN="+toolchain)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("observation suite failed with GOTOOLCHAIN=%s: %w: %s", toolchain, err, strings.TrimSpace(stderr.String()))
+	}
+
+	r := &toolchainRun{goVersion: toolchain, records: make(map[string]observationRecord)}
+	sc := bufio.NewScanner(&stdout)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var o observationRecord
+		if err := json.Unmarshal(sc.Bytes(), &o); err != nil {
+			return nil, fmt.Errorf("invalid record from GOTOOLCHAIN=%s: %w", toolchain, err)
+		}
+		r.goVersion = o.GoVersion
+		if _, ok := r.records[o.Name]; ok || o.isStub() {
+			continue
+		}
+		normalizeRecord(&o)
+		r.records[o.Name] = o
+		r.order = append(r.order, o.Name)
+	}
+	return r, sc.Err()
+}
+
+// sameObservation reports whether two records have the same request on the wire, ignoring the Go version, timing and errors.
+func sameObservation(a, b observationRecord) bool {
+	a.GoVersion, b.GoVersion = "", ""
+	a.DurationNs, b.DurationNs = 0, 0
+	// errors have ephemeral ports in them
+	a.Error, b.Error = "", ""
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return bytes.Equal(ja, jb)
+}
+
+// printObservationDiff prints differences of the request line, headers, their order and body framing between two records.
+func (s *session) printObservationDiff(a, b observationRecord) {
+	if a.RequestLine != b.RequestLine {
+		fmt.Fprintf(s.out, "  ~ %s -> %s\n", a.RequestLine, b.RequestLine)
+	}
+	fieldsA, fieldsB := observedFields(a), observedFields(b)
+	v1, order := groupFields(fieldsA, nil)
+	v2, order := groupFields(fieldsB, order)
+	for _, name := range order {
+		// compared as captured, printed redacted
+		x, y := strings.Join(s.redactValues(name, v1[name]), ", "), strings.Join(s.redactValues(name, v2[name]), ", ")
+		_, in1 := v1[name]
+		_, in2 := v2[name]
+		switch {
+		case !in2:
+			fmt.Fprintf(s.out, "  - %s: %s\n", name, x)
+		case !in1:
+			fmt.Fprintf(s.out, "  + %s: %s\n", name, y)
+		case strings.Join(v1[name], ", ") != strings.Join(v2[name], ", "):
+			fmt.Fprintf(s.out, "  ~ %s: %s -> %s\n", name, x, y)
+		}
+	}
+	if orderA, orderB := strings.Join(fieldNames(fieldsA), ", "), strings.Join(fieldNames(fieldsB), ", "); orderA != orderB {
+		fmt.Fprintf(s.out, "  ~ header order: %s -> %s\n", orderA, orderB)
+	}
+	if a.HeaderBytes != b.HeaderBytes {
+		fmt.Fprintf(s.out, "  ~ header bytes: %d -> %d\n", a.HeaderBytes, b.HeaderBytes)
+	}
+	if a.BodyBytes != b.BodyBytes {
+		fmt.Fprintf(s.out, "  ~ body bytes: %d -> %d\n", a.BodyBytes, b.BodyBytes)
+	}
+	if a.Complete != b.Complete {
+		fmt.Fprintf(s.out, "  ~ complete: %v -> %v\n", a.Complete, b.Complete)
+	}
+}
+
+func observedFields(o observationRecord) []HeaderField {
+	fields := make([]HeaderField, len(o.Headers))
+	for i, h := range o.Headers {
+		fields[i] = HeaderField{Name: h.Name, Value: h.Value}
+	}
+	return fields
+}
+
+func fieldNames(fields []HeaderField) []string {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.Name
+	}
+	return names
+}
@@ -0,0 +1,128 @@
+package observation
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"time"
+)
+
+// validators of the resource served by cacheValidationBehavior
+const cacheETag = `"v1-5d41402a"`
+
+var cacheLastModified = time.Date(2022, 12, 25, 0, 0, 0, 0, time.UTC)
+
+func init() {
+	RegisterServerBehavior("cache-validation", "reply 200 with Cache-Control/ETag/Last-Modified, or 304 to conditional requests matching them", func() ServerBehavior {
+		return cacheValidationBehavior{}
+	})
+}
+
+// cacheValidationBehavior serves a resource with validators like CDNs do, and replies 304 to conditional requests when it's not modified.
+type cacheValidationBehavior struct {
+	BaseBehavior
+}
+
+func (cacheValidationBehavior) Respond(w io.Writer, req *http.Request) (bool, error) {
+	hdr := http.Header{
+		"Cache-Control": {"public, max-age=60"},
+		"Etag":          {cacheETag},
+		"Last-Modified": {cacheLastModified.Format(http.TimeFormat)},
+	}
+	keepAlive := !req.Close
+	if notModified(req) {
+		return keepAlive, writeResponseBody(w, http.StatusNotModified, hdr, nil, keepAlive)
+	}
+	hdr.Set("Content-Type", "text/plain")
+	return keepAlive, writeResponseBody(w, http.StatusOK, hdr, []byte("hello, cached world\n"), keepAlive)
+}
+
+// notModified evaluates preconditions of the request against the resource. If-None-Match takes precedence over If-Modified-Since.
+func notModified(req *http.Request) bool {
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			if tag = strings.TrimSpace(tag); tag == cacheETag || tag == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := req.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := http.ParseTime(ims)
+		return err == nil && !cacheLastModified.After(t)
+	}
+	return false
+}
+
+// observeConditionalGet fetches a resource cold, then revalidates it with conditional requests, acting as a client-side cache would.
+// Reports the conditional headers on the wire, how 304 responses are surfaced, and whether the connection stays reusable.
+func observeConditionalGet(opts runOptions) (*timing, error) {
+	captures := make(chan capturedRequest, 1)
+	url, stop, err := opts.startEphemeralServer(func() ServerBehavior { return cacheValidationBehavior{} }, captures, true)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	defer tr.CloseIdleConnections()
+	cli := &http.Client{Transport: tr}
+
+	var (
+		first        *timing
+		etag, lastMd string
+	)
+	steps := []struct {
+		desc      string
+		condition func(h http.Header)
+	}{
+		{"cold GET", func(http.Header) {}},
+		{"revalidate with If-None-Match", func(h http.Header) { h.Set("If-None-Match", etag) }},
+		{"revalidate with If-Modified-Since", func(h http.Header) { h.Set("If-Modified-Since", lastMd) }},
+		{"revalidate with stale ETag", func(h http.Header) { h.Set("If-None-Match", `"v0-stale"`) }},
+	}
+	for i, step := range steps {
+		req, err := http.NewRequestWithContext(opts.ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+		step.condition(req.Header)
+
+		var reused bool
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				reused = info.Reused
+			},
+		}
+		req, tm := traceTiming(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+		resp, err := cli.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("HTTP request failed: %w", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		tm.finish()
+
+		got := <-captures
+		if i == 0 {
+			first = tm
+			etag, lastMd = resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+		}
+		if opts.quiet {
+			continue
+		}
+
+		fmt.Fprintf(opts.out, "[request %d] %s\n", i+1, step.desc)
+		for _, name := range []string{"If-None-Match", "If-Modified-Since"} {
+			if vs := got.req.Header.Values(name); len(vs) > 0 {
+				fmt.Fprintf(opts.out, "  sent %s: %s\n", name, opts.redactValue(name, strings.Join(vs, ", ")))
+			}
+		}
+		fmt.Fprintf(opts.out, "  response: %s, ContentLength=%d, body read: %d bytes\n", resp.Status, resp.ContentLength, len(body))
+		fmt.Fprintf(opts.out, "  validators: ETag=%s, Last-Modified=%s, Cache-Control=%s\n", resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"), resp.Header.Get("Cache-Control"))
+		fmt.Fprintf(opts.out, "  connection reused: %v\n", reused)
+	}
+	return first, nil
+}
@@ -0,0 +1,238 @@
+package observation
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterServerBehavior("precondition", "reply 204 to writes whose If-Match/If-Unmodified-Since hold against the resource of cache-validation, 412 otherwise, after reading the body", func() ServerBehavior {
+		return &preconditionBehavior{}
+	})
+}
+
+// minimum size of bodies uploaded by observeConditionalPut, to exceed socket buffers so that replies before the body arrive mid-upload
+const condPutMinBody = 16 << 20
+
+var errEarlyPreconditionFailed = errors.New("412 sent before the body with Expect: 100-continue, the client may never send it")
+
+// preconditionBehavior evaluates preconditions of writes against the resource served by cacheValidationBehavior,
+// replying 412 Precondition Failed either after reading the body or right after reading request headers (early).
+// Early replies keep the connection open and drain the body, unless the client expects 100-continue.
+type preconditionBehavior struct {
+	BaseBehavior
+	early bool
+	conn  net.Conn
+
+	mu       sync.Mutex
+	bodyRead int
+	replied  bool // replied before the body
+}
+
+func (b *preconditionBehavior) OnAccept(conn net.Conn) error {
+	b.conn = conn
+	return nil
+}
+
+func (b *preconditionBehavior) OnHeaders(req *http.Request) error {
+	b.mu.Lock()
+	b.bodyRead, b.replied = 0, false
+	b.mu.Unlock()
+	if !b.early || preconditionHolds(req) {
+		return nil
+	}
+	expect := strings.EqualFold(req.Header.Get("Expect"), "100-continue")
+	if err := writeResponse(b.conn, http.StatusPreconditionFailed, nil, !expect); err != nil {
+		return err
+	}
+	if expect {
+		return errEarlyPreconditionFailed
+	}
+	b.mu.Lock()
+	b.replied = true
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *preconditionBehavior) OnBodyChunk(_ *http.Request, chunk []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bodyRead += len(chunk)
+	return nil
+}
+
+func (b *preconditionBehavior) Respond(w io.Writer, req *http.Request) (bool, error) {
+	keepAlive := !req.Close
+	b.mu.Lock()
+	replied := b.replied
+	b.mu.Unlock()
+	switch {
+	case replied:
+		return keepAlive, nil
+	case req.Method != http.MethodPut && req.Method != http.MethodPost:
+		return keepAlive, writeResponse(w, http.StatusOK, nil, keepAlive)
+	case !preconditionHolds(req):
+		return keepAlive, writeResponse(w, http.StatusPreconditionFailed, nil, keepAlive)
+	default:
+		return keepAlive, writeResponse(w, http.StatusNoContent, nil, keepAlive)
+	}
+}
+
+func (b *preconditionBehavior) received() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.bodyRead
+}
+
+// preconditionHolds evaluates If-Match and If-Unmodified-Since against the resource of cacheValidationBehavior.
+// If-Unmodified-Since is ignored if If-Match is present (RFC 9110 Section 13.2.2).
+func preconditionHolds(req *http.Request) bool {
+	if im := req.Header.Get("If-Match"); im != "" {
+		for _, tag := range strings.Split(im, ",") {
+			if tag = strings.TrimSpace(tag); tag == cacheETag || tag == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	if ius := req.Header.Get("If-Unmodified-Since"); ius != "" {
+		t, err := http.ParseTime(ius)
+		return err != nil || !cacheLastModified.After(t)
+	}
+	return true
+}
+
+// observeConditionalPut uploads the file (repeated up to condPutMinBody) with If-Match or If-Unmodified-Since to a server replying 412 on failed preconditions,
+// either after reading the body or before it, then sends a GET on the same Transport.
+// Reports how much of the body was sent and received, and whether the connection was reused for the GET.
+func observeConditionalPut(opts runOptions) (*timing, error) {
+	file, err := os.ReadFile(opts.filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	data := file
+	if len(file) > 0 && len(data) < condPutMinBody {
+		data = bytes.Repeat(file, (condPutMinBody+len(file)-1)/len(file))
+	}
+	stale := cacheLastModified.Add(-24 * time.Hour).Format(http.TimeFormat)
+
+	cases := []struct {
+		desc      string
+		early     bool
+		condition func(h http.Header)
+	}{
+		{"If-Match: current ETag", false, func(h http.Header) { h.Set("If-Match", cacheETag) }},
+		{"If-Match: stale ETag, 412 after the body", false, func(h http.Header) { h.Set("If-Match", `"v0-stale"`) }},
+		{"If-Match: stale ETag, 412 before the body", true, func(h http.Header) { h.Set("If-Match", `"v0-stale"`) }},
+		{"If-Unmodified-Since: before Last-Modified, 412 after the body", false, func(h http.Header) { h.Set("If-Unmodified-Since", stale) }},
+		{"If-Unmodified-Since: before Last-Modified, 412 before the body", true, func(h http.Header) { h.Set("If-Unmodified-Since", stale) }},
+		{"If-Match: stale ETag with Expect: 100-continue, 412 before the body", true, func(h http.Header) {
+			h.Set("If-Match", `"v0-stale"`)
+			h.Set("Expect", "100-continue")
+		}},
+	}
+
+	var first *timing
+	for i, c := range cases {
+		var (
+			mu        sync.Mutex
+			behaviors []*preconditionBehavior
+		)
+		url, stop, err := opts.startEphemeralServer(func() ServerBehavior {
+			mu.Lock()
+			defer mu.Unlock()
+			b := &preconditionBehavior{early: c.early}
+			behaviors = append(behaviors, b)
+			return b
+		}, nil, true)
+		if err != nil {
+			return nil, err
+		}
+
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		cli := &http.Client{Transport: tr}
+
+		req, err := http.NewRequestWithContext(opts.ctx, http.MethodPut, url, bytes.NewReader(data))
+		if err != nil {
+			stop()
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+		c.condition(req.Header)
+
+		var wroteRequest string
+		trace := &httptrace.ClientTrace{
+			WroteRequest: func(info httptrace.WroteRequestInfo) {
+				mu.Lock()
+				defer mu.Unlock()
+				if info.Err != nil {
+					wroteRequest = fmt.Sprintf("write failed: %v", info.Err)
+				} else {
+					wroteRequest = "written"
+				}
+			},
+		}
+		req, tm := traceTiming(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+		resp, reqErr := cli.Do(req)
+		status := ""
+		if reqErr == nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+			status = resp.Status
+		}
+		tm.finish()
+		// wait a moment for the write loop to finish with the body, and the server to read what was sent
+		time.Sleep(100 * time.Millisecond)
+
+		mu.Lock()
+		received := behaviors[0].received()
+		mu.Unlock()
+
+		var reused bool
+		getReq, err := http.NewRequestWithContext(opts.ctx, http.MethodGet, url, nil)
+		if err != nil {
+			stop()
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+		getReq = getReq.WithContext(httptrace.WithClientTrace(getReq.Context(), &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused },
+		}))
+		getErr := opts.sendReq(tr, getReq)
+		tr.CloseIdleConnections()
+		stop()
+
+		if first == nil {
+			first = tm
+		}
+		if opts.quiet {
+			continue
+		}
+
+		mu.Lock()
+		fmt.Fprintf(opts.out, "[request %d] %s\n", i+1, c.desc)
+		if reqErr != nil {
+			fmt.Fprintf(opts.out, "  PUT failed: %v\n", reqErr)
+		} else {
+			fmt.Fprintf(opts.out, "  response: %s at %v\n", status, tm.total)
+		}
+		if wroteRequest == "" {
+			wroteRequest = "not reported"
+		}
+		fmt.Fprintf(opts.out, "  request body: %s, %d of %d bytes received by the server\n", wroteRequest, received, len(data))
+		if getErr != nil {
+			fmt.Fprintf(opts.out, "  following GET failed: %v\n", getErr)
+		} else {
+			fmt.Fprintf(opts.out, "  following GET reused the connection: %v\n", reused)
+		}
+		mu.Unlock()
+	}
+	return first, nil
+}
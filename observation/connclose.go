@@ -0,0 +1,101 @@
+package observation
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strings"
+)
+
+// observeConnClose sends 2 requests in a row on one Transport, either setting Request.Close on the first one
+// or disabling keep-alives on the Transport, and reports the Connection header on the wire and whether the connection was reused.
+// Requests are sent to a dedicated server over TCP, which keeps connections open unless the client asks to close them.
+func observeConnClose(pat reqPattern, opts runOptions) (*timing, error) {
+	captures := make(chan capturedRequest, 1)
+	url, stop, err := opts.startEphemeralServer(func() ServerBehavior { return keepAliveBehavior{} }, captures, true)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	data, err := os.ReadFile(opts.filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	defer tr.CloseIdleConnections()
+	tr.DisableKeepAlives = pat == reqSinglePartDisableKeepAlives
+	cli := &http.Client{Transport: tr}
+
+	var (
+		first  *timing
+		reused []bool
+	)
+	for i := 1; i <= 2; i++ {
+		req, err := http.NewRequestWithContext(opts.ctx, http.MethodPut, url, bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+		req.Close = pat == reqSinglePartReqClose && i == 1
+
+		var r bool
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				r = info.Reused
+			},
+		}
+		req, tm := traceTiming(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+		resp, err := cli.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("HTTP request failed: %w", err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		tm.finish()
+
+		got := <-captures
+		if i == 1 {
+			first = tm
+		}
+		reused = append(reused, r)
+		if opts.quiet {
+			continue
+		}
+
+		conn := "new connection"
+		if r {
+			conn = "reused pooled connection"
+		}
+		fmt.Fprintf(opts.out, "[request %d] Request.Close=%v, Transport.DisableKeepAlives=%v\n", i, req.Close, tr.DisableKeepAlives)
+		fmt.Fprintf(opts.out, "  Connection header on the wire: %s\n", connectionHeader(got.raw))
+		fmt.Fprintf(opts.out, "  %s, response had Connection: close = %v\n", conn, resp.Close)
+	}
+
+	if !opts.quiet {
+		if reused[1] {
+			fmt.Fprintln(opts.out, "=> the connection was returned to the pool and reused by the 2nd request")
+		} else {
+			fmt.Fprintln(opts.out, "=> the connection was closed after the 1st exchange, so the 2nd request dialed a new one")
+		}
+	}
+	return first, nil
+}
+
+// connectionHeader extracts values of Connection header from the header section of the raw request.
+func connectionHeader(raw []byte) string {
+	_, fields, _ := parseRawHead(raw)
+	var vs []string
+	for _, f := range fields {
+		if strings.EqualFold(f.Name, "Connection") {
+			vs = append(vs, f.Value)
+		}
+	}
+	if len(vs) == 0 {
+		return "(none)"
+	}
+	return strings.Join(vs, ", ")
+}
@@ -0,0 +1,245 @@
+package observation
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// curlEquivalent builds curl arguments sending the file of opts to url as close to the way of a pattern as curl can.
+type curlEquivalent struct {
+	args func(url string, opts runOptions) []string
+	// how curl's request differs from Go's in ways no curl option expresses; empty if the request is equivalent
+	differs string
+}
+
+func curlPut(url, filename string, extra ...string) []string {
+	args := append([]string{"-X", "PUT", "--data-binary", "@" + filename, "-H", "Content-Type:"}, extra...)
+	return append(args, url)
+}
+
+var (
+	curlPutLen     = curlEquivalent{args: func(url string, opts runOptions) []string { return curlPut(url, opts.filename) }}
+	curlPutChunked = curlEquivalent{args: func(url string, opts runOptions) []string {
+		return curlPut(url, opts.filename, "-H", "Transfer-Encoding: chunked")
+	}}
+)
+
+// curlForm returns -F arguments of the multipart parts of opts in order, with the file of opts as the first part unless it's among them.
+func curlForm(opts runOptions) []string {
+	parts := opts.parts
+	hasBody := false
+	for _, p := range parts {
+		hasBody = hasBody || p.Body
+	}
+	if !hasBody {
+		parts = append([]MultipartPart{{Name: "file", Body: true}}, parts...)
+	}
+	var args []string
+	for _, p := range parts {
+		switch {
+		case p.Body:
+			args = append(args, "-F", p.Name+"=@"+opts.filename)
+		case p.Path != "":
+			spec := p.Name + "=@" + p.Path
+			if p.ContentType != "" {
+				spec += ";type=" + p.ContentType
+			}
+			args = append(args, "-F", spec)
+		default:
+			// --form-string takes the value literally, while -F would read files for values starting with @ or <
+			args = append(args, "--form-string", p.Name+"="+p.Value)
+		}
+	}
+	return args
+}
+
+// curlEquivalents are the closest curl commands of patterns built by request() and of experiments sending a plain upload.
+// Patterns missing here have none, such as ones depending on Transport behavior across several requests.
+var curlEquivalents = map[reqPattern]curlEquivalent{
+	reqSinglePartWithLen:           curlPutLen,
+	reqSinglePartWithoutLen:        curlPutChunked,
+	reqSinglePartWithBuffer:        curlPutLen,
+	reqSinglePartExplicitlyChunked: curlPutChunked,
+	reqMultipart: {args: func(url string, opts runOptions) []string {
+		return append(curlForm(opts), url)
+	}},
+	reqMultipartWithLen: {args: func(url string, opts runOptions) []string {
+		return append(curlForm(opts), url)
+	}},
+	reqMultipartPipe: {args: func(url string, opts runOptions) []string {
+		return append(append(curlForm(opts), "-H", "Transfer-Encoding: chunked"), url)
+	}},
+	reqSinglePartWithBytesReader:   curlPutLen,
+	reqSinglePartWithStringsReader: curlPutLen,
+	reqSinglePartWithPipe:          curlPutChunked,
+	reqSinglePartUnknownLen:        curlPutChunked,
+	reqSinglePartLenAndChunked:     curlPutChunked,
+	reqSinglePartWithLen_wrong: {
+		args:    curlPutLen.args,
+		differs: "Go drops Content-Length set in Request.Header and sends the length of the body; -H 'Content-Length: ...' would make curl send the wrong value as is",
+	},
+	reqSinglePartChunkedHeader: {
+		args:    curlPutLen.args,
+		differs: "Go drops Transfer-Encoding set in Request.Header and sends Content-Length; -H 'Transfer-Encoding: chunked' would make curl send chunked",
+	},
+	reqGzipBuffered: {
+		args: func(url string, opts runOptions) []string {
+			return curlPut(url, opts.filename+".gz", "-H", "Content-Encoding: gzip")
+		},
+		differs: "curl doesn't compress request bodies, so the file must be compressed first (gzip -k)",
+	},
+	reqGzipStreamed: {
+		args: func(url string, opts runOptions) []string {
+			return curlPut(url, opts.filename+".gz", "-H", "Content-Encoding: gzip", "-H", "Transfer-Encoding: chunked")
+		},
+		differs: "curl doesn't compress request bodies, so the file must be compressed first (gzip -k)",
+	},
+	reqTrailer: {
+		args:    curlPutChunked.args,
+		differs: "curl can't send trailers, so the X-Content-Sha256 trailer and the Trailer header are missing",
+	},
+	reqGetWithBody: {args: func(url string, opts runOptions) []string {
+		return []string{"-X", "GET", "--data-binary", "@" + opts.filename, "-H", "Content-Type:", url}
+	}},
+	reqDeleteWithBody: {args: func(url string, opts runOptions) []string {
+		return []string{"-X", "DELETE", "--data-binary", "@" + opts.filename, "-H", "Content-Type:", url}
+	}},
+	reqExpectContinue: {args: func(url string, opts runOptions) []string {
+		return curlPut(url, opts.filename, "-H", "Expect: 100-continue")
+	}},
+}
+
+// printCurlEquivalent prints the closest curl command of the pattern sending to url, and how it differs from Go's request.
+func printCurlEquivalent(p reqPattern, url string, opts runOptions) {
+	eq, ok := curlEquivalents[p]
+	if !ok {
+		fmt.Fprintln(opts.out, "curl equivalent: none")
+		fmt.Fprintln(opts.out)
+		return
+	}
+	fmt.Fprintf(opts.out, "curl equivalent: curl %s\n", shellQuote(eq.args(url, opts)))
+	if eq.differs != "" {
+		fmt.Fprintf(opts.out, "  (approximate: %s)\n", eq.differs)
+	}
+	fmt.Fprintln(opts.out)
+}
+
+// runCurlComparison sends each pattern built by request() having an equivalent curl command with Go and with curl to the same capture server,
+// and diffs curl's request against Go's: the request line, headers and their order, framing, chunk sizes and Expect handling.
+func (s *session) runCurlComparison(filename string) error {
+	curl, err := exec.LookPath("curl")
+	if err != nil {
+		return fmt.Errorf("curl is needed to compare against: %w", err)
+	}
+	version, _ := exec.Command(curl, "--version").Output()
+	version, _, _ = bytes.Cut(version, []byte("\n"))
+
+	captures := make(chan capturedRequest, 1)
+	url, stop, err := s.startEphemeralServer(func() ServerBehavior { return BaseBehavior{} }, captures, true)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	fmt.Fprintf(s.out, "Comparing against %s\n\n", version)
+	for p := reqSinglePartWithLen; p < reqPatternBound; p++ {
+		eq, ok := curlEquivalents[p]
+		opts := runOptions{session: s, filename: filename, target: url, quiet: true}
+		if !ok || eq.differs != "" || checkSweepable(p, opts) != nil {
+			continue
+		}
+		fmt.Fprintf(s.out, "Request pattern: %v\n", p)
+
+		if _, err := request(p, opts); err != nil {
+			return err
+		}
+		goReq := <-captures
+
+		args := eq.args(url, opts)
+		start := time.Now()
+		curlOut, err := exec.Command(curl, append([]string{"-sS", "-o", os.DevNull}, args...)...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("curl failed: %w: %s", err, curlOut)
+		}
+		elapsed := time.Since(start)
+		curlReq := <-captures
+
+		fmt.Fprintf(s.out, "curl %s\n", shellQuote(args))
+		fmt.Fprintln(s.out, "Go -> curl:")
+		s.printRequestDiff(goReq.raw, curlReq.raw)
+		fmt.Fprintf(s.out, "  header order:\n    Go:   %s\n    curl: %s\n", strings.Join(headerOrder(goReq.raw), ", "), strings.Join(headerOrder(curlReq.raw), ", "))
+		fmt.Fprintf(s.out, "  framing: Go %s, curl %s\n", framingHeaders(goReq.req), framingHeaders(curlReq.req))
+		if len(goReq.req.TransferEncoding) > 0 || len(curlReq.req.TransferEncoding) > 0 {
+			fmt.Fprintf(s.out, "  chunk sizes: Go %s, curl %s\n", describeChunkSizes(goReq), describeChunkSizes(curlReq))
+		}
+		if curlReq.req.Header.Get("Expect") != "" {
+			fmt.Fprintf(s.out, "  curl sent Expect: %s and waited for 100 Continue, which this server never sends (curl took %v)\n", curlReq.req.Header.Get("Expect"), elapsed)
+		}
+		fmt.Fprintln(s.out)
+	}
+	return nil
+}
+
+// shellQuote joins args into a command line for POSIX shells, quoting ones with special characters.
+func shellQuote(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		if a != "" && strings.Trim(a, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./@=:") == "" {
+			quoted[i] = a
+		} else {
+			quoted[i] = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// headerOrder returns header names of the raw request in wire order.
+func headerOrder(raw []byte) []string {
+	_, fields, _ := parseRawHead(raw)
+	names := make([]string, 0, len(fields))
+	for _, f := range fields {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+// chunkSizes returns sizes of chunks in a chunked body on the wire, excluding the last (zero-sized) chunk.
+func chunkSizes(body []byte) []int {
+	var sizes []int
+	for {
+		i := bytes.Index(body, []byte("\r\n"))
+		if i < 0 {
+			return sizes
+		}
+		hex, _, _ := bytes.Cut(body[:i], []byte(";"))
+		n, err := strconv.ParseInt(strings.TrimSpace(string(hex)), 16, 64)
+		if err != nil || n == 0 || int(n)+2 > len(body[i+2:]) {
+			return sizes
+		}
+		sizes = append(sizes, int(n))
+		body = body[i+2+int(n)+2:]
+	}
+}
+
+// describeChunkSizes summarizes chunk sizes of a captured request as "count x size", run-length encoded.
+func describeChunkSizes(c capturedRequest) string {
+	sizes := chunkSizes(wireBody(c.raw))
+	if len(sizes) == 0 {
+		return "-"
+	}
+	var runs []string
+	for i := 0; i < len(sizes); {
+		j := i
+		for j < len(sizes) && sizes[j] == sizes[i] {
+			j++
+		}
+		runs = append(runs, fmt.Sprintf("%dx%d", j-i, sizes[i]))
+		i = j
+	}
+	return strings.Join(runs, " + ")
+}
@@ -0,0 +1,184 @@
+package observation
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+)
+
+// deadConnBehavior serves the first request on a connection, keeping it alive, then closes the connection on the next one without
+// responding. The server reads that request whole so that it's captured, but to the client it's as if the server had closed
+// the idle connection just before the request was written: it gets EOF instead of a response on a reused connection.
+type deadConnBehavior struct {
+	BaseBehavior
+	served int
+}
+
+func (b *deadConnBehavior) Respond(w io.Writer, _ *http.Request) (bool, error) {
+	b.served++
+	if b.served > 1 {
+		return false, nil
+	}
+	return true, writeResponse(w, http.StatusOK, nil, true)
+}
+
+// deadConnCase is a request sent on the pooled connection the server closes.
+type deadConnCase struct {
+	desc  string
+	build func(url string, data []byte, f *os.File) (*http.Request, error)
+}
+
+var deadConnCases = []deadConnCase{
+	{
+		desc: "GET without body",
+		build: func(url string, _ []byte, _ *os.File) (*http.Request, error) {
+			return http.NewRequest(http.MethodGet, url, nil)
+		},
+	},
+	{
+		desc: "PUT with *bytes.Reader (GetBody set by http.NewRequest)",
+		build: func(url string, data []byte, _ *os.File) (*http.Request, error) {
+			return http.NewRequest(http.MethodPut, url, bytes.NewReader(data))
+		},
+	},
+	{
+		desc: "PUT with *bytes.Reader and Idempotency-Key",
+		build: func(url string, data []byte, _ *os.File) (*http.Request, error) {
+			req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Idempotency-Key", "dead-conn-retry")
+			return req, nil
+		},
+	},
+	{
+		desc: "PUT with *os.File and Idempotency-Key (no GetBody)",
+		build: func(url string, _ []byte, f *os.File) (*http.Request, error) {
+			req, err := http.NewRequest(http.MethodPut, url, f)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Idempotency-Key", "dead-conn-retry")
+			return req, nil
+		},
+	},
+}
+
+// observeDeadConnRetry warms up a connection with a GET, then sends requests of deadConnCases on it after the server has closed it,
+// reporting whether the Transport retried each transparently on a new connection, whether GetBody was called to replay the body,
+// and how the retried request differs from the attempt on the dead connection.
+func observeDeadConnRetry(opts runOptions) (*timing, error) {
+	captures := make(chan capturedRequest, 3)
+	url, stop, err := opts.startEphemeralServer(func() ServerBehavior { return &deadConnBehavior{} }, captures, true)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	data, err := os.ReadFile(opts.filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	var first *timing
+	for _, c := range deadConnCases {
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		cli := &http.Client{Transport: tr}
+
+		warmUp, err := http.NewRequestWithContext(opts.ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+		if err := sendReqKeepingConn(cli, warmUp); err != nil {
+			return nil, err
+		}
+		<-captures
+
+		f, err := os.Open(opts.filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %w", err)
+		}
+		req, err := c.build(url, data, f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+		req = req.WithContext(opts.ctx)
+		getBodyCalls := 0
+		if getBody := req.GetBody; getBody != nil {
+			req.GetBody = func() (io.ReadCloser, error) {
+				getBodyCalls++
+				return getBody()
+			}
+		}
+		var reused []bool
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				reused = append(reused, info.Reused)
+			},
+		}
+		req, tm := traceTiming(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+		resp, reqErr := cli.Do(req)
+		if reqErr == nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+		tm.finish()
+		tr.CloseIdleConnections()
+		f.Close()
+		if first == nil {
+			first = tm
+		}
+
+		// the server captures requests before responding or closing, so they're all there once the client is done
+		var attempts []capturedRequest
+		for len(captures) > 0 {
+			attempts = append(attempts, <-captures)
+		}
+		if opts.quiet {
+			continue
+		}
+		fmt.Fprintf(opts.out, "[%s]\n", c.desc)
+		for i, r := range reused {
+			conn := "new connection"
+			if r {
+				conn = "reused pooled connection"
+			}
+			fmt.Fprintf(opts.out, "  attempt %d: %s\n", i+1, conn)
+		}
+		fmt.Fprintf(opts.out, "  GetBody set: %v, calls: %d\n", req.GetBody != nil, getBodyCalls)
+		if reqErr != nil {
+			fmt.Fprintf(opts.out, "  client: %v\n", reqErr)
+		} else {
+			fmt.Fprintf(opts.out, "  client: got %s\n", resp.Status)
+		}
+		if len(attempts) > 1 {
+			fmt.Fprintln(opts.out, "  attempt on the dead connection -> retried request:")
+			opts.printRequestDiff(attempts[0].raw, attempts[1].raw)
+			if n, m := int64(len(attempts[0].body))+attempts[0].bodyDropped, int64(len(attempts[1].body))+attempts[1].bodyDropped; n != m {
+				fmt.Fprintln(opts.out, "  => "+opts.finding(sevError, fmt.Sprintf("the retried request carried %d body bytes, the first attempt %d", m, n)))
+			}
+		}
+		switch {
+		case len(reused) > 1 && reqErr == nil:
+			fmt.Fprintln(opts.out, "  => "+opts.finding(sevInfo, "the Transport retried the request transparently on a new connection"))
+		case reqErr != nil:
+			fmt.Fprintln(opts.out, "  => "+opts.finding(sevWarn, "the Transport didn't retry, returning the error of the dead connection: only idempotent (GET, HEAD, OPTIONS, TRACE or with Idempotency-Key) requests with no body or GetBody are retried"))
+		}
+	}
+	return first, nil
+}
+
+// sendReqKeepingConn sends req and reads its response to the end, leaving the connection in the pool of cli.
+func sendReqKeepingConn(cli *http.Client, req *http.Request) error {
+	resp, err := cli.Do(req)
+	if err != nil {
+		return fmt.Errorf("HTTP request failed: %w", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
@@ -0,0 +1,83 @@
+package observation
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// sentRecordingConn records all bytes written to the connection.
+// It hides ReadFrom of the underlying connection, so that bodies sent with sendfile are recorded too.
+type sentRecordingConn struct {
+	net.Conn
+	rec *recorder
+}
+
+func (c *sentRecordingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.rec.write(p[:n])
+	return n, err
+}
+
+// withSentRecording returns a clone of tr whose connections record bytes written to them to rec.
+func withSentRecording(tr http.RoundTripper, rec *recorder) http.RoundTripper {
+	t := tr.(*http.Transport).Clone()
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &sentRecordingConn{Conn: conn, rec: rec}, nil
+	}
+	return t
+}
+
+// printDualCapture byte-diffs what the client wrote to the connection against what the capture server read from it.
+// The server may have read only a prefix (the default server stops after -capture-bytes) or kept only a prefix in memory,
+// in which case only that prefix is compared.
+func (s *session) printDualCapture(sent []byte, received capturedRequest) {
+	got := received.raw
+	total := int64(len(got)) + received.rawDropped
+	fmt.Fprintf(s.out, "Dual capture: client wrote %d bytes, server read %d bytes\n", len(sent), total)
+
+	n := len(sent)
+	if len(got) < n {
+		n = len(got)
+	}
+	for i := 0; i < n; i++ {
+		if sent[i] != got[i] {
+			fmt.Fprintln(s.out, "  => "+s.finding(sevError, fmt.Sprintf("bytes read by the server differ from bytes written by the client at offset %d", i)))
+			// redacted as whole messages, as the bytes around i may not include the name of the header they're in.
+			// Redaction preserves lengths, so i stays the offset of the divergence
+			fmt.Fprintf(s.out, "  client: %q\n  server: %q\n", around(s.redact(sent), i), around(s.redact(got), i))
+			return
+		}
+	}
+	switch {
+	case int64(len(sent)) == total && len(got) == len(sent):
+		fmt.Fprintln(s.out, "  = identical")
+	case total < int64(len(sent)):
+		fmt.Fprintf(s.out, "  = the %d bytes the server read are identical to the start of what the client wrote; the rest wasn't read\n", n)
+	case total > int64(len(sent)):
+		fmt.Fprintln(s.out, "  => "+s.finding(sevError, fmt.Sprintf("server read %d bytes more than the client wrote", total-int64(len(sent)))))
+	default:
+		fmt.Fprintf(s.out, "  = same length, the first %d bytes (kept in memory) are identical\n", n)
+	}
+}
+
+// around returns up to 16 bytes of b on each side of offset i.
+func around(b []byte, i int) []byte {
+	from, to := i-16, i+16
+	if from < 0 {
+		from = 0
+	}
+	if to > len(b) {
+		to = len(b)
+	}
+	return b[from:to]
+}
@@ -0,0 +1,24 @@
+package observation
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestPrintDualCaptureRedacts checks that the bytes printed around a divergence have values of redacted headers masked.
+func TestPrintDualCaptureRedacts(t *testing.T) {
+	var out bytes.Buffer
+	s := newSession(&out)
+	s.headerRedaction = newHeaderRedaction("Authorization")
+	sent := []byte("PUT / HTTP/1.1\r\nHost: localhost\r\nAuthorization: Bearer secret-token\r\n\r\nbody")
+	got := []byte("PUT / HTTP/1.1\r\nHost: localhost\r\nAuthorization: Bearer secret-tokeX\r\n\r\nbody")
+	s.printDualCapture(sent, capturedRequest{raw: got})
+
+	if !strings.Contains(out.String(), "differ from bytes written by the client at offset") {
+		t.Fatalf("divergence isn't reported:\n%s", out.String())
+	}
+	if strings.Contains(out.String(), "secret") {
+		t.Errorf("value of a redacted header is printed:\n%s", out.String())
+	}
+}
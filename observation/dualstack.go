@@ -0,0 +1,232 @@
+package observation
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// hostname resolved by the fake DNS server to blackholeIPv6 and loopback IPv4
+	dualStackHost = "dualstack.test"
+	// how long a connect attempt to blackholeIPv6 hangs before failing, like a SYN that's never answered
+	blackholeTimeout = 2 * time.Second
+)
+
+// address in the discard-only prefix (RFC 6666)
+var blackholeIPv6 = net.ParseIP("100::1")
+
+// startFakeDNS starts a DNS server over UDP answering A and AAAA queries for any name with v4 and v6 respectively.
+func startFakeDNS(v4, v6 net.IP) (addr string, stop func(), err error) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to start fake DNS server: %w", err)
+	}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if resp := fakeDNSResponse(buf[:n], v4, v6); resp != nil {
+				_, _ = pc.WriteTo(resp, from)
+			}
+		}
+	}()
+	return pc.LocalAddr().String(), func() { _ = pc.Close() }, nil
+}
+
+// fakeDNSResponse builds a response to the DNS query q, which has a single A or AAAA record answering the question.
+func fakeDNSResponse(q []byte, v4, v6 net.IP) []byte {
+	const (
+		typeA    = 1
+		typeAAAA = 28
+	)
+	if len(q) < 12 {
+		return nil
+	}
+	// skip labels of QNAME
+	i := 12
+	for i < len(q) && q[i] != 0 {
+		i += int(q[i]) + 1
+	}
+	qend := i + 5 // terminating zero, QTYPE and QCLASS
+	if qend > len(q) {
+		return nil
+	}
+
+	var rdata []byte
+	switch binary.BigEndian.Uint16(q[i+1:]) {
+	case typeA:
+		rdata = v4.To4()
+	case typeAAAA:
+		rdata = v6.To16()
+	}
+
+	resp := []byte{
+		q[0], q[1], // ID
+		0x81, 0x80, // QR, RD, RA, NOERROR
+		0, 1, // QDCOUNT
+		0, 0, // ANCOUNT
+		0, 0, // NSCOUNT
+		0, 0, // ARCOUNT
+	}
+	resp = append(resp, q[12:qend]...)
+	if rdata != nil {
+		resp[7] = 1
+		resp = append(resp, 0xc0, 12) // pointer to QNAME
+		resp = append(resp, q[i+1:qend]...)
+		resp = binary.BigEndian.AppendUint32(resp, 60) // TTL
+		resp = binary.BigEndian.AppendUint16(resp, uint16(len(rdata)))
+		resp = append(resp, rdata...)
+	}
+	return resp
+}
+
+// emulateBlackhole returns a Dialer.Control hanging connect attempts to blackholeIPv6 for blackholeTimeout, then failing them.
+// It makes the experiment independent of whether the host has IPv6 routes (without them, connect fails immediately).
+// pending tracks attempts still hanging, which may outlive the dial if another attempt wins the race.
+func emulateBlackhole(pending *sync.WaitGroup) func(network, address string, _ syscall.RawConn) error {
+	return func(network, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if net.ParseIP(host).Equal(blackholeIPv6) {
+			pending.Add(1)
+			defer pending.Done()
+
+			time.Sleep(blackholeTimeout)
+			return fmt.Errorf("connect to %s timed out (emulated black hole)", address)
+		}
+		return nil
+	}
+}
+
+// runHappyEyeballs resolves a hostname to a black-holed IPv6 address and a working IPv4 address,
+// then sends a request with several Dialer.FallbackDelay settings, reporting connect attempts, the fallback delay and which address carried the request.
+func (s *session) runHappyEyeballs() error {
+	dnsAddr, stopDNS, err := startFakeDNS(net.IPv4(127, 0, 0, 1), blackholeIPv6)
+	if err != nil {
+		return err
+	}
+	defer stopDNS()
+
+	url, stop, err := s.startEphemeralServer(func() ServerBehavior { return BaseBehavior{} }, nil, true)
+	if err != nil {
+		return err
+	}
+	defer stop()
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(url, "http://"))
+	url = fmt.Sprintf("http://%s:%s/", dualStackHost, port)
+
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "udp", dnsAddr)
+		},
+	}
+
+	fmt.Fprintf(s.out, "Happy Eyeballs: %s resolves to %v (black hole) and 127.0.0.1\n", dualStackHost, blackholeIPv6)
+	fmt.Fprintf(s.out, "connect attempts to %v hang for %v, then fail\n\n", blackholeIPv6, blackholeTimeout)
+
+	for _, fallback := range []time.Duration{0, 50 * time.Millisecond, time.Second, -1} {
+		desc := fallback.String()
+		switch {
+		case fallback == 0:
+			desc = "0 (default: 300ms)"
+		case fallback < 0:
+			desc = "negative (Happy Eyeballs disabled)"
+		}
+		fmt.Fprintf(s.out, "FallbackDelay = %s\n", desc)
+
+		var pending sync.WaitGroup
+		dialer := &net.Dialer{Resolver: resolver, FallbackDelay: fallback, Control: emulateBlackhole(&pending)}
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.Proxy = nil
+		tr.DialContext = dialer.DialContext
+
+		if err := s.observeDualStackDial(tr, url); err != nil {
+			return err
+		}
+		// let abandoned attempts finish, so they don't mix with the next setting
+		pending.Wait()
+		tr.CloseIdleConnections()
+		fmt.Fprintln(s.out)
+	}
+	return nil
+}
+
+func (s *session) observeDualStackDial(tr *http.Transport, url string) error {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	var (
+		mu             sync.Mutex
+		start                        = time.Now()
+		first4, first6 time.Duration = -1, -1
+		remote         net.Addr
+		ipv4First      bool
+	)
+	trace := &httptrace.ClientTrace{
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			fmt.Fprintf(s.out, "  %10v  resolved (in the order sorted by RFC 6724 address selection): %v\n", time.Since(start), info.Addrs)
+			if len(info.Addrs) > 0 && info.Addrs[0].IP.To4() != nil {
+				ipv4First = true
+			}
+		},
+		ConnectStart: func(network, addr string) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			at := time.Since(start)
+			host, _, _ := net.SplitHostPort(addr)
+			if net.ParseIP(host).To4() != nil {
+				if first4 < 0 {
+					first4 = at
+				}
+			} else if first6 < 0 {
+				first6 = at
+			}
+			fmt.Fprintf(s.out, "  %10v  connect start: %s %s\n", at, network, addr)
+		},
+		ConnectDone: func(network, addr string, err error) {
+			res := "ok"
+			if err != nil {
+				res = err.Error()
+			}
+			fmt.Fprintf(s.out, "  %10v  connect done:  %s %s: %s\n", time.Since(start), network, addr, res)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			remote = info.Conn.RemoteAddr()
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	resp, err := (&http.Client{Transport: tr}).Do(req)
+	if err != nil {
+		return fmt.Errorf("HTTP request failed: %w", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	fmt.Fprintf(s.out, "  => request carried by the connection to %v, %v after start\n", remote, time.Since(start))
+	switch {
+	case ipv4First:
+		fmt.Fprintln(s.out, "  => IPv4 was sorted first on this host (e.g. no global IPv6 source address), so it was dialed as the primary and FallbackDelay doesn't matter")
+	case first4 >= 0 && first6 >= 0:
+		fmt.Fprintf(s.out, "  => IPv4 attempt started %v after the IPv6 attempt\n", first4-first6)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package observation
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// variants of an empty PUT body
+var emptyBodyCases = []struct {
+	desc string
+	body func() io.Reader
+}{
+	{"http.NoBody", func() io.Reader { return http.NoBody }},
+	{"nil", func() io.Reader { return nil }},
+	{"empty *bytes.Reader", func() io.Reader { return bytes.NewReader(nil) }},
+	{"empty io.Reader of unknown length", func() io.Reader { return io.MultiReader() }},
+}
+
+// observeEmptyBodies sends PUTs and GETs with empty bodies given in various ways, and reports what http.NewRequest made of them
+// and which framing header, if any, went on the wire.
+func observeEmptyBodies(opts runOptions) (*timing, error) {
+	captures := make(chan capturedRequest, 1)
+	url, stop, err := opts.startEphemeralServer(func() ServerBehavior { return BaseBehavior{} }, captures, true)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	var first *timing
+	for _, c := range emptyBodyCases {
+		var built string
+		framing := make(map[string]string)
+		for _, method := range []string{http.MethodPut, http.MethodGet} {
+			req, err := http.NewRequestWithContext(opts.ctx, method, url, c.body())
+			if err != nil {
+				return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+			}
+			built = fmt.Sprintf("ContentLength %d, Body %s", req.ContentLength, describeBody(req.Body))
+
+			req, tm := traceTiming(req)
+			err = opts.sendReq(http.DefaultTransport, req)
+			tm.finish()
+			if err != nil {
+				return nil, err
+			}
+			if first == nil {
+				first = tm
+			}
+			framing[method] = framingHeaders((<-captures).req)
+		}
+
+		if opts.quiet {
+			continue
+		}
+		fmt.Fprintf(opts.out, "[body: %s]\n", c.desc)
+		fmt.Fprintf(opts.out, "  http.NewRequest: %s\n", built)
+		fmt.Fprintf(opts.out, "  framing on the wire: PUT %s, GET %s\n", framing[http.MethodPut], framing[http.MethodGet])
+	}
+	return first, nil
+}
+
+// describeBody describes Request.Body for reports.
+func describeBody(body io.ReadCloser) string {
+	switch body {
+	case nil:
+		return "nil"
+	case http.NoBody:
+		return "http.NoBody"
+	}
+	return fmt.Sprintf("%T", body)
+}
@@ -0,0 +1,942 @@
+package observation
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"httpcli-contentlen-example/observe"
+)
+
+type reqPattern int
+
+const (
+	reqSinglePartWithLen reqPattern = iota + 1
+	reqSinglePartWithoutLen
+	reqSinglePartWithLen_wrong
+	reqSinglePartWithBuffer
+	reqSinglePartExplicitlyChunked
+	reqMultipart
+	reqSinglePartSeekerRewind
+	reqSinglePartReqClose
+	reqSinglePartDisableKeepAlives
+	reqConditionalGet
+	reqPathNormalization
+	reqHugeResponseHeaders
+	reqSinglePartWrappedBody
+	reqProxyConnectHeader
+	reqMTLSCertRotation
+	reqH2Faults
+	reqJSONMarshaled
+	reqJSONEncodedPipe
+	reqConditionalPut
+	reqAuthChange
+	reqWriteVsWriteProxy
+	reqStuckBody
+	reqUpgradeTLS
+	reqHTTP10
+	reqExpectContinue
+	reqGetWithBody
+	reqDeleteWithBody
+	reqEmptyBody
+	reqSinglePartWithBytesReader
+	reqSinglePartWithStringsReader
+	reqSinglePartWithPipe
+	reqSinglePartUnknownLen
+	reqMultipartPipe
+	reqMultipartWithLen
+	reqURLEncodedForm
+	reqGzipBuffered
+	reqGzipStreamed
+	reqTrailer
+	reqSinglePartChunkedHeader
+	reqSinglePartLenAndChunked
+	reqSinglePartLenTooSmall
+	reqSinglePartLenTooLarge
+	reqSinglePartLenReader
+	reqSinglePartSeekReader
+	reqSinglePartLimitReader
+	reqDeadConnRetry
+	reqRedirectReplay
+	reqGetBodyRedirect
+	reqHeaderCanonicalization
+	reqHostField
+	reqHostHeader
+	reqUADefault
+	reqUAOverride
+	reqUASuppressed
+	reqPatternBound // sentinel value ending built-in patterns, from which custom patterns are numbered
+)
+
+func (p reqPattern) String() string {
+	switch p {
+	case reqSinglePartWithLen:
+		return "single-part with Content-Length"
+	case reqSinglePartWithoutLen:
+		return "single-part without Content-Length"
+	case reqSinglePartWithLen_wrong:
+		return "single-part with wrong Content-Length (setting the header directly)"
+	case reqSinglePartWithBuffer:
+		return "single-part without Content-Length, using *byets.Buffer"
+	case reqSinglePartExplicitlyChunked:
+		return "single-part using *bytes.Buffer, setting 'Transfer-Encding: chunked' explicitly"
+	case reqMultipart:
+		return "multipart"
+	case reqSinglePartSeekerRewind:
+		return "single-part with io.ReadSeeker body, rewound and resent by an auth RoundTripper after 401"
+	case reqSinglePartReqClose:
+		return "single-part with Request.Close = true, followed by a plain request"
+	case reqSinglePartDisableKeepAlives:
+		return "single-part with Transport.DisableKeepAlives = true, sending 2 requests"
+	case reqConditionalGet:
+		return "conditional GETs (If-None-Match, If-Modified-Since) revalidating a resource with Cache-Control/ETag/Last-Modified"
+	case reqPathNormalization:
+		return "GETs to paths with dot segments, duplicate slashes and empty path"
+	case reqHugeResponseHeaders:
+		return "single-part with Content-Length, receiving response headers exceeding Transport.MaxResponseHeaderBytes"
+	case reqSinglePartWrappedBody:
+		return "single-part with the body wrapped in io.TeeReader and hashing readers, compared with the bare *bytes.Reader"
+	case reqProxyConnectHeader:
+		return "single-part to an HTTPS origin through a CONNECT proxy, with proxy credentials in Transport.ProxyConnectHeader, Request.Header or the proxy URL"
+	case reqMTLSCertRotation:
+		return "single-part over mTLS, rotating the client certificate returned by GetClientCertificate between requests"
+	case reqH2Faults:
+		return "single-part over HTTP/2, with RST_STREAM or GOAWAY injected by the server mid-upload"
+	case reqJSONMarshaled:
+		return "JSON marshaled into bytes.Buffer"
+	case reqJSONEncodedPipe:
+		return "JSON encoded by json.Encoder into io.Pipe"
+	case reqConditionalPut:
+		return "conditional PUT (If-Match / If-Unmodified-Since), with 412 replied before or after the body"
+	case reqAuthChange:
+		return "single-part with Authorization changed between requests on a pooled connection"
+	case reqWriteVsWriteProxy:
+		return "single-part serialized directly with Request.Write vs Request.WriteProxy"
+	case reqStuckBody:
+		return "single-part whose body reader blocks forever after the headers are sent"
+	case reqUpgradeTLS:
+		return "in-band upgrade of a plaintext connection to TLS with Upgrade: TLS/1.2"
+	case reqHTTP10:
+		return "single-part with Request.Proto pinned to HTTP/1.0"
+	case reqExpectContinue:
+		return "single-part with Expect: 100-continue, to servers sending 100 Continue, replying 417 or staying silent"
+	case reqGetWithBody:
+		return "GET with a body, with and without Request.ContentLength"
+	case reqDeleteWithBody:
+		return "DELETE with a body, with and without Request.ContentLength"
+	case reqEmptyBody:
+		return "PUT and GET with http.NoBody, a nil body and empty readers"
+	case reqSinglePartWithBytesReader:
+		return "single-part without Content-Length, using *bytes.Reader"
+	case reqSinglePartWithStringsReader:
+		return "single-part without Content-Length, using *strings.Reader"
+	case reqSinglePartWithPipe:
+		return "single-part streamed through io.Pipe by a producer goroutine"
+	case reqSinglePartUnknownLen:
+		return "single-part with ContentLength = -1 (unknown) set explicitly"
+	case reqMultipartPipe:
+		return "multipart streamed through io.Pipe"
+	case reqMultipartWithLen:
+		return "multipart with Content-Length computed up front"
+	case reqURLEncodedForm:
+		return "application/x-www-form-urlencoded form, as sent by http.PostForm"
+	case reqGzipBuffered:
+		return "single-part compressed with gzip into bytes.Buffer, with Content-Encoding: gzip"
+	case reqGzipStreamed:
+		return "single-part compressed with gzip through io.Pipe, with Content-Encoding: gzip"
+	case reqTrailer:
+		return "single-part with a SHA-256 checksum of the body sent in a trailer (Request.Trailer)"
+	case reqSinglePartChunkedHeader:
+		return "single-part using *bytes.Buffer, setting 'Transfer-Encoding: chunked' in Request.Header (incorrectly)"
+	case reqSinglePartLenAndChunked:
+		return "single-part with both ContentLength and TransferEncoding = chunked set"
+	case reqSinglePartLenTooSmall:
+		return "single-part setting ContentLength field to half the size of the body"
+	case reqSinglePartLenTooLarge:
+		return "single-part setting ContentLength field to double the size of the body"
+	case reqSinglePartLenReader:
+		return "single-part with a custom reader implementing Len()"
+	case reqSinglePartSeekReader:
+		return "single-part with a custom reader implementing io.Seeker"
+	case reqSinglePartLimitReader:
+		return "single-part sending the first half of the file through io.LimitReader"
+	case reqDeadConnRetry:
+		return "requests sent on a pooled keep-alive connection the server has closed, retried or not by the Transport"
+	case reqRedirectReplay:
+		return "single-part redirected with 307/308 to a second server, replaying the body with and without GetBody"
+	case reqGetBodyRedirect:
+		return "single-part streaming *os.File with Request.GetBody set by hand, redirected with 307/308 to a second server"
+	case reqHeaderCanonicalization:
+		return "GET with headers of unusual casing, set with Set/Add, assigned to the map directly and with multiple values"
+	case reqHostField:
+		return "GET setting Request.Host to a host different from the URL host"
+	case reqHostHeader:
+		return "GET setting a Host header in Request.Header instead of Request.Host"
+	case reqUADefault:
+		return "GET leaving User-Agent to the Transport's default"
+	case reqUAOverride:
+		return "GET setting User-Agent in Request.Header"
+	case reqUASuppressed:
+		return "GET setting User-Agent to the empty string to suppress it"
+	default:
+		return ""
+	}
+}
+
+// Name returns the short name of the pattern, used to select it from the command line.
+func (p reqPattern) Name() string {
+	switch p {
+	case reqSinglePartWithLen:
+		return "with-len"
+	case reqSinglePartWithoutLen:
+		return "without-len"
+	case reqSinglePartWithLen_wrong:
+		return "wrong-len-header"
+	case reqSinglePartWithBuffer:
+		return "buffer"
+	case reqSinglePartExplicitlyChunked:
+		return "explicit-chunked"
+	case reqMultipart:
+		return "multipart"
+	case reqSinglePartSeekerRewind:
+		return "seeker-rewind"
+	case reqSinglePartReqClose:
+		return "req-close"
+	case reqSinglePartDisableKeepAlives:
+		return "disable-keep-alives"
+	case reqConditionalGet:
+		return "conditional-get"
+	case reqPathNormalization:
+		return "path-normalization"
+	case reqHugeResponseHeaders:
+		return "huge-response-headers"
+	case reqSinglePartWrappedBody:
+		return "wrapped-body"
+	case reqProxyConnectHeader:
+		return "proxy-connect-header"
+	case reqMTLSCertRotation:
+		return "mtls-cert-rotation"
+	case reqH2Faults:
+		return "h2-faults"
+	case reqJSONMarshaled:
+		return "json-marshaled"
+	case reqJSONEncodedPipe:
+		return "json-encoded-pipe"
+	case reqConditionalPut:
+		return "conditional-put"
+	case reqAuthChange:
+		return "auth-change"
+	case reqWriteVsWriteProxy:
+		return "write-vs-write-proxy"
+	case reqStuckBody:
+		return "stuck-body"
+	case reqUpgradeTLS:
+		return "upgrade-tls"
+	case reqHTTP10:
+		return "http10"
+	case reqExpectContinue:
+		return "expect-continue"
+	case reqGetWithBody:
+		return "get-with-body"
+	case reqDeleteWithBody:
+		return "delete-with-body"
+	case reqEmptyBody:
+		return "empty-body"
+	case reqSinglePartWithBytesReader:
+		return "bytes-reader"
+	case reqSinglePartWithStringsReader:
+		return "strings-reader"
+	case reqSinglePartWithPipe:
+		return "pipe"
+	case reqSinglePartUnknownLen:
+		return "unknown-len"
+	case reqMultipartPipe:
+		return "multipart-pipe"
+	case reqMultipartWithLen:
+		return "multipart-with-len"
+	case reqURLEncodedForm:
+		return "urlencoded-form"
+	case reqGzipBuffered:
+		return "gzip-buffered"
+	case reqGzipStreamed:
+		return "gzip-streamed"
+	case reqTrailer:
+		return "trailer"
+	case reqSinglePartChunkedHeader:
+		return "chunked-header"
+	case reqSinglePartLenAndChunked:
+		return "len-and-chunked"
+	case reqSinglePartLenTooSmall:
+		return "len-too-small"
+	case reqSinglePartLenTooLarge:
+		return "len-too-large"
+	case reqSinglePartLenReader:
+		return "len-reader"
+	case reqSinglePartSeekReader:
+		return "seek-reader"
+	case reqSinglePartLimitReader:
+		return "limit-reader"
+	case reqDeadConnRetry:
+		return "dead-conn-retry"
+	case reqRedirectReplay:
+		return "redirect-replay"
+	case reqGetBodyRedirect:
+		return "getbody-redirect"
+	case reqHeaderCanonicalization:
+		return "header-case"
+	case reqHostField:
+		return "host-field"
+	case reqHostHeader:
+		return "host-header"
+	case reqUADefault:
+		return "ua-default"
+	case reqUAOverride:
+		return "ua-override"
+	case reqUASuppressed:
+		return "ua-suppressed"
+	default:
+		return ""
+	}
+}
+
+func (p reqPattern) NeedsLen() bool {
+	return p == reqSinglePartWithLen || p == reqSinglePartWithLen_wrong || p == reqMultipartWithLen || p == reqSinglePartLenAndChunked ||
+		p == reqSinglePartLimitReader
+}
+
+// listenerURL returns base, the URL of the capture server, with the port of l, or base as is if l doesn't listen on TCP (e.g. named pipes).
+func listenerURL(l net.Listener, base string) string {
+	a, ok := l.Addr().(*net.TCPAddr)
+	if !ok {
+		return base
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return base
+	}
+	u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(a.Port))
+	return u.String()
+}
+
+// options shared by all request patterns
+type runOptions struct {
+	*session
+
+	filename   string
+	trackClose bool
+	logWrites  bool
+	boundary   string          // multipart boundary. Random if empty
+	parts      []MultipartPart // parts of multipart bodies, in order. Just the file if empty
+	bodyDelay  time.Duration   // delay between writing headers and releasing the body to the Transport
+	hdrStages  bool            // diff headers as built, at RoundTrip and on the wire
+	stuckAfter time.Duration   // time after which requests with a stuck body are reported as not rescued
+	sent       *recorder       // records bytes the client wrote to the connection, if non-nil
+	response   bool            // print the response as the client received it
+	received   *recorder       // records the response as the client received it with response, if non-nil
+
+	target      string      // URL to send requests to instead of serverURL, always on TCP
+	proxy       *url.URL    // forward proxy to send requests through, if non-nil
+	extraHeader http.Header // headers added to requests
+	quiet       bool        // suppress dumps of requests (for repeated runs of a pattern)
+	lifecycle   bool        // print httptrace timestamps of requests sent by sendReq
+	dialTrace   bool        // print connect attempts of requests sent by sendReq
+}
+
+func request(pat reqPattern, opts runOptions) (*timing, error) {
+	f, err := os.Open(opts.filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	req, err := buildPatternRequest(pat, f, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, vs := range opts.extraHeader {
+		req.Header[k] = append(req.Header[k], vs...)
+	}
+	if cl := req.Header.Get("Content-Length"); cl != "" && !opts.quiet {
+		fmt.Fprintln(opts.out, "=> "+opts.finding(sevWarn, fmt.Sprintf("user-set Content-Length: %s in Request.Header is ignored by the Transport; set Request.ContentLength instead", cl)))
+	}
+	if te := req.Header.Get("Transfer-Encoding"); te != "" && !opts.quiet {
+		fmt.Fprintln(opts.out, "=> "+opts.finding(sevWarn, fmt.Sprintf("user-set Transfer-Encoding: %s in Request.Header is ignored by the Transport; set Request.TransferEncoding instead", te)))
+	}
+	if req.ContentLength > 0 && len(req.TransferEncoding) > 0 && req.TransferEncoding[0] == "chunked" && !opts.quiet {
+		fmt.Fprintln(opts.out, "=> "+opts.finding(sevInfo, fmt.Sprintf("Request.ContentLength (%d) is ignored without an error, as Request.TransferEncoding is chunked", req.ContentLength)))
+	}
+	tr := opts.transport
+	if opts.target != "" {
+		u, err := url.Parse(opts.target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target URL: %w", err)
+		}
+		req.URL.Scheme, req.URL.Host, req.Host = u.Scheme, u.Host, u.Host
+		tr = http.DefaultTransport
+	}
+	// transports cloned here are closed once the request is done, so that servers finish serving their connections.
+	// The transport of the session is left alone, as observations may share its connections; the capture server closes them.
+	cloned := false
+	if opts.proxy != nil {
+		t := tr.(*http.Transport).Clone()
+		t.Proxy = http.ProxyURL(opts.proxy)
+		tr, cloned = t, true
+	}
+
+	req, tm := traceTiming(req)
+	req, interim := traceInterim(req)
+	var gate *bodyGate
+	if opts.bodyDelay > 0 {
+		req, gate = delayBody(req, opts.bodyDelay)
+	}
+	var (
+		tl   *timeline
+		body *closeTrackingBody
+	)
+	if opts.trackClose {
+		tl = newTimeline()
+		req, body = trackBodyClose(req, tl)
+	}
+
+	if opts.sent != nil {
+		tr, cloned = withSentRecording(tr, opts.sent), true
+	}
+	var wl *writeLog
+	if opts.logWrites {
+		tr, wl = withWriteLogging(tr)
+		cloned = true
+	}
+	var stages *headerStages
+	if opts.hdrStages {
+		tr, stages = traceHeaderStages(req, tr)
+		cloned = true
+	}
+	if cloned {
+		defer (&http.Client{Transport: tr}).CloseIdleConnections()
+	}
+
+	if opts.response {
+		err = opts.sendReqObservingResponse(tr, req, opts.received, opts.quiet)
+	} else {
+		err = opts.sendReq(tr, req)
+	}
+	tm.finish()
+	if !opts.quiet {
+		interim.print(opts.session)
+		if gate != nil {
+			gate.print(opts.out)
+		}
+		if wl != nil {
+			wl.print(opts.out)
+		}
+		if stages != nil {
+			stages.print(opts.session)
+		}
+	}
+
+	if opts.trackClose {
+		if err != nil {
+			tl.add("client: Do returned error")
+		} else {
+			tl.add("client: Do returned response")
+		}
+		if !opts.quiet {
+			opts.reportBodyClose(body, tl, err)
+		}
+	}
+	return tm, err
+}
+
+// buildPatternRequest builds the request of a pattern sent by request, uploading f.
+func buildPatternRequest(pat reqPattern, f *os.File, opts runOptions) (*http.Request, error) {
+	var size int
+	if pat.NeedsLen() {
+		stat, err := os.Stat(opts.filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat file: %w", err)
+		}
+		size = int(stat.Size())
+	}
+
+	var (
+		req *http.Request
+		err error
+	)
+	switch pat {
+	case reqSinglePartWithLen:
+		req, err = singlepartWithLen(opts.serverURL, f, size)
+	case reqSinglePartWithLen_wrong:
+		req, err = singlepartWithLen_wrong(opts.serverURL, f, size)
+	case reqSinglePartWithoutLen:
+		req, err = singlepartWithoutLen(opts.serverURL, f)
+	case reqSinglePartWithBuffer:
+		req, err = singlepartWithBuffer(opts.serverURL, f)
+	case reqSinglePartExplicitlyChunked:
+		req, err = singlepartExplicitlyChunked(opts.serverURL, f)
+	case reqMultipart:
+		req, err = opts.multipartReq(f, opts.filename, opts.parts, opts.boundary)
+	case reqSinglePartWithBytesReader:
+		req, err = singlepartWithBytesReader(opts.serverURL, f)
+	case reqSinglePartWithStringsReader:
+		req, err = singlepartWithStringsReader(opts.serverURL, f)
+	case reqSinglePartWithPipe:
+		req, err = singlepartWithPipe(opts.serverURL, f)
+	case reqSinglePartUnknownLen:
+		req, err = singlepartUnknownLen(opts.serverURL, f)
+	case reqMultipartPipe:
+		req, err = multipartPipeReq(opts.serverURL, f, opts.filename, opts.parts, opts.boundary)
+	case reqMultipartWithLen:
+		req, err = multipartWithLenReq(opts.serverURL, f, size, opts.filename, opts.parts, opts.boundary)
+	case reqURLEncodedForm:
+		req, err = urlEncodedFormReq(opts.serverURL, f, opts.filename, opts.parts)
+	case reqGzipBuffered:
+		req, err = gzipBufferedReq(opts.serverURL, f)
+	case reqGzipStreamed:
+		req, err = gzipStreamedReq(opts.serverURL, f)
+	case reqTrailer:
+		req, err = trailerReq(opts.serverURL, f)
+	case reqSinglePartChunkedHeader:
+		req, err = singlepartChunkedHeader(opts.serverURL, f)
+	case reqSinglePartLenAndChunked:
+		req, err = singlepartLenAndChunked(opts.serverURL, f, size)
+	case reqSinglePartLimitReader:
+		req, err = singlepartLimitReader(opts.serverURL, f, size/2)
+	case reqJSONMarshaled, reqJSONEncodedPipe:
+		var doc *jsonUploadDoc
+		if doc, err = readJSONUploadDoc(f, opts.filename); err != nil {
+			break
+		}
+		if pat == reqJSONMarshaled {
+			req, err = jsonMarshaledReq(opts.serverURL, doc)
+		} else {
+			req, err = jsonEncodedPipeReq(opts.serverURL, doc)
+		}
+	default:
+		if c, ok := opts.patterns.lookup(pat); ok {
+			req, err = c.build(f, opts)
+			break
+		}
+		err = fmt.Errorf("%v is an experiment of its own, not a request built by request()", pat)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if req.Context() == context.Background() {
+		// sent with the context of the Run, unless the pattern built the request with a context of its own
+		req = req.WithContext(opts.ctx)
+	}
+	return req, nil
+}
+
+// sendReq sends req with tr, discarding the response, and prints the connect attempts and the lifecycle of the request if opts asks for them.
+func (opts runOptions) sendReq(tr http.RoundTripper, req *http.Request) error {
+	if opts.dialTrace {
+		var d *dialAttempts
+		req, d = traceDial(req)
+		defer d.print(opts.session)
+	}
+	if opts.lifecycle {
+		var lc *lifecycle
+		req, lc = traceLifecycle(req)
+		defer lc.print(opts.session)
+	}
+	resp, err := (&http.Client{Transport: tr}).Do(req)
+	if err != nil {
+		return fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+	return nil
+}
+
+// single-part PUT request, setting ContentLength field explicitly
+func singlepartWithLen(serverURL string, body io.Reader, len int) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPut, serverURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.ContentLength = int64(len)
+	return req, nil
+}
+
+// single-part PUT request, setting Content-Length header directly (and incorrectly)
+func singlepartWithLen_wrong(serverURL string, body io.Reader, len int) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPut, serverURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Length", strconv.Itoa(len))
+	return req, nil
+}
+
+// single-part PUT request, without setting ContentLength field
+func singlepartWithoutLen(serverURL string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPut, serverURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	return req, nil
+}
+
+// single-part PUT request, setting ContentLength field to -1 to mark the length unknown explicitly
+func singlepartUnknownLen(serverURL string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPut, serverURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.ContentLength = -1
+	return req, nil
+}
+
+// single-part PUT request, setting both ContentLength field and TransferEncoding field to chunked, which conflict
+func singlepartLenAndChunked(serverURL string, body io.Reader, len int) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPut, serverURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.ContentLength = int64(len)
+	req.TransferEncoding = []string{"chunked"}
+	return req, nil
+}
+
+// single-part PUT request, sending only the first n bytes of the body through io.LimitReader, without setting ContentLength field
+func singlepartLimitReader(serverURL string, body io.Reader, n int) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPut, serverURL, io.LimitReader(body, int64(n)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	return req, nil
+}
+
+// single-part PUT request. Copy data to bytes.Buffer first, then send it without setting ContentLength field
+func singlepartWithBuffer(serverURL string, body io.Reader) (*http.Request, error) {
+	buf := new(bytes.Buffer)
+	_, _ = io.Copy(buf, body)
+
+	req, err := http.NewRequest(http.MethodPut, serverURL, buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	return req, nil
+}
+
+// single-part PUT request. Copy data to bytes.Buffer first, then send it with setting "Transfer-Encoding: chunked" explicitly
+func singlepartExplicitlyChunked(serverURL string, body io.Reader) (*http.Request, error) {
+	buf := new(bytes.Buffer)
+	_, _ = io.Copy(buf, body)
+
+	req, err := http.NewRequest(http.MethodPut, serverURL, buf)
+	req.TransferEncoding = []string{"chunked"}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	return req, nil
+}
+
+// single-part PUT request. Read data into []byte first, then send it via bytes.Reader without setting ContentLength field
+func singlepartWithBytesReader(serverURL string, body io.Reader) (*http.Request, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, serverURL, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	return req, nil
+}
+
+// single-part PUT request. Read data into string first, then send it via strings.Reader without setting ContentLength field
+func singlepartWithStringsReader(serverURL string, body io.Reader) (*http.Request, error) {
+	var sb strings.Builder
+	if _, err := io.Copy(&sb, body); err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, serverURL, strings.NewReader(sb.String()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	return req, nil
+}
+
+// size of writes by the producer of singlepartWithPipe, odd enough to be told apart from buffer sizes in chunk sizes on the wire
+const pipeWriteSize = 5000
+
+// single-part PUT request. A goroutine copies data into io.Pipe in pipeWriteSize writes, and the read end is sent as the body
+func singlepartWithPipe(serverURL string, body io.Reader) (*http.Request, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		buf := make([]byte, pipeWriteSize)
+		for {
+			n, err := io.ReadFull(body, buf)
+			if n > 0 {
+				// blocks until the Transport reads it all
+				if _, werr := pw.Write(buf[:n]); werr != nil {
+					return
+				}
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				_ = pw.Close()
+				return
+			}
+			if err != nil {
+				_ = pw.CloseWithError(err)
+				return
+			}
+		}
+	}()
+
+	req, err := http.NewRequest(http.MethodPut, serverURL, pr)
+	if err != nil {
+		_ = pr.Close()
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	return req, nil
+}
+
+// single-part PUT request. Copy data to bytes.Buffer first, then send it with setting "Transfer-Encoding: chunked" header directly (and incorrectly)
+func singlepartChunkedHeader(serverURL string, body io.Reader) (*http.Request, error) {
+	buf := new(bytes.Buffer)
+	_, _ = io.Copy(buf, body)
+
+	req, err := http.NewRequest(http.MethodPut, serverURL, buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Header.Set("Transfer-Encoding", "chunked")
+	return req, nil
+}
+
+// multipart request of parts. Boundary is random if empty
+func (s *session) multipartReq(body io.Reader, filename string, parts []MultipartPart, boundary string) (*http.Request, error) {
+	mb, err := newMultipartBody(parts, body, -1, filename, boundary)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, mb.reader()); err != nil {
+		return nil, fmt.Errorf("failed to read parts: %w", err)
+	}
+
+	fmt.Fprintln(s.out, buf.Len())
+	req, err := http.NewRequest(http.MethodPost, s.serverURL, &buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	return req, nil
+}
+
+// multipart request of parts streamed through io.Pipe, written by a goroutine as the Transport reads it. Boundary is random if empty
+func multipartPipeReq(serverURL string, body io.Reader, filename string, parts []MultipartPart, boundary string) (*http.Request, error) {
+	mb, err := newMultipartBody(parts, body, -1, filename, boundary)
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := io.Copy(pw, mb.reader())
+		_ = pw.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, serverURL, pr)
+	if err != nil {
+		_ = pr.Close()
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", mb.contentType)
+	return req, nil
+}
+
+// multipart request of parts with ContentLength set to the size of the part headers, form values, files and boundaries,
+// streaming files between the pre-rendered pieces. Boundary is random if empty
+func multipartWithLenReq(serverURL string, body io.Reader, size int, filename string, parts []MultipartPart, boundary string) (*http.Request, error) {
+	mb, err := newMultipartBody(parts, body, int64(size), filename, boundary)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, serverURL, mb.reader())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.ContentLength = mb.size
+	req.Header.Set("Content-Type", mb.contentType)
+	return req, nil
+}
+
+// url-encoded form POST request built as http.PostForm does, with the file base64-encoded in the "data" field
+// along with form fields of parts (files other than the body source are ignored)
+func urlEncodedFormReq(serverURL string, body io.Reader, filename string, parts []MultipartPart) (*http.Request, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+	form := url.Values{}
+	for _, p := range parts {
+		if p.Path == "" && !p.Body {
+			form.Add(p.Name, p.Value)
+		}
+	}
+	form.Set("name", filename)
+	form.Set("data", base64.StdEncoding.EncodeToString(data))
+
+	req, err := http.NewRequest(http.MethodPost, serverURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
+
+// single-part PUT request. Compress data with gzip into bytes.Buffer first, then send it with "Content-Encoding: gzip"
+func gzipBufferedReq(serverURL string, body io.Reader) (*http.Request, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := io.Copy(zw, body); err != nil {
+		return nil, fmt.Errorf("failed to compress body: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress body: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, serverURL, &buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Encoding", "gzip")
+	return req, nil
+}
+
+// single-part PUT request. A goroutine compresses data with gzip into io.Pipe, and the read end is sent with "Content-Encoding: gzip"
+func gzipStreamedReq(serverURL string, body io.Reader) (*http.Request, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		zw := gzip.NewWriter(pw)
+		_, err := io.Copy(zw, body)
+		if cerr := zw.Close(); err == nil {
+			err = cerr
+		}
+		_ = pw.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequest(http.MethodPut, serverURL, pr)
+	if err != nil {
+		_ = pr.Close()
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Encoding", "gzip")
+	return req, nil
+}
+
+// checksumTrailerReader computes SHA-256 of the body as it's read, setting it to the trailer at EOF
+type checksumTrailerReader struct {
+	r       io.Reader
+	h       hash.Hash
+	trailer http.Header
+}
+
+func (c *checksumTrailerReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.h.Write(p[:n])
+	if err == io.EOF {
+		c.trailer.Set("X-Content-Sha256", hex.EncodeToString(c.h.Sum(nil)))
+	}
+	return n, err
+}
+
+// single-part PUT request, declaring "X-Content-Sha256" in Trailer and sending the checksum computed while streaming the body in it
+func trailerReq(serverURL string, body io.Reader) (*http.Request, error) {
+	trailer := http.Header{"X-Content-Sha256": nil}
+	req, err := http.NewRequest(http.MethodPut, serverURL, &checksumTrailerReader{r: body, h: sha256.New(), trailer: trailer})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Trailer = trailer
+	return req, nil
+}
+
+/* server */
+func startServer(ip net.IP, port int) (*net.TCPListener, error) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: ip, Port: port})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start listening: %v", err)
+	}
+	return l, nil
+}
+
+// serve reads and logs first serverCaptureBytes of conn accepted by the capture server, then disconnects.
+// With CaptureAll, reads the whole request as framed instead, replying 200 before disconnecting.
+// Sends the captured bytes to captures if it's non-nil, before disconnecting.
+func (s *session) serve(conn net.Conn, captures chan<- capturedRequest, quiet bool) {
+	s.observer.Emit(&observe.ConnAccepted{RemoteAddr: conn.RemoteAddr().String()})
+	if err := s.handshakeCapture(conn, quiet); err != nil {
+		fmt.Fprintf(s.out, "server: %v\n", err)
+		conn.Close()
+		return
+	}
+
+	buf := &spillBuffer{limits: s.captureLimits, redactor: headRedactor{names: s.headerRedaction}}
+	defer buf.Reset()
+	var req *http.Request
+	if s.captureBytes == CaptureAll {
+		req = readWholeRequest(conn, buf)
+	} else {
+		_, _ = io.CopyN(buf, conn, s.captureBytes)
+	}
+	if captures != nil {
+		captures <- capturedRequest{raw: buf.Bytes(), req: req, rawDropped: buf.dropped(), spilled: buf.spilled()}
+	}
+	if req != nil {
+		_ = writeResponse(conn, http.StatusOK, nil, false)
+	}
+	conn.Close()
+
+	if quiet {
+		return
+	}
+	s.printRaw(buf.Bytes())
+	fmt.Fprintln(s.out)
+	fmt.Fprintln(s.out)
+	if f := buf.spilled(); f != "" {
+		fmt.Fprintf(s.out, "capture: %d bytes on the wire, the first %d kept in memory, the whole request streamed to %s\n", buf.n, len(buf.Bytes()), f)
+	} else if req != nil {
+		s.printRequestEnd(buf.Bytes(), req)
+	}
+	if s.headerSizes {
+		s.printHeaderSizeReport(buf.Bytes())
+	}
+	s.printMessageViolations(buf.Bytes())
+}
+
+// readWholeRequest reads a request from conn to the end of its body, writing the bytes read to w.
+// Returns nil if the request is malformed, leaving what was read in w.
+func readWholeRequest(conn net.Conn, w io.Writer) *http.Request {
+	req, err := http.ReadRequest(bufio.NewReader(io.TeeReader(conn, w)))
+	if err != nil {
+		return nil
+	}
+	if _, err := io.Copy(io.Discard, req.Body); err != nil {
+		return nil
+	}
+	return req
+}
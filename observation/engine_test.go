@@ -0,0 +1,38 @@
+package observation
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"strings"
+	"testing"
+)
+
+// TestSendReqReusesSharedTransport checks that sendReq leaves connections of the Transport it's given in its pool,
+// as patterns sharing a Transport across requests observe what happens on the reused connection.
+func TestSendReqReusesSharedTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	defer srv.Close()
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	defer tr.CloseIdleConnections()
+	opts := runOptions{session: newSession(io.Discard)}
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequest(http.MethodPut, srv.URL, strings.NewReader("body"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reused bool
+		trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused }}
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+		if err := opts.sendReq(tr, req); err != nil {
+			t.Fatal(err)
+		}
+		if want := i > 0; reused != want {
+			t.Errorf("request %d: connection reused = %v, want %v", i+1, reused, want)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package observation
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+)
+
+// statusSniffer passes writes through to w, remembering the status code of the first response written.
+type statusSniffer struct {
+	w      io.Writer
+	head   []byte
+	status int
+}
+
+func (s *statusSniffer) Write(p []byte) (int, error) {
+	if s.status == 0 && len(s.head) < 64 {
+		n := len(p)
+		if n > 64 {
+			n = 64
+		}
+		s.head = append(s.head, p[:n]...)
+		// "HTTP/1.1 200 OK\r\n"
+		if i := bytes.Index(s.head, []byte("\r\n")); i >= 0 {
+			if fields := bytes.Fields(s.head[:i]); len(fields) >= 2 {
+				s.status, _ = strconv.Atoi(string(fields[1]))
+			}
+		}
+	}
+	return s.w.Write(p)
+}
@@ -0,0 +1,197 @@
+package observation
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how expectBehavior answers requests with Expect: 100-continue
+type expectMode int
+
+const (
+	expectContinue expectMode = iota // send 100 Continue
+	expectReject                     // reply 417 Expectation Failed and close the connection
+	expectSilent                     // send nothing, waiting for the body
+)
+
+func init() {
+	RegisterServerBehavior("expect-continue", "send 100 Continue to requests with Expect: 100-continue, then reply 200 after reading the whole body", func() ServerBehavior {
+		return &expectBehavior{mode: expectContinue}
+	})
+	RegisterServerBehavior("expect-reject", "reply 417 Expectation Failed to requests with Expect: 100-continue right after reading headers, and close the connection", func() ServerBehavior {
+		return &expectBehavior{mode: expectReject}
+	})
+	RegisterServerBehavior("expect-silent", "send nothing to requests with Expect: 100-continue until the body arrives, then reply 200", func() ServerBehavior {
+		return &expectBehavior{mode: expectSilent}
+	})
+}
+
+var errExpectationFailed = errors.New("417 sent before the body to a request with Expect: 100-continue")
+
+// expectBehavior answers Expect: 100-continue as its mode says, recording when headers and the first piece of the body arrived.
+type expectBehavior struct {
+	BaseBehavior
+	mode expectMode
+	conn net.Conn
+
+	mu        sync.Mutex
+	headersAt time.Time
+	bodyAt    time.Time // zero until the body arrives
+	bodyRead  int
+}
+
+func (b *expectBehavior) OnAccept(conn net.Conn) error {
+	b.conn = conn
+	return nil
+}
+
+func (b *expectBehavior) OnHeaders(req *http.Request) error {
+	b.mu.Lock()
+	b.headersAt, b.bodyAt, b.bodyRead = time.Now(), time.Time{}, 0
+	b.mu.Unlock()
+	if !strings.EqualFold(req.Header.Get("Expect"), "100-continue") {
+		return nil
+	}
+	switch b.mode {
+	case expectContinue:
+		_, err := io.WriteString(b.conn, "HTTP/1.1 100 Continue\r\n\r\n")
+		return err
+	case expectReject:
+		if err := writeResponse(b.conn, http.StatusExpectationFailed, nil, false); err != nil {
+			return err
+		}
+		return errExpectationFailed
+	}
+	return nil
+}
+
+func (b *expectBehavior) OnBodyChunk(_ *http.Request, chunk []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.bodyAt.IsZero() {
+		b.bodyAt = time.Now()
+	}
+	b.bodyRead += len(chunk)
+	return nil
+}
+
+// bodyDelay returns how long after the headers the body started arriving, and how many bytes of it were read.
+func (b *expectBehavior) bodyDelay() (time.Duration, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.bodyAt.IsZero() {
+		return -1, b.bodyRead
+	}
+	return b.bodyAt.Sub(b.headersAt), b.bodyRead
+}
+
+// observeExpectContinue uploads the file with Expect: 100-continue to servers sending 100 Continue, replying 417 and staying silent,
+// and reports whether and when the client sent the body, as seen by both the client and the server.
+func observeExpectContinue(opts runOptions) (*timing, error) {
+	data, err := os.ReadFile(opts.filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	cases := []struct {
+		desc    string
+		mode    expectMode
+		timeout time.Duration // Transport.ExpectContinueTimeout
+	}{
+		{"server sends 100 Continue", expectContinue, time.Second},
+		{"server replies 417 Expectation Failed", expectReject, time.Second},
+		{"server stays silent", expectSilent, time.Second},
+		{"server stays silent", expectSilent, 0},
+	}
+
+	var first *timing
+	for _, c := range cases {
+		b := &expectBehavior{mode: c.mode}
+		url, stop, err := opts.startEphemeralServer(func() ServerBehavior { return b }, nil, true)
+		if err != nil {
+			return nil, err
+		}
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.ExpectContinueTimeout = c.timeout
+
+		req, err := http.NewRequestWithContext(opts.ctx, http.MethodPut, url, bytes.NewReader(data))
+		if err != nil {
+			stop()
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+		req.Header.Set("Expect", "100-continue")
+
+		var (
+			mu                                 sync.Mutex
+			start                              = time.Now()
+			wroteHeaders, got100, wroteRequest time.Duration
+			waited                             bool
+		)
+		trace := &httptrace.ClientTrace{
+			WroteHeaders:    func() { mu.Lock(); wroteHeaders = time.Since(start); mu.Unlock() },
+			Wait100Continue: func() { mu.Lock(); waited = true; mu.Unlock() },
+			Got100Continue:  func() { mu.Lock(); got100 = time.Since(start); mu.Unlock() },
+			WroteRequest: func(httptrace.WroteRequestInfo) {
+				mu.Lock()
+				wroteRequest = time.Since(start)
+				mu.Unlock()
+			},
+		}
+		req, tm := traceTiming(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+		resp, reqErr := (&http.Client{Transport: tr}).Do(req)
+		if reqErr == nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+		tm.finish()
+		tr.CloseIdleConnections()
+		stop()
+		if first == nil {
+			first = tm
+		}
+
+		if opts.quiet {
+			continue
+		}
+		fmt.Fprintf(opts.out, "[%s, ExpectContinueTimeout %v]\n", c.desc, c.timeout)
+		if reqErr != nil {
+			fmt.Fprintf(opts.out, "  client: request failed: %v\n", reqErr)
+		} else {
+			fmt.Fprintf(opts.out, "  client: %s in %v\n", resp.Status, tm.total)
+		}
+		mu.Lock()
+		fmt.Fprintf(opts.out, "  client: headers written at %v, waited for 100 Continue: %v", wroteHeaders, waited)
+		if got100 != 0 {
+			fmt.Fprintf(opts.out, ", got it at %v", got100)
+		}
+		if wroteRequest != 0 {
+			fmt.Fprintf(opts.out, ", request written at %v\n", wroteRequest)
+		} else {
+			fmt.Fprintln(opts.out, ", body not written")
+		}
+		mu.Unlock()
+
+		delay, n := b.bodyDelay()
+		if delay < 0 {
+			fmt.Fprintf(opts.out, "  server: no body received (%d bytes expected)\n", len(data))
+		} else {
+			fmt.Fprintf(opts.out, "  server: body started %v after the headers, %d of %d bytes received\n", delay, n, len(data))
+		}
+		if c.mode == expectSilent && c.timeout > 0 && delay >= c.timeout {
+			fmt.Fprintln(opts.out, "  => "+opts.finding(sevInfo, fmt.Sprintf("the client sent the body only after ExpectContinueTimeout (%v) passed without 100 Continue", c.timeout)))
+		}
+		if c.mode == expectReject && n > 0 {
+			fmt.Fprintln(opts.out, "  => "+opts.finding(sevWarn, "the client sent the body although the server rejected the expectation"))
+		}
+	}
+	return first, nil
+}
@@ -0,0 +1,178 @@
+package observation
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"httpcli-contentlen-example/observe"
+)
+
+// explainFromReader analyzes a single request serialized as raw HTTP/1.1 read from r (-explain -), e.g. by httputil.DumpRequestOut.
+// Its record is written to obsWriter if it's non-nil.
+func (s *session) explainFromReader(r io.Reader, obsWriter *observationWriter) error {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read request: %w", err)
+	}
+	// dumps often have bare LF line endings after being edited by hand.
+	// Mixed line endings are kept, to be reported as RFC 9112 violations
+	if !bytes.Contains(raw, []byte("\r\n")) {
+		raw = bytes.ReplaceAll(raw, []byte("\n"), []byte("\r\n"))
+	}
+	if obsWriter != nil {
+		if err := obsWriter.write(s.newObservation("explain", capturedRequest{raw: raw})); err != nil {
+			return err
+		}
+	}
+	return s.explainRequest(raw)
+}
+
+// explainFromNet analyzes requests arriving on l until maxRequests were analyzed, maxDuration passed (unlimited if zero)
+// or the context of the session is done, then stops accepting connections and returns.
+func (s *session) explainFromNet(l net.Listener, maxRequests int, maxDuration time.Duration, obsWriter *observationWriter) error {
+	fmt.Fprintf(s.out, "Waiting for requests on %v. Use observe.Transport in your program and run it with:\n", l.Addr())
+	fmt.Fprintf(s.out, "  %s=%v\n\n", observe.EnvVar, l.Addr())
+
+	captures := make(chan capturedRequest)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serveConn(conn, BaseBehavior{}, captures, true)
+		}
+	}()
+	defer l.Close()
+
+	var deadline <-chan time.Time
+	if maxDuration > 0 {
+		t := time.NewTimer(maxDuration)
+		defer t.Stop()
+		deadline = t.C
+	}
+
+	n := 0
+	for maxRequests == 0 || n < maxRequests {
+		select {
+		case c := <-captures:
+			n++
+			fmt.Fprintf(s.out, "[request %d]\n", n)
+			if obsWriter != nil {
+				if err := obsWriter.write(s.newObservation("explain", c)); err != nil {
+					return err
+				}
+			}
+			if err := s.explainRequest(c.raw); err != nil {
+				return err
+			}
+			fmt.Fprintln(s.out)
+		case <-deadline:
+			fmt.Fprintf(s.out, "Stopped after %v: %d request(s) analyzed\n", maxDuration, n)
+			return nil
+		case <-s.ctx.Done():
+			fmt.Fprintf(s.out, "Stopped as interrupted: %d request(s) analyzed\n", n)
+			return nil
+		}
+	}
+	fmt.Fprintf(s.out, "Stopped after %d request(s)\n", n)
+	return nil
+}
+
+// explainRequest prints an analysis of the raw request: its head, framing, body, header sizes and notes on how it was likely built.
+func (s *session) explainRequest(raw []byte) error {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		s.printMessageViolations(raw)
+		return fmt.Errorf("failed to parse request: %w", err)
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("failed to read request body: %w", err)
+	}
+	truncated := err != nil
+
+	line, fields, _ := parseRawHead(raw)
+	fmt.Fprintln(s.out, "Request line and headers (in wire order):")
+	fmt.Fprintf(s.out, "  %s\n", line)
+	for _, f := range fields {
+		fmt.Fprintf(s.out, "  %s: %s\n", f.Name, s.redactValue(f.Name, f.Value))
+	}
+	fmt.Fprintln(s.out)
+
+	fmt.Fprintf(s.out, "Framing: %s\n", framingHeaders(req))
+	fmt.Fprintf(s.out, "Body: %d bytes", len(body))
+	if len(req.TransferEncoding) > 0 {
+		fmt.Fprintf(s.out, " (%d bytes on the wire including chunked framing)", len(wireBody(raw)))
+	}
+	if truncated {
+		fmt.Fprint(s.out, ", "+s.finding(sevError, "TRUNCATED: fewer bytes than the framing announced"))
+	}
+	fmt.Fprintln(s.out)
+	fmt.Fprintln(s.out)
+	s.printHeaderSizeReport(raw)
+	fmt.Fprintln(s.out)
+	if vs := validateRequestMessage(raw); len(vs) > 0 {
+		s.printMessageViolations(raw)
+		fmt.Fprintln(s.out)
+	}
+
+	notes := s.explainNotes(req, body)
+	if len(notes) > 0 {
+		fmt.Fprintln(s.out, "Notes:")
+		for _, n := range notes {
+			fmt.Fprintf(s.out, "  - %s\n", n)
+		}
+	}
+	return nil
+}
+
+// explainNotes infers how the request was likely built by a Go client, and points out common pitfalls, as findings.
+func (s *session) explainNotes(req *http.Request, body []byte) []string {
+	var notes []string
+	switch {
+	case len(req.TransferEncoding) > 0:
+		notes = append(notes, s.finding(sevInfo, "chunked: the Transport didn't know the body length. http.NewRequest infers it only for *bytes.Buffer, *bytes.Reader and *strings.Reader; "+
+			"for other readers (including *os.File and wrapped readers) set Request.ContentLength, as Content-Length in Request.Header is ignored"))
+	case req.ContentLength > 0:
+		notes = append(notes, s.finding(sevInfo, "Content-Length: the body length was known, either inferred by http.NewRequest or set in Request.ContentLength"))
+	case len(body) == 0 && (req.Method == http.MethodPost || req.Method == http.MethodPut || req.Method == http.MethodPatch):
+		notes = append(notes, s.finding(sevInfo, fmt.Sprintf("%s without a body: Content-Length: 0 is sent only for these methods with a nil or http.NoBody body", req.Method)))
+	}
+	if len(body) > 0 && req.Header.Get("Content-Type") == "" {
+		notes = append(notes, s.finding(sevWarn, "the body has no Content-Type, so servers may have to sniff it"))
+	}
+	if ct := req.Header.Get("Content-Type"); strings.HasPrefix(ct, "multipart/") {
+		_, params, err := mime.ParseMediaType(ct)
+		switch {
+		case err != nil || params["boundary"] == "":
+			notes = append(notes, s.finding(sevError, "multipart Content-Type without a valid boundary parameter"))
+		case !bytes.HasPrefix(body, []byte("--"+params["boundary"])):
+			notes = append(notes, s.finding(sevError, "the body doesn't start with the boundary in Content-Type; was Content-Type taken from another multipart.Writer?"))
+		}
+	}
+	if req.Header.Get("Accept-Encoding") == "gzip" {
+		notes = append(notes, s.finding(sevInfo, "Accept-Encoding: gzip is typically added by the Transport for transparent decompression (Transport.DisableCompression turns it off)"))
+	}
+	if ua := req.Header.Get("User-Agent"); strings.HasPrefix(ua, "Go-http-client/") {
+		notes = append(notes, s.finding(sevInfo, "User-Agent is the Go default; set it explicitly to identify the application"))
+	}
+	if req.Close {
+		notes = append(notes, s.finding(sevInfo, "Connection: close: Request.Close or Transport.DisableKeepAlives is set, so the connection won't be reused"))
+	}
+	if req.Header.Get("Expect") == "100-continue" {
+		notes = append(notes, s.finding(sevInfo, "Expect: 100-continue: the Transport waits for Transport.ExpectContinueTimeout before sending the body"))
+	}
+	if req.Header.Get("Proxy-Authorization") != "" {
+		notes = append(notes, s.finding(sevError, "Proxy-Authorization reached the origin; for HTTPS through a proxy it belongs in Transport.ProxyConnectHeader"))
+	}
+	return notes
+}
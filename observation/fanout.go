@@ -0,0 +1,116 @@
+package observation
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fanOutResult is the outcome of a request sent to one of the fan-out targets.
+type fanOutResult struct {
+	target  string
+	tm      *timing
+	err     error
+	capture *capturedRequest
+}
+
+// observeFanOut sends the pattern to n capture servers concurrently, as a client replicating one logical request
+// across regions would, and reports per-target captures and timings in one report correlated by X-Fanout-Id.
+func observeFanOut(pat reqPattern, n int, newBehavior func() ServerBehavior, opts runOptions) (*timing, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate fan-out ID: %w", err)
+	}
+	fanOutID := hex.EncodeToString(id)
+
+	results := make([]fanOutResult, n)
+	captures := make([]chan capturedRequest, n)
+	for i := range results {
+		captures[i] = make(chan capturedRequest, 1)
+		url, stop, err := opts.startEphemeralServer(newBehavior, captures[i], true)
+		if err != nil {
+			return nil, err
+		}
+		defer stop()
+		results[i].target = url
+	}
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := range results {
+		wg.Add(1)
+		go func(r *fanOutResult) {
+			defer wg.Done()
+			o := opts
+			o.target = r.target
+			o.extraHeader = http.Header{"X-Fanout-Id": {fanOutID}}
+			o.quiet = true
+			r.tm, r.err = request(pat, o)
+		}(&results[i])
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	var (
+		first                *timing
+		fastest, slowest     time.Duration
+		failed, uncorrelated int
+	)
+	for i := range results {
+		r := &results[i]
+		select {
+		case c := <-captures[i]:
+			r.capture = &c
+		default:
+		}
+		if r.err != nil {
+			failed++
+			continue
+		}
+		if first == nil {
+			first = r.tm
+		}
+		if fastest == 0 || r.tm.total < fastest {
+			fastest = r.tm.total
+		}
+		if r.tm.total > slowest {
+			slowest = r.tm.total
+		}
+		if r.capture == nil || r.capture.req.Header.Get("X-Fanout-Id") != fanOutID {
+			uncorrelated++
+		}
+	}
+	if first == nil {
+		return nil, results[0].err
+	}
+	if opts.quiet {
+		return first, nil
+	}
+
+	fmt.Fprintf(opts.out, "Fan-out to %d targets (X-Fanout-Id: %s):\n", n, fanOutID)
+	fmt.Fprintf(opts.out, "  %-24s  %-28s  %-14s  %-15s  %-15s  %s\n", "target", "framing headers", "received", "headers written", "request written", "total")
+	for _, r := range results {
+		target := strings.TrimPrefix(r.target, "http://")
+		if r.err != nil {
+			fmt.Fprintf(opts.out, "  %-24s  failed: %v\n", target, r.err)
+			continue
+		}
+		framing, received := "-", "-"
+		if r.capture != nil {
+			framing, received = framingHeaders(r.capture.req), fmt.Sprintf("%d bytes", len(r.capture.body))
+		}
+		fmt.Fprintf(opts.out, "  %-24s  %-28s  %-14s  %-15v  %-15v  %v\n", target, framing, received, r.tm.wroteHeaders, r.tm.wroteRequest, r.tm.total)
+	}
+	fmt.Fprintf(opts.out, "=> all targets done in %v, spread between the fastest and the slowest: %v\n", elapsed, slowest-fastest)
+	if failed > 0 {
+		fmt.Fprintf(opts.out, "=> %d of %d targets failed\n", failed, n)
+	}
+	if uncorrelated > 0 {
+		fmt.Fprintf(opts.out, "=> %d targets didn't receive the request with the expected X-Fanout-Id\n", uncorrelated)
+	}
+	return first, nil
+}
@@ -0,0 +1,181 @@
+package observation
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// time stallBehavior holds a request without responding, after which it gives up and closes the connection
+	stallTimeout = 3 * time.Second
+	// response trickled by slowBehavior: slowPiece bytes every slowInterval
+	slowBodySize = 1024
+	slowPiece    = 64
+	slowInterval = 50 * time.Millisecond
+)
+
+func init() {
+	RegisterServerBehavior("reset", "reset the connection (RST with SO_LINGER 0) as soon as the body starts arriving, or right after headers of requests without body", func() ServerBehavior {
+		return &resetBehavior{}
+	})
+	RegisterServerBehavior("close", "close the connection cleanly (FIN) right after reading headers, without responding", func() ServerBehavior {
+		return &closeBehavior{}
+	})
+	RegisterServerBehavior("stall", fmt.Sprintf("read the whole request and never respond, closing the connection after %v unless the client gives up first", stallTimeout), func() ServerBehavior {
+		return &stallBehavior{}
+	})
+	RegisterServerBehavior("slow", fmt.Sprintf("read the whole request and trickle a 200 response with a %d byte body, %d bytes every %v", slowBodySize, slowPiece, slowInterval), func() ServerBehavior {
+		return slowBehavior{}
+	})
+}
+
+// faultBehavior is a ServerBehavior failing requests on purpose. Errors of the client are what's observed with it,
+// so Run reports them along with the connections the request was sent over, instead of aborting.
+type faultBehavior interface {
+	ServerBehavior
+	fault() string
+}
+
+// resetBehavior aborts the connection with RST mid-upload, as a server crashing or a middlebox dropping the connection would.
+type resetBehavior struct {
+	BaseBehavior
+	conn net.Conn
+}
+
+func (b *resetBehavior) OnAccept(conn net.Conn) error {
+	b.conn = conn
+	return nil
+}
+
+func (b *resetBehavior) OnHeaders(req *http.Request) error {
+	if req.ContentLength == 0 {
+		return b.reset()
+	}
+	return nil
+}
+
+func (b *resetBehavior) OnBodyChunk(*http.Request, []byte) error {
+	return b.reset()
+}
+
+// reset makes closing the connection send RST instead of FIN. Connections which can't be set so (e.g. TLS) are closed cleanly.
+func (b *resetBehavior) reset() error {
+	if c, ok := b.conn.(interface{ SetLinger(sec int) error }); ok {
+		if err := c.SetLinger(0); err != nil {
+			return err
+		}
+		return errors.New("resetting the connection")
+	}
+	return errors.New("closing the connection, which can't be reset")
+}
+
+func (*resetBehavior) fault() string { return "reset the connection mid-upload" }
+
+// closeBehavior closes the connection after headers without responding. It shuts down its side with FIN, then discards the body
+// until the client closes the connection too, as closing with unread data would send RST instead.
+type closeBehavior struct {
+	BaseBehavior
+	conn net.Conn
+}
+
+func (b *closeBehavior) OnAccept(conn net.Conn) error {
+	b.conn = conn
+	return nil
+}
+
+func (b *closeBehavior) OnHeaders(*http.Request) error {
+	c, ok := b.conn.(interface{ CloseWrite() error })
+	if !ok {
+		return errors.New("closing the connection after headers")
+	}
+	if err := c.CloseWrite(); err != nil {
+		return err
+	}
+	if err := b.conn.SetReadDeadline(time.Now().Add(stallTimeout)); err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, b.conn)
+	return errors.New("closed the connection after headers")
+}
+
+func (*closeBehavior) fault() string { return "closed the connection after headers" }
+
+// stallBehavior reads the whole request, then waits for the client to give up without responding.
+type stallBehavior struct {
+	BaseBehavior
+	conn net.Conn
+}
+
+func (b *stallBehavior) OnAccept(conn net.Conn) error {
+	b.conn = conn
+	return nil
+}
+
+func (b *stallBehavior) Respond(io.Writer, *http.Request) (bool, error) {
+	start := time.Now()
+	if err := b.conn.SetReadDeadline(start.Add(stallTimeout)); err != nil {
+		return false, err
+	}
+	// the client sends nothing more while waiting for the response, so reads return once it closes the connection or the deadline passes
+	_, err := io.Copy(io.Discard, b.conn)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return false, fmt.Errorf("stalled for %v without the client giving up", stallTimeout)
+	}
+	return false, fmt.Errorf("the client gave up after %v of stalling", time.Since(start).Round(time.Millisecond))
+}
+
+func (*stallBehavior) fault() string { return "never responded" }
+
+// slowBehavior trickles the response, so that time to the first response byte and to the end of the body differ a lot.
+type slowBehavior struct {
+	BaseBehavior
+}
+
+func (slowBehavior) Respond(w io.Writer, _ *http.Request) (bool, error) {
+	resp := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", slowBodySize, strings.Repeat("s", slowBodySize))
+	for i := 0; i < len(resp); i += slowPiece {
+		if i > 0 {
+			time.Sleep(slowInterval)
+		}
+		end := i + slowPiece
+		if end > len(resp) {
+			end = len(resp)
+		}
+		if _, err := io.WriteString(w, resp[i:end]); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+func (slowBehavior) fault() string { return "trickled the response" }
+
+// printFaultReaction prints how the client reacted to the fault of the server: the error or the response it got, when,
+// and whether the Transport retried the request on another connection.
+func (s *session) printFaultReaction(b faultBehavior, err error, tm *timing, conns int) {
+	result := "got the response"
+	if err != nil {
+		result = err.Error()
+	}
+	var after time.Duration
+	if tm != nil {
+		after = tm.total
+	}
+	over := "1 connection"
+	if conns != 1 {
+		over = fmt.Sprintf("%d connections", conns)
+	}
+	fmt.Fprintf(s.out, "client: server %s; %s after %v, over %s\n", b.fault(), result, after.Round(time.Millisecond), over)
+	switch {
+	case conns > 1:
+		fmt.Fprintln(s.out, "  => "+s.finding(sevInfo, fmt.Sprintf("the Transport retried the request on %d more connections", conns-1)))
+	case err != nil:
+		fmt.Fprintln(s.out, "  => "+s.finding(sevInfo, "the Transport didn't retry: it retries only requests which are idempotent (GET, HEAD, OPTIONS, TRACE or with Idempotency-Key) and replayable, when a reused connection fails"))
+	}
+}
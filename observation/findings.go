@@ -0,0 +1,64 @@
+package observation
+
+import (
+	"fmt"
+
+	"httpcli-contentlen-example/observe"
+)
+
+// severity of a finding of an analysis
+type severity int
+
+const (
+	sevInfo severity = iota
+	sevWarn
+	sevError
+)
+
+var severityNames = [...]string{sevInfo: "info", sevWarn: "warn", sevError: "error"}
+
+func (s severity) String() string {
+	return severityNames[s]
+}
+
+// recordedFinding is a finding reported in a session.
+type recordedFinding struct {
+	sev severity
+	msg string
+}
+
+func (f recordedFinding) String() string {
+	return fmt.Sprintf("[%s] %s", f.sev, f.msg)
+}
+
+// finding records a finding with its severity in the session and emits it to the observer, and returns the message prefixed
+// with the severity for printing.
+func (s *session) finding(sev severity, msg string) string {
+	s.observer.Emit(&observe.FindingReported{Severity: sev.String(), Message: msg})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := recordedFinding{sev: sev, msg: msg}
+	s.findings = append(s.findings, f)
+	return f.String()
+}
+
+// findingsMark returns a mark to pass to findingsSince later.
+func (s *session) findingsMark() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.findings)
+}
+
+// findingsSince returns findings at or above atLeast reported in the session after mark was taken.
+func (s *session) findingsSince(mark int, atLeast severity) []recordedFinding {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var fs []recordedFinding
+	for _, f := range s.findings[mark:] {
+		if f.sev >= atLeast {
+			fs = append(fs, f)
+		}
+	}
+	return fs
+}
@@ -0,0 +1,53 @@
+package observation
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// observeViaForwardProxy sends the pattern through a forward proxy set as Transport.Proxy with credentials in its URL,
+// and shows what the client sent to the proxy: the request in absolute-form with Proxy-Authorization for http,
+// or CONNECT for https (-tls), in which case the request itself goes through the tunnel to the capture server as usual.
+// Forwarded requests are diffed against the ones the client sent to the proxy.
+func observeViaForwardProxy(server *captureServer, pat reqPattern, b ServerBehavior, opts runOptions) (*timing, error) {
+	proxy, err := startConnectProxy()
+	if err != nil {
+		return nil, err
+	}
+	defer proxy.close()
+
+	h := server.handoff(func(conn net.Conn, captures chan<- capturedRequest) {
+		opts.serveBehavior(conn, b, captures, opts.quiet)
+	})
+	opts.proxy = &url.URL{Scheme: "http", Host: proxy.l.Addr().String(), User: url.UserPassword("proxy", "secret")}
+	tm, reqErr := request(pat, opts)
+	got := h.wait()
+	heads := proxy.takeHeads()
+	if opts.quiet {
+		return tm, reqErr
+	}
+
+	for _, head := range heads {
+		line, fields, _ := parseRawHead(head)
+		fmt.Fprintf(opts.out, "Client -> proxy: %s\n", line)
+		for _, f := range fields {
+			fmt.Fprintf(opts.out, "  %s: %s\n", f.Name, opts.redactValue(f.Name, f.Value))
+		}
+		if strings.HasPrefix(line, http.MethodConnect+" ") {
+			fmt.Fprintln(opts.out, "  => "+opts.finding(sevInfo, "the request was tunneled with CONNECT, so the proxy saw only the target and its own credentials; the request inside the tunnel is captured by the server"))
+		}
+	}
+	for _, c := range got {
+		if len(heads) > 0 && !strings.HasPrefix(string(heads[0]), http.MethodConnect+" ") {
+			fmt.Fprintln(opts.out, "Client request -> request forwarded by the proxy:")
+			opts.printRequestDiff(heads[0], c.raw)
+		}
+		if c.req.Header.Get("Proxy-Authorization") != "" {
+			fmt.Fprintln(opts.out, "=> "+opts.finding(sevError, "Proxy-Authorization reached the capture server"))
+		}
+	}
+	return tm, reqErr
+}
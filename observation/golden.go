@@ -0,0 +1,144 @@
+package observation
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// fixed boundary of multipart patterns checked against golden files
+const goldenBoundary = "golden-file-boundary"
+
+// nondeterministic parts of header lines, replaced before comparison
+var goldenNormalizers = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`boundary=("[^"]*"|[^;\s]+)`), "boundary=<boundary>"},
+	{regexp.MustCompile(`(?i)^(date|last-modified|if-modified-since|if-unmodified-since): .*$`), "$1: <date>"},
+	{regexp.MustCompile(`(?i)^(host: localhost):\d+$`), "$1:<port>"}, // the capture server listens on an ephemeral port
+}
+
+// normalizeRecord replaces nondeterministic parts of header values in o with placeholders,
+// counting header bytes of the head as normalized.
+func normalizeRecord(o *observationRecord) {
+	for i, h := range o.Headers {
+		line := h.Name + ": " + h.Value
+		for _, n := range goldenNormalizers {
+			line = n.re.ReplaceAllString(line, n.repl)
+		}
+		name, value, _ := strings.Cut(line, ": ")
+		o.HeaderBytes += len(value) - len(h.Value)
+		o.Headers[i] = observedHeader{Name: name, Value: value}
+	}
+}
+
+// goldenCaptures are normalized captures of patterns, in the format of golden files.
+type goldenCaptures struct {
+	files      map[string]string // by pattern name
+	order      []string
+	uncaptured int // patterns run without captures
+}
+
+// captureGolden runs patterns (all if empty) with the default server reading whole requests, and renders the records of captured requests.
+// opts are applied after the ones fixing the captures, e.g. to redact headers, whose values are then masked in the records rendered.
+func captureGolden(ctx context.Context, patterns []string, filename string, opts ...Option) (*goldenCaptures, error) {
+	var records bytes.Buffer
+	opts = append([]Option{
+		WithPatterns(patterns...), WithBodySource(filename), WithBoundary(goldenBoundary), WithCaptureBytes(CaptureAll),
+		WithObservations(&records), WithOutput(io.Discard),
+	}, opts...)
+	report, err := Run(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &goldenCaptures{files: make(map[string]string)}
+	sc := bufio.NewScanner(&records)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var o observationRecord
+		if err := json.Unmarshal(sc.Bytes(), &o); err != nil {
+			return nil, fmt.Errorf("invalid observation: %w", err)
+		}
+		if _, ok := g.files[o.Name]; ok || o.isStub() {
+			continue
+		}
+		normalizeRecord(&o)
+		g.files[o.Name] = renderGolden(o)
+		g.order = append(g.order, o.Name)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read observations: %w", err)
+	}
+	g.uncaptured = len(report.Patterns) - len(g.order)
+	return g, nil
+}
+
+// renderGolden renders the request on the wire recorded in o, including chunk framing and trailers of a chunked body.
+func renderGolden(o observationRecord) string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# %s\n", o.Pattern)
+	fmt.Fprintln(&b, o.RequestLine)
+	for _, h := range o.Headers {
+		fmt.Fprintf(&b, "%s: %s\n", h.Name, h.Value)
+	}
+	fmt.Fprintln(&b)
+	fmt.Fprintf(&b, "header bytes: %d\n", o.HeaderBytes)
+	fmt.Fprintf(&b, "body bytes: %d\n", o.BodyBytes)
+	if len(o.Chunks) > 0 {
+		fmt.Fprintf(&b, "chunks: %s\n", runLengths(o.Chunks))
+	}
+	for _, h := range o.Trailers {
+		fmt.Fprintf(&b, "trailer: %s: %s\n", h.Name, h.Value)
+	}
+	fmt.Fprintf(&b, "complete: %v\n", o.Complete)
+	return b.String()
+}
+
+// lineOp is a line of a diff: kept (' '), removed ('-') or added ('+').
+type lineOp struct {
+	kind byte
+	line string
+}
+
+// diffLines returns the edit from want to got by their longest common subsequence, including kept lines.
+func diffLines(want, got []string) []lineOp {
+	lcs := make([][]int, len(want)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(got)+1)
+	}
+	for i := len(want) - 1; i >= 0; i-- {
+		for j := len(got) - 1; j >= 0; j-- {
+			switch {
+			case want[i] == got[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var ops []lineOp
+	i, j := 0, 0
+	for i < len(want) || j < len(got) {
+		switch {
+		case i < len(want) && j < len(got) && want[i] == got[j]:
+			ops = append(ops, lineOp{' ', want[i]})
+			i, j = i+1, j+1
+		case j == len(got) || (i < len(want) && lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, lineOp{'-', want[i]})
+			i++
+		default:
+			ops = append(ops, lineOp{'+', got[j]})
+			j++
+		}
+	}
+	return ops
+}
@@ -0,0 +1,105 @@
+package observation
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files in testdata/golden and testdata/har with the captures instead of comparing")
+
+// TestGolden runs every pattern against the in-process capture server and compares the normalized captures
+// against testdata/golden/<pattern name>.golden, so that the documented behaviors are verified by go test.
+// Patterns running experiments of their own aren't captured, so they have no golden files.
+func TestGolden(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs every pattern")
+	}
+	dir, err := filepath.Abs(filepath.Join("testdata", "golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := captureGoldenFromRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.order) == 0 {
+		t.Fatal("no pattern was captured")
+	}
+
+	if *update {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range got.order {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name+".golden")
+			if *update {
+				if err := os.WriteFile(path, []byte(got.files[name]), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(path)
+			if errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("no golden file %s (write it with -update)", path)
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if string(want) != got.files[name] {
+				t.Errorf("capture differs from %s (-want +got):\n%s", path, lineDiff(string(want), got.files[name]))
+			}
+		})
+	}
+
+	// golden files of patterns removed or no longer captured would never be checked again
+	files, err := filepath.Glob(filepath.Join(dir, "*.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range files {
+		name := strings.TrimSuffix(filepath.Base(path), ".golden")
+		if _, ok := got.files[name]; ok {
+			continue
+		}
+		if *update {
+			if err := os.Remove(path); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		t.Errorf("stale golden file %s: pattern %s isn't captured (remove it with -update)", path, name)
+	}
+}
+
+// captureGoldenFromRoot captures the patterns in the root of the module, as the CLI runs there:
+// multipart patterns send the name of the body source as given, "photo.jpg".
+func captureGoldenFromRoot() (*goldenCaptures, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chdir(".."); err != nil {
+		return nil, err
+	}
+	defer os.Chdir(wd)
+	return captureGolden(context.Background(), nil, "photo.jpg")
+}
+
+// lineDiff renders the lines removed from want and added in got.
+func lineDiff(want, got string) string {
+	var b strings.Builder
+	for _, op := range diffLines(strings.Split(want, "\n"), strings.Split(got, "\n")) {
+		if op.kind != ' ' {
+			fmt.Fprintf(&b, "  %c %s\n", op.kind, op.line)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,16 @@
+//go:build go1.24
+
+package observation
+
+import "net/http"
+
+// h2cSupported reports whether h2c can be sent: the Transport speaks cleartext HTTP/2 only since Go 1.24 (Transport.Protocols).
+const h2cSupported = true
+
+// newH2CTransport returns a Transport sending http:// requests over cleartext HTTP/2 with prior knowledge (h2c),
+// starting connections with the HTTP/2 preface instead of an HTTP/1.1 Upgrade.
+func newH2CTransport() *http.Transport {
+	tr := &http.Transport{Protocols: new(http.Protocols)}
+	tr.Protocols.SetUnencryptedHTTP2(true)
+	return tr
+}
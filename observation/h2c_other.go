@@ -0,0 +1,11 @@
+//go:build !go1.24
+
+package observation
+
+import "net/http"
+
+const h2cSupported = false
+
+func newH2CTransport() *http.Transport {
+	panic("h2c isn't supported before Go 1.24")
+}
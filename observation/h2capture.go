@@ -0,0 +1,303 @@
+package observation
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// h2FrameRecord is a frame the HTTP/2 capture server received.
+type h2FrameRecord struct {
+	typ    byte
+	flags  byte
+	stream uint32
+	length int // of the payload
+	data   int // application data of DATA frames, excluding padding
+}
+
+// h2Capture is what the HTTP/2 capture server received on a connection.
+type h2Capture struct {
+	frames  []h2FrameRecord
+	fields  []hpackField // of the first request
+	hpErr   error
+	readErr error
+}
+
+// serveH2Capture serves an HTTP/2 connection, recording the frames received and decoding the header block of the first request.
+// Responds 200 to every request once its body ends, replenishing flow control windows as DATA frames arrive.
+func serveH2Capture(conn net.Conn, c *h2Capture, mu *sync.Mutex) {
+	defer conn.Close()
+
+	preface := make([]byte, h2ClientPrefaceLen)
+	if _, err := io.ReadFull(conn, preface); err != nil {
+		return
+	}
+	if err := writeH2Frame(conn, h2FrameSettings, 0, 0, nil); err != nil {
+		return
+	}
+
+	dec := newHPACKDecoder()
+	var block []byte
+	for {
+		f, err := readH2Frame(conn)
+		if err != nil {
+			if err != io.EOF {
+				mu.Lock()
+				c.readErr = err
+				mu.Unlock()
+			}
+			return
+		}
+		mu.Lock()
+		c.frames = append(c.frames, h2FrameRecord{typ: f.typ, flags: f.flags, stream: f.stream, length: len(f.payload), data: f.dataLen()})
+		mu.Unlock()
+
+		var werr error
+		switch f.typ {
+		case h2FrameSettings:
+			if f.flags&h2FlagAck == 0 {
+				werr = writeH2Frame(conn, h2FrameSettings, h2FlagAck, 0, nil)
+			}
+		case h2FramePing:
+			if f.flags&h2FlagAck == 0 {
+				werr = writeH2Frame(conn, h2FramePing, h2FlagAck, 0, f.payload)
+			}
+		case h2FrameHeaders, h2FrameContinuation:
+			if f.typ == h2FrameHeaders {
+				block = f.headerBlockFragment()
+			} else {
+				block = append(block, f.payload...)
+			}
+			if f.flags&h2FlagEndHeaders != 0 {
+				// decode every block to keep the dynamic table in sync, recording only the first request
+				fields, err := dec.decode(block)
+				mu.Lock()
+				if c.fields == nil && c.hpErr == nil {
+					c.fields, c.hpErr = fields, err
+				}
+				mu.Unlock()
+				block = nil
+			}
+			if f.typ == h2FrameHeaders && f.flags&h2FlagEndStream != 0 {
+				werr = writeH2Frame(conn, h2FrameHeaders, h2FlagEndHeaders|h2FlagEndStream, f.stream, h2Status200)
+			}
+		case h2FrameData:
+			if len(f.payload) > 0 {
+				if werr = writeH2WindowUpdate(conn, 0, len(f.payload)); werr == nil {
+					werr = writeH2WindowUpdate(conn, f.stream, len(f.payload))
+				}
+			}
+			if werr == nil && f.flags&h2FlagEndStream != 0 {
+				werr = writeH2Frame(conn, h2FrameHeaders, h2FlagEndHeaders|h2FlagEndStream, f.stream, h2Status200)
+			}
+		}
+		if werr != nil {
+			return
+		}
+	}
+}
+
+// observeOverH2 sends the request of a pattern built by request() over HTTP/2 to a hand-rolled server, with TLS or
+// in cleartext with prior knowledge (h2c) if cleartext is set, and reports the frames it arrived in, the header fields decoded from HPACK,
+// and how the body was framed in DATA frames.
+func observeOverH2(pat reqPattern, cleartext bool, opts runOptions) (*timing, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start listening: %w", err)
+	}
+	var (
+		tl     = l
+		tr     *http.Transport
+		scheme = "http"
+		proto  = "h2c (cleartext HTTP/2 with prior knowledge)"
+	)
+	if cleartext {
+		tr = newH2CTransport()
+	} else {
+		ca, err := newTestCA()
+		if err != nil {
+			_ = l.Close()
+			return nil, fmt.Errorf("failed to create test CA: %w", err)
+		}
+		cert, err := ca.issue("server", true)
+		if err != nil {
+			_ = l.Close()
+			return nil, fmt.Errorf("failed to issue server certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		pool.AddCert(ca.cert)
+		tl = tls.NewListener(l, &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"h2"}})
+		tr = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}, ForceAttemptHTTP2: true}
+		scheme, proto = "https", "HTTP/2 over TLS"
+	}
+	defer tl.Close()
+
+	var (
+		mu      sync.Mutex
+		capt    h2Capture
+		netConn net.Conn // accepted, closed once the client is done as it may not close the connection
+		wg      sync.WaitGroup
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		conn, err := tl.Accept()
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		netConn = conn
+		mu.Unlock()
+		serveH2Capture(conn, &capt, &mu)
+	}()
+
+	f, err := os.Open(opts.filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+	req, err := buildPatternRequest(pat, f, opts)
+	if err != nil {
+		return nil, err
+	}
+	target := &url.URL{Scheme: scheme, Host: l.Addr().String()}
+	req.URL.Scheme, req.URL.Host, req.Host = target.Scheme, target.Host, target.Host
+
+	req, tm := traceTiming(req)
+	resp, reqErr := (&http.Client{Transport: tr}).Do(req)
+	if reqErr == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}
+	tm.finish()
+	tr.CloseIdleConnections()
+	_ = tl.Close()
+	mu.Lock()
+	if netConn != nil {
+		_ = netConn.Close()
+	}
+	mu.Unlock()
+	wg.Wait()
+	if reqErr != nil {
+		return tm, fmt.Errorf("HTTP request failed: %w", reqErr)
+	}
+
+	if !opts.quiet {
+		fmt.Fprintf(opts.out, "[%s, response %s]\n", proto, resp.Proto)
+		opts.printH2Capture(&capt)
+	}
+	return tm, nil
+}
+
+func (s *session) printH2Capture(c *h2Capture) {
+	fmt.Fprintln(s.out, "frames from the client:")
+	var (
+		dataSizes []int
+		dataTotal int
+		endStream string
+	)
+	flushData := func() {
+		if len(dataSizes) > 0 {
+			fmt.Fprintf(s.out, "  DATA x%d, payload sizes %s\n", len(dataSizes), runLengths(dataSizes))
+			dataSizes = nil
+		}
+	}
+	for _, f := range c.frames {
+		if f.typ == h2FrameData {
+			dataSizes = append(dataSizes, f.data)
+			dataTotal += f.data
+			if f.flags&h2FlagEndStream != 0 {
+				endStream = fmt.Sprintf("a DATA frame of %d bytes", f.data)
+			}
+			continue
+		}
+		flushData()
+		if f.typ == h2FrameHeaders && f.flags&h2FlagEndStream != 0 {
+			endStream = "the HEADERS frame"
+		}
+		name := h2FrameNames[f.typ]
+		if name == "" {
+			name = fmt.Sprintf("type 0x%x", f.typ)
+		}
+		fmt.Fprintf(s.out, "  %s stream %d, %d bytes%s\n", name, f.stream, f.length, h2FlagNames(f.typ, f.flags))
+	}
+	flushData()
+	if c.readErr != nil {
+		fmt.Fprintf(s.out, "  (server stopped reading: %v)\n", c.readErr)
+	}
+
+	if c.hpErr != nil {
+		fmt.Fprintf(s.out, "header fields: failed to decode: %v\n", c.hpErr)
+		return
+	}
+	fmt.Fprintln(s.out, "header fields (decoded from HPACK):")
+	contentLength := ""
+	for _, f := range c.fields {
+		fmt.Fprintf(s.out, "  %s: %s\n", f.name, s.redactValue(f.name, f.value))
+		if f.name == "content-length" {
+			contentLength = f.value
+		}
+	}
+	fmt.Fprintf(s.out, "body: %d bytes in DATA frames, END_STREAM on %s\n", dataTotal, endStream)
+	switch {
+	case contentLength == "":
+		fmt.Fprintln(s.out, "  => "+s.finding(sevInfo, "no content-length header: the body length is known only from END_STREAM"))
+	case contentLength != fmt.Sprint(dataTotal):
+		fmt.Fprintln(s.out, "  => "+s.finding(sevError, fmt.Sprintf("content-length: %s doesn't match the %d bytes in DATA frames", contentLength, dataTotal)))
+	default:
+		fmt.Fprintln(s.out, "  = content-length is sent as a regular header field, matching the DATA frames")
+	}
+}
+
+// h2FlagNames describes the flags of a frame of type typ.
+func h2FlagNames(typ, flags byte) string {
+	var names []string
+	switch typ {
+	case h2FrameSettings, h2FramePing:
+		if flags&h2FlagAck != 0 {
+			names = append(names, "ACK")
+		}
+	case h2FrameHeaders, h2FrameData, h2FrameContinuation:
+		if typ != h2FrameContinuation && flags&h2FlagEndStream != 0 {
+			names = append(names, "END_STREAM")
+		}
+		if typ != h2FrameData && flags&h2FlagEndHeaders != 0 {
+			names = append(names, "END_HEADERS")
+		}
+		if typ != h2FrameContinuation && flags&h2FlagPadded != 0 {
+			names = append(names, "PADDED")
+		}
+		if typ == h2FrameHeaders && flags&h2FlagPriority != 0 {
+			names = append(names, "PRIORITY")
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	return ", " + strings.Join(names, "|")
+}
+
+// runLengths formats sizes compressing runs of the same size, e.g. "16384 x6, 3813".
+func runLengths(sizes []int) string {
+	var parts []string
+	for i := 0; i < len(sizes); {
+		j := i
+		for j < len(sizes) && sizes[j] == sizes[i] {
+			j++
+		}
+		if j-i > 1 {
+			parts = append(parts, fmt.Sprintf("%d x%d", sizes[i], j-i))
+		} else {
+			parts = append(parts, fmt.Sprint(sizes[i]))
+		}
+		i = j
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,266 @@
+package observation
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"httpcli-contentlen-example/observe"
+)
+
+// bytes of the request body the HTTP/2 fault server reads before injecting a fault
+const h2FaultAfterBytes = 16 << 10
+
+// h2Fault is a fault injected by the HTTP/2 fault server on the first connection, mid-upload.
+// Later connections serve requests normally.
+type h2Fault struct {
+	desc   string
+	inject func(w io.Writer, stream uint32) error
+	close  bool // close the connection after injecting
+}
+
+var h2Faults = []h2Fault{
+	{desc: "RST_STREAM REFUSED_STREAM", inject: func(w io.Writer, s uint32) error { return writeH2RSTStream(w, s, h2RefusedStream) }},
+	{desc: "RST_STREAM CANCEL", inject: func(w io.Writer, s uint32) error { return writeH2RSTStream(w, s, h2Cancel) }},
+	{desc: "RST_STREAM INTERNAL_ERROR", inject: func(w io.Writer, s uint32) error { return writeH2RSTStream(w, s, h2InternalError) }},
+	{
+		desc: "200 response, then RST_STREAM NO_ERROR",
+		inject: func(w io.Writer, s uint32) error {
+			if err := writeH2Frame(w, h2FrameHeaders, h2FlagEndHeaders|h2FlagEndStream, s, h2Status200); err != nil {
+				return err
+			}
+			return writeH2RSTStream(w, s, h2NoError)
+		},
+	},
+	{desc: "GOAWAY NO_ERROR, last stream 0", inject: func(w io.Writer, _ uint32) error { return writeH2GoAway(w, 0, h2NoError) }, close: true},
+	{desc: "GOAWAY ENHANCE_YOUR_CALM, last stream 0", inject: func(w io.Writer, _ uint32) error { return writeH2GoAway(w, 0, h2EnhanceYourCalm) }, close: true},
+	{desc: "GOAWAY INTERNAL_ERROR, last stream = the stream", inject: func(w io.Writer, s uint32) error { return writeH2GoAway(w, s, h2InternalError) }, close: true},
+}
+
+// h2ConnLog records what the HTTP/2 fault server saw on a connection.
+type h2ConnLog struct {
+	streams  []uint32
+	bodyRead int
+	injected string
+	closeErr error
+}
+
+// serveH2Fault serves an HTTP/2 connection without HPACK decoding, responding 200 to every request once its body ends.
+// If fault is non-nil, it's injected on the first stream after h2FaultAfterBytes of the body are read.
+func (s *session) serveH2Fault(conn net.Conn, fault *h2Fault, cl *h2ConnLog, mu *sync.Mutex) {
+	defer conn.Close()
+
+	preface := make([]byte, h2ClientPrefaceLen)
+	if _, err := io.ReadFull(conn, preface); err != nil {
+		return
+	}
+	if err := writeH2Frame(conn, h2FrameSettings, 0, 0, nil); err != nil {
+		return
+	}
+
+	var injected uint32 // stream the fault was injected on
+	for {
+		f, err := readH2Frame(conn)
+		if err != nil {
+			mu.Lock()
+			if err != io.EOF {
+				cl.closeErr = err
+			}
+			mu.Unlock()
+			return
+		}
+
+		var werr error
+		switch f.typ {
+		case h2FrameSettings:
+			if f.flags&h2FlagAck == 0 {
+				werr = writeH2Frame(conn, h2FrameSettings, h2FlagAck, 0, nil)
+			}
+		case h2FramePing:
+			if f.flags&h2FlagAck == 0 {
+				werr = writeH2Frame(conn, h2FramePing, h2FlagAck, 0, f.payload)
+			}
+		case h2FrameHeaders:
+			mu.Lock()
+			cl.streams = append(cl.streams, f.stream)
+			mu.Unlock()
+			if f.flags&h2FlagEndStream != 0 {
+				werr = writeH2Frame(conn, h2FrameHeaders, h2FlagEndHeaders|h2FlagEndStream, f.stream, h2Status200)
+			}
+		case h2FrameData:
+			n := f.dataLen()
+			mu.Lock()
+			cl.bodyRead += n
+			read := cl.bodyRead
+			mu.Unlock()
+			if f.stream == injected {
+				// the stream is already reset
+				continue
+			}
+			if len(f.payload) > 0 {
+				// replenish flow control windows, as the body is larger than the initial window
+				if werr = writeH2WindowUpdate(conn, 0, len(f.payload)); werr == nil {
+					werr = writeH2WindowUpdate(conn, f.stream, len(f.payload))
+				}
+			}
+			if werr == nil && fault != nil && injected == 0 && read >= h2FaultAfterBytes {
+				injected = f.stream
+				mu.Lock()
+				cl.injected = fault.desc
+				mu.Unlock()
+				s.observer.Emit(&observe.FaultInjected{RemoteAddr: conn.RemoteAddr().String(), Fault: fault.desc})
+				if werr = fault.inject(conn, f.stream); werr == nil && fault.close {
+					// give the client a moment to read GOAWAY before the connection goes away
+					time.Sleep(50 * time.Millisecond)
+					return
+				}
+				continue
+			}
+			if werr == nil && f.flags&h2FlagEndStream != 0 {
+				werr = writeH2Frame(conn, h2FrameHeaders, h2FlagEndHeaders|h2FlagEndStream, f.stream, h2Status200)
+			}
+		}
+		if werr != nil {
+			return
+		}
+	}
+}
+
+// observeH2Faults uploads the file over HTTP/2 to a server injecting RST_STREAM or GOAWAY with various error codes mid-upload,
+// and reports whether the client retried the request (on which connection and stream) and the error surfaced to the caller.
+func observeH2Faults(opts runOptions) (*timing, error) {
+	data, err := os.ReadFile(opts.filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	ca, err := newTestCA()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create test CA: %w", err)
+	}
+	cert, err := ca.issue("server", true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue server certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	var first *timing
+	for _, fault := range h2Faults {
+		fault := fault
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, fmt.Errorf("failed to start listening: %w", err)
+		}
+		tl := tls.NewListener(l, &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"h2"}})
+
+		var (
+			mu       sync.Mutex
+			conns    []*h2ConnLog
+			netConns []net.Conn
+			wg       sync.WaitGroup
+		)
+		go func() {
+			for {
+				conn, err := tl.Accept()
+				if err != nil {
+					return
+				}
+				cl := &h2ConnLog{}
+				mu.Lock()
+				conns = append(conns, cl)
+				netConns = append(netConns, conn)
+				f := &fault
+				if len(conns) > 1 {
+					f = nil
+				}
+				mu.Unlock()
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					opts.serveH2Fault(conn, f, cl, &mu)
+				}()
+			}
+		}()
+
+		tr := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}, ForceAttemptHTTP2: true}
+		ctx, cancel := context.WithTimeout(opts.ctx, 5*time.Second)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, "https://"+l.Addr().String(), bytes.NewReader(data))
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+		var gotConns []string
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				mu.Lock()
+				defer mu.Unlock()
+				gotConns = append(gotConns, fmt.Sprintf("%v (reused: %v)", info.Conn.LocalAddr(), info.Reused))
+			},
+		}
+		req, tm := traceTiming(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+		resp, reqErr := (&http.Client{Transport: tr}).Do(req)
+		if reqErr == nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+		tm.finish()
+		cancel()
+		tr.CloseIdleConnections()
+		_ = tl.Close()
+		mu.Lock()
+		for _, conn := range netConns {
+			_ = conn.Close()
+		}
+		mu.Unlock()
+		wg.Wait()
+
+		if first == nil {
+			first = tm
+		}
+		if opts.quiet {
+			continue
+		}
+
+		mu.Lock()
+		fmt.Fprintf(opts.out, "[server injects %s after %d bytes of the body]\n", fault.desc, h2FaultAfterBytes)
+		fmt.Fprintf(opts.out, "  client got connections: %s\n", strings.Join(gotConns, ", "))
+		for i, cl := range conns {
+			injected := ""
+			if cl.injected != "" {
+				injected = ", injected " + cl.injected
+			}
+			fmt.Fprintf(opts.out, "  server connection #%d: streams %v, %d body bytes read%s\n", i+1, cl.streams, cl.bodyRead, injected)
+		}
+		if reqErr != nil {
+			fmt.Fprintf(opts.out, "  => error surfaced to the caller (%T): %v\n", unwrapURLError(reqErr), reqErr)
+		} else {
+			retried := "not retried"
+			if len(conns) > 1 || (len(conns) == 1 && len(conns[0].streams) > 1) {
+				retried = "retried"
+			}
+			fmt.Fprintf(opts.out, "  => %s, %s\n", resp.Status, retried)
+		}
+		mu.Unlock()
+	}
+	return first, nil
+}
+
+// unwrapURLError returns the error wrapped by *url.Error returned from http.Client, to show its concrete type.
+func unwrapURLError(err error) error {
+	type unwrapper interface{ Unwrap() error }
+	if u, ok := err.(unwrapper); ok {
+		return u.Unwrap()
+	}
+	return err
+}
@@ -0,0 +1,135 @@
+package observation
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// HTTP/2 frame types and flags (RFC 9113), for hand-rolled HTTP/2 servers injecting faults the standard server can't.
+const (
+	h2FrameData         = 0x0
+	h2FrameHeaders      = 0x1
+	h2FrameRSTStream    = 0x3
+	h2FrameSettings     = 0x4
+	h2FramePing         = 0x6
+	h2FrameGoAway       = 0x7
+	h2FrameWindowUpdate = 0x8
+	h2FrameContinuation = 0x9
+
+	h2FlagEndStream  = 0x1
+	h2FlagAck        = 0x1
+	h2FlagEndHeaders = 0x4
+	h2FlagPadded     = 0x8
+	h2FlagPriority   = 0x20
+)
+
+// HTTP/2 error codes
+const (
+	h2NoError         = 0x0
+	h2ProtocolError   = 0x1
+	h2InternalError   = 0x2
+	h2RefusedStream   = 0x7
+	h2Cancel          = 0x8
+	h2EnhanceYourCalm = 0xb
+)
+
+// length of the client connection preface ("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+const h2ClientPrefaceLen = 24
+
+var h2ErrorNames = map[uint32]string{
+	h2NoError:         "NO_ERROR",
+	h2ProtocolError:   "PROTOCOL_ERROR",
+	h2InternalError:   "INTERNAL_ERROR",
+	h2RefusedStream:   "REFUSED_STREAM",
+	h2Cancel:          "CANCEL",
+	h2EnhanceYourCalm: "ENHANCE_YOUR_CALM",
+}
+
+var h2FrameNames = map[byte]string{
+	h2FrameData:         "DATA",
+	h2FrameHeaders:      "HEADERS",
+	0x2:                 "PRIORITY",
+	h2FrameRSTStream:    "RST_STREAM",
+	h2FrameSettings:     "SETTINGS",
+	0x5:                 "PUSH_PROMISE",
+	h2FramePing:         "PING",
+	h2FrameGoAway:       "GOAWAY",
+	h2FrameWindowUpdate: "WINDOW_UPDATE",
+	h2FrameContinuation: "CONTINUATION",
+}
+
+// HPACK encoding of ":status: 200" (static table index 8)
+var h2Status200 = []byte{0x88}
+
+type h2Frame struct {
+	typ     byte
+	flags   byte
+	stream  uint32
+	payload []byte
+}
+
+func readH2Frame(r io.Reader) (h2Frame, error) {
+	var hdr [9]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return h2Frame{}, err
+	}
+	length := int(hdr[0])<<16 | int(hdr[1])<<8 | int(hdr[2])
+	f := h2Frame{
+		typ:     hdr[3],
+		flags:   hdr[4],
+		stream:  binary.BigEndian.Uint32(hdr[5:]) & 0x7fffffff,
+		payload: make([]byte, length),
+	}
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return h2Frame{}, fmt.Errorf("failed to read frame payload: %w", err)
+	}
+	return f, nil
+}
+
+func writeH2Frame(w io.Writer, typ, flags byte, stream uint32, payload []byte) error {
+	b := make([]byte, 9, 9+len(payload))
+	b[0], b[1], b[2] = byte(len(payload)>>16), byte(len(payload)>>8), byte(len(payload))
+	b[3], b[4] = typ, flags
+	binary.BigEndian.PutUint32(b[5:], stream)
+	_, err := w.Write(append(b, payload...))
+	return err
+}
+
+// headerBlockFragment returns the header block fragment in a HEADERS frame, excluding padding and priority fields.
+func (f h2Frame) headerBlockFragment() []byte {
+	p := f.payload
+	pad := 0
+	if f.flags&h2FlagPadded != 0 && len(p) > 0 {
+		pad = int(p[0])
+		p = p[1:]
+	}
+	if f.flags&h2FlagPriority != 0 && len(p) >= 5 {
+		p = p[5:]
+	}
+	if pad > len(p) {
+		return nil
+	}
+	return p[:len(p)-pad]
+}
+
+// dataLen returns the length of application data in a DATA frame, excluding padding.
+func (f h2Frame) dataLen() int {
+	if f.flags&h2FlagPadded != 0 && len(f.payload) > 0 {
+		return len(f.payload) - 1 - int(f.payload[0])
+	}
+	return len(f.payload)
+}
+
+func writeH2WindowUpdate(w io.Writer, stream uint32, n int) error {
+	return writeH2Frame(w, h2FrameWindowUpdate, 0, stream, binary.BigEndian.AppendUint32(nil, uint32(n)))
+}
+
+func writeH2RSTStream(w io.Writer, stream, code uint32) error {
+	return writeH2Frame(w, h2FrameRSTStream, 0, stream, binary.BigEndian.AppendUint32(nil, code))
+}
+
+func writeH2GoAway(w io.Writer, lastStream, code uint32) error {
+	payload := binary.BigEndian.AppendUint32(nil, lastStream)
+	return writeH2Frame(w, h2FrameGoAway, 0, 0, binary.BigEndian.AppendUint32(payload, code))
+}
@@ -0,0 +1,287 @@
+package observation
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// HAR 1.2 (http://www.softwareishard.com/blog/har-12-spec/) document written by -har. Custom fields are prefixed with _ as the spec allows.
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+	Comment string     `json:"comment,omitempty"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Pattern         string      `json:"_pattern"`
+	PatternName     string      `json:"_patternName,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harCookie    `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+	Comment     string         `json:"comment,omitempty"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harCookie    `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+	Comment     string         `json:"comment,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// harWriter collects HAR entries of observed requests, writing them as a HAR document to w on Close, as the format isn't streamable.
+type harWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	entries []harEntry
+	omitted []string // patterns without entries, listed in the comment of the log
+}
+
+func newHARWriter(w io.Writer) *harWriter {
+	return &harWriter{w: w, entries: []harEntry{}}
+}
+
+func (w *harWriter) add(e harEntry) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.entries = append(w.entries, e)
+}
+
+// omit records that the pattern has no entry, as its requests didn't reach the capture server.
+func (w *harWriter) omit(pattern string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.omitted = append(w.omitted, pattern)
+}
+
+func (w *harWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	doc := struct {
+		Log harLog `json:"log"`
+	}{harLog{Version: "1.2", Creator: harCreator{Name: "httpcli-contentlen-example", Version: "1"}, Entries: w.entries}}
+	if len(w.omitted) > 0 {
+		doc.Log.Comment = fmt.Sprintf("no entries of %d patterns whose requests didn't reach the capture server: %s", len(w.omitted), strings.Join(w.omitted, "; "))
+	}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode HAR: %w", err)
+	}
+	if _, err := w.w.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("failed to write HAR: %w", err)
+	}
+	return nil
+}
+
+// newHAREntry builds a HAR entry of the request captured by the server, sent by the pattern, with the response received
+// by the client if it was recorded (received is nil otherwise). Relative request targets are resolved against the server URL of the session.
+func (s *session) newHAREntry(p reqPattern, c capturedRequest, tm *timing, received []byte) harEntry {
+	line, fields, complete := parseRawHead(c.raw)
+	headerBytes := len(c.raw)
+	if complete {
+		headerBytes = bytes.Index(c.raw, []byte("\r\n\r\n")) + 4
+	}
+	method, target, _ := strings.Cut(line, " ")
+	target, proto, _ := strings.Cut(target, " ")
+
+	req := harRequest{
+		Method:      method,
+		HTTPVersion: proto,
+		Cookies:     []harCookie{},
+		Headers:     []harNameValue{},
+		QueryString: []harNameValue{},
+		HeadersSize: headerBytes,
+		BodySize:    int64(len(c.raw)-headerBytes) + c.rawDropped,
+	}
+	h := make(http.Header)
+	for _, f := range fields {
+		req.Headers = append(req.Headers, harNameValue{Name: f.Name, Value: s.redactValue(f.Name, f.Value)})
+		h.Add(f.Name, s.redactValue(f.Name, f.Value))
+	}
+	for _, ck := range (&http.Request{Header: h}).Cookies() {
+		req.Cookies = append(req.Cookies, harCookie{Name: ck.Name, Value: ck.Value})
+	}
+	req.URL = target
+	if u, err := url.Parse(target); err == nil {
+		if !u.IsAbs() {
+			scheme, _, _ := strings.Cut(s.serverURL, "://")
+			u.Scheme, u.Host = scheme, h.Get("Host")
+			req.URL = u.String()
+		}
+		for _, name := range sortedKeys(u.Query()) {
+			for _, v := range u.Query()[name] {
+				req.QueryString = append(req.QueryString, harNameValue{Name: name, Value: v})
+			}
+		}
+	}
+	if req.BodySize > 0 {
+		req.PostData = harRequestBody(c, h.Get("Content-Type"), headerBytes)
+	}
+	if c.req == nil {
+		// the default server stopped reading after a prefix, so the size of the body is unknown
+		req.BodySize = -1
+	}
+
+	e := harEntry{Request: req, Response: harNoResponse(), Pattern: s.patterns.describe(p), PatternName: s.patterns.name(p)}
+	if tm != nil {
+		e.StartedDateTime = tm.start.Format(time.RFC3339Nano)
+		e.Time = msec(tm.total)
+		e.Timings.Send = msec(tm.total)
+		if tm.wroteRequest != 0 {
+			e.Timings.Send, e.Timings.Wait = msec(tm.wroteRequest), msec(tm.total-tm.wroteRequest)
+		}
+	} else {
+		e.StartedDateTime = time.Now().Format(time.RFC3339Nano)
+	}
+	if received != nil {
+		e.Response = s.harReceivedResponse(received)
+	}
+	return e
+}
+
+// harRequestBody returns the body of c as HAR postData. Bodies which aren't UTF-8 (e.g. JPEG) can't be represented,
+// and are left out with a comment, as postData has no encoding.
+func harRequestBody(c capturedRequest, mimeType string, headerBytes int) *harPostData {
+	pd := &harPostData{MimeType: mimeType}
+	body, dropped := c.body, c.bodyDropped
+	if c.req != nil && body == nil && c.rawDropped == 0 {
+		// the default server with -capture-bytes all keeps the request only as on the wire
+		if r, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(c.raw))); err == nil {
+			body, _ = io.ReadAll(r.Body)
+		}
+	}
+	if c.req == nil {
+		// not parsed, so the body is as on the wire, up to where the default server stopped reading
+		body, dropped = c.raw[headerBytes:], c.rawDropped
+		pd.Comment = fmt.Sprintf("first %d bytes of the body as on the wire, where the capture server stopped reading (see -capture-bytes). ", len(body))
+	}
+	switch {
+	case !utf8.Valid(body):
+		pd.Comment += fmt.Sprintf("%d bytes of binary body left out", int64(len(body))+dropped)
+	case dropped > 0:
+		pd.Text = string(body)
+		pd.Comment += fmt.Sprintf("%d bytes of body beyond -capture-mem left out", dropped)
+	default:
+		pd.Text = string(body)
+	}
+	pd.Comment = strings.TrimSpace(pd.Comment)
+	return pd
+}
+
+// harNoResponse returns the response of entries whose response wasn't recorded: status 0, as HAR tools show aborted requests.
+func harNoResponse() harResponse {
+	return harResponse{
+		Cookies:     []harCookie{},
+		Headers:     []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    -1,
+		Comment:     "response not recorded (use -server canned)",
+	}
+}
+
+// harReceivedResponse returns the response the client read from the connection as a HAR response.
+// The content is the body with transfer coding removed but content coding (e.g. gzip) kept, base64-encoded unless it's UTF-8.
+func (s *session) harReceivedResponse(raw []byte) harResponse {
+	_, fields, complete := parseRawHead(raw)
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(raw)), nil)
+	if !complete || err != nil {
+		r := harNoResponse()
+		r.Comment = "response received by the client couldn't be parsed"
+		return r
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	headerBytes := bytes.Index(raw, []byte("\r\n\r\n")) + 4
+	r := harResponse{
+		Status:      resp.StatusCode,
+		StatusText:  strings.TrimPrefix(resp.Status, fmt.Sprintf("%d ", resp.StatusCode)),
+		HTTPVersion: resp.Proto,
+		Cookies:     []harCookie{},
+		Headers:     []harNameValue{},
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: headerBytes,
+		BodySize:    int64(len(raw) - headerBytes),
+		Content:     harContent{Size: int64(len(body)), MimeType: resp.Header.Get("Content-Type")},
+	}
+	for _, f := range fields {
+		r.Headers = append(r.Headers, harNameValue{Name: f.Name, Value: s.redactValue(f.Name, f.Value)})
+	}
+	for _, ck := range resp.Cookies() {
+		r.Cookies = append(r.Cookies, harCookie{Name: ck.Name, Value: ck.Value})
+	}
+	if utf8.Valid(body) {
+		r.Content.Text = string(body)
+	} else {
+		r.Content.Text, r.Content.Encoding = base64.StdEncoding.EncodeToString(body), "base64"
+	}
+	return r
+}
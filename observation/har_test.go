@@ -0,0 +1,95 @@
+package observation
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHARWriter checks the HAR document written for a request with a query string, cookies, a redacted header and a text body,
+// and the canned response received by the client, against testdata/har/entry.har (rewritten with -update).
+func TestHARWriter(t *testing.T) {
+	s := newSession(io.Discard)
+	s.serverURL = "http://localhost:8080"
+	s.headerRedaction = newHeaderRedaction("Authorization")
+	ps, err := newPatternSet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.patterns = ps
+
+	raw := []byte("POST /upload?b=2&a=1&a=3 HTTP/1.1\r\n" +
+		"Host: localhost:8080\r\n" +
+		"Authorization: Bearer secret\r\n" +
+		"Cookie: session=abc; theme=dark\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Content-Length: 5\r\n" +
+		"\r\n" +
+		"hello")
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm := &timing{start: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), wroteRequest: 2 * time.Millisecond, total: 5 * time.Millisecond}
+	received := []byte("HTTP/1.1 201 Created\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Set-Cookie: id=42\r\n" +
+		"Content-Length: 2\r\n" +
+		"\r\n" +
+		"ok")
+
+	var got bytes.Buffer
+	w := newHARWriter(&got)
+	w.add(s.newHAREntry(reqSinglePartWithLen, capturedRequest{raw: raw, req: req}, tm, received))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join("testdata", "har", "entry.har")
+	if *update {
+		if err := os.WriteFile(path, got.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(want, got.Bytes()) {
+		t.Errorf("HAR differs from %s (-want +got):\n%s", path, lineDiff(string(want), got.String()))
+	}
+}
+
+// TestRunHAROmitsUncaptured checks that a pattern not captured by the capture server has no HAR entry, but is listed in the comment.
+func TestRunHAROmitsUncaptured(t *testing.T) {
+	var got bytes.Buffer
+	if _, err := Run(context.Background(),
+		WithPatterns("seeker-rewind", "with-len"),
+		WithBodySource("../photo.jpg"),
+		WithOutput(io.Discard),
+		WithHAR(&got),
+	); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Log harLog `json:"log"`
+	}
+	if err := json.Unmarshal(got.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Log.Entries) != 1 || doc.Log.Entries[0].PatternName != "with-len" {
+		t.Errorf("entries = %+v, want one of with-len", doc.Log.Entries)
+	}
+	if !strings.Contains(doc.Log.Comment, "rewound and resent") || strings.Contains(doc.Log.Comment, "with Content-Length") {
+		t.Errorf("comment = %q, want seeker-rewind listed", doc.Log.Comment)
+	}
+}
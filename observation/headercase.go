@@ -0,0 +1,126 @@
+package observation
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// ways of setting headers with unusual names and values
+var headerCaseCases = []struct {
+	desc  string
+	given string // name given to Set or Add, "" if assigned to the map directly
+	apply func(h http.Header)
+}{
+	{`Header.Set("x-my-HEADER", "set")`, "x-my-HEADER", func(h http.Header) { h.Set("x-my-HEADER", "set") }},
+	{`Header.Add("x-multi", "a"), Header.Add("X-MULTI", "b")`, "x-multi", func(h http.Header) { h.Add("x-multi", "a"); h.Add("X-MULTI", "b") }},
+	{`Header["x-my-RAW"] = []string{"direct"}`, "", func(h http.Header) { h["x-my-RAW"] = []string{"direct"} }},
+	{`Header["X-Dup"] = []string{"canonical"}, Header["x-dup"] = []string{"lowercase"}`, "", func(h http.Header) {
+		h["X-Dup"] = []string{"canonical"}
+		h["x-dup"] = []string{"lowercase"}
+	}},
+	{`Header["X-Joined"] = []string{"a, b"}`, "", func(h http.Header) { h["X-Joined"] = []string{"a, b"} }},
+	{`Header.Set("X-Padded", "  padded  ")`, "X-Padded", func(h http.Header) { h.Set("X-Padded", "  padded  ") }},
+	{`Header.Set("x_under_score", "v")`, "x_under_score", func(h http.Header) { h.Set("x_under_score", "v") }},
+}
+
+// observeHeaderCanonicalization sends a GET with headers set in the ways of headerCaseCases, and reports the keys they got in Request.Header,
+// the header lines written on the wire in order, and the keys a Go server files them under.
+func observeHeaderCanonicalization(opts runOptions) (*timing, error) {
+	captures := make(chan capturedRequest, 1)
+	url, stop, err := opts.startEphemeralServer(func() ServerBehavior { return BaseBehavior{} }, captures, true)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	req, err := http.NewRequestWithContext(opts.ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	keys := make([][]string, len(headerCaseCases))
+	for i, c := range headerCaseCases {
+		h := make(http.Header)
+		c.apply(h)
+		keys[i] = sortedKeys(h)
+		c.apply(req.Header)
+	}
+
+	req, tm := traceTiming(req)
+	err = opts.sendReq(http.DefaultTransport, req)
+	tm.finish()
+	if err != nil {
+		return nil, err
+	}
+	got := <-captures
+	if opts.quiet {
+		return tm, nil
+	}
+
+	// values as written, before parseRawHead trims them, with redacted ones masked
+	head := got.raw
+	if i := bytes.Index(head, []byte("\r\n\r\n")); i >= 0 {
+		head = head[:i]
+	}
+	lines := strings.Split(string(opts.redact(head)), "\r\n")[1:]
+	linesOf := func(name string) []string {
+		var ls []string
+		for _, line := range lines {
+			if n, _, _ := strings.Cut(line, ":"); n == name {
+				ls = append(ls, line)
+			}
+		}
+		return ls
+	}
+
+	for i, c := range headerCaseCases {
+		fmt.Fprintf(opts.out, "[%s]\n", c.desc)
+		for _, k := range keys[i] {
+			fmt.Fprintf(opts.out, "  Request.Header key %q %q\n", k, opts.redactValues(k, req.Header[k]))
+			for _, line := range linesOf(k) {
+				fmt.Fprintf(opts.out, "    on the wire: %q\n", line)
+			}
+			canon := textproto.CanonicalMIMEHeaderKey(k)
+			fmt.Fprintf(opts.out, "    Go server's Request.Header key %q %q\n", canon, opts.redactValues(canon, got.req.Header[canon]))
+		}
+	}
+
+	fmt.Fprintln(opts.out, "header lines on the wire, in order:")
+	var fromMap []string
+	for i, line := range lines {
+		fmt.Fprintf(opts.out, "  %2d. %s\n", i+1, line)
+		if n, _, _ := strings.Cut(line, ":"); req.Header[n] != nil {
+			fromMap = append(fromMap, n)
+		}
+	}
+
+	var canonicalized []string
+	for i, c := range headerCaseCases {
+		if c.given != "" && len(keys[i]) == 1 && keys[i][0] != c.given {
+			canonicalized = append(canonicalized, fmt.Sprintf("%s -> %s", c.given, keys[i][0]))
+		}
+	}
+	if len(canonicalized) > 0 {
+		fmt.Fprintln(opts.out, "  => "+opts.finding(sevInfo, "Set and Add canonicalized the names ("+strings.Join(canonicalized, ", ")+
+			"), while keys assigned to the map directly went on the wire exactly as written"))
+	}
+	if sort.StringsAreSorted(fromMap) {
+		fmt.Fprintln(opts.out, "  => "+opts.finding(sevInfo, "Request.Header was written sorted by key in byte order, so lowercase keys followed all capitalized ones "+
+			"regardless of the order they were set in; Host and User-Agent came first, and Accept-Encoding added by the Transport last"))
+	} else {
+		fmt.Fprintln(opts.out, "  => "+opts.finding(sevInfo, "Request.Header wasn't written sorted by key: "+strings.Join(fromMap, ", ")))
+	}
+	if n := len(linesOf("X-Multi")); n > 1 {
+		fmt.Fprintln(opts.out, "  => "+opts.finding(sevInfo, fmt.Sprintf("the %d values of X-Multi were written as separate lines, not folded into one comma-separated line", n)))
+	}
+	if dup := got.req.Header["X-Dup"]; len(dup) > 1 {
+		fmt.Fprintln(opts.out, "  => "+opts.finding(sevInfo, fmt.Sprintf("X-Dup and x-dup went out as separate lines, and a Go server merged them under one key: %q", opts.redactValues("X-Dup", dup))))
+	}
+	if ls := linesOf("X-Padded"); len(ls) == 1 && !strings.HasSuffix(ls[0], "  ") {
+		fmt.Fprintln(opts.out, "  => "+opts.finding(sevInfo, "leading and trailing spaces of the X-Padded value were trimmed on the wire"))
+	}
+	return tm, nil
+}
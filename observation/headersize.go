@@ -0,0 +1,93 @@
+package observation
+
+import (
+	"bytes"
+	"fmt"
+	"net/textproto"
+	"sort"
+)
+
+// maximum number of headers listed in the header size report
+const headerSizeTopN = 10
+
+// headerSize is the on-wire size of header lines sharing the same field name.
+type headerSize struct {
+	name  string
+	bytes int // including the trailing CRLF of each line
+	lines int
+}
+
+// headerSizes measures the on-wire size of the request line and each header in the captured raw request.
+// complete is false if the end of the header section wasn't in the capture.
+func headerSizes(raw []byte) (reqLine int, sizes []headerSize, complete bool) {
+	head := raw
+	if i := bytes.Index(raw, []byte("\r\n\r\n")); i >= 0 {
+		head = raw[:i+2]
+		complete = true
+	}
+
+	idx := make(map[string]int)
+	for n := 0; ; n++ {
+		i := bytes.Index(head, []byte("\r\n"))
+		if i < 0 {
+			break
+		}
+		line := head[:i]
+		head = head[i+2:]
+
+		if n == 0 {
+			reqLine = len(line) + 2
+			continue
+		}
+		name := string(line)
+		if c := bytes.IndexByte(line, ':'); c >= 0 {
+			name = textproto.CanonicalMIMEHeaderKey(string(line[:c]))
+		}
+		j, ok := idx[name]
+		if !ok {
+			j = len(sizes)
+			idx[name] = j
+			sizes = append(sizes, headerSize{name: name})
+		}
+		sizes[j].bytes += len(line) + 2
+		sizes[j].lines++
+	}
+
+	sort.SliceStable(sizes, func(i, j int) bool {
+		return sizes[i].bytes > sizes[j].bytes
+	})
+	return reqLine, sizes, complete
+}
+
+// printHeaderSizeReport ranks headers in the captured raw request by on-wire byte size.
+func (s *session) printHeaderSizeReport(raw []byte) {
+	reqLine, sizes, complete := headerSizes(raw)
+
+	var hdrTotal int
+	for _, s := range sizes {
+		hdrTotal += s.bytes
+	}
+	total := reqLine + hdrTotal
+	if complete {
+		total += 2 // blank line terminating the header section
+	}
+
+	fmt.Fprintf(s.out, "Header size: %d bytes in total (request line: %d bytes, %d headers: %d bytes)\n", total, reqLine, len(sizes), hdrTotal)
+	if !complete {
+		fmt.Fprintln(s.out, "  (header section is truncated in the capture)")
+	}
+
+	var cum int
+	for i, hs := range sizes {
+		if i == headerSizeTopN {
+			fmt.Fprintf(s.out, "  ... and %d more\n", len(sizes)-headerSizeTopN)
+			break
+		}
+		cum += hs.bytes
+		lines := ""
+		if hs.lines > 1 {
+			lines = fmt.Sprintf(" (%d lines)", hs.lines)
+		}
+		fmt.Fprintf(s.out, "  %2d. %6d bytes %5.1f%% (cumulative %6d bytes) %s%s\n", i+1, hs.bytes, 100*float64(hs.bytes)/float64(hdrTotal), cum, hs.name, lines)
+	}
+}
@@ -0,0 +1,111 @@
+package observation
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// headerStages records request headers at three layers: as built by the caller, as passed to the Transport
+// (after http.Client added its headers), and as written on the wire by the Transport.
+type headerStages struct {
+	built     http.Header
+	roundTrip http.Header
+	wire      *recorder
+}
+
+// wireRecordingConn records all bytes written to the connection.
+type wireRecordingConn struct {
+	net.Conn
+	rec *recorder
+}
+
+func (c *wireRecordingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.rec.write(p[:n])
+	return n, err
+}
+
+// snapshotRoundTripper takes a snapshot of request headers just before handing the request to the Transport.
+type snapshotRoundTripper struct {
+	base   http.RoundTripper
+	stages *headerStages
+}
+
+func (t *snapshotRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.stages.roundTrip == nil {
+		t.stages.roundTrip = req.Header.Clone()
+	}
+	return t.base.RoundTrip(req)
+}
+
+// CloseIdleConnections closes idle connections of the base Transport, for http.Client.CloseIdleConnections.
+func (t *snapshotRoundTripper) CloseIdleConnections() {
+	(&http.Client{Transport: t.base}).CloseIdleConnections()
+}
+
+// traceHeaderStages snapshots headers of the built request, and wraps tr to snapshot them at RoundTrip and record the wire.
+func traceHeaderStages(req *http.Request, tr http.RoundTripper) (http.RoundTripper, *headerStages) {
+	s := &headerStages{built: req.Header.Clone(), wire: &recorder{}}
+
+	t := tr.(*http.Transport).Clone()
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &wireRecordingConn{Conn: conn, rec: s.wire}, nil
+	}
+	return &snapshotRoundTripper{base: t, stages: s}, s
+}
+
+// print prints a three-way diff of headers, attributing each addition or change to the layer that made it.
+func (st *headerStages) print(s *session) {
+	_, fields, _ := parseRawHead(st.wire.bytes())
+	wire, order := groupFields(fields, nil)
+	built, order := canonicalHeader(st.built, order)
+	roundTrip, order := canonicalHeader(st.roundTrip, order)
+
+	value := func(h map[string][]string, name string) string {
+		vs, ok := h[name]
+		if !ok {
+			return "(none)"
+		}
+		return s.redactValue(name, strings.Join(vs, ", "))
+	}
+
+	fmt.Fprintln(s.out, "Header stages (built -> at RoundTrip -> on the wire):")
+	for _, name := range order {
+		b, r, w := value(built, name), value(roundTrip, name), value(wire, name)
+		var layers []string
+		if b != r {
+			layers = append(layers, "http.Client")
+		}
+		if r != w {
+			layers = append(layers, "Transport")
+		}
+		if len(layers) == 0 {
+			fmt.Fprintf(s.out, "  = %s: %s\n", name, b)
+			continue
+		}
+		fmt.Fprintf(s.out, "  ~ %s: %s -> %s -> %s  (changed by %s)\n", name, b, r, w, strings.Join(layers, " and "))
+	}
+}
+
+// canonicalHeader groups header values by canonical name like groupFields, for headers set in http.Header directly.
+func canonicalHeader(h http.Header, order []string) (map[string][]string, []string) {
+	var fields []HeaderField
+	for _, name := range sortedKeys(h) {
+		for _, v := range h[name] {
+			fields = append(fields, HeaderField{Name: textproto.CanonicalMIMEHeaderKey(name), Value: v})
+		}
+	}
+	return groupFields(fields, order)
+}
@@ -0,0 +1,68 @@
+package observation
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// overrideHost is the host the Host patterns ask for in place of the URL host.
+const overrideHost = "example.com"
+
+// observeHostOverride sends a GET to the URL of a capture server, asking for overrideHost with Request.Host (reqHostField)
+// or with a Host header in Request.Header (reqHostHeader), and reports which host went on the wire.
+func observeHostOverride(p reqPattern, opts runOptions) (*timing, error) {
+	captures := make(chan capturedRequest, 1)
+	url, stop, err := opts.startEphemeralServer(func() ServerBehavior { return BaseBehavior{} }, captures, true)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	req, err := http.NewRequestWithContext(opts.ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	if p == reqHostField {
+		req.Host = overrideHost
+	} else {
+		req.Header.Set("Host", overrideHost)
+	}
+	urlHost, reqHost, headerHost := req.URL.Host, req.Host, req.Header["Host"]
+
+	req, tm := traceTiming(req)
+	err = opts.sendReq(http.DefaultTransport, req)
+	tm.finish()
+	if err != nil {
+		return nil, err
+	}
+	got := <-captures
+	if opts.quiet {
+		return tm, nil
+	}
+
+	_, fields, _ := parseRawHead(got.raw)
+	var onWire []string
+	for _, f := range fields {
+		if strings.EqualFold(f.Name, "Host") {
+			onWire = append(onWire, f.Value)
+		}
+	}
+	fmt.Fprintf(opts.out, "%-26s%s (where the connection went)\n", "URL host:", urlHost)
+	fmt.Fprintf(opts.out, "%-26s%q\n", "Request.Host:", reqHost)
+	fmt.Fprintf(opts.out, "%-26s%q\n", `Request.Header["Host"]:`, headerHost)
+	fmt.Fprintf(opts.out, "%-26s%q\n", "Host on the wire:", onWire)
+	fmt.Fprintf(opts.out, "%-26s%q\n", "Go server's Request.Host:", got.req.Host)
+
+	switch {
+	case len(onWire) != 1:
+		fmt.Fprintln(opts.out, "  => "+opts.finding(sevWarn, fmt.Sprintf("%d Host header lines were written", len(onWire))))
+	case onWire[0] == overrideHost && p == reqHostField:
+		fmt.Fprintln(opts.out, "  => "+opts.finding(sevInfo, "the Host header carried Request.Host instead of the URL host, while the connection still went to the URL host"))
+	case onWire[0] == urlHost && p == reqHostHeader:
+		fmt.Fprintln(opts.out, "  => "+opts.finding(sevInfo, "Request.Header[\"Host\"] was ignored: the Host header carried the URL host, as http.NewRequest had set Request.Host from it"))
+	default:
+		fmt.Fprintln(opts.out, "  => "+opts.finding(sevInfo, fmt.Sprintf("the Host header carried %q", onWire[0])))
+	}
+	return tm, nil
+}
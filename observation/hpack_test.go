@@ -0,0 +1,201 @@
+package observation
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// RFC 7541 C.1
+func TestHPACKInt(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		prefix int
+		want   uint64
+	}{
+		{"C.1.1 10 with a 5-bit prefix", "0a", 5, 10},
+		{"C.1.1 with the bits before the prefix set", "ea", 5, 10},
+		{"C.1.2 1337 with a 5-bit prefix", "1f 9a 0a", 5, 1337},
+		{"C.1.3 42 at an octet boundary", "2a", 8, 42},
+		{"prefix filled with a zero continuation", "1f 00", 5, 31},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, rest, err := hpackInt(append(decodeHex(t, tt.in), 0xff), tt.prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+			if len(rest) != 1 {
+				t.Errorf("left %d bytes, want the 1 byte following the integer", len(rest))
+			}
+		})
+	}
+}
+
+// RFC 7541 C.2 to C.6. Blocks of a case are decoded in order by one decoder, as the dynamic table carries over.
+func TestHPACKDecode(t *testing.T) {
+	reqs := [][]hpackField{
+		{{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"}},
+		{{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"}, {"cache-control", "no-cache"}},
+		{{":method", "GET"}, {":scheme", "https"}, {":path", "/index.html"}, {":authority", "www.example.com"}, {"custom-key", "custom-value"}},
+	}
+	reqTable := []hpackField{{"custom-key", "custom-value"}, {"cache-control", "no-cache"}, {":authority", "www.example.com"}}
+	resps := [][]hpackField{
+		{{":status", "302"}, {"cache-control", "private"}, {"date", "Mon, 21 Oct 2013 20:13:21 GMT"}, {"location", "https://www.example.com"}},
+		{{":status", "307"}, {"cache-control", "private"}, {"date", "Mon, 21 Oct 2013 20:13:21 GMT"}, {"location", "https://www.example.com"}},
+		{{":status", "200"}, {"cache-control", "private"}, {"date", "Mon, 21 Oct 2013 20:13:22 GMT"}, {"location", "https://www.example.com"},
+			{"content-encoding", "gzip"}, {"set-cookie", "foo=ASDJKHQKBZXOQWEOPIUAXQWEOIU; max-age=3600; version=1"}},
+	}
+	respTable := []hpackField{{"set-cookie", "foo=ASDJKHQKBZXOQWEOPIUAXQWEOIU; max-age=3600; version=1"}, {"content-encoding", "gzip"},
+		{"date", "Mon, 21 Oct 2013 20:13:22 GMT"}}
+
+	tests := []struct {
+		name      string
+		maxSize   int // of the dynamic table, 4096 if 0
+		blocks    []string
+		want      [][]hpackField
+		wantTable []hpackField // after the last block, newest first
+		wantSize  int
+	}{
+		{
+			name:      "C.2.1 literal with indexing",
+			blocks:    []string{"400a 6375 7374 6f6d 2d6b 6579 0d63 7573 746f 6d2d 6865 6164 6572"},
+			want:      [][]hpackField{{{"custom-key", "custom-header"}}},
+			wantTable: []hpackField{{"custom-key", "custom-header"}},
+			wantSize:  55,
+		},
+		{
+			name:   "C.2.2 literal without indexing",
+			blocks: []string{"040c 2f73 616d 706c 652f 7061 7468"},
+			want:   [][]hpackField{{{":path", "/sample/path"}}},
+		},
+		{
+			name:   "C.2.3 literal never indexed",
+			blocks: []string{"1008 7061 7373 776f 7264 0673 6563 7265 74"},
+			want:   [][]hpackField{{{"password", "secret"}}},
+		},
+		{
+			name:   "C.2.4 indexed",
+			blocks: []string{"82"},
+			want:   [][]hpackField{{{":method", "GET"}}},
+		},
+		{
+			name: "C.3 requests without Huffman coding",
+			blocks: []string{
+				"8286 8441 0f77 7777 2e65 7861 6d70 6c65 2e63 6f6d",
+				"8286 84be 5808 6e6f 2d63 6163 6865",
+				"8287 85bf 400a 6375 7374 6f6d 2d6b 6579 0c63 7573 746f 6d2d 7661 6c75 65",
+			},
+			want:      reqs,
+			wantTable: reqTable,
+			wantSize:  164,
+		},
+		{
+			name: "C.4 requests with Huffman coding",
+			blocks: []string{
+				"8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff",
+				"8286 84be 5886 a8eb 1064 9cbf",
+				"8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf",
+			},
+			want:      reqs,
+			wantTable: reqTable,
+			wantSize:  164,
+		},
+		{
+			name:    "C.5 responses without Huffman coding, evicting entries",
+			maxSize: 256,
+			blocks: []string{
+				"4803 3330 3258 0770 7269 7661 7465 611d 4d6f 6e2c 2032 3120 4f63 7420 3230 3133 2032 303a 3133 3a32 3120 474d 546e 1768 7474 7073 3a2f 2f77 7777 2e65 7861 6d70 6c65 2e63 6f6d",
+				"4803 3330 37c1 c0bf",
+				"88c1 611d 4d6f 6e2c 2032 3120 4f63 7420 3230 3133 2032 303a 3133 3a32 3220 474d 54c0 5a04 677a 6970 7738 666f 6f3d 4153 444a 4b48 514b 425a 584f 5157 454f 5049 5541 5851 5745 4f49 553b 206d 6178 2d61 6765 3d33 3630 303b 2076 6572 7369 6f6e 3d31",
+			},
+			want:      resps,
+			wantTable: respTable,
+			wantSize:  215,
+		},
+		{
+			name:    "C.6 responses with Huffman coding, evicting entries",
+			maxSize: 256,
+			blocks: []string{
+				"4882 6402 5885 aec3 771a 4b61 96d0 7abe 9410 54d4 44a8 2005 9504 0b81 66e0 82a6 2d1b ff6e 919d 29ad 1718 63c7 8f0b 97c8 e9ae 82ae 43d3",
+				"4883 640e ffc1 c0bf",
+				"88c1 6196 d07a be94 1054 d444 a820 0595 040b 8166 e084 a62d 1bff c05a 839b d9ab 77ad 94e7 821d d7f2 e6c7 b335 dfdf cd5b 3960 d5af 2708 7f36 72c1 ab27 0fb5 291f 9587 3160 65c0 03ed 4ee5 b106 3d50 07",
+			},
+			want:      resps,
+			wantTable: respTable,
+			wantSize:  215,
+		},
+		{
+			name:   "dynamic table size update to 0 empties the table",
+			blocks: []string{"8286 8441 0f77 7777 2e65 7861 6d70 6c65 2e63 6f6d", "20 82"},
+			want:   [][]hpackField{reqs[0], {{":method", "GET"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newHPACKDecoder()
+			if tt.maxSize > 0 {
+				d.maxSize = tt.maxSize
+			}
+			for i, block := range tt.blocks {
+				got, err := d.decode(decodeHex(t, block))
+				if err != nil {
+					t.Fatalf("block %d: %v", i+1, err)
+				}
+				if !reflect.DeepEqual(got, tt.want[i]) {
+					t.Errorf("block %d: got %q, want %q", i+1, got, tt.want[i])
+				}
+			}
+			if len(d.dyn) != len(tt.wantTable) || (len(d.dyn) > 0 && !reflect.DeepEqual(d.dyn, tt.wantTable)) {
+				t.Errorf("dynamic table: got %q, want %q", d.dyn, tt.wantTable)
+			}
+			if d.size != tt.wantSize {
+				t.Errorf("dynamic table size: got %d, want %d", d.size, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestHPACKDecodeMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		block   string
+		wantErr string
+	}{
+		{"integer truncated before its continuation", "1f", "truncated"},
+		{"integer truncated within its continuation", "ff 80", "truncated"},
+		{"integer overflowing 64 bits", "ff ffff ffff ffff ffff ffff 01", "overflow"},
+		{"string length beyond the block", "40 05 6162", "truncated"},
+		{"index 0", "80", "index 0"},
+		{"index beyond the static table with an empty dynamic table", "be", "out of the tables"},
+		{"name index beyond the tables", "7f 01 00", "out of the tables"},
+		{"Huffman-coded EOS", "00 84 ffff ffff 00", "invalid Huffman code"},
+		{"Huffman padding longer than 7 bits", "00 82 ffff 00", "invalid Huffman padding"},
+		{"Huffman padding of zero bits", "00 81 18 00", "invalid Huffman padding"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newHPACKDecoder().decode(decodeHex(t, tt.block))
+			if err == nil {
+				t.Fatalf("got %q, want an error containing %q", got, tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %q, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
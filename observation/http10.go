@@ -0,0 +1,213 @@
+package observation
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"os"
+	"strings"
+)
+
+// http10Behavior replies in HTTP/1.0, keeping the connection open and saying so with Connection: keep-alive if keepAlive.
+type http10Behavior struct {
+	BaseBehavior
+	keepAlive bool
+}
+
+func (b http10Behavior) Respond(w io.Writer, _ *http.Request) (bool, error) {
+	resp := "HTTP/1.0 200 OK\r\nContent-Length: 0\r\n"
+	if b.keepAlive {
+		resp += "Connection: keep-alive\r\n"
+	}
+	_, err := io.WriteString(w, resp+"\r\n")
+	return b.keepAlive, err
+}
+
+// variants of a request on the wire fed to the server side, derived from the one the client sent
+var http10ParseCases = []struct {
+	desc  string
+	proto string
+	host  bool   // keep the Host header
+	conn  string // Connection header to add, if any
+	chunk bool   // replace the body with a chunked one
+}{
+	{desc: "HTTP/1.0 with Host", proto: "HTTP/1.0", host: true},
+	{desc: "HTTP/1.0 without Host", proto: "HTTP/1.0"},
+	{desc: "HTTP/1.0 with Connection: keep-alive", proto: "HTTP/1.0", host: true, conn: "keep-alive"},
+	{desc: "HTTP/1.0 with a chunked body", proto: "HTTP/1.0", host: true, chunk: true},
+	{desc: "HTTP/1.1 without Host", proto: "HTTP/1.1"},
+}
+
+// observeHTTP10 sets Proto, ProtoMajor and ProtoMinor of outgoing requests to HTTP/1.0, and reports what the Transport
+// and Request.Write put on the wire, and how keep-alive goes with servers replying in HTTP/1.0 with and without Connection: keep-alive.
+// Then feeds HTTP/1.0 variants of the request to http.ReadRequest and net/http's server, reporting how they treat them.
+func observeHTTP10(opts runOptions) (*timing, error) {
+	data, err := os.ReadFile(opts.filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	newReq := func(url string) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(opts.ctx, http.MethodPut, url, bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+		req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/1.0", 1, 0
+		return req, nil
+	}
+
+	var (
+		first *timing
+		sent  []byte
+	)
+	for _, keepAlive := range []bool{false, true} {
+		captures := make(chan capturedRequest, 2)
+		url, stop, err := opts.startEphemeralServer(func() ServerBehavior { return http10Behavior{keepAlive: keepAlive} }, captures, true)
+		if err != nil {
+			return nil, err
+		}
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		cli := &http.Client{Transport: tr}
+
+		var (
+			reused bool
+			resp   *http.Response
+		)
+		for i := 0; i < 2; i++ {
+			req, err := newReq(url)
+			if err != nil {
+				stop()
+				return nil, err
+			}
+			trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused }}
+			req, tm := traceTiming(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+			if resp, err = cli.Do(req); err != nil {
+				stop()
+				return nil, fmt.Errorf("HTTP request failed: %w", err)
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+			tm.finish()
+			if first == nil {
+				first = tm
+			}
+		}
+		tr.CloseIdleConnections()
+		stop()
+		got := <-captures
+		sent = got.raw
+
+		if opts.quiet {
+			continue
+		}
+		if keepAlive {
+			fmt.Fprintln(opts.out, "[Transport, server replying HTTP/1.0 with Connection: keep-alive]")
+		} else {
+			fmt.Fprintln(opts.out, "[Transport, server replying HTTP/1.0 without Connection]")
+		}
+		line, _, _ := parseRawHead(got.raw)
+		fmt.Fprintf(opts.out, "  request line: %s, Host: %s, Connection: %s\n", line, got.req.Host, connectionHeader(got.raw))
+		if !strings.HasSuffix(line, "HTTP/1.0") {
+			fmt.Fprintln(opts.out, "  => "+opts.finding(sevInfo, "Request.Proto, ProtoMajor and ProtoMinor are ignored by the client: the request went out as HTTP/1.1"))
+		}
+		fmt.Fprintf(opts.out, "  response: %s, Response.Close: %v, second request reused the connection: %v\n", resp.Proto, resp.Close, reused)
+	}
+
+	if !opts.quiet {
+		fmt.Fprintln(opts.out, "[Request.Write]")
+		req, err := newReq("http://origin.example/")
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := req.Write(&buf); err != nil {
+			return nil, fmt.Errorf("failed to write request: %w", err)
+		}
+		line, _, _ := parseRawHead(buf.Bytes())
+		fmt.Fprintf(opts.out, "  request line: %s, Connection: %s\n", line, connectionHeader(buf.Bytes()))
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(io.Discard, r.Body)
+		fmt.Fprintf(w, "%s %d", r.Proto, n)
+	}))
+	defer srv.Close()
+	for _, c := range http10ParseCases {
+		raw := http10Variant(sent, c.proto, c.host, c.conn, c.chunk)
+		parsed, perr := http.ReadRequest(bufio.NewReader(bytes.NewReader(raw)))
+		status, err := sendRawToServer(srv.Listener.Addr().String(), raw)
+		if err != nil {
+			return nil, err
+		}
+		if opts.quiet {
+			continue
+		}
+		fmt.Fprintf(opts.out, "[server side: %s]\n", c.desc)
+		if perr != nil {
+			fmt.Fprintf(opts.out, "  http.ReadRequest: %v\n", perr)
+		} else {
+			fmt.Fprintf(opts.out, "  http.ReadRequest: Proto %s, Host %q, Close %v, ContentLength %d, TransferEncoding %v\n",
+				parsed.Proto, parsed.Host, parsed.Close, parsed.ContentLength, parsed.TransferEncoding)
+			if c.chunk && len(parsed.TransferEncoding) == 0 {
+				fmt.Fprintln(opts.out, "  => "+opts.finding(sevWarn, "Transfer-Encoding of an HTTP/1.0 request is ignored by http.ReadRequest, leaving the chunked body on the connection unread"))
+			}
+		}
+		fmt.Fprintf(opts.out, "  net/http server: %s\n", status)
+	}
+	return first, nil
+}
+
+// http10Variant rewrites the version in the request line of raw to proto, dropping Host unless host,
+// adding Connection: conn if it's non-empty, and replacing the body with a small chunked one if chunk.
+func http10Variant(raw []byte, proto string, host bool, conn string, chunk bool) []byte {
+	head, body, _ := bytes.Cut(raw, []byte("\r\n\r\n"))
+	lines := strings.Split(string(head), "\r\n")
+	if i := strings.LastIndexByte(lines[0], ' '); i >= 0 {
+		lines[0] = lines[0][:i+1] + proto
+	}
+	var b bytes.Buffer
+	b.WriteString(lines[0] + "\r\n")
+	for _, l := range lines[1:] {
+		name, _, _ := strings.Cut(l, ":")
+		if strings.EqualFold(name, "Host") && !host {
+			continue
+		}
+		if chunk && strings.EqualFold(name, "Content-Length") {
+			continue
+		}
+		b.WriteString(l + "\r\n")
+	}
+	if conn != "" {
+		b.WriteString("Connection: " + conn + "\r\n")
+	}
+	if chunk {
+		b.WriteString("Transfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n")
+	} else {
+		b.WriteString("\r\n")
+		b.Write(body)
+	}
+	return b.Bytes()
+}
+
+// sendRawToServer writes raw to a connection to addr, and summarizes the response.
+func sendRawToServer(addr string, raw []byte) (string, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(raw); err != nil {
+		return "", fmt.Errorf("failed to write request: %w", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return fmt.Sprintf("no response: %v", err), nil
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	return fmt.Sprintf("%s %s, Connection: %q, body %q, Response.Close %v", resp.Proto, resp.Status, resp.Header.Get("Connection"), body, resp.Close), nil
+}
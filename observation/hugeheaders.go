@@ -0,0 +1,150 @@
+package observation
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// total size of response headers sent by hugeHeadersBehavior
+	hugeHeadersSize = 1 << 20
+	// Transport.MaxResponseHeaderBytes in the experiment, far below hugeHeadersSize
+	experimentMaxResponseHeaderBytes = 64 << 10
+)
+
+func init() {
+	RegisterServerBehavior("huge-headers", "reply 200 with about 1MiB of response headers after reading the whole body", func() ServerBehavior {
+		return &hugeHeadersBehavior{}
+	})
+}
+
+// hugeHeadersBehavior replies with oversized response headers, either after reading the whole body or right after reading request headers (early).
+type hugeHeadersBehavior struct {
+	BaseBehavior
+	early bool
+	conn  net.Conn
+}
+
+func (b *hugeHeadersBehavior) OnAccept(conn net.Conn) error {
+	b.conn = conn
+	return nil
+}
+
+func (b *hugeHeadersBehavior) OnHeaders(*http.Request) error {
+	if !b.early {
+		return nil
+	}
+	return writeResponse(b.conn, http.StatusOK, hugeHeader(), false)
+}
+
+func (b *hugeHeadersBehavior) Respond(w io.Writer, _ *http.Request) (bool, error) {
+	if b.early {
+		return false, nil
+	}
+	return false, writeResponse(w, http.StatusOK, hugeHeader(), false)
+}
+
+// hugeHeader builds filler headers of about hugeHeadersSize bytes in total.
+func hugeHeader() http.Header {
+	h := make(http.Header)
+	value := strings.Repeat("a", 1000)
+	for i := 0; i < hugeHeadersSize/len(value); i++ {
+		h.Set(fmt.Sprintf("X-Filler-%04d", i), value)
+	}
+	return h
+}
+
+// observeHugeResponseHeaders uploads the file to a server replying with oversized headers, with Transport.MaxResponseHeaderBytes set.
+// Reports when the client aborted, the error returned and whether the request body had been fully sent by then,
+// both when the response is sent after the body is read and when it's sent before.
+func observeHugeResponseHeaders(opts runOptions) (*timing, error) {
+	data, err := os.ReadFile(opts.filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	var first *timing
+	for _, early := range []bool{false, true} {
+		url, stop, err := opts.startEphemeralServer(func() ServerBehavior { return &hugeHeadersBehavior{early: early} }, nil, true)
+		if err != nil {
+			return nil, err
+		}
+
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.MaxResponseHeaderBytes = experimentMaxResponseHeaderBytes
+
+		req, err := http.NewRequestWithContext(opts.ctx, http.MethodPut, url, bytes.NewReader(data))
+		if err != nil {
+			stop()
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+
+		var (
+			mu           sync.Mutex
+			start        = time.Now()
+			wroteRequest string
+			firstByte    time.Duration
+		)
+		trace := &httptrace.ClientTrace{
+			WroteRequest: func(info httptrace.WroteRequestInfo) {
+				mu.Lock()
+				defer mu.Unlock()
+				if info.Err != nil {
+					wroteRequest = fmt.Sprintf("failed at %v: %v", time.Since(start), info.Err)
+				} else {
+					wroteRequest = fmt.Sprintf("fully sent at %v", time.Since(start))
+				}
+			},
+			GotFirstResponseByte: func() {
+				mu.Lock()
+				defer mu.Unlock()
+				firstByte = time.Since(start)
+			},
+		}
+		req, tm := traceTiming(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+		resp, reqErr := (&http.Client{Transport: tr}).Do(req)
+		if reqErr == nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+		tm.finish()
+		// wait a moment for the write loop to report the result of writing the body
+		time.Sleep(50 * time.Millisecond)
+		tr.CloseIdleConnections()
+		stop()
+
+		if first == nil {
+			first = tm
+		}
+		if opts.quiet {
+			continue
+		}
+
+		when := "after reading the whole body"
+		if early {
+			when = "before reading the body"
+		}
+		mu.Lock()
+		fmt.Fprintf(opts.out, "[%d bytes of response headers, sent %s; MaxResponseHeaderBytes = %d]\n", hugeHeadersSize, when, experimentMaxResponseHeaderBytes)
+		fmt.Fprintf(opts.out, "  first response byte at %v\n", firstByte)
+		if reqErr != nil {
+			fmt.Fprintf(opts.out, "  client aborted at %v: %v\n", tm.total, reqErr)
+		} else {
+			fmt.Fprintf(opts.out, "  client got response at %v: %s\n", tm.total, resp.Status)
+		}
+		if wroteRequest == "" {
+			wroteRequest = "not reported"
+		}
+		fmt.Fprintf(opts.out, "  request body: %s\n", wroteRequest)
+		mu.Unlock()
+	}
+	return first, nil
+}
@@ -0,0 +1,78 @@
+package observation
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"sync"
+	"time"
+)
+
+// interimRecorder records 1xx interim responses received by the client, along with when the request was fully sent.
+type interimRecorder struct {
+	mu           sync.Mutex
+	start        time.Time
+	wroteRequest time.Duration // zero until the whole request is written
+	responses    []interimResponse
+}
+
+type interimResponse struct {
+	at       time.Duration
+	code     int
+	header   textproto.MIMEHeader
+	bodySent bool // whether the request had been fully written when the response arrived
+}
+
+// traceInterim attaches httptrace hooks recording 1xx interim responses other than "100 Continue".
+func traceInterim(req *http.Request) (*http.Request, *interimRecorder) {
+	r := &interimRecorder{start: time.Now()}
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+
+			r.responses = append(r.responses, interimResponse{
+				at:       time.Since(r.start),
+				code:     code,
+				header:   header,
+				bodySent: r.wroteRequest != 0,
+			})
+			return nil
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+
+			if r.wroteRequest == 0 {
+				r.wroteRequest = time.Since(r.start)
+			}
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace)), r
+}
+
+func (r *interimRecorder) print(s *session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.responses) == 0 {
+		return
+	}
+	fmt.Fprintln(s.out, "Interim responses received by the client (httptrace Got1xxResponse):")
+	for i, res := range r.responses {
+		when := "before the request was fully sent"
+		if res.bodySent {
+			when = "after the request was fully sent"
+		}
+		fmt.Fprintf(s.out, "  #%d: %d %s at %v (%s)\n", i+1, res.code, http.StatusText(res.code), res.at, when)
+		for _, k := range sortedKeys(res.header) {
+			for _, v := range res.header[k] {
+				fmt.Fprintf(s.out, "      %s: %s\n", k, s.redactValue(k, v))
+			}
+		}
+	}
+	if r.wroteRequest != 0 {
+		fmt.Fprintf(s.out, "  request fully sent at %v\n", r.wroteRequest)
+	}
+}
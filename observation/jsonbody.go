@@ -0,0 +1,77 @@
+package observation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// jsonUploadDoc is the JSON document uploaded by the JSON body patterns, embedding the file as base64.
+type jsonUploadDoc struct {
+	Name    string `json:"name"`
+	Content []byte `json:"content"`
+}
+
+func readJSONUploadDoc(body io.Reader, filename string) (*jsonUploadDoc, error) {
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+	return &jsonUploadDoc{Name: filepath.Base(filename), Content: content}, nil
+}
+
+// POST request with a JSON body marshaled into bytes.Buffer first
+func jsonMarshaledReq(url string, doc *jsonUploadDoc) (*http.Request, error) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(doc); err != nil {
+		return nil, fmt.Errorf("failed to encode JSON: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, url, &buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// POST request with a JSON body written by json.Encoder into io.Pipe while the Transport sends it.
+// Note that Encoder.Encode marshals the whole value into its own buffer before writing to the pipe.
+func jsonEncodedPipeReq(url string, doc *jsonUploadDoc) (*http.Request, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		_ = pw.CloseWithError(json.NewEncoder(pw).Encode(doc))
+	}()
+	req, err := http.NewRequest(http.MethodPost, url, pr)
+	if err != nil {
+		_ = pr.Close()
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// JSON bodies compared by -json-perf. The document is read from the file while building, as API clients usually have it in memory already
+var jsonUploads = []uploadBuild{
+	{desc: "marshaled (bytes.Buffer)", build: jsonUploadBuild(jsonMarshaledReq)},
+	{desc: "encoded (io.Pipe)", build: jsonUploadBuild(jsonEncodedPipeReq)},
+}
+
+func jsonUploadBuild(newReq func(url string, doc *jsonUploadDoc) (*http.Request, error)) func(url, filename string) (*http.Request, error) {
+	return func(url, filename string) (*http.Request, error) {
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		doc, err := readJSONUploadDoc(f, filename)
+		if err != nil {
+			return nil, err
+		}
+		return newReq(url, doc)
+	}
+}
@@ -0,0 +1,134 @@
+package observation
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// resourceSnapshot counts goroutines by the function that created them, and open file descriptors by kind.
+type resourceSnapshot struct {
+	goroutines map[string]int
+	fds        map[string]int // nil if file descriptors can't be listed on this platform
+}
+
+func takeResourceSnapshot() resourceSnapshot {
+	return resourceSnapshot{goroutines: goroutinesByCreator(), fds: openFDsByKind()}
+}
+
+// goroutinesByCreator classifies all goroutines by the "created by" line of their stacks.
+func goroutinesByCreator() map[string]int {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	counts := make(map[string]int)
+	for _, g := range bytes.Split(buf, []byte("\n\n")) {
+		creator := "(main)"
+		for _, line := range strings.Split(string(g), "\n") {
+			if strings.HasPrefix(line, "created by ") {
+				creator, _, _ = strings.Cut(strings.TrimPrefix(line, "created by "), " in goroutine")
+			}
+		}
+		counts[creator]++
+	}
+	return counts
+}
+
+// openFDsByKind classifies open file descriptors of the process (socket, pipe, file etc.), using /proc.
+func openFDsByKind() map[string]int {
+	dir := "/proc/self/fd"
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	counts := make(map[string]int)
+	for _, e := range entries {
+		target, err := os.Readlink(filepath.Join(dir, e.Name()))
+		if err != nil {
+			// the fd used for reading the directory itself is already closed
+			continue
+		}
+		kind := "file"
+		if k, _, ok := strings.Cut(target, ":["); ok {
+			kind = k // socket, pipe
+		} else if strings.HasPrefix(target, "anon_inode:") {
+			kind = target
+		}
+		counts[kind]++
+	}
+	return counts
+}
+
+// settleResources waits for goroutines to wind down to the count in base, up to a second,
+// so that connections being closed asynchronously aren't reported as leaks.
+func settleResources(base resourceSnapshot) resourceSnapshot {
+	want := 0
+	for _, n := range base.goroutines {
+		want += n
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > want && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	return takeResourceSnapshot()
+}
+
+// resourceLeaks describes increases of goroutines and file descriptors from before to after.
+func resourceLeaks(before, after resourceSnapshot) []string {
+	var leaks []string
+	for _, creator := range sortedKeys(after.goroutines) {
+		if d := after.goroutines[creator] - before.goroutines[creator]; d > 0 {
+			leaks = append(leaks, fmt.Sprintf("+%d goroutines created by %s", d, creator))
+		}
+	}
+	for _, kind := range sortedKeys(after.fds) {
+		if d := after.fds[kind] - before.fds[kind]; d > 0 {
+			msg := fmt.Sprintf("+%d open %s fds", d, kind)
+			if kind == "pipe" {
+				msg += " (may be pipes pooled by the runtime for splice(2) between TCP connections)"
+			}
+			leaks = append(leaks, msg)
+		}
+	}
+	return leaks
+}
+
+func (s *session) printResourceLeaks(leaks []string) {
+	if len(leaks) == 0 {
+		fmt.Fprintln(s.out, "Leak check: no goroutines or file descriptors left behind")
+		return
+	}
+	fmt.Fprintln(s.out, "Leak check: left behind after the pattern")
+	for _, l := range leaks {
+		fmt.Fprintf(s.out, "  %s\n", s.finding(sevWarn, l))
+	}
+}
+
+// printLeakSummary prints leaks attributed to each pattern at the end of the run.
+func (s *session) printLeakSummary(leaks map[string][]string, fdsAvailable bool) {
+	fmt.Fprintln(s.out, "Leak summary:")
+	if !fdsAvailable {
+		fmt.Fprintln(s.out, "  (open file descriptors can't be listed on this platform, only goroutines are checked)")
+	}
+	if len(leaks) == 0 {
+		fmt.Fprintln(s.out, "  no pattern left goroutines or file descriptors behind")
+		return
+	}
+	for _, pat := range sortedKeys(leaks) {
+		fmt.Fprintf(s.out, "  %s:\n", pat)
+		for _, l := range leaks[pat] {
+			fmt.Fprintf(s.out, "    %s\n", l)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package observation
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// lenCountBehavior replies 200 like BaseBehavior, counting bytes of the body received.
+type lenCountBehavior struct {
+	BaseBehavior
+	contentLength int64 // as the server parsed it
+	received      int64
+}
+
+func (b *lenCountBehavior) OnHeaders(req *http.Request) error {
+	b.contentLength = req.ContentLength
+	return nil
+}
+
+func (b *lenCountBehavior) OnBodyChunk(_ *http.Request, chunk []byte) error {
+	b.received += int64(len(chunk))
+	return nil
+}
+
+// observeLenMismatch sends the file with Request.ContentLength set to half (len-too-small) or double (len-too-large) its size,
+// and reports what the client made of the mismatch and how much of the body the server received.
+// The error from the Transport is expected, so it's reported rather than returned.
+func observeLenMismatch(p reqPattern, opts runOptions) (*timing, error) {
+	f, err := os.Open(opts.filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	size := stat.Size()
+	cl := size / 2
+	if p == reqSinglePartLenTooLarge {
+		cl = size * 2
+	}
+
+	// serve the single connection here rather than by startEphemeralServer, to know when the server is done with it
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start listening: %w", err)
+	}
+	defer l.Close()
+	b := &lenCountBehavior{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		// the server waits for the missing bytes of len-too-large until the client gives up on the connection
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		opts.serveConn(conn, b, nil, opts.quiet)
+	}()
+
+	req, err := http.NewRequestWithContext(opts.ctx, http.MethodPut, "http://"+l.Addr().String(), f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.ContentLength = cl
+
+	req, tm := traceTiming(req)
+	reqErr := opts.sendReq(http.DefaultTransport, req)
+	tm.finish()
+	<-done
+
+	if opts.quiet {
+		return tm, nil
+	}
+	fmt.Fprintf(opts.out, "Request.ContentLength = %d, file size %d\n", cl, size)
+	if reqErr != nil {
+		fmt.Fprintf(opts.out, "  client: %v\n", reqErr)
+	} else {
+		fmt.Fprintf(opts.out, "  client: succeeded in %v\n", tm.total)
+	}
+	fmt.Fprintf(opts.out, "  server: Content-Length %d, %d bytes of the body received\n", b.contentLength, b.received)
+	switch {
+	case b.received == b.contentLength && b.received < size:
+		fmt.Fprintln(opts.out, "  => "+opts.finding(sevWarn, fmt.Sprintf("the server received a complete request with the body truncated to %d of %d bytes; the client reports the error only after sending it", b.received, size)))
+	case b.received < b.contentLength:
+		fmt.Fprintln(opts.out, "  => "+opts.finding(sevInfo, fmt.Sprintf("the client closed the connection after %d of the %d bytes promised, so the server saw an incomplete body", b.received, b.contentLength)))
+	}
+	return tm, nil
+}
@@ -0,0 +1,124 @@
+package observation
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lifecycle records httptrace events of a request with the time since the request was sent.
+type lifecycle struct {
+	mu     sync.Mutex
+	start  time.Time
+	events []lifecycleEvent
+}
+
+type lifecycleEvent struct {
+	at     time.Duration
+	name   string
+	detail string
+}
+
+func (l *lifecycle) add(name, detail string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.events = append(l.events, lifecycleEvent{at: time.Since(l.start), name: name, detail: detail})
+}
+
+// at returns when the first event with the name happened, and whether it did.
+func (l *lifecycle) at(name string) (time.Duration, bool) {
+	for _, e := range l.events {
+		if e.name == name {
+			return e.at, true
+		}
+	}
+	return 0, false
+}
+
+// traceLifecycle attaches httptrace hooks recording the client lifecycle of req:
+// getting a connection, DNS, connecting, TLS, writing headers and the body, and the response.
+func traceLifecycle(req *http.Request) (*http.Request, *lifecycle) {
+	l := &lifecycle{start: time.Now()}
+	errDetail := func(err error) string {
+		if err != nil {
+			return "error: " + err.Error()
+		}
+		return ""
+	}
+	trace := &httptrace.ClientTrace{
+		GetConn: func(hostPort string) { l.add("GetConn", hostPort) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			detail := "new connection"
+			if info.Reused {
+				detail = fmt.Sprintf("reused, idle for %v", info.IdleTime)
+			}
+			l.add("GotConn", detail)
+		},
+		DNSStart: func(info httptrace.DNSStartInfo) { l.add("DNSStart", info.Host) },
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			if info.Err != nil {
+				l.add("DNSDone", errDetail(info.Err))
+				return
+			}
+			addrs := make([]string, len(info.Addrs))
+			for i, a := range info.Addrs {
+				addrs[i] = a.String()
+			}
+			l.add("DNSDone", strings.Join(addrs, ", "))
+		},
+		ConnectStart: func(network, addr string) { l.add("ConnectStart", network+" "+addr) },
+		ConnectDone: func(network, addr string, err error) {
+			detail := network + " " + addr
+			if err != nil {
+				detail += ", " + errDetail(err)
+			}
+			l.add("ConnectDone", detail)
+		},
+		TLSHandshakeStart: func() { l.add("TLSHandshakeStart", "") },
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err != nil {
+				l.add("TLSHandshakeDone", errDetail(err))
+				return
+			}
+			l.add("TLSHandshakeDone", fmt.Sprintf("%s, ALPN %q", tlsVersionName(state.Version), state.NegotiatedProtocol))
+		},
+		WroteHeaders:    func() { l.add("WroteHeaders", "") },
+		Wait100Continue: func() { l.add("Wait100Continue", "") },
+		Got100Continue:  func() { l.add("Got100Continue", "") },
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			l.add("WroteRequest", errDetail(info.Err))
+		},
+		GotFirstResponseByte: func() { l.add("GotFirstResponseByte", "") },
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace)), l
+}
+
+func (l *lifecycle) print(s *session) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	fmt.Fprintln(s.out, "Client lifecycle (httptrace):")
+	var prev time.Duration
+	for _, e := range l.events {
+		line := fmt.Sprintf("  %12v (+%-10v) %-20s %s", e.at, e.at-prev, e.name, e.detail)
+		fmt.Fprintln(s.out, strings.TrimRight(line, " "))
+		prev = e.at
+	}
+	headers, ok := l.at("WroteHeaders")
+	if !ok {
+		return
+	}
+	if body, ok := l.at("WroteRequest"); ok {
+		fmt.Fprintf(s.out, "  = headers written at %v, the body took %v more to write\n", headers, body-headers)
+	}
+	if resp, ok := l.at("GotFirstResponseByte"); ok {
+		if body, ok := l.at("WroteRequest"); !ok || resp < body {
+			fmt.Fprintln(s.out, "  => "+s.finding(sevInfo, "the response started arriving before the request was fully written"))
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package observation
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+)
+
+// serverHost returns the host the client addresses the capture server listening on ip by. Unspecified addresses (e.g. :: listening
+// dual-stack) and the IPv4 loopback are addressed as localhost, letting the client resolve it as the host configures and dial
+// the addresses it resolves to as it would dual-stack hosts; other addresses, including ::1 which localhost doesn't resolve to
+// on every host, are addressed as themselves.
+func serverHost(ip net.IP) string {
+	switch {
+	case ip.IsUnspecified(), ip.IsLoopback() && ip.To4() != nil:
+		return "localhost"
+	case ip.To4() == nil:
+		return "[" + ip.String() + "]"
+	default:
+		return ip.String()
+	}
+}
+
+// addrFamily returns the address family of the host of addr ("IPv4" or "IPv6").
+func addrFamily(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		return "IPv6"
+	}
+	return "IPv4"
+}
+
+// dialAttempts records the connect attempts of a request and the connection it was sent over.
+type dialAttempts struct {
+	mu       sync.Mutex
+	attempts []string
+	failed   []string // families of failed attempts
+	remote   string
+	reused   bool
+}
+
+// traceDial attaches httptrace hooks recording the connect attempts of req and the connection which carried it.
+func traceDial(req *http.Request) (*http.Request, *dialAttempts) {
+	d := &dialAttempts{}
+	trace := &httptrace.ClientTrace{
+		ConnectDone: func(network, addr string, err error) {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+
+			res := "ok"
+			if err != nil {
+				res = err.Error()
+				d.failed = append(d.failed, addrFamily(addr))
+			}
+			d.attempts = append(d.attempts, fmt.Sprintf("%s %s (%s): %s", network, addr, addrFamily(addr), res))
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+
+			d.remote, d.reused = info.Conn.RemoteAddr().String(), info.Reused
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace)), d
+}
+
+func (d *dialAttempts) print(s *session) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fmt.Fprintln(s.out, "Client dial:")
+	for _, a := range d.attempts {
+		fmt.Fprintf(s.out, "  connect %s\n", a)
+	}
+	if d.remote == "" {
+		fmt.Fprintln(s.out, "  no connection carried the request")
+		return
+	}
+	conn := "new connection"
+	if d.reused {
+		conn = "reused connection"
+	}
+	family := addrFamily(d.remote)
+	fmt.Fprintf(s.out, "  request carried by the %s to %s (%s)\n", conn, d.remote, family)
+	for _, f := range d.failed {
+		if f != family {
+			fmt.Fprintln(s.out, "  => "+s.finding(sevInfo, fmt.Sprintf("connecting over %s failed, and the client fell back to %s", f, family)))
+			return
+		}
+	}
+}
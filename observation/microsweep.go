@@ -0,0 +1,152 @@
+package observation
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+)
+
+// opaqueReader hides the concrete type of the underlying reader, so that the length of the body can't be inferred.
+type opaqueReader struct {
+	io.Reader
+}
+
+// microBodyCase is a combination of body size and framing option for the micro-body sweep.
+type microBodyCase struct {
+	size    int
+	framing string
+	build   func(url string, body []byte) (*http.Request, error) // sending to url
+}
+
+var microBodyFramings = []struct {
+	name  string
+	build func(url string, body []byte) (*http.Request, error)
+}{
+	{
+		name: "known length (*bytes.Reader)",
+		build: func(url string, body []byte) (*http.Request, error) {
+			return http.NewRequest(http.MethodPut, url, bytes.NewReader(body))
+		},
+	},
+	{
+		name: "unknown length (opaque io.Reader)",
+		build: func(url string, body []byte) (*http.Request, error) {
+			return http.NewRequest(http.MethodPut, url, opaqueReader{bytes.NewReader(body)})
+		},
+	},
+	{
+		name: "explicitly chunked",
+		build: func(url string, body []byte) (*http.Request, error) {
+			req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(body))
+			if err != nil {
+				return nil, err
+			}
+			req.TransferEncoding = []string{"chunked"}
+			return req, nil
+		},
+	},
+}
+
+func microBodyCases() []microBodyCase {
+	cases := []microBodyCase{{
+		size:    0,
+		framing: "http.NoBody",
+		build: func(url string, _ []byte) (*http.Request, error) {
+			return http.NewRequest(http.MethodPut, url, http.NoBody)
+		},
+	}}
+	for size := 0; size <= 2; size++ {
+		for _, f := range microBodyFramings {
+			cases = append(cases, microBodyCase{size: size, framing: f.name, build: f.build})
+		}
+	}
+	return cases
+}
+
+// runMicroBodySweep sends tiny bodies (0, 1 and 2 bytes) with each framing option over one keep-alive client,
+// and reports the framing emitted on the wire and whether the connection was reused for each case.
+func (s *session) runMicroBodySweep(l net.Listener) error {
+	captures := make(chan capturedRequest, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serveConn(conn, keepAliveBehavior{}, captures, true)
+		}
+	}()
+
+	cli := &http.Client{Transport: s.transport}
+	var inconsistencies []string
+	fmt.Fprintln(s.out, "Micro-body sweep:")
+	fmt.Fprintf(s.out, "  %-4s  %-34s  %-28s  %-24s  %-6s  %s\n", "size", "framing option", "framing headers", "body on wire", "reused", "received")
+	for i, c := range microBodyCases() {
+		body := bytes.Repeat([]byte("a"), c.size)
+		req, err := c.build(s.serverURL, body)
+		if err != nil {
+			return fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+
+		var reused bool
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				reused = info.Reused
+			},
+		}
+		req = req.WithContext(httptrace.WithClientTrace(s.ctx, trace))
+
+		resp, err := cli.Do(req)
+		if err != nil {
+			return fmt.Errorf("HTTP request failed: %w", err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+
+		got := <-captures
+		fmt.Fprintf(s.out, "  %-4d  %-34s  %-28s  %-24q  %-6v  %d bytes\n", c.size, c.framing, framingHeaders(got.req), wireBody(got.raw), reused, len(got.body))
+
+		if len(got.body) != c.size {
+			inconsistencies = append(inconsistencies, s.finding(sevError, fmt.Sprintf("%d byte body with %s: server received %d bytes", c.size, c.framing, len(got.body))))
+		}
+		if i > 0 && !reused {
+			inconsistencies = append(inconsistencies, s.finding(sevWarn, fmt.Sprintf("%d byte body with %s: connection was not reused", c.size, c.framing)))
+		}
+	}
+
+	fmt.Fprintln(s.out)
+	if len(inconsistencies) == 0 {
+		fmt.Fprintln(s.out, "=> every case delivered the exact body and reused the connection")
+	}
+	for _, msg := range inconsistencies {
+		fmt.Fprintf(s.out, "=> %s\n", msg)
+	}
+	return nil
+}
+
+// framingHeaders describes the message framing headers of the request as received.
+func framingHeaders(req *http.Request) string {
+	var hs []string
+	if cl, ok := req.Header["Content-Length"]; ok {
+		hs = append(hs, "Content-Length: "+strings.Join(cl, ","))
+	}
+	if len(req.TransferEncoding) > 0 {
+		hs = append(hs, "Transfer-Encoding: "+strings.Join(req.TransferEncoding, ","))
+	}
+	if len(hs) == 0 {
+		return "(none)"
+	}
+	return strings.Join(hs, ", ")
+}
+
+// wireBody returns the part of the raw request following the header section.
+func wireBody(raw []byte) []byte {
+	if i := bytes.Index(raw, []byte("\r\n\r\n")); i >= 0 {
+		return raw[i+4:]
+	}
+	return nil
+}
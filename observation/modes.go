@@ -0,0 +1,136 @@
+package observation
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// withListener runs f with the listener of the capture server configured by cfg, closing it and the pcap recording when f returns.
+func (cfg *runConfig) withListener(s *session, f func(l net.Listener) error) (err error) {
+	l, closePcap, err := cfg.listen(s)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if perr := closePcap(); err == nil {
+			err = perr
+		}
+	}()
+	defer l.Close()
+	return f(l)
+}
+
+// ExplainRequest analyzes a single request serialized as raw HTTP/1.1 read from r, e.g. by httputil.DumpRequestOut (-explain -):
+// its head, framing, body, header sizes and notes on how it was likely built. Its record is written as configured by WithObservations.
+func ExplainRequest(ctx context.Context, r io.Reader, opts ...Option) error {
+	cfg, s, err := configure(ctx, opts)
+	if err != nil {
+		return err
+	}
+	var obsWriter *observationWriter
+	if cfg.obsOut != nil {
+		if obsWriter, err = newObservationWriter(cfg.obsOut); err != nil {
+			return err
+		}
+	}
+	return s.explainFromReader(r, obsWriter)
+}
+
+// ExplainServer analyzes requests sent to the capture server by programs using the observe package (-explain net),
+// until maxRequests were analyzed, maxDuration passed (unlimited if zero) or ctx is done.
+// Records of analyzed requests are written as configured by WithObservations.
+func ExplainServer(ctx context.Context, maxRequests int, maxDuration time.Duration, opts ...Option) error {
+	if maxRequests < 0 || maxDuration < 0 {
+		return fmt.Errorf("max requests and max duration must not be negative: %d, %v", maxRequests, maxDuration)
+	}
+	cfg, s, err := configure(ctx, opts)
+	if err != nil {
+		return err
+	}
+	var obsWriter *observationWriter
+	if cfg.obsOut != nil {
+		if obsWriter, err = newObservationWriter(cfg.obsOut); err != nil {
+			return err
+		}
+	}
+	return cfg.withListener(s, func(l net.Listener) error {
+		return s.explainFromNet(l, maxRequests, maxDuration, obsWriter)
+	})
+}
+
+// MicroSweep sends 0, 1 and 2 byte bodies with each framing option to the capture server, and reports framing and connection reuse
+// (-micro-sweep).
+func MicroSweep(ctx context.Context, opts ...Option) error {
+	cfg, s, err := configure(ctx, opts)
+	if err != nil {
+		return err
+	}
+	return cfg.withListener(s, s.runMicroBodySweep)
+}
+
+// UploadPerf uploads the body source in each way of the format, comparing peak memory, time to first byte, duration and framing:
+// buffered and io.Pipe-streamed multipart for "multipart" (-multipart-perf), and JSON marshaled into bytes.Buffer
+// and encoded into io.Pipe for "json" (-json-perf).
+func UploadPerf(ctx context.Context, format string, opts ...Option) error {
+	cfg, s, err := configure(ctx, opts)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "multipart":
+		return s.runUploadPerf("Multipart", multipartUploads, cfg.opts.filename)
+	case "json":
+		return s.runUploadPerf("JSON", jsonUploads, cfg.opts.filename)
+	default:
+		return fmt.Errorf(`unknown upload format: %q (must be "multipart" or "json")`, format)
+	}
+}
+
+// CompareCurl sends patterns having a curl equivalent with both Go and curl, diffing curl's request against Go's (-compare-curl).
+// curl must be in PATH.
+func CompareCurl(ctx context.Context, opts ...Option) error {
+	cfg, s, err := configure(ctx, opts)
+	if err != nil {
+		return err
+	}
+	return s.runCurlComparison(cfg.opts.filename)
+}
+
+// Sweep runs the pattern, which must be built by request(), across a parameter range given as name=from..to with binary size units
+// (e.g. body-size=1KiB..100MiB; see SweepParams), at steps values spaced evenly on a log scale, WithRepeat times each (-sweep).
+// It charts latency, throughput and wire overhead, also into svgPath as SVG if non-empty.
+// The pattern is given by name or numeric ID, with-len if empty.
+func Sweep(ctx context.Context, spec string, steps int, pattern, svgPath string, opts ...Option) error {
+	sp, err := parseSweepSpec(spec)
+	if err != nil {
+		return fmt.Errorf("invalid sweep: %w", err)
+	}
+	if steps < 1 {
+		return fmt.Errorf("sweep steps must be positive: %d", steps)
+	}
+	cfg, s, err := configure(ctx, opts)
+	if err != nil {
+		return err
+	}
+	p := reqSinglePartWithLen
+	if pattern != "" {
+		if p, err = s.patterns.parse(pattern); err != nil {
+			return fmt.Errorf("invalid sweep pattern: %w", err)
+		}
+	}
+	o := runOptions{session: s, filename: cfg.opts.filename, boundary: cfg.opts.boundary}
+	return runSweep(sp, steps, p, o, cfg.repeat, svgPath)
+}
+
+// HappyEyeballs observes Happy Eyeballs fallback from a black-holed IPv6 address to IPv4 with several Dialer.FallbackDelay settings
+// (-happy-eyeballs).
+func HappyEyeballs(ctx context.Context, opts ...Option) error {
+	_, s, err := configure(ctx, opts)
+	if err != nil {
+		return err
+	}
+	return s.runHappyEyeballs()
+}
@@ -0,0 +1,212 @@
+package observation
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// testCA is a throwaway certificate authority issuing certificates for the mTLS experiment.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA() (*testCA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "observation test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &testCA{cert: cert, key: key}, nil
+}
+
+var certSerial int64 = 1
+
+// issue issues a certificate for cn, usable for client auth, or server auth for 127.0.0.1 if server is true.
+func (ca *testCA) issue(cn string, server bool) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(atomic.AddInt64(&certSerial, 1)),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		tmpl.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// certRotationRow is what a step of observeClientCertRotation observed: the identity the server saw and whether the connection was reused.
+type certRotationRow struct {
+	seen   string
+	reused bool
+}
+
+// observeClientCertRotation uploads the file over mTLS, swapping the client certificate returned by
+// tls.Config.GetClientCertificate between requests, and reports which identity the server saw on each request.
+// Runs without and with a TLS session cache, as resumed sessions carry the identity of the original handshake.
+func observeClientCertRotation(opts runOptions) (*timing, error) {
+	data, err := os.ReadFile(opts.filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	ca, err := newTestCA()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create test CA: %w", err)
+	}
+	serverCert, err := ca.issue("server", true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue server certificate: %w", err)
+	}
+	clientCerts := make(map[string]*tls.Certificate)
+	for _, cn := range []string{"client-old", "client-new"} {
+		c, err := ca.issue(cn, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to issue client certificate: %w", err)
+		}
+		clientCerts[cn] = &c
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	var (
+		mu   sync.Mutex
+		seen string
+	)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		seen = r.TLS.PeerCertificates[0].Subject.CommonName
+		if r.TLS.DidResume {
+			seen += " (resumed session)"
+		}
+		mu.Unlock()
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	steps := []struct {
+		desc      string
+		cert      string
+		closeIdle bool
+	}{
+		{"initial request", "client-old", false},
+		{"after rotating the certificate", "client-new", false},
+		{"after rotating and CloseIdleConnections", "client-new", true},
+	}
+
+	var first *timing
+	for _, useCache := range []bool{false, true} {
+		var (
+			current  atomic.Value
+			getCalls int32
+			rows     []certRotationRow // of each step, in order
+		)
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.TLSClientConfig = &tls.Config{
+			RootCAs: pool,
+			GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				atomic.AddInt32(&getCalls, 1)
+				return current.Load().(*tls.Certificate), nil
+			},
+		}
+		if useCache {
+			tr.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+		}
+		if !opts.quiet {
+			fmt.Fprintf(opts.out, "[tls.Config.ClientSessionCache set: %v]\n", useCache)
+		}
+
+		for _, step := range steps {
+			current.Store(clientCerts[step.cert])
+			if step.closeIdle {
+				tr.CloseIdleConnections()
+			}
+
+			req, err := http.NewRequestWithContext(opts.ctx, http.MethodPut, srv.URL, bytes.NewReader(data))
+			if err != nil {
+				return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+			}
+			var reused bool
+			trace := &httptrace.ClientTrace{
+				GotConn: func(info httptrace.GotConnInfo) {
+					reused = info.Reused
+				},
+			}
+			req, tm := traceTiming(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+			calls := atomic.LoadInt32(&getCalls)
+			if err := opts.sendReq(tr, req); err != nil {
+				tr.CloseIdleConnections()
+				return nil, err
+			}
+			tm.finish()
+			if first == nil {
+				first = tm
+			}
+			mu.Lock()
+			rows = append(rows, certRotationRow{seen: seen, reused: reused})
+			mu.Unlock()
+			if opts.quiet {
+				continue
+			}
+
+			fmt.Fprintf(opts.out, "  %-40s  configured: %-10s  server saw: %-30s  conn reused: %-5v  GetClientCertificate called: %v\n",
+				step.desc, step.cert, rows[len(rows)-1].seen, reused, atomic.LoadInt32(&getCalls) > calls)
+		}
+		tr.CloseIdleConnections()
+		if !opts.quiet && rows[1] == rows[2] {
+			// e.g. the pooled connection was closed after the initial request, so the rotation took effect right away
+			fmt.Fprintln(opts.out, "  => "+opts.finding(sevError, fmt.Sprintf("the request after rotating the certificate was handled like the one after CloseIdleConnections (server saw %s, conn reused: %v), so this run doesn't show what a pooled connection keeps", rows[1].seen, rows[1].reused)))
+		}
+	}
+	return first, nil
+}
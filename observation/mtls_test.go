@@ -0,0 +1,34 @@
+package observation
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestClientCertRotationKeepsPooledIdentity checks that the request after rotating the client certificate goes on the pooled connection
+// with the old identity, unlike the one after CloseIdleConnections, which is what mtls-cert-rotation shows.
+func TestClientCertRotationKeepsPooledIdentity(t *testing.T) {
+	var out bytes.Buffer
+	s := newSession(&out)
+	opts := runOptions{session: s, filename: writeTestFile(t, "body", "0123456789abcdef")}
+	if _, err := observeClientCertRotation(opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range s.findingsSince(0, sevWarn) {
+		t.Errorf("unexpected finding: %v", f)
+	}
+	rotated := 0
+	for _, line := range strings.Split(out.String(), "\n") {
+		if !strings.Contains(line, "after rotating the certificate") {
+			continue
+		}
+		rotated++
+		if !strings.Contains(line, "server saw: client-old") || !strings.Contains(line, "conn reused: true") {
+			t.Errorf("request after rotating the certificate didn't reuse the connection with the old identity: %s", line)
+		}
+	}
+	if rotated != 2 {
+		t.Errorf("got %d rows after rotating the certificate, want 2 (without and with a session cache):\n%s", rotated, out.String())
+	}
+}
@@ -0,0 +1,136 @@
+package observation
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"strings"
+)
+
+// MultipartPart is a part of the bodies of multipart patterns, given by -f or -form in command-line order.
+type MultipartPart struct {
+	Name        string // form field name
+	Value       string // value of a form field, if Path is empty
+	Path        string // file of a file part
+	ContentType string // Content-Type of a file part, application/octet-stream if empty
+	Body        bool   // the file part of the body source, read from the file opened by the pattern
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// header returns the MIME header of the part, as multipart.Writer.CreateFormFile and CreateFormField do.
+func (p MultipartPart) header(filename string) textproto.MIMEHeader {
+	h := make(textproto.MIMEHeader)
+	if p.Path == "" && !p.Body {
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"`, quoteEscaper.Replace(p.Name)))
+		return h
+	}
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, quoteEscaper.Replace(p.Name), quoteEscaper.Replace(filename)))
+	ct := p.ContentType
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	h.Set("Content-Type", ct)
+	return h
+}
+
+// multipartBody is the body of a multipart request laid out up front: boundaries, part headers and form values
+// are rendered into memory, while files are read as the body is read.
+type multipartBody struct {
+	readers     []io.Reader
+	size        int64 // total size in bytes, -1 if the size of the body source isn't known
+	contentType string
+}
+
+func (b *multipartBody) reader() io.Reader {
+	return io.MultiReader(b.readers...)
+}
+
+// newMultipartBody lays out parts, adding the body source as the first file part if parts don't have it.
+// The body source is read from body, whose size is bodySize (-1 if unknown). Other files are opened here, and closed once read to the end.
+// Boundary is random if empty.
+func newMultipartBody(parts []MultipartPart, body io.Reader, bodySize int64, filename, boundary string) (*multipartBody, error) {
+	hasBody := false
+	for _, p := range parts {
+		hasBody = hasBody || p.Body
+	}
+	if !hasBody {
+		parts = append([]MultipartPart{{Name: "file", Body: true}}, parts...)
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if boundary != "" {
+		if err := mw.SetBoundary(boundary); err != nil {
+			return nil, fmt.Errorf("failed to set boundary: %w", err)
+		}
+	}
+	b := &multipartBody{contentType: mw.FormDataContentType()}
+	var opened []*os.File
+	fail := func(err error) (*multipartBody, error) {
+		for _, f := range opened {
+			f.Close()
+		}
+		return nil, err
+	}
+	flush := func() {
+		if buf.Len() > 0 {
+			b.readers = append(b.readers, bytes.NewReader(append([]byte(nil), buf.Bytes()...)))
+			b.size += int64(buf.Len())
+			buf.Reset()
+		}
+	}
+	for _, p := range parts {
+		name := p.Path
+		if p.Body {
+			name = filename
+		}
+		w, err := mw.CreatePart(p.header(name))
+		if err != nil {
+			return fail(fmt.Errorf("failed to create new part: %w", err))
+		}
+		switch {
+		case p.Body:
+			flush()
+			b.readers = append(b.readers, body)
+			b.size += bodySize
+		case p.Path != "":
+			f, err := os.Open(p.Path)
+			if err != nil {
+				return fail(fmt.Errorf("failed to open file: %w", err))
+			}
+			opened = append(opened, f)
+			stat, err := f.Stat()
+			if err != nil {
+				return fail(fmt.Errorf("failed to stat file: %w", err))
+			}
+			flush()
+			b.readers = append(b.readers, &closeAtEOF{f})
+			b.size += stat.Size()
+		default:
+			_, _ = io.WriteString(w, p.Value)
+		}
+	}
+	_ = mw.Close()
+	flush()
+	if bodySize < 0 {
+		b.size = -1
+	}
+	return b, nil
+}
+
+// closeAtEOF closes the file once it's read to the end.
+type closeAtEOF struct {
+	f *os.File
+}
+
+func (r *closeAtEOF) Read(p []byte) (int, error) {
+	n, err := r.f.Read(p)
+	if err == io.EOF {
+		_ = r.f.Close()
+	}
+	return n, err
+}
@@ -0,0 +1,197 @@
+package observation
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// uploadBuild is a way of building an upload of a file, for the streaming vs buffering comparisons.
+type uploadBuild struct {
+	desc  string
+	build func(url, filename string) (*http.Request, error)
+}
+
+var multipartUploads = []uploadBuild{
+	{
+		desc: "buffered (bytes.Buffer)",
+		build: func(url, filename string) (*http.Request, error) {
+			f, err := os.Open(filename)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+
+			var buf bytes.Buffer
+			mw := multipart.NewWriter(&buf)
+			w, err := mw.CreateFormFile("file", filepath.Base(filename))
+			if err != nil {
+				return nil, err
+			}
+			if _, err := io.Copy(w, f); err != nil {
+				return nil, err
+			}
+			if err := mw.Close(); err != nil {
+				return nil, err
+			}
+			req, err := http.NewRequest(http.MethodPost, url, &buf)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+			return req, nil
+		},
+	},
+	{
+		desc: "streamed (io.Pipe)",
+		build: func(url, filename string) (*http.Request, error) {
+			f, err := os.Open(filename)
+			if err != nil {
+				return nil, err
+			}
+
+			pr, pw := io.Pipe()
+			mw := multipart.NewWriter(pw)
+			go func() {
+				defer f.Close()
+				w, err := mw.CreateFormFile("file", filepath.Base(filename))
+				if err == nil {
+					_, err = io.Copy(w, f)
+				}
+				if err == nil {
+					err = mw.Close()
+				}
+				_ = pw.CloseWithError(err)
+			}()
+			req, err := http.NewRequest(http.MethodPost, url, pr)
+			if err != nil {
+				_ = pr.Close()
+				return nil, err
+			}
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+			return req, nil
+		},
+	},
+}
+
+// heapPeak samples the heap in use while running, to find the peak above the baseline at start.
+type heapPeak struct {
+	base uint64
+	peak uint64
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+func startHeapPeak() *heapPeak {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	h := &heapPeak{base: ms.HeapInuse, peak: ms.HeapInuse, stop: make(chan struct{})}
+	h.done.Add(1)
+	go func() {
+		defer h.done.Done()
+		t := time.NewTicker(time.Millisecond)
+		defer t.Stop()
+		for {
+			var ms runtime.MemStats
+			runtime.ReadMemStats(&ms)
+			if ms.HeapInuse > h.peak {
+				h.peak = ms.HeapInuse
+			}
+			select {
+			case <-h.stop:
+				return
+			case <-t.C:
+			}
+		}
+	}()
+	return h
+}
+
+// finish stops sampling and returns the peak heap growth in bytes.
+func (h *heapPeak) finish() uint64 {
+	close(h.stop)
+	h.done.Wait()
+	return h.peak - h.base
+}
+
+// startDiscardServer starts a server reading and discarding whole requests without buffering them,
+// so that it doesn't affect memory measurements. firstByte receives the time each request's headers arrived.
+func startDiscardServer(firstByte chan<- time.Time) (url string, stop func(), err error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to start listening: %w", err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				firstByte <- time.Now()
+				_, _ = io.Copy(io.Discard, req.Body)
+				_ = writeResponse(conn, http.StatusOK, nil, false)
+			}()
+		}
+	}()
+	return "http://" + l.Addr().String(), func() { _ = l.Close() }, nil
+}
+
+// runUploadPerf uploads the file in each way of uploads (e.g. a fully buffered body and one streamed through io.Pipe),
+// and reports peak heap growth, time until the server got the request headers, total duration and framing side by side.
+func (s *session) runUploadPerf(what string, uploads []uploadBuild, filename string) error {
+	stat, err := os.Stat(filename)
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	firstByte := make(chan time.Time, 1)
+	url, stop, err := startDiscardServer(firstByte)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	fmt.Fprintf(s.out, "%s upload of %s (%d bytes): buffered vs streamed\n", what, filename, stat.Size())
+	fmt.Fprintf(s.out, "  %-24s  %-28s  %-12s  %-14s  %s\n", "body", "framing", "peak heap", "headers at", "total")
+	for _, u := range uploads {
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		heap := startHeapPeak()
+		start := time.Now()
+		req, err := u.build(url, filename)
+		if err != nil {
+			heap.finish()
+			return fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+		req = req.WithContext(s.ctx)
+		framing := "Transfer-Encoding: chunked"
+		if req.ContentLength > 0 {
+			framing = fmt.Sprintf("Content-Length: %d", req.ContentLength)
+		}
+		err = runOptions{session: s}.sendReq(tr, req)
+		total := time.Since(start)
+		peak := heap.finish()
+		tr.CloseIdleConnections()
+		if err != nil {
+			return err
+		}
+		headersAt := (<-firstByte).Sub(start)
+
+		fmt.Fprintf(s.out, "  %-24s  %-28s  %-12s  %-14v  %v\n", u.desc, framing, fmt.Sprintf("%d KiB", peak>>10), headersAt, total)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package observation
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const namedPipePrefix = `\\.\pipe\`
+
+// namedPipePath completes name to a full named pipe path (e.g. `\\.\pipe\docker_engine`) if it's a bare name.
+func namedPipePath(name string) string {
+	if strings.HasPrefix(name, namedPipePrefix) {
+		return name
+	}
+	return namedPipePrefix + name
+}
+
+type namedPipeAddr string
+
+func (a namedPipeAddr) Network() string { return "npipe" }
+func (a namedPipeAddr) String() string  { return string(a) }
+
+// namedPipeConn adapts a named pipe handle opened as *os.File to net.Conn.
+type namedPipeConn struct {
+	*os.File
+	addr namedPipeAddr
+}
+
+func (c *namedPipeConn) LocalAddr() net.Addr  { return c.addr }
+func (c *namedPipeConn) RemoteAddr() net.Addr { return c.addr }
+
+// namedPipeTransport returns a Transport which sends every request over the named pipe regardless of the URL, like Docker clients on Windows do.
+func namedPipeTransport(name string) http.RoundTripper {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dialNamedPipe(ctx, namedPipePath(name))
+	}
+	return tr
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+
+package observation
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+var errNamedPipeUnsupported = errors.New("named pipes are only supported on Windows")
+
+func listenNamedPipe(string) (net.Listener, error) {
+	return nil, errNamedPipeUnsupported
+}
+
+func dialNamedPipe(context.Context, string) (net.Conn, error) {
+	return nil, errNamedPipeUnsupported
+}
+
+func isNamedPipeClosed(error) bool {
+	return false
+}
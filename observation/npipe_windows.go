@@ -0,0 +1,278 @@
+package observation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	pipeAccessDuplex       = 0x3
+	pipeUnlimitedInstances = 255
+	pipeBufferSize         = 64 * 1024
+
+	errorPipeBusy      syscall.Errno = 231
+	errorNoData        syscall.Errno = 232
+	errorPipeConnected syscall.Errno = 535
+)
+
+var (
+	kernel32                   = syscall.NewLazyDLL("kernel32.dll")
+	procCreateNamedPipeW       = kernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe       = kernel32.NewProc("ConnectNamedPipe")
+	procCreateEventW           = kernel32.NewProc("CreateEventW")
+	procGetOverlappedResult    = kernel32.NewProc("GetOverlappedResult")
+	procWaitForMultipleObjects = kernel32.NewProc("WaitForMultipleObjects")
+	procSetEvent               = kernel32.NewProc("SetEvent")
+)
+
+// namedPipeListener accepts connections on a Windows named pipe. It keeps a pending pipe instance ahead of Accept,
+// created when listening and replaced before Accept returns, so that the pipe exists for clients whenever the listener is open.
+// Instances are opened for overlapped I/O, so that Close can cancel an Accept waiting for a client.
+type namedPipeListener struct {
+	name    string
+	closing syscall.Handle // manual-reset event signaled by Close
+
+	mu      sync.Mutex
+	closed  bool
+	pending syscall.Handle // instance waiting for the next Accept, or 0 if an Accept took it and couldn't create the next one
+}
+
+func listenNamedPipe(name string) (net.Listener, error) {
+	l := &namedPipeListener{name: namedPipePath(name)}
+	h, err := l.createInstance()
+	if err != nil {
+		return nil, err
+	}
+	closing, err := createEvent()
+	if err != nil {
+		_ = syscall.CloseHandle(h)
+		return nil, fmt.Errorf("failed to create named pipe listener: %w", err)
+	}
+	l.closing, l.pending = closing, h
+	return l, nil
+}
+
+// createInstance creates a new instance of the pipe, which clients can open once it's created.
+func (l *namedPipeListener) createInstance() (syscall.Handle, error) {
+	path, err := syscall.UTF16PtrFromString(l.name)
+	if err != nil {
+		return 0, err
+	}
+	h, _, err := procCreateNamedPipeW.Call(
+		uintptr(unsafe.Pointer(path)),
+		pipeAccessDuplex|syscall.FILE_FLAG_OVERLAPPED,
+		0, // PIPE_TYPE_BYTE | PIPE_READMODE_BYTE | PIPE_WAIT
+		pipeUnlimitedInstances,
+		pipeBufferSize,
+		pipeBufferSize,
+		0,
+		0,
+	)
+	if syscall.Handle(h) == syscall.InvalidHandle {
+		return 0, fmt.Errorf("failed to create named pipe: %w", err)
+	}
+	return syscall.Handle(h), nil
+}
+
+// Accept waits for a client to open the pipe. Once the listener is closed, it returns net.ErrClosed.
+func (l *namedPipeListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil, net.ErrClosed
+	}
+	// takes the pending instance, or creates one if it's taken by a concurrent Accept or failed to be created
+	h := l.pending
+	l.pending = 0
+	l.mu.Unlock()
+	if h == 0 {
+		var err error
+		if h, err = l.createInstance(); err != nil {
+			return nil, err
+		}
+	}
+
+	// waits until a client opens the pipe, or the listener is closed
+	_, err := overlappedIO(h, l.closing, func(ov *syscall.Overlapped) error {
+		if ok, _, err := procConnectNamedPipe.Call(uintptr(h), uintptr(unsafe.Pointer(ov))); ok == 0 {
+			return err
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errorPipeConnected) {
+		_ = syscall.CloseHandle(h)
+		if errors.Is(err, syscall.ERROR_OPERATION_ABORTED) {
+			return nil, net.ErrClosed
+		}
+		return nil, fmt.Errorf("failed to wait for named pipe client: %w", err)
+	}
+	c := &overlappedPipeConn{h: h, addr: namedPipeAddr(l.name)}
+
+	// creates the next instance before returning, so that the next client doesn't find the pipe missing or busy.
+	// If it fails, the next Accept tries again.
+	next, err := l.createInstance()
+	if err != nil {
+		return c, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed || l.pending != 0 {
+		_ = syscall.CloseHandle(next)
+	} else {
+		l.pending = next
+	}
+	return c, nil
+}
+
+// Close makes Accept calls, waiting or later ones, return net.ErrClosed. Connections already accepted stay open.
+func (l *namedPipeListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	if l.pending != 0 {
+		_ = syscall.CloseHandle(l.pending)
+		l.pending = 0
+	}
+	// the event stays signaled, and is left to the process like the listener's name
+	if ok, _, err := procSetEvent.Call(uintptr(l.closing)); ok == 0 {
+		return fmt.Errorf("failed to close named pipe listener: %w", err)
+	}
+	return nil
+}
+
+func (l *namedPipeListener) Addr() net.Addr { return namedPipeAddr(l.name) }
+
+// overlappedPipeConn is a pipe instance opened for overlapped I/O, accepted by namedPipeListener.
+// Reads and writes block until they complete. Deadlines aren't supported, as with *os.File of pipes.
+type overlappedPipeConn struct {
+	h    syscall.Handle
+	addr namedPipeAddr
+
+	closeOnce sync.Once
+}
+
+func (c *overlappedPipeConn) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err := overlappedIO(c.h, 0, func(ov *syscall.Overlapped) error { return syscall.ReadFile(c.h, p, nil, ov) })
+	if errors.Is(err, syscall.ERROR_BROKEN_PIPE) || (err == nil && n == 0) {
+		// the client closed its end
+		return int(n), io.EOF
+	}
+	return int(n), err
+}
+
+func (c *overlappedPipeConn) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n, err := overlappedIO(c.h, 0, func(ov *syscall.Overlapped) error { return syscall.WriteFile(c.h, p[written:], nil, ov) })
+		written += int(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Close cancels reads and writes in progress and closes the pipe instance.
+func (c *overlappedPipeConn) Close() error {
+	err := os.ErrClosed
+	c.closeOnce.Do(func() {
+		_ = syscall.CancelIoEx(c.h, nil)
+		err = syscall.CloseHandle(c.h)
+	})
+	return err
+}
+
+func (c *overlappedPipeConn) LocalAddr() net.Addr                { return c.addr }
+func (c *overlappedPipeConn) RemoteAddr() net.Addr               { return c.addr }
+func (c *overlappedPipeConn) SetDeadline(t time.Time) error      { return os.ErrNoDeadline }
+func (c *overlappedPipeConn) SetReadDeadline(t time.Time) error  { return os.ErrNoDeadline }
+func (c *overlappedPipeConn) SetWriteDeadline(t time.Time) error { return os.ErrNoDeadline }
+
+// createEvent creates a manual-reset event, initially not signaled.
+func createEvent() (syscall.Handle, error) {
+	h, _, err := procCreateEventW.Call(0, 1, 0, 0)
+	if h == 0 {
+		return 0, err
+	}
+	return syscall.Handle(h), nil
+}
+
+// overlappedIO starts op, an overlapped I/O call on h, and waits for it to complete, returning the bytes transferred.
+// If cancel is non-zero and gets signaled first, the call is canceled, failing with ERROR_OPERATION_ABORTED.
+// An error of op other than ERROR_IO_PENDING is returned as is, without waiting.
+func overlappedIO(h, cancel syscall.Handle, op func(ov *syscall.Overlapped) error) (uint32, error) {
+	ev, err := createEvent()
+	if err != nil {
+		return 0, err
+	}
+	defer syscall.CloseHandle(ev)
+
+	ov := &syscall.Overlapped{HEvent: ev}
+	if err := op(ov); err != nil && !errors.Is(err, syscall.ERROR_IO_PENDING) {
+		return 0, err
+	}
+	if cancel != 0 {
+		handles := [2]syscall.Handle{ev, cancel}
+		// on anything but the completion, including a failed wait, the call is canceled so as not to block forever
+		if r, _, _ := procWaitForMultipleObjects.Call(2, uintptr(unsafe.Pointer(&handles[0])), 0, syscall.INFINITE); r != syscall.WAIT_OBJECT_0 {
+			_ = syscall.CancelIoEx(h, ov)
+		}
+	}
+	var n uint32
+	// waits for the I/O to complete, including a canceled one, before ov and the buffer of op may be released
+	if ok, _, err := procGetOverlappedResult.Call(uintptr(h), uintptr(unsafe.Pointer(ov)), uintptr(unsafe.Pointer(&n)), 1); ok == 0 {
+		return n, err
+	}
+	return n, nil
+}
+
+// how long dialNamedPipe waits for the pipe to be created or an instance of it to be free
+const namedPipeDialTimeout = 5 * time.Second
+
+// dialNamedPipe opens the named pipe, retrying while it doesn't exist or all its instances are busy,
+// until namedPipeDialTimeout passes or ctx is done.
+func dialNamedPipe(ctx context.Context, name string) (net.Conn, error) {
+	path, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	timeout := time.NewTimer(namedPipeDialTimeout)
+	defer timeout.Stop()
+	for {
+		h, err := syscall.CreateFile(path, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil, syscall.OPEN_EXISTING, 0, 0)
+		if err == nil {
+			return &namedPipeConn{File: os.NewFile(uintptr(h), name), addr: namedPipeAddr(name)}, nil
+		}
+		// the server hasn't created the pipe yet, or all instances are busy: wait for it to create a new one
+		if !errors.Is(err, errorPipeBusy) && !errors.Is(err, syscall.ERROR_FILE_NOT_FOUND) {
+			return nil, fmt.Errorf("failed to open named pipe: %w", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timeout.C:
+			return nil, fmt.Errorf("failed to open named pipe in %v: %w", namedPipeDialTimeout, err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// isNamedPipeClosed reports whether err means the peer closed the named pipe.
+func isNamedPipeClosed(err error) bool {
+	return errors.Is(err, syscall.ERROR_BROKEN_PIPE) || errors.Is(err, errorNoData)
+}
@@ -0,0 +1,207 @@
+// Package observation sends HTTP requests to a local capture server and reports them as they appeared on the wire,
+// so that other projects can check how their requests are framed, e.g. in their own tests.
+// Transport records requests sent to real servers in the same form.
+//
+//	req, _ := http.NewRequest(http.MethodPut, "https://example.com/upload", f)
+//	obs, err := observation.ObserveRequest(req)
+//	if err != nil {
+//		return err
+//	}
+//	fmt.Println(obs.RequestLine, obs.ContentLengthHeader, obs.TransferEncoding)
+//
+// Observation has helpers for asserting on the request in tests:
+//
+//	if n, ok := obs.ContentLength(); !ok || obs.IsChunked() {
+//		t.Errorf("sent with %v instead of Content-Length", obs.TransferEncoding)
+//	} else if n != size {
+//		t.Errorf("Content-Length: %d, want %d", n, size)
+//	}
+//	if !obs.HasHeader("Content-Type", "image/jpeg") {
+//		t.Error("Content-Type is missing")
+//	}
+//
+// Run runs the request patterns of the command line interface (package main of the module) against a capture server of its own,
+// configured by Options, and returns what was observed of each pattern:
+//
+//	report, err := observation.Run(ctx, observation.WithPatterns("with-len", "without-len"), observation.WithOutput(io.Discard))
+package observation
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Observation is a request as the capture server received it.
+type Observation struct {
+	Raw                 []byte        // the whole request on the wire
+	RequestLine         string        // without CRLF
+	Header              []HeaderField // in wire order, names as sent
+	ContentLengthHeader int64         // value of the Content-Length header, -1 if there was none; see ContentLength
+	TransferEncoding    []string      // codings in Transfer-Encoding headers, in order
+	Body                []byte        // with chunked framing removed
+	Trailer             http.Header   // trailers of a chunked body, nil if there were none
+	Duration            time.Duration // from sending the request until the response headers arrived
+}
+
+// HeaderField is a header line as it appeared on the wire.
+type HeaderField struct {
+	Name  string
+	Value string
+}
+
+// Get returns the value of the first header field with the name, compared case-insensitively, or "" if there's none.
+func (o *Observation) Get(name string) string {
+	for _, f := range o.Header {
+		if strings.EqualFold(f.Name, name) {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// HasHeader reports whether a header field with the name, compared case-insensitively, and exactly the value was sent.
+func (o *Observation) HasHeader(name, value string) bool {
+	for _, f := range o.Header {
+		if strings.EqualFold(f.Name, name) && f.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+// IsChunked reports whether the body was sent with chunked framing, i.e. chunked is the last coding in Transfer-Encoding.
+func (o *Observation) IsChunked() bool {
+	return len(o.TransferEncoding) > 0 && strings.EqualFold(o.TransferEncoding[len(o.TransferEncoding)-1], "chunked")
+}
+
+// ContentLength returns the value of the Content-Length header, and whether there was one.
+// It's the ContentLengthHeader field in the comma-ok form.
+func (o *Observation) ContentLength() (int64, bool) {
+	return o.ContentLengthHeader, o.ContentLengthHeader >= 0
+}
+
+// BodyPrefix returns the first n bytes of the body with chunked framing removed, or the whole body if it's shorter.
+func (o *Observation) BodyPrefix(n int) []byte {
+	if n < len(o.Body) {
+		return o.Body[:n]
+	}
+	return o.Body
+}
+
+// ObserveRequest sends req with a clone of http.DefaultTransport to a capture server listening on the loopback interface,
+// and returns the request as the server received it. The capture server replies 200 with an empty body.
+//
+// The request is sent as it would be to the original host, including the Host header and the request-target.
+// For https URLs, the TLS handshake is skipped and the request is sent in plain HTTP/1.1,
+// so the observation shows what would have been sent inside TLS. Proxy settings are ignored.
+// The whole request is kept in memory, so it's not meant for huge bodies.
+func ObserveRequest(req *http.Request) (*Observation, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("observation: failed to start listening: %w", err)
+	}
+	defer l.Close()
+
+	captured := make(chan capture, 1)
+	go func() { captured <- captureRequest(l) }()
+
+	addr := l.Addr().String()
+	dial := func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.Proxy = nil
+	tr.DialContext = dial
+	tr.DialTLSContext = dial
+	defer tr.CloseIdleConnections()
+
+	start := time.Now()
+	resp, err := (&http.Client{Transport: tr}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("observation: request failed: %w", err)
+	}
+	d := time.Since(start)
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	var c capture
+	select {
+	case c = <-captured:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	c.obs.Duration = d
+	return c.obs, nil
+}
+
+type capture struct {
+	obs *Observation
+	err error
+}
+
+// captureRequest accepts a connection on l, reads a request from it to the end of the body, and replies 200.
+func captureRequest(l net.Listener) capture {
+	conn, err := l.Accept()
+	if err != nil {
+		return capture{err: fmt.Errorf("observation: failed to accept: %w", err)}
+	}
+	defer conn.Close()
+
+	var raw bytes.Buffer
+	req, err := http.ReadRequest(bufio.NewReader(io.TeeReader(conn, &raw)))
+	if err != nil {
+		return capture{err: fmt.Errorf("observation: malformed request %q: %w", raw.Bytes(), err)}
+	}
+	if _, err := io.Copy(io.Discard, req.Body); err != nil {
+		return capture{err: fmt.Errorf("observation: failed to read body: %w", err)}
+	}
+	_, _ = io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+	return capture{obs: parse(raw.Bytes())}
+}
+
+// parse builds an Observation of raw, the bytes of a request on the wire: the request line and headers as sent,
+// and the body and trailers as http.ReadRequest reads them. Of a truncated request, it has as much as there is.
+func parse(raw []byte) *Observation {
+	obs := parseHead(raw)
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		return obs
+	}
+	obs.Body, _ = io.ReadAll(req.Body)
+	if len(req.Trailer) > 0 {
+		obs.Trailer = req.Trailer
+	}
+	return obs
+}
+
+// parseHead builds an Observation of the header section of raw with parseRawHead, without the body:
+// the request line and headers as sent, and the framing they announce.
+func parseHead(raw []byte) *Observation {
+	obs := &Observation{Raw: raw, ContentLengthHeader: -1}
+	obs.RequestLine, obs.Header, _ = parseRawHead(raw)
+	for _, f := range obs.Header {
+		switch textproto.CanonicalMIMEHeaderKey(f.Name) {
+		case "Content-Length":
+			if n, err := strconv.ParseInt(f.Value, 10, 64); err == nil {
+				obs.ContentLengthHeader = n
+			}
+		case "Transfer-Encoding":
+			for _, te := range strings.Split(f.Value, ",") {
+				obs.TransferEncoding = append(obs.TransferEncoding, strings.TrimSpace(te))
+			}
+		}
+	}
+	return obs
+}
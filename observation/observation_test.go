@@ -0,0 +1,161 @@
+package observation
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestObservationAssertions(t *testing.T) {
+	const (
+		withLen = "PUT /upload HTTP/1.1\r\nHost: example.com\r\nContent-Type: image/jpeg\r\nContent-Length: 5\r\n\r\nhello"
+		chunked = "PUT /upload HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\nX-Multi: a\r\nx-multi: b\r\n\r\n" +
+			"3\r\nhel\r\n2\r\nlo\r\n0\r\nX-Sum: 42\r\n\r\n"
+		gzipped = "PUT /upload HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked, gzip\r\n\r\n"
+		noBody  = "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"
+		badLen  = "PUT / HTTP/1.1\r\nHost: example.com\r\nContent-Length: five\r\n\r\n"
+	)
+
+	t.Run("Get", func(t *testing.T) {
+		tests := []struct {
+			raw, name, want string
+		}{
+			{raw: withLen, name: "Content-Type", want: "image/jpeg"},
+			{raw: withLen, name: "content-type", want: "image/jpeg"},
+			{raw: chunked, name: "X-Multi", want: "a"},
+			{raw: withLen, name: "Transfer-Encoding", want: ""},
+		}
+		for _, tt := range tests {
+			if got := parse([]byte(tt.raw)).Get(tt.name); got != tt.want {
+				t.Errorf("Get(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("HasHeader", func(t *testing.T) {
+		tests := []struct {
+			raw, name, value string
+			want             bool
+		}{
+			{raw: withLen, name: "Content-Type", value: "image/jpeg", want: true},
+			{raw: withLen, name: "CONTENT-TYPE", value: "image/jpeg", want: true},
+			{raw: chunked, name: "X-Multi", value: "b", want: true},
+			{raw: withLen, name: "Content-Type", value: "IMAGE/JPEG", want: false},
+			{raw: withLen, name: "Content-Type", value: "image/png", want: false},
+			{raw: noBody, name: "Content-Type", value: "", want: false},
+		}
+		for _, tt := range tests {
+			if got := parse([]byte(tt.raw)).HasHeader(tt.name, tt.value); got != tt.want {
+				t.Errorf("HasHeader(%q, %q) = %v, want %v", tt.name, tt.value, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("IsChunked", func(t *testing.T) {
+		tests := []struct {
+			raw  string
+			want bool
+		}{
+			{raw: chunked, want: true},
+			{raw: withLen, want: false},
+			{raw: noBody, want: false},
+			// chunked isn't the final coding
+			{raw: gzipped, want: false},
+		}
+		for _, tt := range tests {
+			if got := parse([]byte(tt.raw)).IsChunked(); got != tt.want {
+				t.Errorf("IsChunked() of %q = %v, want %v", tt.raw, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("ContentLength", func(t *testing.T) {
+		tests := []struct {
+			raw    string
+			want   int64
+			wantOK bool
+		}{
+			{raw: withLen, want: 5, wantOK: true},
+			{raw: chunked, want: -1, wantOK: false},
+			{raw: noBody, want: -1, wantOK: false},
+			{raw: badLen, want: -1, wantOK: false},
+		}
+		for _, tt := range tests {
+			if got, ok := parse([]byte(tt.raw)).ContentLength(); got != tt.want || ok != tt.wantOK {
+				t.Errorf("ContentLength() of %q = %d, %v, want %d, %v", tt.raw, got, ok, tt.want, tt.wantOK)
+			}
+		}
+	})
+
+	t.Run("BodyPrefix", func(t *testing.T) {
+		tests := []struct {
+			raw  string
+			n    int
+			want string
+		}{
+			{raw: withLen, n: 3, want: "hel"},
+			{raw: withLen, n: 5, want: "hello"},
+			{raw: withLen, n: 100, want: "hello"},
+			{raw: withLen, n: 0, want: ""},
+			// with chunked framing removed
+			{raw: chunked, n: 4, want: "hell"},
+			{raw: noBody, n: 4, want: ""},
+		}
+		for _, tt := range tests {
+			if got := parse([]byte(tt.raw)).BodyPrefix(tt.n); string(got) != tt.want {
+				t.Errorf("BodyPrefix(%d) of %q = %q, want %q", tt.n, tt.raw, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("Trailer", func(t *testing.T) {
+		if got := parse([]byte(chunked)).Trailer.Get("X-Sum"); got != "42" {
+			t.Errorf("Trailer X-Sum = %q, want 42", got)
+		}
+		if tr := parse([]byte(withLen)).Trailer; tr != nil {
+			t.Errorf("Trailer = %v, want nil", tr)
+		}
+	})
+}
+
+// TestObserveRequest checks the framing ObserveRequest reports for a body of known length and one of unknown length.
+func TestObserveRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    io.Reader
+		chunked bool
+	}{
+		{name: "bytes.Reader", body: bytes.NewReader([]byte("hello")), chunked: false},
+		{name: "unknown length", body: io.MultiReader(strings.NewReader("hello")), chunked: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPut, "https://example.com/upload", tt.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "text/plain")
+			obs, err := ObserveRequest(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if obs.RequestLine != "PUT /upload HTTP/1.1" {
+				t.Errorf("request line = %q", obs.RequestLine)
+			}
+			if !obs.HasHeader("Host", "example.com") || !obs.HasHeader("Content-Type", "text/plain") {
+				t.Errorf("headers = %v, want Host: example.com and Content-Type: text/plain", obs.Header)
+			}
+			if obs.IsChunked() != tt.chunked {
+				t.Errorf("IsChunked() = %v, want %v", obs.IsChunked(), tt.chunked)
+			}
+			if n, ok := obs.ContentLength(); ok == tt.chunked || (ok && n != 5) {
+				t.Errorf("ContentLength() = %d, %v", n, ok)
+			}
+			if string(obs.BodyPrefix(10)) != "hello" {
+				t.Errorf("BodyPrefix(10) = %q, want hello", obs.BodyPrefix(10))
+			}
+		})
+	}
+}
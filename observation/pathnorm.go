@@ -0,0 +1,77 @@
+package observation
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// request targets (path and query part of URLs) with unusual path forms
+var unusualTargets = []string{
+	"",
+	"/",
+	"?q=1",
+	"/a/./b",
+	"/a/../b",
+	"/..",
+	"//a//b",
+	"/a/%2e%2e/b",
+	"/a%2Fb",
+	"/a%2fb",
+	"/a/b/",
+	"/%7Euser",
+	"/caf%C3%A9",
+	"/a;p=1/b",
+}
+
+// observePathNormalization sends GET requests to URLs whose paths contain dot segments, duplicate slashes etc,
+// and reports the request-target on the wire, flagging any normalization done by the URL parser or the Transport.
+func observePathNormalization(opts runOptions) (*timing, error) {
+	captures := make(chan capturedRequest, 1)
+	url, stop, err := opts.startEphemeralServer(func() ServerBehavior { return keepAliveBehavior{} }, captures, true)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	defer tr.CloseIdleConnections()
+	cli := &http.Client{Transport: tr}
+
+	var first *timing
+	if !opts.quiet {
+		fmt.Fprintf(opts.out, "  %-16s  %-16s  %-16s  %s\n", "target in URL", "URL.Path", "URL.RawPath", "request-target on the wire")
+	}
+	for i, target := range unusualTargets {
+		req, err := http.NewRequestWithContext(opts.ctx, http.MethodGet, url+target, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+		req, tm := traceTiming(req)
+		resp, err := cli.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("HTTP request failed: %w", err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		tm.finish()
+
+		got := <-captures
+		if i == 0 {
+			first = tm
+		}
+		if opts.quiet {
+			continue
+		}
+
+		line, _, _ := parseRawHead(got.raw)
+		onWire := strings.Fields(line)[1]
+		mark := ""
+		if onWire != target {
+			mark = "  <- normalized"
+		}
+		fmt.Fprintf(opts.out, "  %-16q  %-16q  %-16q  %q%s\n", target, req.URL.Path, req.URL.RawPath, onWire, mark)
+	}
+	return first, nil
+}
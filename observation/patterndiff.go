@@ -0,0 +1,75 @@
+package observation
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DiffPatterns runs two patterns, given by name or numeric ID, against the capture server reading whole requests, and prints
+// a unified diff of their normalized captures, so that the delta between them (e.g. Content-Length against Transfer-Encoding)
+// stands out (the diff subcommand). Of the options, the ones of the output, body source and redaction apply; values of redacted headers
+// are masked in the captures before they're rendered and diffed.
+func DiffPatterns(ctx context.Context, a, b string, opts ...Option) error {
+	cfg, s, err := configure(ctx, opts)
+	if err != nil {
+		return err
+	}
+	patterns, err := s.patterns.parseList(a + "," + b)
+	if err != nil {
+		return err
+	}
+	got, err := captureGolden(ctx, []string{a, b}, cfg.opts.filename, WithRedactedHeaders(cfg.redacted...))
+	if err != nil {
+		return err
+	}
+	ps := s.patterns
+	var lines [2][]string
+	for i, p := range patterns {
+		file, ok := got.files[ps.name(p)]
+		if !ok {
+			return fmt.Errorf("pattern %s runs an experiment of its own and isn't captured, so it can't be diffed", ps.name(p))
+		}
+		lines[i] = normalizeForDiff(file)
+	}
+	s.printUnifiedDiff(ps.name(patterns[0]), ps.name(patterns[1]), lines[0], lines[1])
+	return nil
+}
+
+// normalizeForDiff returns lines of a rendered capture (see renderGolden) with the pattern comment dropped,
+// and header lines sorted by name so that diffs show headers added or removed rather than moved.
+func normalizeForDiff(file string) []string {
+	lines := strings.Split(strings.TrimSuffix(file, "\n"), "\n")
+	if len(lines) > 0 && strings.HasPrefix(lines[0], "# ") {
+		lines = lines[1:]
+	}
+	end := 1
+	for end < len(lines) && lines[end] != "" {
+		end++
+	}
+	if end > 1 {
+		hdrs := lines[1:end]
+		sort.SliceStable(hdrs, func(i, j int) bool {
+			a, _, _ := strings.Cut(hdrs[i], ":")
+			b, _, _ := strings.Cut(hdrs[j], ":")
+			return strings.ToLower(a) < strings.ToLower(b)
+		})
+	}
+	return lines
+}
+
+// printUnifiedDiff prints the diff from a to b in unified format, as a single hunk with all lines for context.
+func (s *session) printUnifiedDiff(nameA, nameB string, a, b []string) {
+	fmt.Fprintf(s.out, "--- %s\n", nameA)
+	fmt.Fprintf(s.out, "+++ %s\n", nameB)
+	fmt.Fprintf(s.out, "@@ -1,%d +1,%d @@\n", len(a), len(b))
+	changed := false
+	for _, op := range diffLines(a, b) {
+		fmt.Fprintf(s.out, "%c%s\n", op.kind, op.line)
+		changed = changed || op.kind != ' '
+	}
+	if !changed {
+		fmt.Fprintln(s.out, "(no differences)")
+	}
+}
@@ -0,0 +1,687 @@
+package observation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// patternDoc documents how a request pattern is built and what it's expected to put on the wire.
+type patternDoc struct {
+	construction string   // Go code building the request
+	framing      string   // expected body framing on the wire
+	caveats      []string // known pitfalls or environment dependencies
+}
+
+var patternDocs = map[reqPattern]patternDoc{
+	reqSinglePartWithLen: {
+		construction: `req, _ := http.NewRequest(http.MethodPut, url, f) // f: *os.File
+req.ContentLength = size`,
+		framing: "Content-Length: <file size>",
+	},
+	reqSinglePartWithoutLen: {
+		construction: `req, _ := http.NewRequest(http.MethodPut, url, f) // f: *os.File`,
+		framing:      "Transfer-Encoding: chunked",
+		caveats: []string{
+			"http.NewRequest infers ContentLength only for *bytes.Buffer, *bytes.Reader and *strings.Reader, not for *os.File",
+		},
+	},
+	reqSinglePartWithLen_wrong: {
+		construction: `req, _ := http.NewRequest(http.MethodPut, url, f) // f: *os.File
+req.Header.Set("Content-Length", strconv.Itoa(size))`,
+		framing: "Transfer-Encoding: chunked",
+		caveats: []string{
+			"the Transport ignores Content-Length in Request.Header and derives framing from Request.ContentLength",
+		},
+	},
+	reqSinglePartWithBuffer: {
+		construction: `buf := new(bytes.Buffer)
+io.Copy(buf, f)
+req, _ := http.NewRequest(http.MethodPut, url, buf)`,
+		framing: "Content-Length: <file size>",
+		caveats: []string{
+			"the length is inferred from the buffer when the request is created, so writing to the buffer afterwards breaks framing",
+		},
+	},
+	reqSinglePartExplicitlyChunked: {
+		construction: `buf := new(bytes.Buffer)
+io.Copy(buf, f)
+req, _ := http.NewRequest(http.MethodPut, url, buf)
+req.TransferEncoding = []string{"chunked"}`,
+		framing: "Transfer-Encoding: chunked",
+		caveats: []string{
+			"Request.TransferEncoding takes precedence over the inferred ContentLength",
+		},
+	},
+	reqMultipart: {
+		construction: `var buf bytes.Buffer
+mw := multipart.NewWriter(&buf)
+w, _ := mw.CreateFormFile("file", filename)
+io.Copy(w, f)
+mw.Close()
+req, _ := http.NewRequest(http.MethodPost, url, &buf)`,
+		framing: "Content-Length: <size of the whole multipart body>",
+		caveats: []string{
+			"the boundary is random unless -boundary is given, so captures differ between runs",
+			"the Content-Type header with the boundary is not set, as in many hand-written uploaders",
+		},
+	},
+	reqSinglePartSeekerRewind: {
+		construction: `req, _ := http.NewRequest(http.MethodPut, url, seekableBody{f}) // io.ReadSeeker with no-op Close
+cli := &http.Client{Transport: &authRoundTripper{base: tr}} // on 401, Seek(0, io.SeekStart) and resend with credentials`,
+		framing: "Transfer-Encoding: chunked, for both attempts",
+		caveats: []string{
+			"the Transport closes the body after the first attempt, so the wrapper's Close must be a no-op for rewinding to work",
+		},
+	},
+	reqSinglePartReqClose: {
+		construction: `req, _ := http.NewRequest(http.MethodPut, url, bytes.NewReader(data))
+req.Close = true`,
+		framing: "Content-Length: <file size>, with Connection: close on the first request",
+		caveats: []string{
+			"runs on a dedicated server on an ephemeral port, not on the capture server",
+		},
+	},
+	reqSinglePartDisableKeepAlives: {
+		construction: `tr := http.DefaultTransport.(*http.Transport).Clone()
+tr.DisableKeepAlives = true
+req, _ := http.NewRequest(http.MethodPut, url, bytes.NewReader(data))`,
+		framing: "Content-Length: <file size>, with Connection: close on every request",
+		caveats: []string{
+			"runs on a dedicated server on an ephemeral port, not on the capture server",
+		},
+	},
+	reqConditionalGet: {
+		construction: `req, _ := http.NewRequest(http.MethodGet, url, nil)
+req.Header.Set("If-None-Match", etag) // or If-Modified-Since`,
+		framing: "no body",
+		caveats: []string{
+			"net/http has no HTTP cache, so validators must be stored and sent by the caller",
+		},
+	},
+	reqPathNormalization: {
+		construction: `req, _ := http.NewRequest(http.MethodGet, url+"/a/../b", nil)`,
+		framing:      "no body",
+		caveats: []string{
+			"the request-target is sent as parsed by net/url, without dot segment removal. Only an empty path is replaced with \"/\"",
+		},
+	},
+	reqHugeResponseHeaders: {
+		construction: `tr := http.DefaultTransport.(*http.Transport).Clone()
+tr.MaxResponseHeaderBytes = 64 << 10
+req, _ := http.NewRequest(http.MethodPut, url, bytes.NewReader(data))`,
+		framing: "Content-Length: <file size>",
+		caveats: []string{
+			"whether the body is fully sent before the client aborts depends on socket buffer sizes",
+		},
+	},
+	reqSinglePartWrappedBody: {
+		construction: `h := sha256.New()
+req, _ := http.NewRequest(http.MethodPut, url, io.TeeReader(bytes.NewReader(data), h))`,
+		framing: "Transfer-Encoding: chunked when wrapped, Content-Length: <file size> for the bare reader",
+		caveats: []string{
+			"wrapping hides the concrete reader type, losing both ContentLength inference and GetBody",
+		},
+	},
+	reqProxyConnectHeader: {
+		construction: `tr.Proxy = http.ProxyURL(proxyURL)
+tr.ProxyConnectHeader = http.Header{"Proxy-Authorization": {"Basic ..."}}
+req, _ := http.NewRequest(http.MethodPut, "https://...", bytes.NewReader(data))`,
+		framing: "Content-Length: <file size>, inside the TLS tunnel",
+		caveats: []string{
+			"for HTTPS origins, Request.Header goes only to the origin through the tunnel, so Proxy-Authorization set there never reaches the proxy",
+			"ProxyConnectHeader is not used for plain HTTP origins, which are sent to the proxy in absolute-form without CONNECT",
+		},
+	},
+	reqMTLSCertRotation: {
+		construction: `tr.TLSClientConfig = &tls.Config{
+	RootCAs: pool,
+	GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return current.Load().(*tls.Certificate), nil // swapped between requests
+	},
+}
+req, _ := http.NewRequest(http.MethodPut, "https://...", bytes.NewReader(data))`,
+		framing: "Content-Length: <file size>, inside TLS",
+		caveats: []string{
+			"GetClientCertificate is called only during full handshakes, so pooled connections keep the identity they were established with",
+			"with ClientSessionCache, resumed sessions keep the old identity even on new connections",
+		},
+	},
+	reqH2Faults: {
+		construction: `tr := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}, ForceAttemptHTTP2: true}
+req, _ := http.NewRequest(http.MethodPut, "https://...", bytes.NewReader(data))`,
+		framing: "HTTP/2 DATA frames, END_STREAM on the last one",
+		caveats: []string{
+			"retries rewind the body with GetBody, which NewRequest sets only for in-memory readers",
+			"RST_STREAM REFUSED_STREAM and GOAWAY with a last stream below the request's are retried; other codes surface as errors",
+			"a response received before RST_STREAM NO_ERROR is returned as is",
+		},
+	},
+	reqJSONMarshaled: {
+		construction: `var buf bytes.Buffer
+_ = json.NewEncoder(&buf).Encode(doc)
+req, _ := http.NewRequest(http.MethodPost, url, &buf)
+req.Header.Set("Content-Type", "application/json")`,
+		framing: "Content-Length: <encoded document size>",
+		caveats: []string{
+			"the encoded document is held in memory alongside the value, roughly 4/3 of the embedded bytes for base64",
+			"headers are sent only after encoding finishes",
+		},
+	},
+	reqJSONEncodedPipe: {
+		construction: `pr, pw := io.Pipe()
+go func() { _ = pw.CloseWithError(json.NewEncoder(pw).Encode(doc)) }()
+req, _ := http.NewRequest(http.MethodPost, url, pr)
+req.Header.Set("Content-Type", "application/json")`,
+		framing: "Transfer-Encoding: chunked",
+		caveats: []string{
+			"Encoder.Encode marshals the whole value into its own buffer before writing, so memory still grows with the document; only the extra copy of bytes.Buffer is saved",
+			"headers are sent while the value is being encoded, but the body starts only after encoding finishes",
+			"the length is unknown, so servers requiring Content-Length reject it with 411",
+			"the encoding goroutine must not outlive the request: a failed request closes the pipe, failing the pending Write",
+		},
+	},
+	reqConditionalPut: {
+		construction: `req, _ := http.NewRequest(http.MethodPut, url, bytes.NewReader(data))
+req.Header.Set("If-Match", etag) // or If-Unmodified-Since: <Last-Modified>
+req.Header.Set("Expect", "100-continue") // optional`,
+		framing: "Content-Length: <file size, repeated up to 16MiB>",
+		caveats: []string{
+			"without Expect: 100-continue, the HTTP/1 Transport keeps sending the whole body after a 412 arrives before it; the connection is reusable only if the server drains it",
+			"with Expect: 100-continue, a 412 instead of 100 Continue skips the body, and the connection isn't reused",
+			"If-Unmodified-Since is ignored by servers when If-Match is present",
+		},
+	},
+	reqAuthChange: {
+		construction: `req, _ := http.NewRequest(http.MethodPut, url, bytes.NewReader(data))
+req.Header.Set("Authorization", "Bearer "+token) // token changes between requests on one Transport`,
+		framing: "Content-Length: <file size>",
+		caveats: []string{
+			"the Transport pools connections by scheme, host and proxy only; credentials never take part, so one connection carries every identity",
+			"authentication bound to connections (NTLM, Negotiate, or proxies caching the identity per connection) applies the first identity to later requests",
+			"use separate Transports per identity, or CloseIdleConnections before switching, when connection-bound authentication is involved",
+		},
+	},
+	reqWriteVsWriteProxy: {
+		construction: `req, _ := http.NewRequest(http.MethodPut, "http://origin.example:8080/upload?name=photo.jpg", bytes.NewReader(data))
+_ = req.Write(conn)      // origin-form: PUT /upload?name=photo.jpg
+_ = req.WriteProxy(conn) // absolute-form: PUT http://origin.example:8080/upload?name=photo.jpg`,
+		framing: "Content-Length: <file size>",
+		caveats: []string{
+			"only the request-target differs; headers are the same, and neither adds Accept-Encoding like the Transport does",
+			"WriteProxy builds the absolute-form from Request.Host when it's set, not from the URL's host",
+			"userinfo in the URL is dropped by both, without adding Authorization",
+		},
+	},
+	reqStuckBody: {
+		construction: `body := newStuckBody(data[:16<<10]) // Read blocks forever after the prefix, Close doesn't unblock it
+req, _ := http.NewRequestWithContext(ctx, http.MethodPut, url, body)
+resp, err := cli.Do(req)`,
+		framing: "Transfer-Encoding: chunked (the body isn't a known type)",
+		caveats: []string{
+			"none of ResponseHeaderTimeout, Client.Timeout, context deadlines or the server closing the connection makes Do return: it waits for the write loop, which is stuck in Read",
+			"Do returns the timeout or cancellation error only once Read returns, so a body reader has to honor the request context itself",
+			"the Transport doesn't call Close on the body while Read is blocked, so closing can't be used to unblock it either",
+		},
+	},
+	reqUpgradeTLS: {
+		construction: `req, _ := http.NewRequest(http.MethodOptions, url, nil)
+req.URL.Path = "*"
+req.Header.Set("Upgrade", "TLS/1.2")
+req.Header.Set("Connection", "Upgrade")
+resp, _ := cli.Do(req) // 101 Switching Protocols
+rwc := resp.Body.(io.ReadWriteCloser)
+tconn := tls.Client(upgradedConn{rwc}, conf)`,
+		framing: "none for OPTIONS *, Content-Length: <file size> for the PUT case (the body is sent before the 101)",
+		caveats: []string{
+			"the Transport hands over the connection as the io.ReadWriteCloser body of the 101 response, and never pools it again",
+			"the TLS config of the Transport doesn't apply to the upgraded connection: ALPN, SNI and roots come from the config passed to tls.Client",
+			"a 426 Upgrade Required is returned to the caller as is, the Transport doesn't upgrade by itself",
+		},
+	},
+	reqHTTP10: {
+		construction: `req, _ := http.NewRequest(http.MethodPut, url, bytes.NewReader(data))
+req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/1.0", 1, 0`,
+		framing: "Content-Length: <file size>, in an HTTP/1.1 request",
+		caveats: []string{
+			"the protocol version fields are ignored for outgoing requests: both the Transport and Request.Write send HTTP/1.1 with Host",
+			"an HTTP/1.0 response closes the connection unless it carries Connection: keep-alive",
+			"net/http's server accepts HTTP/1.0 requests without Host, and ignores their Transfer-Encoding",
+		},
+	},
+	reqExpectContinue: {
+		construction: `req, _ := http.NewRequest(http.MethodPut, url, bytes.NewReader(data))
+req.Header.Set("Expect", "100-continue")
+tr.ExpectContinueTimeout = time.Second // as http.DefaultTransport`,
+		framing: "Content-Length: <file size>",
+		caveats: []string{
+			"the Transport holds back the body until 100 Continue arrives or ExpectContinueTimeout passes, so a silent server costs a full timeout per request",
+			"with ExpectContinueTimeout = 0, the body is sent right after the headers as if Expect weren't set, while the header is still sent",
+			"a final response instead of 100 Continue skips the body, and the connection isn't reused",
+		},
+	},
+	reqGetWithBody: {
+		construction: `req, _ := http.NewRequest(http.MethodGet, url, f) // f: *os.File
+req.ContentLength = size // or left zero`,
+		framing: "Content-Length: <file size> with ContentLength, Transfer-Encoding: chunked without",
+		caveats: []string{
+			"the Transport sends the body of a GET like any other method, without dropping headers",
+			"a GET body has no defined semantics (RFC 9110 Section 9.3.1): servers, proxies and caches may ignore it or reject the request",
+		},
+	},
+	reqDeleteWithBody: {
+		construction: `req, _ := http.NewRequest(http.MethodDelete, url, f) // f: *os.File
+req.ContentLength = size // or left zero`,
+		framing: "Content-Length: <file size> with ContentLength, Transfer-Encoding: chunked without",
+		caveats: []string{
+			"the Transport sends the body of a DELETE like any other method, without dropping headers",
+			"a DELETE body has no defined semantics (RFC 9110 Section 9.3.5), and some intermediaries drop it or reject the request",
+		},
+	},
+	reqEmptyBody: {
+		construction: `req, _ := http.NewRequest(http.MethodPut, url, http.NoBody) // or nil, bytes.NewReader(nil), io.MultiReader()`,
+		framing:      "Content-Length: 0 for PUT, none for GET; Transfer-Encoding: chunked for a PUT with an empty reader of unknown length",
+		caveats: []string{
+			"http.NewRequest replaces empty *bytes.Buffer, *bytes.Reader and *strings.Reader bodies with http.NoBody",
+			"the Transport sends Content-Length: 0 for methods which usually carry a body, regardless of NoBody or nil",
+			"for other readers with ContentLength 0, the length is unknown: PUT goes chunked with just the last chunk, while GET probes the body and sends no framing when it's empty",
+		},
+	},
+	reqSinglePartWithBytesReader: {
+		construction: `data, _ := io.ReadAll(f)
+req, _ := http.NewRequest(http.MethodPut, url, bytes.NewReader(data))`,
+		framing: "Content-Length: <file size>",
+		caveats: []string{
+			"http.NewRequest sets ContentLength from Len() of *bytes.Reader, along with GetBody, without the caller setting anything",
+			"Len() is taken when the request is built: reading or seeking the reader before Do shrinks the length sent",
+		},
+	},
+	reqSinglePartWithStringsReader: {
+		construction: `req, _ := http.NewRequest(http.MethodPut, url, strings.NewReader(s)) // s: file contents`,
+		framing:      "Content-Length: <file size>",
+		caveats: []string{
+			"http.NewRequest sets ContentLength from Len() of *strings.Reader, along with GetBody, without the caller setting anything",
+			"wrapping the reader (e.g. in io.NopCloser or a custom type) hides it from the type switch, turning the request chunked",
+		},
+	},
+	reqSinglePartWithPipe: {
+		construction: `pr, pw := io.Pipe()
+go func() {
+	for ... { pw.Write(piece) } // 5000 bytes each
+	pw.Close()
+}()
+req, _ := http.NewRequest(http.MethodPut, url, pr)`,
+		framing: "Transfer-Encoding: chunked, a chunk per Write of the producer",
+		caveats: []string{
+			"io.Pipe has no buffer: each Write blocks until the Transport has read it all, so the producer runs at the pace of the connection",
+			"the Transport copies the body with a 32KiB buffer and reads from the pipe return at most one Write, so small writes become small chunks with their own framing overhead",
+			"the producer must close the pipe, with CloseWithError on failure; otherwise the request never ends",
+			"if the request fails, the Transport closes the read end and the pending Write fails with io.ErrClosedPipe, which the producer must handle to not leak",
+		},
+	},
+	reqSinglePartUnknownLen: {
+		construction: `req, _ := http.NewRequest(http.MethodPut, url, f) // f: *os.File
+req.ContentLength = -1`,
+		framing: "Transfer-Encoding: chunked, the same chunks as without-len",
+		caveats: []string{
+			"for *os.File, the wire dump is identical to leaving ContentLength zero; with-len is the one sending Content-Length",
+			"with zero, the Transport first reads a byte of the body to tell an empty body from an unknown one; -1 skips that probe",
+			"-1 also overrides the length http.NewRequest inferred for *bytes.Buffer, *bytes.Reader and *strings.Reader, turning them chunked",
+		},
+	},
+	reqMultipartPipe: {
+		construction: `pr, pw := io.Pipe()
+mw := multipart.NewWriter(pw)
+go func() {
+	w, _ := mw.CreateFormFile("file", filename)
+	io.Copy(w, f)
+	pw.CloseWithError(mw.Close())
+}()
+req, _ := http.NewRequest(http.MethodPost, url, pr)
+req.Header.Set("Content-Type", mw.FormDataContentType())`,
+		framing: "Transfer-Encoding: chunked: the part headers, the file in 32KiB pieces and the closing boundary in chunks of their own",
+		caveats: []string{
+			"no full-body buffer is made: memory stays flat regardless of the file size (see -multipart-perf)",
+			"the length is unknown, so servers requiring Content-Length reject it with 411",
+			"the body can't be replayed: GetBody is nil, so the Transport can't retry it and redirects needing the body fail",
+		},
+	},
+	reqMultipartWithLen: {
+		construction: `var head bytes.Buffer
+mw := multipart.NewWriter(&head)
+mw.CreateFormFile("file", filename) // renders the part header only
+tail := "\r\n--" + mw.Boundary() + "--\r\n"
+req, _ := http.NewRequest(http.MethodPost, url, io.MultiReader(&head, f, strings.NewReader(tail)))
+req.ContentLength = int64(head.Len()) + size + int64(len(tail))
+req.Header.Set("Content-Type", mw.FormDataContentType())`,
+		framing: "Content-Length: <part header + file size + closing boundary>, the same bytes as multipart",
+		caveats: []string{
+			"streams the file like multipart-pipe, but without chunked framing, so servers requiring Content-Length accept it",
+			"the length must be exact: the file changing size after Stat makes the Transport fail the request (longer) or the server wait for missing bytes (shorter)",
+			"the body can't be replayed unless GetBody is set to rebuild the readers",
+		},
+	},
+	reqURLEncodedForm: {
+		construction: `form := url.Values{}
+form.Set("name", filename)
+form.Set("data", base64.StdEncoding.EncodeToString(data))
+req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(form.Encode()))
+req.Header.Set("Content-Type", "application/x-www-form-urlencoded") // what http.PostForm does`,
+		framing: "Content-Length: <size of the encoded form>",
+		caveats: []string{
+			"the length comes from the *strings.Reader, so the form is never chunked",
+			"url.Values.Encode sorts fields by key: the order on the wire isn't the order they were added in",
+			"base64 and then percent-encoding inflate binary data by roughly 40%, which is why files are usually sent as multipart",
+		},
+	},
+	reqGzipBuffered: {
+		construction: `var buf bytes.Buffer
+zw := gzip.NewWriter(&buf)
+io.Copy(zw, f)
+zw.Close()
+req, _ := http.NewRequest(http.MethodPut, url, &buf)
+req.Header.Set("Content-Encoding", "gzip")`,
+		framing: "Content-Length: <compressed size>",
+		caveats: []string{
+			"Content-Length is the length of the compressed body, as Content-Encoding is part of the representation",
+			"already compressed data like JPEG gets slightly larger: the gzip header and trailer and stored blocks add overhead",
+			"the Transport never compresses request bodies itself, and servers aren't required to accept Content-Encoding on requests (415)",
+		},
+	},
+	reqGzipStreamed: {
+		construction: `pr, pw := io.Pipe()
+go func() {
+	zw := gzip.NewWriter(pw)
+	_, err := io.Copy(zw, f)
+	zw.Close()
+	pw.CloseWithError(err)
+}()
+req, _ := http.NewRequest(http.MethodPut, url, pr)
+req.Header.Set("Content-Encoding", "gzip")`,
+		framing: "Transfer-Encoding: chunked, with tiny chunks for the gzip header, block headers and the trailer",
+		caveats: []string{
+			"every Write of gzip.Writer to the pipe becomes a chunk, so the 10-byte header, deflate block boundaries and the 8-byte trailer show up as chunks of a few bytes",
+			"wrap the pipe writer in bufio.Writer to coalesce them, flushing before closing the pipe",
+			"the gzip.Writer must be closed before the pipe, or the trailer (CRC-32 and size) is never sent",
+		},
+	},
+	reqTrailer: {
+		construction: `trailer := http.Header{"X-Content-Sha256": nil} // declared up front, filled at EOF by the body reader
+req, _ := http.NewRequest(http.MethodPut, url, &checksumTrailerReader{r: f, h: sha256.New(), trailer: trailer})
+req.Trailer = trailer`,
+		framing: "Transfer-Encoding: chunked, with Trailer: X-Content-Sha256 in the headers and the field after the last chunk",
+		caveats: []string{
+			"the Transport reads Request.Trailer only after the body hits EOF, so values can be set while streaming as long as the keys are declared before Do",
+			"trailers need chunked framing: with ContentLength set, Request.Trailer is silently not sent",
+			"the default server reads only the first -capture-bytes; use -capture-bytes all to see the trailer section",
+			"servers may ignore trailers; net/http exposes them in Request.Trailer only after the body is read to the end",
+		},
+	},
+	reqSinglePartChunkedHeader: {
+		construction: `buf := new(bytes.Buffer)
+io.Copy(buf, f)
+req, _ := http.NewRequest(http.MethodPut, url, buf)
+req.Header.Set("Transfer-Encoding", "chunked")`,
+		framing: "Content-Length: <file size>",
+		caveats: []string{
+			"the Transport drops Transfer-Encoding in Request.Header without an error, neither honoring nor duplicating it; framing comes from Request.ContentLength and Request.TransferEncoding",
+			"set Request.TransferEncoding (see explicit-chunked) to force chunked framing",
+		},
+	},
+	reqSinglePartLenAndChunked: {
+		construction: `req, _ := http.NewRequest(http.MethodPut, url, f) // f: *os.File
+req.ContentLength = size
+req.TransferEncoding = []string{"chunked"}`,
+		framing: "Transfer-Encoding: chunked",
+		caveats: []string{
+			"chunked wins: the Transport drops ContentLength without an error and never sends both headers",
+			"http.NewRequest with *bytes.Buffer and friends sets ContentLength, so explicit-chunked is the same conflict",
+		},
+	},
+	reqSinglePartLenTooSmall: {
+		construction: `req, _ := http.NewRequest(http.MethodPut, url, f) // f: *os.File
+req.ContentLength = size / 2`,
+		framing: "Content-Length: <half the file size>",
+		caveats: []string{
+			"the Transport sends exactly ContentLength bytes, then finds the body goes on and fails with \"http: ContentLength=N with Body length M\"",
+			"by then the server has a complete request with a truncated body, and may well have accepted it",
+		},
+	},
+	reqSinglePartLenTooLarge: {
+		construction: `req, _ := http.NewRequest(http.MethodPut, url, f) // f: *os.File
+req.ContentLength = size * 2`,
+		framing: "Content-Length: <double the file size>",
+		caveats: []string{
+			"the Transport sends the whole body, fails with \"http: ContentLength=N with Body length M\" at its end, and closes the connection",
+			"the server waits for the rest of the body until the connection is closed, so it never sees a complete request",
+		},
+	},
+	reqSinglePartLenReader: {
+		construction: `req, _ := http.NewRequest(http.MethodPut, url, &lenReader{r: f, n: size}) // lenReader: Read() and Len() int`,
+		framing:      "Transfer-Encoding: chunked, Content-Length: <file size> once ContentLength is set from Len()",
+		caveats: []string{
+			"http.NewRequest infers ContentLength from the concrete types *bytes.Buffer, *bytes.Reader and *strings.Reader, not from Len()",
+			"the body can't be replayed either way: GetBody is nil, so 307/308 redirects aren't followed",
+		},
+	},
+	reqSinglePartSeekReader: {
+		construction: `req, _ := http.NewRequest(http.MethodPut, url, seekReader{f}) // seekReader: io.ReadSeeker only`,
+		framing:      "Transfer-Encoding: chunked",
+		caveats: []string{
+			"neither http.NewRequest nor the client seeks the body: only GetBody replays it on 307/308 redirects and retries",
+			"a GetBody seeking to the start and returning the same reader makes the redirect work",
+		},
+	},
+	reqSinglePartLimitReader: {
+		construction: `req, _ := http.NewRequest(http.MethodPut, url, io.LimitReader(f, size/2))`,
+		framing:      "Transfer-Encoding: chunked",
+		caveats: []string{
+			"*io.LimitedReader carries its limit in N, but neither http.NewRequest nor the Transport infers ContentLength from it",
+			"set ContentLength to the limit for Content-Length framing, as long as the underlying reader has at least that many bytes",
+		},
+	},
+	reqDeadConnRetry: {
+		construction: `cli.Do(warmUp) // the connection goes back to the pool, and the server closes it
+req, _ := http.NewRequest(method, url, body) // GET, PUT with *bytes.Reader, with or without Idempotency-Key, PUT with *os.File
+cli.Do(req)                                  // sent on the dead pooled connection`,
+		framing: "as usual for each request, on the dead connection and again on a new one if retried",
+		caveats: []string{
+			"the Transport retries a request failing on a reused connection only if it's idempotent (GET, HEAD, OPTIONS, TRACE or with Idempotency-Key) and its body can be replayed",
+			"bodies are replayed with GetBody, so a PUT with *os.File isn't retried even with Idempotency-Key",
+			"a PUT without Idempotency-Key fails with the error of the dead connection, though http.NewRequest set GetBody",
+		},
+	},
+	reqRedirectReplay: {
+		construction: `req, _ := http.NewRequest(http.MethodPut, "http://127.0.0.1:<port>", body) // body: *bytes.Reader or *os.File
+req.SetBasicAuth("user", "pass")
+http.DefaultClient.Do(req) // replied 307/308 with Location: http://localhost:<another port>/redirected`,
+		framing: "Content-Length: <file size> with *bytes.Reader, Transfer-Encoding: chunked with *os.File, on both hops",
+		caveats: []string{
+			"307 and 308 are followed with the same method and body only if Request.GetBody is set; otherwise the client returns the redirect response as is",
+			"Authorization, Cookie and WWW-Authenticate are dropped when the redirect goes to another host",
+		},
+	},
+	reqGetBodyRedirect: {
+		construction: `req, _ := http.NewRequest(http.MethodPut, url, f) // f: *os.File, so GetBody is nil
+req.GetBody = func() (io.ReadCloser, error) { return os.Open(filename) }`,
+		framing: "Transfer-Encoding: chunked on both hops, as GetBody doesn't tell the length",
+		caveats: []string{
+			"without GetBody, the client returns the 307/308 response as is; with it, the client follows the redirect sending a fresh body",
+			"GetBody must return a new reader of the whole body each time: returning the already read f would send an empty body",
+		},
+	},
+	reqHeaderCanonicalization: {
+		construction: `req, _ := http.NewRequest(http.MethodGet, url, nil)
+req.Header.Set("x-my-HEADER", "set")              // stored as X-My-Header
+req.Header["x-my-RAW"] = []string{"direct"}      // stored as is
+req.Header.Add("x-multi", "a"); req.Header.Add("X-MULTI", "b")`,
+		framing: "none",
+		caveats: []string{
+			"Set, Add and Get canonicalize names with textproto.CanonicalMIMEHeaderKey; keys assigned to the map directly are sent exactly as written, and Get can't find them",
+			"the Transport writes Host and User-Agent first, then Request.Header sorted by key in byte order, so lowercase keys come after all capitalized ones",
+			"each value of a key goes on its own line, never folded into one comma-separated line; values are trimmed of surrounding spaces",
+			"a Go server canonicalizes names again, merging keys differing only in case, such as X-Dup and x-dup",
+		},
+	},
+	reqHostField: {
+		construction: `req, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1:<port>", nil)
+req.Host = "example.com"`,
+		framing: "none",
+		caveats: []string{
+			"the Host header carries Request.Host, while the connection goes to the URL host; this is how to reach a virtual host by address",
+			"http.NewRequest sets Request.Host from the URL, so it must be overwritten after the request is built",
+		},
+	},
+	reqHostHeader: {
+		construction: `req, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1:<port>", nil)
+req.Header.Set("Host", "example.com") // ignored`,
+		framing: "none",
+		caveats: []string{
+			"the client ignores Host in Request.Header: the Host header carries Request.Host, or the URL host if it's empty",
+			"no second Host line is written, so the value set in the map is silently dropped",
+		},
+	},
+	reqUADefault: {
+		construction: `req, _ := http.NewRequest(http.MethodGet, url, nil)`,
+		framing:      "none",
+		caveats: []string{
+			"the Transport sends User-Agent: Go-http-client/1.1 when Request.Header has no User-Agent key",
+		},
+	},
+	reqUAOverride: {
+		construction: `req, _ := http.NewRequest(http.MethodGet, url, nil)
+req.Header.Set("User-Agent", "observation/1.0")`,
+		framing: "none",
+		caveats: []string{
+			"the value in Request.Header replaces the default, written right after Host rather than in sorted order",
+		},
+	},
+	reqUASuppressed: {
+		construction: `req, _ := http.NewRequest(http.MethodGet, url, nil)
+req.Header.Set("User-Agent", "") // sends no User-Agent`,
+		framing: "none",
+		caveats: []string{
+			"a User-Agent key with an empty value suppresses the header; deleting the key brings the default back",
+		},
+	},
+}
+
+// parseList parses a comma-separated list of pattern names or numeric IDs, in the order given.
+func (ps *patternSet) parseList(s string) ([]reqPattern, error) {
+	var pats []reqPattern
+	for _, f := range strings.Split(s, ",") {
+		p, err := ps.parse(strings.TrimSpace(f))
+		if err != nil {
+			return nil, err
+		}
+		pats = append(pats, p)
+	}
+	return pats, nil
+}
+
+// parse parses a pattern name or numeric ID.
+func (ps *patternSet) parse(s string) (reqPattern, error) {
+	if id, err := strconv.Atoi(s); err == nil {
+		if p := reqPattern(id); p >= reqSinglePartWithLen && p < ps.end() {
+			return p, nil
+		}
+		return 0, fmt.Errorf("unknown pattern ID: %d (1 to %d)", id, ps.end()-1)
+	}
+	for p := reqSinglePartWithLen; p < ps.end(); p++ {
+		if strings.EqualFold(s, ps.name(p)) {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown pattern: %q (see -list)", s)
+}
+
+// list prints the ID, name and description of each pattern.
+func (ps *patternSet) list(w io.Writer) {
+	for p := reqSinglePartWithLen; p < ps.end(); p++ {
+		fmt.Fprintf(w, "%2d  %-22s  %s\n", p, ps.name(p), ps.describe(p))
+	}
+}
+
+// ListPatterns prints the ID, name and description of each pattern, including the ones registered with RegisterPattern
+// and the ones described in the scenario files (-list).
+func ListPatterns(w io.Writer, scenarioFiles ...string) error {
+	ps, err := newPatternSet(scenarioFiles...)
+	if err != nil {
+		return err
+	}
+	ps.list(w)
+	return nil
+}
+
+// DescribePatterns prints the construction, expected framing and caveats of each built-in pattern in the format,
+// one of "text", "json" and "markdown" (the patterns describe subcommand).
+func DescribePatterns(w io.Writer, format string) error {
+	switch format {
+	case "text":
+		describePatternsText(w)
+	case "json":
+		return describePatternsJSON(w)
+	case "markdown":
+		describePatternsMarkdown(w)
+	default:
+		return fmt.Errorf("unknown format: %q", format)
+	}
+	return nil
+}
+
+func describePatternsText(w io.Writer) {
+	for p := reqSinglePartWithLen; p < reqPatternBound; p++ {
+		doc := patternDocs[p]
+		fmt.Fprintf(w, "%d. %v\n", p, p)
+		fmt.Fprintf(w, "  expected framing: %s\n", doc.framing)
+		fmt.Fprintln(w, "  construction:")
+		for _, line := range strings.Split(doc.construction, "\n") {
+			fmt.Fprintf(w, "    %s\n", line)
+		}
+		for _, c := range doc.caveats {
+			fmt.Fprintf(w, "  caveat: %s\n", c)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func describePatternsJSON(w io.Writer) error {
+	type patternJSON struct {
+		ID           int      `json:"id"`
+		Name         string   `json:"name"`
+		Description  string   `json:"description"`
+		Construction string   `json:"construction"`
+		Framing      string   `json:"expected_framing"`
+		Caveats      []string `json:"caveats"`
+	}
+	ps := make([]patternJSON, 0, reqPatternBound-1)
+	for p := reqSinglePartWithLen; p < reqPatternBound; p++ {
+		doc := patternDocs[p]
+		caveats := doc.caveats
+		if caveats == nil {
+			caveats = []string{}
+		}
+		ps = append(ps, patternJSON{ID: int(p), Name: p.Name(), Description: p.String(), Construction: doc.construction, Framing: doc.framing, Caveats: caveats})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ps)
+}
+
+func describePatternsMarkdown(w io.Writer) {
+	fmt.Fprintln(w, "# Request patterns")
+	for p := reqSinglePartWithLen; p < reqPatternBound; p++ {
+		doc := patternDocs[p]
+		fmt.Fprintf(w, "\n## %d. %v\n\n", p, p)
+		fmt.Fprintf(w, "Expected framing: %s\n\n", doc.framing)
+		fmt.Fprintf(w, "```go\n%s\n```\n", doc.construction)
+		if len(doc.caveats) > 0 {
+			fmt.Fprintln(w, "\nCaveats:")
+			for _, c := range doc.caveats {
+				fmt.Fprintf(w, "- %s\n", c)
+			}
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package observation
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	pcapLinkTypeRaw = 101 // LINKTYPE_RAW: packets begin with an IPv4 or IPv6 header
+	pcapSnapLen     = 262144
+	// payload bytes of each synthetic TCP segment
+	pcapMaxSegment = 16384
+
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpRST = 0x04
+	tcpPSH = 0x08
+	tcpACK = 0x10
+)
+
+// pcapWriter writes bytes read and written on connections of the capture server to a writer as pcap, in TCP segments of synthetic
+// TCP/IP framing: a handshake on accepting, a segment per pcapMaxSegment bytes of each Read and Write, and FIN or RST on closing.
+// Connections of other than TCP (e.g. Unix domain sockets) are given loopback addresses.
+type pcapWriter struct {
+	mu    sync.Mutex
+	w     io.Writer
+	conns int
+	err   error // first error writing, reported by Close
+}
+
+// newPcapWriter writes the pcap global header to w and returns a writer of packets following it.
+func newPcapWriter(w io.Writer) (*pcapWriter, error) {
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:], 0xa1b2c3d4) // microsecond timestamps
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(hdr[20:], pcapLinkTypeRaw)
+	if _, err := w.Write(hdr); err != nil {
+		return nil, fmt.Errorf("failed to write pcap: %w", err)
+	}
+	return &pcapWriter{w: w}, nil
+}
+
+// Close reports the first error writing packets. It doesn't close the underlying writer.
+func (w *pcapWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return fmt.Errorf("failed to write pcap: %w", w.err)
+	}
+	return nil
+}
+
+// wrapListener returns l recording connections it accepts.
+func (w *pcapWriter) wrapListener(l net.Listener) net.Listener {
+	return &pcapListener{Listener: l, w: w}
+}
+
+// writePacket writes a record of an IP packet carrying a TCP segment from src to dst. w.mu must be held.
+func (w *pcapWriter) writePacket(src, dst *net.TCPAddr, seq, ack uint32, flags byte, payload []byte) {
+	if w.err != nil {
+		return
+	}
+	pkt := buildTCPPacket(src, dst, seq, ack, flags, payload)
+	now := time.Now()
+	rec := make([]byte, 16, 16+len(pkt))
+	binary.LittleEndian.PutUint32(rec[0:], uint32(now.Unix()))
+	binary.LittleEndian.PutUint32(rec[4:], uint32(now.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(rec[8:], uint32(len(pkt)))
+	binary.LittleEndian.PutUint32(rec[12:], uint32(len(pkt)))
+	_, w.err = w.w.Write(append(rec, pkt...))
+}
+
+// buildTCPPacket builds an IPv4 or IPv6 packet (as the family of src) carrying a TCP segment without options, with valid checksums.
+func buildTCPPacket(src, dst *net.TCPAddr, seq, ack uint32, flags byte, payload []byte) []byte {
+	tcp := make([]byte, 20+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:], uint16(src.Port))
+	binary.BigEndian.PutUint16(tcp[2:], uint16(dst.Port))
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	binary.BigEndian.PutUint32(tcp[8:], ack)
+	tcp[12] = 5 << 4 // data offset
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 65535) // window
+	copy(tcp[20:], payload)
+
+	var ip, pseudo []byte
+	if src4, dst4 := src.IP.To4(), dst.IP.To4(); src4 != nil && dst4 != nil {
+		ip = make([]byte, 20)
+		ip[0] = 4<<4 | 5
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+len(tcp)))
+		ip[6] = 0x40 // don't fragment
+		ip[8] = 64   // TTL
+		ip[9] = syscall.IPPROTO_TCP
+		copy(ip[12:], src4)
+		copy(ip[16:], dst4)
+		binary.BigEndian.PutUint16(ip[10:], inetChecksum(0, ip))
+
+		pseudo = append(append(append([]byte{}, src4...), dst4...), 0, syscall.IPPROTO_TCP, 0, 0)
+		binary.BigEndian.PutUint16(pseudo[10:], uint16(len(tcp)))
+	} else {
+		ip = make([]byte, 40)
+		ip[0] = 6 << 4
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(tcp)))
+		ip[6] = syscall.IPPROTO_TCP
+		ip[7] = 64 // hop limit
+		copy(ip[8:], src.IP.To16())
+		copy(ip[24:], dst.IP.To16())
+
+		pseudo = make([]byte, 40)
+		copy(pseudo, ip[8:40])
+		binary.BigEndian.PutUint32(pseudo[32:], uint32(len(tcp)))
+		pseudo[39] = syscall.IPPROTO_TCP
+	}
+	binary.BigEndian.PutUint16(tcp[16:], inetChecksum(inetSum(0, pseudo), tcp))
+	return append(ip, tcp...)
+}
+
+// inetSum adds b to the one's complement sum of 16-bit words.
+func inetSum(sum uint32, b []byte) uint32 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	return sum
+}
+
+// inetChecksum returns the Internet checksum (RFC 1071) of b, continuing sum.
+func inetChecksum(sum uint32, b []byte) uint16 {
+	sum = inetSum(sum, b)
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+type pcapListener struct {
+	net.Listener
+	w *pcapWriter
+}
+
+func (l *pcapListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.w.newConn(conn), nil
+}
+
+// newConn returns conn recording bytes read as segments from the client, and bytes written as segments from the server,
+// after writing the handshake of the connection.
+func (w *pcapWriter) newConn(conn net.Conn) *pcapConn {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.conns++
+	client, ok1 := conn.RemoteAddr().(*net.TCPAddr)
+	server, ok2 := conn.LocalAddr().(*net.TCPAddr)
+	if !ok1 || !ok2 {
+		client = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 49152 + w.conns%16384}
+		server = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80}
+	}
+	c := &pcapConn{Conn: conn, w: w, client: client, server: server, clientSeq: 1000, serverSeq: 5000}
+	w.writePacket(client, server, c.clientSeq, 0, tcpSYN, nil)
+	w.writePacket(server, client, c.serverSeq, c.clientSeq+1, tcpSYN|tcpACK, nil)
+	c.clientSeq++
+	c.serverSeq++
+	w.writePacket(client, server, c.clientSeq, c.serverSeq, tcpACK, nil)
+	return c
+}
+
+// pcapConn records a connection of the capture server to its pcapWriter. It has methods of *net.TCPConn used by server behaviors,
+// which are no-ops if the wrapped connection lacks them.
+type pcapConn struct {
+	net.Conn
+	w                    *pcapWriter
+	client, server       *net.TCPAddr
+	clientSeq, serverSeq uint32 // next sequence number of each side
+	clientFin, serverFin bool
+	linger0              bool
+}
+
+func (c *pcapConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.w.mu.Lock()
+	defer c.w.mu.Unlock()
+
+	c.segments(c.client, c.server, &c.clientSeq, c.serverSeq, p[:n])
+	switch {
+	case errors.Is(err, syscall.ECONNRESET):
+		c.w.writePacket(c.client, c.server, c.clientSeq, c.serverSeq, tcpRST|tcpACK, nil)
+	case err != nil && n == 0 && !c.clientFin && !errors.Is(err, os.ErrDeadlineExceeded) && !errors.Is(err, net.ErrClosed):
+		c.clientFin = true
+		c.w.writePacket(c.client, c.server, c.clientSeq, c.serverSeq, tcpFIN|tcpACK, nil)
+		c.clientSeq++
+	}
+	return n, err
+}
+
+func (c *pcapConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.w.mu.Lock()
+	defer c.w.mu.Unlock()
+
+	c.segments(c.server, c.client, &c.serverSeq, c.clientSeq, p[:n])
+	return n, err
+}
+
+// segments writes b as segments from src to dst, advancing seq. c.w.mu must be held.
+func (c *pcapConn) segments(src, dst *net.TCPAddr, seq *uint32, ack uint32, b []byte) {
+	for len(b) > 0 {
+		n := len(b)
+		if n > pcapMaxSegment {
+			n = pcapMaxSegment
+		}
+		c.w.writePacket(src, dst, *seq, ack, tcpPSH|tcpACK, b[:n])
+		*seq += uint32(n)
+		b = b[n:]
+	}
+}
+
+// serverFIN writes FIN from the server unless written already. c.w.mu must be held.
+func (c *pcapConn) serverFIN() {
+	if c.serverFin {
+		return
+	}
+	c.serverFin = true
+	c.w.writePacket(c.server, c.client, c.serverSeq, c.clientSeq, tcpFIN|tcpACK, nil)
+	c.serverSeq++
+}
+
+func (c *pcapConn) Close() error {
+	err := c.Conn.Close()
+	c.w.mu.Lock()
+	defer c.w.mu.Unlock()
+
+	if c.linger0 {
+		if !c.serverFin {
+			c.serverFin = true
+			c.w.writePacket(c.server, c.client, c.serverSeq, c.clientSeq, tcpRST|tcpACK, nil)
+		}
+		return err
+	}
+	c.serverFIN()
+	return err
+}
+
+func (c *pcapConn) CloseWrite() error {
+	cw, ok := c.Conn.(interface{ CloseWrite() error })
+	if !ok {
+		return errors.New("connection can't be half-closed")
+	}
+	err := cw.CloseWrite()
+	c.w.mu.Lock()
+	defer c.w.mu.Unlock()
+
+	c.serverFIN()
+	return err
+}
+
+func (c *pcapConn) SetLinger(sec int) error {
+	sl, ok := c.Conn.(interface{ SetLinger(sec int) error })
+	if !ok {
+		return errors.New("connection can't be reset")
+	}
+	if err := sl.SetLinger(sec); err != nil {
+		return err
+	}
+	c.w.mu.Lock()
+	defer c.w.mu.Unlock()
+
+	c.linger0 = sec == 0
+	return nil
+}
+
+func (c *pcapConn) SetReadBuffer(bytes int) error {
+	if rb, ok := c.Conn.(interface{ SetReadBuffer(bytes int) error }); ok {
+		return rb.SetReadBuffer(bytes)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package observation
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// pcapPacket is a record read back from a pcap file, with the fields of its IPv4 and TCP headers the tests look at.
+type pcapPacket struct {
+	flags    byte
+	seq, ack uint32
+	payload  []byte
+}
+
+// readPcap checks the global header and the lengths in each record header of a pcap file written by pcapWriter,
+// and the lengths and checksums of the IPv4 packets in them, returning the TCP segments.
+func readPcap(t *testing.T, b []byte) []pcapPacket {
+	t.Helper()
+	if len(b) < 24 {
+		t.Fatalf("pcap of %d bytes has no global header", len(b))
+	}
+	le := binary.LittleEndian
+	if got := le.Uint32(b[0:]); got != 0xa1b2c3d4 {
+		t.Errorf("magic = %#x, want 0xa1b2c3d4", got)
+	}
+	if major, minor := le.Uint16(b[4:]), le.Uint16(b[6:]); major != 2 || minor != 4 {
+		t.Errorf("version = %d.%d, want 2.4", major, minor)
+	}
+	if got := le.Uint32(b[16:]); got != pcapSnapLen {
+		t.Errorf("snaplen = %d, want %d", got, pcapSnapLen)
+	}
+	if got := le.Uint32(b[20:]); got != pcapLinkTypeRaw {
+		t.Errorf("link type = %d, want %d", got, pcapLinkTypeRaw)
+	}
+
+	var pkts []pcapPacket
+	for b = b[24:]; len(b) > 0; {
+		if len(b) < 16 {
+			t.Fatalf("truncated record header: %d bytes", len(b))
+		}
+		incl, orig := le.Uint32(b[8:]), le.Uint32(b[12:])
+		if incl != orig {
+			t.Errorf("record %d: captured length %d != original length %d", len(pkts), incl, orig)
+		}
+		if int(incl) > len(b)-16 {
+			t.Fatalf("record %d: captured length %d exceeds the %d bytes left", len(pkts), incl, len(b)-16)
+		}
+		pkt := b[16 : 16+incl]
+		b = b[16+incl:]
+
+		if pkt[0] != 4<<4|5 {
+			t.Fatalf("record %d: not an IPv4 packet without options: %#x", len(pkts), pkt[0])
+		}
+		if got := binary.BigEndian.Uint16(pkt[2:]); int(got) != len(pkt) {
+			t.Errorf("record %d: IPv4 total length %d, want %d", len(pkts), got, len(pkt))
+		}
+		if inetChecksum(0, pkt[:20]) != 0 {
+			t.Errorf("record %d: invalid IPv4 header checksum", len(pkts))
+		}
+		tcp := pkt[20:]
+		pseudo := append(append(append([]byte{}, pkt[12:20]...), 0, pkt[9]), byte(len(tcp)>>8), byte(len(tcp)))
+		if inetChecksum(inetSum(0, pseudo), tcp) != 0 {
+			t.Errorf("record %d: invalid TCP checksum", len(pkts))
+		}
+		pkts = append(pkts, pcapPacket{
+			flags:   tcp[13],
+			seq:     binary.BigEndian.Uint32(tcp[4:]),
+			ack:     binary.BigEndian.Uint32(tcp[8:]),
+			payload: tcp[20:],
+		})
+	}
+	return pkts
+}
+
+func TestPcapWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newPcapWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, client := net.Pipe()
+	conn := w.newConn(server)
+
+	response := bytes.Repeat([]byte("x"), pcapMaxSegment+100)
+	done := make(chan error, 1)
+	go func() {
+		if _, err := client.Write([]byte("hello")); err != nil {
+			done <- err
+			return
+		}
+		_, err := io.ReadFull(client, make([]byte, len(response)))
+		done <- err
+	}()
+	p := make([]byte, 5)
+	if _, err := io.ReadFull(conn, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(response); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []pcapPacket{
+		{flags: tcpSYN, seq: 1000},
+		{flags: tcpSYN | tcpACK, seq: 5000, ack: 1001},
+		{flags: tcpACK, seq: 1001, ack: 5001},
+		{flags: tcpPSH | tcpACK, seq: 1001, ack: 5001, payload: []byte("hello")},
+		{flags: tcpPSH | tcpACK, seq: 5001, ack: 1006, payload: response[:pcapMaxSegment]},
+		{flags: tcpPSH | tcpACK, seq: 5001 + pcapMaxSegment, ack: 1006, payload: response[pcapMaxSegment:]},
+		{flags: tcpFIN | tcpACK, seq: 5001 + uint32(len(response)), ack: 1006},
+	}
+	got := readPcap(t, buf.Bytes())
+	if len(got) != len(want) {
+		t.Fatalf("%d packets recorded, want %d", len(got), len(want))
+	}
+	for i := range want {
+		g, e := got[i], want[i]
+		if g.flags != e.flags || g.seq != e.seq || g.ack != e.ack || !bytes.Equal(g.payload, e.payload) {
+			t.Errorf("packet %d: flags %#x seq %d ack %d payload %d bytes, want flags %#x seq %d ack %d payload %d bytes",
+				i, g.flags, g.seq, g.ack, len(g.payload), e.flags, e.seq, e.ack, len(e.payload))
+		}
+	}
+}
+
+type failingWriter struct{ n int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n == 0 {
+		return 0, errors.New("disk full")
+	}
+	w.n--
+	return len(p), nil
+}
+
+// TestPcapWriterReportsWriteError checks that Close reports an error writing packets, after the global header was written.
+func TestPcapWriterReportsWriteError(t *testing.T) {
+	w, err := newPcapWriter(&failingWriter{n: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, client := net.Pipe()
+	defer client.Close()
+	_ = w.newConn(server).Close()
+	if err := w.Close(); err == nil {
+		t.Error("Close didn't report the error writing packets")
+	}
+}
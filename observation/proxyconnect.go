@@ -0,0 +1,246 @@
+package observation
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+)
+
+// connectProxy is a forward proxy, recording the head of every request it receives. It tunnels CONNECT requests,
+// and forwards requests in absolute-form to their origin in origin-form, one request per connection.
+type connectProxy struct {
+	l     net.Listener
+	mu    sync.Mutex
+	heads [][]byte
+}
+
+// headBuffer records bytes written to it until done is set, so that the body isn't kept after the head was recorded.
+type headBuffer struct {
+	bytes.Buffer
+	done bool
+}
+
+func (b *headBuffer) Write(p []byte) (int, error) {
+	if b.done {
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+func startConnectProxy() (*connectProxy, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start listening: %w", err)
+	}
+	p := &connectProxy{l: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn)
+		}
+	}()
+	return p, nil
+}
+
+func (p *connectProxy) serve(conn net.Conn) {
+	defer conn.Close()
+
+	var raw headBuffer
+	br := bufio.NewReader(io.TeeReader(conn, &raw))
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return
+	}
+	raw.done = true
+	p.mu.Lock()
+	p.heads = append(p.heads, append([]byte(nil), raw.Bytes()...))
+	p.mu.Unlock()
+
+	if req.Method != http.MethodConnect {
+		p.forward(conn, req)
+		return
+	}
+	upstream, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		_ = writeResponse(conn, http.StatusBadGateway, nil, false)
+		return
+	}
+	defer upstream.Close()
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		return
+	}
+
+	// bytes already buffered by br belong to the tunnel
+	go func() {
+		_, _ = io.Copy(upstream, br)
+		_ = upstream.(*net.TCPConn).CloseWrite()
+	}()
+	_, _ = io.Copy(conn, upstream)
+}
+
+// forward sends req, received in absolute-form, to its origin in origin-form without the headers meant for the proxy,
+// and relays the response.
+func (p *connectProxy) forward(conn net.Conn, req *http.Request) {
+	if req.URL.Host == "" {
+		_ = writeResponse(conn, http.StatusBadRequest, nil, false)
+		return
+	}
+	host := req.URL.Host
+	if req.URL.Port() == "" {
+		host = net.JoinHostPort(req.URL.Hostname(), "80")
+	}
+	upstream, err := net.Dial("tcp", host)
+	if err != nil {
+		_ = writeResponse(conn, http.StatusBadGateway, nil, false)
+		return
+	}
+	defer upstream.Close()
+
+	req.Header.Del("Proxy-Authorization")
+	req.Header.Del("Proxy-Connection")
+	req.Close = true
+	if err := req.Write(upstream); err != nil {
+		_ = writeResponse(conn, http.StatusBadGateway, nil, false)
+		return
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(upstream), req)
+	if err != nil {
+		_ = writeResponse(conn, http.StatusBadGateway, nil, false)
+		return
+	}
+	defer resp.Body.Close()
+	resp.Close = true
+	_ = resp.Write(conn)
+}
+
+// takeHeads returns heads of requests received so far, and forgets them.
+func (p *connectProxy) takeHeads() [][]byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	hs := p.heads
+	p.heads = nil
+	return hs
+}
+
+func (p *connectProxy) close() {
+	_ = p.l.Close()
+}
+
+// proxyHopCase is a way of passing credentials to a forward proxy, for the ProxyConnectHeader experiment.
+type proxyHopCase struct {
+	desc      string
+	userinfo  *url.Userinfo
+	connectHd http.Header // Transport.ProxyConnectHeader
+	reqHd     http.Header // added to Request.Header
+}
+
+// observeProxyConnectHeader uploads the file to an HTTPS origin through a CONNECT proxy, passing proxy credentials
+// via Transport.ProxyConnectHeader, Request.Header or the proxy URL, and reports which headers reached the proxy in CONNECT
+// and which reached the origin. connectHeader replaces the default ProxyConnectHeader if non-empty.
+func observeProxyConnectHeader(connectHeader http.Header, opts runOptions) (*timing, error) {
+	data, err := os.ReadFile(opts.filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	var (
+		mu        sync.Mutex
+		originHds []http.Header
+	)
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		originHds = append(originHds, r.Header.Clone())
+		mu.Unlock()
+	}))
+	defer origin.Close()
+
+	proxy, err := startConnectProxy()
+	if err != nil {
+		return nil, err
+	}
+	defer proxy.close()
+
+	if len(connectHeader) == 0 {
+		connectHeader = http.Header{"Proxy-Authorization": {"Basic cHJveHk6c2VjcmV0"}}
+	}
+	cases := []proxyHopCase{
+		{
+			desc:      "Transport.ProxyConnectHeader",
+			connectHd: connectHeader,
+			reqHd:     http.Header{"Authorization": {"Bearer origin-token"}},
+		},
+		{
+			desc:  "Proxy-Authorization in Request.Header (wrong hop)",
+			reqHd: http.Header{"Proxy-Authorization": {"Basic cHJveHk6c2VjcmV0"}, "Authorization": {"Bearer origin-token"}},
+		},
+		{
+			desc:     "userinfo in the proxy URL",
+			userinfo: url.UserPassword("proxy", "secret"),
+			reqHd:    http.Header{"Authorization": {"Bearer origin-token"}},
+		},
+	}
+
+	var first *timing
+	for i, c := range cases {
+		proxyURL := &url.URL{Scheme: "http", Host: proxy.l.Addr().String(), User: c.userinfo}
+		tr := origin.Client().Transport.(*http.Transport).Clone()
+		tr.Proxy = http.ProxyURL(proxyURL)
+		tr.ProxyConnectHeader = c.connectHd
+
+		req, err := http.NewRequestWithContext(opts.ctx, http.MethodPut, origin.URL, bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+		for k, vs := range c.reqHd {
+			req.Header[k] = vs
+		}
+		req, tm := traceTiming(req)
+		err = opts.sendReq(tr, req)
+		tm.finish()
+		tr.CloseIdleConnections()
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			first = tm
+		}
+		if opts.quiet {
+			continue
+		}
+
+		fmt.Fprintf(opts.out, "[credentials for the proxy in %s]\n", c.desc)
+		for _, raw := range proxy.takeHeads() {
+			line, fields, _ := parseRawHead(raw)
+			fmt.Fprintf(opts.out, "  to the proxy: %s\n", line)
+			for _, f := range fields {
+				fmt.Fprintf(opts.out, "    %s: %s\n", f.Name, opts.redactValue(f.Name, f.Value))
+			}
+		}
+		mu.Lock()
+		for _, h := range originHds {
+			fmt.Fprintln(opts.out, "  to the origin (through the tunnel):")
+			for _, k := range sortedKeys(h) {
+				for _, v := range h[k] {
+					fmt.Fprintf(opts.out, "    %s: %s\n", k, opts.redactValue(k, v))
+				}
+			}
+			if h.Get("Proxy-Authorization") != "" {
+				fmt.Fprintln(opts.out, "  => "+opts.finding(sevError, "Proxy-Authorization leaked to the origin"))
+			}
+		}
+		originHds = nil
+		mu.Unlock()
+	}
+	return first, nil
+}
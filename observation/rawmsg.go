@@ -0,0 +1,186 @@
+package observation
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// printRaw prints raw bytes of a captured request to out in labeled sections: the request line, each header,
+// the framing headers and the body prefix, with redacted header values masked.
+// The body is printed as it is, or in hexdump -C style (offset, hex bytes and ASCII gutter) if s.hexDump is set.
+func (s *session) printRaw(raw []byte) {
+	raw = s.redact(raw)
+	line, fields, complete := parseRawHead(raw)
+	fmt.Fprintf(s.out, "request line: %s\n", line)
+	if complete {
+		fmt.Fprintf(s.out, "headers (%d):\n", len(fields))
+	} else {
+		fmt.Fprintf(s.out, "headers (%d, the header section is cut off in the capture):\n", len(fields))
+	}
+	var framing []string
+	for _, f := range fields {
+		fmt.Fprintf(s.out, "  %s: %s\n", f.Name, f.Value)
+		if name := textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(f.Name)); name == "Content-Length" || name == "Transfer-Encoding" {
+			framing = append(framing, name+": "+f.Value)
+		}
+	}
+	if len(framing) == 0 {
+		framing = append(framing, "none (neither Content-Length nor Transfer-Encoding)")
+	}
+	fmt.Fprintf(s.out, "framing: %s\n", strings.Join(framing, ", "))
+	if !complete {
+		return
+	}
+	body := wireBody(raw)
+	fmt.Fprintf(s.out, "body (%d bytes captured):\n", len(body))
+	if !s.hexDump {
+		_, _ = s.out.Write(body)
+		return
+	}
+	d := hex.Dumper(s.out)
+	_, _ = d.Write(body)
+	_ = d.Close()
+}
+
+// parseRawHead splits the header section of a raw HTTP message into the start line and header fields in wire order.
+// complete is false if the end of the header section wasn't in raw.
+func parseRawHead(raw []byte) (startLine string, fields []HeaderField, complete bool) {
+	head := raw
+	if i := bytes.Index(raw, []byte("\r\n\r\n")); i >= 0 {
+		head = raw[:i]
+		complete = true
+	}
+	lines := strings.Split(string(head), "\r\n")
+	if !complete {
+		// the last line may be cut in the middle
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return "", nil, complete
+	}
+	for _, line := range lines[1:] {
+		name, value, _ := strings.Cut(line, ":")
+		fields = append(fields, HeaderField{Name: name, Value: strings.TrimSpace(value)})
+	}
+	return lines[0], fields, complete
+}
+
+// wireChunk is a chunk of a chunked body as it appeared on the wire.
+type wireChunk struct {
+	size      int64
+	ext       string // chunk extensions, including the leading ';'
+	headerLen int    // bytes of the chunk size line, including CRLF
+}
+
+// parseChunks walks the chunked framing of body as on the wire, returning the chunks up to the last (zero-size) one
+// and the trailer section following it, including the final CRLF. complete is false if body ends before the trailer section does.
+func parseChunks(body []byte) (chunks []wireChunk, trailer []byte, complete bool) {
+	for {
+		i := bytes.Index(body, []byte("\r\n"))
+		if i < 0 {
+			return chunks, nil, false
+		}
+		sizeStr, ext, _ := strings.Cut(string(body[:i]), ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeStr), 16, 64)
+		if err != nil || size < 0 {
+			return chunks, nil, false
+		}
+		if ext != "" {
+			ext = ";" + ext
+		}
+		chunks = append(chunks, wireChunk{size: size, ext: ext, headerLen: i + 2})
+		body = body[i+2:]
+		if size == 0 {
+			if bytes.HasPrefix(body, []byte("\r\n")) {
+				return chunks, body[:2], true
+			}
+			if j := bytes.Index(body, []byte("\r\n\r\n")); j >= 0 {
+				return chunks, body[:j+4], true
+			}
+			return chunks, body, false
+		}
+		if int64(len(body)) < size+2 {
+			return chunks, nil, false
+		}
+		body = body[size+2:]
+	}
+}
+
+// printChunkAnalysis prints the cost of chunked framing of a complete chunked body: the number and sizes of chunks,
+// and bytes spent on framing, compared with sending the same data with Content-Length.
+func (s *session) printChunkAnalysis(chunks []wireChunk, trailer []byte) {
+	var (
+		data, headers int64
+		sizes         []int
+	)
+	for _, c := range chunks {
+		headers += int64(c.headerLen)
+		if c.size > 0 {
+			data += c.size
+			sizes = append(sizes, int(c.size))
+		}
+	}
+	crlfs := int64(2 * len(sizes))
+	overhead := headers + crlfs + int64(len(trailer))
+	fmt.Fprintf(s.out, "chunk analysis: %d data chunks and the last chunk, %d data bytes\n", len(sizes), data)
+	fmt.Fprintf(s.out, "  framing overhead: %d bytes (%.2f%% of the body on the wire): chunk size lines %d, CRLFs after chunk data %d, trailer section %d\n",
+		overhead, 100*float64(overhead)/float64(data+overhead), headers, crlfs, len(trailer))
+	// "Transfer-Encoding: chunked\r\n" replaces "Content-Length: <data>\r\n" in the header section
+	teHeader, clHeader := len("Transfer-Encoding: chunked\r\n"), len("Content-Length: \r\n")+len(strconv.FormatInt(data, 10))
+	fmt.Fprintf(s.out, "  vs Content-Length: %d bytes more on the wire (framing overhead, plus %d bytes of Transfer-Encoding: chunked instead of %d bytes of Content-Length: %d)\n",
+		overhead+int64(teHeader-clHeader), teHeader, clHeader, data)
+	if len(sizes) > 0 {
+		fmt.Fprintln(s.out, "  chunk size histogram:")
+		printSizeHistogram(s.out, sizes, "    ")
+	}
+}
+
+// printRequestEnd prints how the body of a request captured whole ended on the wire: the chunks with the terminating
+// last chunk and trailer section of a chunked body, or the body length against Content-Length.
+func (s *session) printRequestEnd(raw []byte, req *http.Request) {
+	body := wireBody(raw)
+	switch {
+	case len(req.TransferEncoding) > 0:
+		chunks, trailer, complete := parseChunks(body)
+		sizes := make([]string, 0, len(chunks))
+		for _, c := range chunks {
+			sizes = append(sizes, strconv.FormatInt(c.size, 10)+c.ext)
+		}
+		fmt.Fprintf(s.out, "end of request: %d chunks on the wire (sizes: %s)\n", len(chunks), strings.Join(sizes, ", "))
+		if !complete || len(chunks) == 0 || chunks[len(chunks)-1].size != 0 {
+			fmt.Fprintln(s.out, "=> "+s.finding(sevError, "the chunked body isn't terminated by the last chunk and the trailer section"))
+			return
+		}
+		tail := body
+		if len(tail) > 16 {
+			tail = tail[len(tail)-16:]
+		}
+		fmt.Fprintf(s.out, "  terminated by the last chunk and the trailer section %q, ending with %q\n", trailer, tail)
+		s.printChunkAnalysis(chunks, trailer)
+		// http.ReadRequest moves the Trailer header into Request.Trailer, so take the declaration from the wire
+		var declared []string
+		_, fields, _ := parseRawHead(raw)
+		for _, f := range fields {
+			if strings.EqualFold(strings.TrimSpace(f.Name), "Trailer") {
+				declared = append(declared, f.Value)
+			}
+		}
+		if len(declared) > 0 || len(req.Trailer) > 0 {
+			fmt.Fprintf(s.out, "  trailer fields declared in Trailer: [%s], received:\n", strings.Join(declared, ", "))
+			for _, k := range sortedKeys(req.Trailer) {
+				for _, v := range req.Trailer[k] {
+					fmt.Fprintf(s.out, "    %s: %s\n", k, s.redactValue(k, v))
+				}
+			}
+		}
+	case req.ContentLength > 0:
+		fmt.Fprintf(s.out, "end of request: %d body bytes on the wire, Content-Length: %d\n", len(body), req.ContentLength)
+	default:
+		fmt.Fprintf(s.out, "end of request: no body framing, %d bytes after the header section\n", len(body))
+	}
+}
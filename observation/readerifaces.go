@@ -0,0 +1,157 @@
+package observation
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// path redirectBehavior redirects requests to
+const redirectedPath = "/redirected"
+
+func init() {
+	RegisterServerBehavior("redirect", "reply 307 Temporary Redirect to "+redirectedPath+" after reading the whole request, and 200 to requests there", func() ServerBehavior {
+		return redirectBehavior{}
+	})
+}
+
+// redirectBehavior replies 307 to requests to other paths than redirectedPath, asking the client to send the same request there.
+type redirectBehavior struct {
+	BaseBehavior
+}
+
+func (redirectBehavior) Respond(w io.Writer, req *http.Request) (bool, error) {
+	if req.URL.Path != redirectedPath {
+		return false, writeResponse(w, http.StatusTemporaryRedirect, http.Header{"Location": {redirectedPath}}, false)
+	}
+	return false, writeResponse(w, http.StatusOK, nil, false)
+}
+
+// lenReader reads the file, telling the length of the rest by Len() as *bytes.Reader does, without being one.
+type lenReader struct {
+	r io.Reader
+	n int
+}
+
+func (r *lenReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n -= n
+	return n, err
+}
+
+func (r *lenReader) Len() int { return r.n }
+
+// seekReader reads the file through io.ReadSeeker, hiding *os.File.
+type seekReader struct {
+	io.ReadSeeker
+}
+
+// readerIfaceCase is a way of passing the file to http.NewRequest as a reader implementing Len() or io.Seeker.
+// fix is nil for the reader as is, or sets what the caller can to make up for the Transport ignoring the interface.
+type readerIfaceCase struct {
+	desc string
+	body func(f *os.File, size int64) io.Reader
+	fix  func(req *http.Request, body io.Reader)
+}
+
+var readerIfaceCases = map[reqPattern][]readerIfaceCase{
+	reqSinglePartLenReader: {
+		{desc: "reader with Len() as is", body: newLenReader},
+		{
+			desc: "reader with Len(), setting ContentLength from it",
+			body: newLenReader,
+			fix: func(req *http.Request, body io.Reader) {
+				req.ContentLength = int64(body.(*lenReader).Len())
+			},
+		},
+	},
+	reqSinglePartSeekReader: {
+		{desc: "io.ReadSeeker as is", body: newSeekReader},
+		{
+			desc: "io.ReadSeeker, setting GetBody seeking to the start",
+			body: newSeekReader,
+			fix: func(req *http.Request, body io.Reader) {
+				s := body.(io.Seeker)
+				req.GetBody = func() (io.ReadCloser, error) {
+					if _, err := s.Seek(0, io.SeekStart); err != nil {
+						return nil, err
+					}
+					return io.NopCloser(body), nil
+				}
+			},
+		},
+	},
+}
+
+func newLenReader(f *os.File, size int64) io.Reader { return &lenReader{r: f, n: int(size)} }
+
+func newSeekReader(f *os.File, _ int64) io.Reader { return seekReader{f} }
+
+// observeReaderIfaces uploads the file as a reader implementing Len() (len-reader) or io.Seeker (seek-reader) to a server redirecting with 307,
+// and reports which of them http.NewRequest and the client look at: ContentLength and GetBody set, the framing on the wire,
+// and whether the redirect was followed with the body replayed.
+func observeReaderIfaces(p reqPattern, opts runOptions) (*timing, error) {
+	captures := make(chan capturedRequest, 2)
+	url, stop, err := opts.startEphemeralServer(func() ServerBehavior { return redirectBehavior{} }, captures, true)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	var first *timing
+	for _, c := range readerIfaceCases[p] {
+		f, err := os.Open(opts.filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %w", err)
+		}
+		stat, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to stat file: %w", err)
+		}
+		body := c.body(f, stat.Size())
+		req, err := http.NewRequestWithContext(opts.ctx, http.MethodPut, url, body)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+		built := fmt.Sprintf("ContentLength %d, GetBody set: %v", req.ContentLength, req.GetBody != nil)
+		if c.fix != nil {
+			c.fix(req, body)
+		}
+
+		req, tm := traceTiming(req)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("HTTP request failed: %w", err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		tm.finish()
+		f.Close()
+		if first == nil {
+			first = tm
+		}
+
+		// the server sends captures before responding, so they're all there once the response is read
+		var got []capturedRequest
+		for len(captures) > 0 {
+			got = append(got, <-captures)
+		}
+		if opts.quiet {
+			continue
+		}
+		fmt.Fprintf(opts.out, "[%s]\n", c.desc)
+		fmt.Fprintf(opts.out, "  http.NewRequest: %s\n", built)
+		for i, g := range got {
+			fmt.Fprintf(opts.out, "  request %d: %s %s, framing: %s, body received: %d of %d bytes\n", i+1, g.req.Method, g.req.URL.Path, framingHeaders(g.req), int64(len(g.body))+g.bodyDropped, stat.Size())
+		}
+		fmt.Fprintf(opts.out, "  client: got %s\n", resp.Status)
+		if resp.StatusCode == http.StatusTemporaryRedirect {
+			fmt.Fprintln(opts.out, "  => "+opts.finding(sevInfo, "the client returned the 307 response without following it, as the body can't be replayed without GetBody"))
+		}
+	}
+	return first, nil
+}
@@ -0,0 +1,148 @@
+package observation
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+)
+
+// version of the observation record format, matching "schema_version" in observationSchema.
+// Bump it (and add a new schema file) on incompatible changes to the format.
+const observationSchemaVersion = 1
+
+// JSON Schema of observation records
+//
+//go:embed schema/observation.v1.json
+var observationSchema []byte
+
+// ObservationSchema returns the JSON Schema of records written by WithObservations (-print-schema).
+func ObservationSchema() []byte {
+	return append([]byte(nil), observationSchema...)
+}
+
+// observationRecord is a machine-readable record of a captured request. See observationSchema for the meaning of fields.
+type observationRecord struct {
+	SchemaVersion int              `json:"schema_version"`
+	Pattern       string           `json:"pattern"`
+	RequestLine   string           `json:"request_line"`
+	Headers       []observedHeader `json:"headers"`
+	HeaderBytes   int              `json:"header_bytes"`
+	BodyBytes     int              `json:"body_bytes"`
+	Complete      bool             `json:"complete"`
+
+	Name             string           `json:"name,omitempty"`
+	TransferEncoding []string         `json:"transfer_encoding,omitempty"`
+	ContentLength    *int64           `json:"content_length,omitempty"`
+	Chunks           []int            `json:"chunks,omitempty"`
+	Trailers         []observedHeader `json:"trailers,omitempty"`
+	DurationNs       int64            `json:"duration_ns,omitempty"`
+	Error            string           `json:"error,omitempty"`
+	Captured         *bool            `json:"captured,omitempty"` // false in stub records built by uncapturedObservation
+	GoVersion        string           `json:"go_version"`
+}
+
+type observedHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// newObservation builds a record of the request captured by the server, sent by the pattern.
+func (s *session) newObservation(pattern string, c capturedRequest) observationRecord {
+	obs := parseHead(c.raw)
+	headerBytes := len(c.raw)
+	if i := bytes.Index(c.raw, []byte("\r\n\r\n")); i >= 0 {
+		headerBytes = i + 4
+	}
+	hdrs := make([]observedHeader, 0, len(obs.Header))
+	for _, f := range obs.Header {
+		hdrs = append(hdrs, observedHeader{Name: f.Name, Value: s.redactValue(f.Name, f.Value)})
+	}
+	var cl *int64
+	if n, ok := obs.ContentLength(); ok {
+		cl = &n
+	}
+	var (
+		sizes    []int
+		trailers []observedHeader
+	)
+	if len(obs.TransferEncoding) > 0 {
+		if chunks, trailer, complete := parseChunks(c.raw[headerBytes:]); complete {
+			for _, c := range chunks {
+				sizes = append(sizes, int(c.size))
+			}
+			for _, line := range strings.Split(string(trailer), "\r\n") {
+				if name, value, ok := strings.Cut(line, ":"); ok {
+					trailers = append(trailers, observedHeader{Name: name, Value: s.redactValue(name, strings.TrimSpace(value))})
+				}
+			}
+		}
+	}
+	return observationRecord{
+		SchemaVersion: observationSchemaVersion,
+		Pattern:       pattern,
+		RequestLine:   obs.RequestLine,
+		Headers:       hdrs,
+		HeaderBytes:   headerBytes,
+		BodyBytes:     len(c.raw) - headerBytes + int(c.rawDropped),
+		// the default server parses requests only with -capture-bytes all, and otherwise stops reading after a prefix
+		Complete:         c.req != nil,
+		TransferEncoding: obs.TransferEncoding,
+		ContentLength:    cl,
+		Chunks:           sizes,
+		Trailers:         trailers,
+		GoVersion:        runtime.Version(),
+	}
+}
+
+// uncapturedObservation builds a stub record of a run of the pattern whose request wasn't captured by the capture server,
+// e.g. as the pattern runs its own servers. Required properties of the request are left empty.
+func uncapturedObservation(pattern, name string) observationRecord {
+	captured := false
+	return observationRecord{
+		SchemaVersion: observationSchemaVersion,
+		Pattern:       pattern,
+		Name:          name,
+		Headers:       []observedHeader{},
+		Captured:      &captured,
+		GoVersion:     runtime.Version(),
+	}
+}
+
+// isStub reports whether o is a stub record of a run not captured, which has no request to look at.
+func (o observationRecord) isStub() bool {
+	return o.Captured != nil && !*o.Captured
+}
+
+// observationWriter writes observation records to w as JSON Lines, validating each one against observationSchema.
+type observationWriter struct {
+	w      io.Writer
+	schema map[string]any
+}
+
+func newObservationWriter(w io.Writer) (*observationWriter, error) {
+	var schema map[string]any
+	if err := json.Unmarshal(observationSchema, &schema); err != nil {
+		return nil, fmt.Errorf("invalid embedded schema: %w", err)
+	}
+	return &observationWriter{w: w, schema: schema}, nil
+}
+
+func (w *observationWriter) write(o observationRecord) error {
+	b, err := json.Marshal(o)
+	if err != nil {
+		return fmt.Errorf("failed to encode observation: %w", err)
+	}
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return fmt.Errorf("failed to decode observation: %w", err)
+	}
+	if err := validateSchema(w.schema, v, "$"); err != nil {
+		return fmt.Errorf("observation doesn't conform to the schema: %w", err)
+	}
+	_, err = w.w.Write(append(b, '\n'))
+	return err
+}
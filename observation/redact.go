@@ -0,0 +1,135 @@
+package observation
+
+import (
+	"bytes"
+	"net/textproto"
+	"strings"
+)
+
+// headerRedaction is the set of canonical names of headers whose values are masked in all outputs of a session.
+// The zero value masks nothing.
+type headerRedaction map[string]bool
+
+// newHeaderRedaction returns a redaction of the headers named, ignoring empty names.
+func newHeaderRedaction(names ...string) headerRedaction {
+	r := make(headerRedaction)
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			r[textproto.CanonicalMIMEHeaderKey(name)] = true
+		}
+	}
+	return r
+}
+
+// redact returns a copy of the raw HTTP message whose values of redacted headers are masked with '*'.
+// Lengths are preserved, so byte offsets and sizes in reports stay the same as on the wire.
+func (r headerRedaction) redact(raw []byte) []byte {
+	if len(r) == 0 {
+		return raw
+	}
+	out := append([]byte(nil), raw...)
+
+	head := out
+	if i := bytes.Index(out, []byte("\r\n\r\n")); i >= 0 {
+		head = out[:i+2]
+	}
+	for len(head) > 0 {
+		i := bytes.Index(head, []byte("\r\n"))
+		if i < 0 {
+			// a header line truncated in the capture is masked as well
+			i = len(head)
+		}
+		line := head[:i]
+		if c := bytes.IndexByte(line, ':'); c >= 0 && r[textproto.CanonicalMIMEHeaderKey(string(line[:c]))] {
+			mask(bytes.TrimLeft(line[c+1:], " \t"))
+		}
+		if i+2 > len(head) {
+			break
+		}
+		head = head[i+2:]
+	}
+	return out
+}
+
+// redactValue masks a header value if the header is to be redacted.
+func (r headerRedaction) redactValue(name, value string) string {
+	if !r[textproto.CanonicalMIMEHeaderKey(name)] {
+		return value
+	}
+	return strings.Repeat("*", len(value))
+}
+
+// redactValues masks each of the values of a header if the header is to be redacted.
+func (r headerRedaction) redactValues(name string, values []string) []string {
+	if !r[textproto.CanonicalMIMEHeaderKey(name)] {
+		return values
+	}
+	masked := make([]string, len(values))
+	for i, v := range values {
+		masked[i] = r.redactValue(name, v)
+	}
+	return masked
+}
+
+func mask(b []byte) {
+	for i := range b {
+		b[i] = '*'
+	}
+}
+
+// headRedactor masks values of redacted headers in the header section of a message streamed through it in pieces,
+// as redact does to a whole message. Bytes after the header section pass as they are.
+type headRedactor struct {
+	names    headerRedaction
+	done     bool   // the header section has ended
+	lineLen  int    // bytes of the current line so far, excluding CR
+	name     []byte // the current line up to ':', bounded
+	colon    bool   // ':' was seen in the current line
+	masking  bool   // bytes of the current line are a value to be masked
+	valueLen int    // bytes of the value of the current line so far, after leading whitespace
+}
+
+// maxRedactedName bounds the bytes of a header name kept by headRedactor. No redacted header has a longer name.
+const maxRedactedName = 256
+
+// next returns p with the values of redacted headers masked, a copy if any byte is.
+func (r *headRedactor) next(p []byte) []byte {
+	if r.done || len(r.names) == 0 {
+		return p
+	}
+	masked, copied := p, false
+	for i, c := range p {
+		if r.done {
+			break
+		}
+		switch {
+		case c == '\r':
+			continue
+		case c == '\n':
+			if r.lineLen == 0 {
+				r.done = true
+			}
+			*r = headRedactor{names: r.names, done: r.done}
+			continue
+		}
+		r.lineLen++
+		switch {
+		case r.masking:
+			if r.valueLen == 0 && (c == ' ' || c == '\t') {
+				continue
+			}
+			r.valueLen++
+			if !copied {
+				masked, copied = append([]byte(nil), p...), true
+			}
+			masked[i] = '*'
+		case r.colon:
+		case c == ':':
+			r.colon = true
+			r.masking = r.names[textproto.CanonicalMIMEHeaderKey(string(r.name))]
+		case len(r.name) < maxRedactedName:
+			r.name = append(r.name, c)
+		}
+	}
+	return masked
+}
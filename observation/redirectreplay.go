@@ -0,0 +1,157 @@
+package observation
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// redirectToBehavior replies status with Location to every request, after reading the whole request.
+type redirectToBehavior struct {
+	BaseBehavior
+	status   int
+	location string
+}
+
+func (b redirectToBehavior) Respond(w io.Writer, _ *http.Request) (bool, error) {
+	return false, writeResponse(w, b.status, http.Header{"Location": {b.location}}, false)
+}
+
+// redirectReplayBody is a body sent through the redirect, with GetBody or without.
+// getBody is nil to leave GetBody as http.NewRequest set it, or returns one set by the caller for the file.
+type redirectReplayBody struct {
+	desc    string
+	new     func(f *os.File, data []byte) io.Reader
+	getBody func(filename string) func() (io.ReadCloser, error)
+}
+
+func newFileBody(f *os.File, _ []byte) io.Reader { return f }
+
+var redirectReplayBodies = map[reqPattern][]redirectReplayBody{
+	reqRedirectReplay: {
+		{desc: "*bytes.Reader (GetBody set)", new: func(_ *os.File, data []byte) io.Reader { return bytes.NewReader(data) }},
+		{desc: "*os.File (no GetBody)", new: newFileBody},
+	},
+	reqGetBodyRedirect: {
+		{desc: "*os.File (no GetBody)", new: newFileBody},
+		{
+			desc: "*os.File, with GetBody reopening the file",
+			new:  newFileBody,
+			getBody: func(filename string) func() (io.ReadCloser, error) {
+				return func() (io.ReadCloser, error) { return os.Open(filename) }
+			},
+		},
+	},
+}
+
+// observeRedirectReplay uploads the file to a server replying 307 and 308 to a second server, with bodies of the pattern with and without GetBody,
+// capturing both hops: whether the client followed the redirect, whether the body arrived intact at the second server,
+// and how headers changed across the redirect. The second server is addressed as localhost while the first as 127.0.0.1,
+// so that the redirect crosses hosts as it would between services, and headers set on the request include credentials.
+func observeRedirectReplay(p reqPattern, opts runOptions) (*timing, error) {
+	targets := make(chan capturedRequest, 1)
+	targetURL, stopTarget, err := opts.startEphemeralServer(func() ServerBehavior { return BaseBehavior{} }, targets, true)
+	if err != nil {
+		return nil, err
+	}
+	defer stopTarget()
+	targetURL = strings.Replace(targetURL, "127.0.0.1", "localhost", 1) + redirectedPath
+
+	data, err := os.ReadFile(opts.filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	var first *timing
+	for _, status := range []int{http.StatusTemporaryRedirect, http.StatusPermanentRedirect} {
+		origins := make(chan capturedRequest, 1)
+		originURL, stopOrigin, err := opts.startEphemeralServer(func() ServerBehavior {
+			return redirectToBehavior{status: status, location: targetURL}
+		}, origins, true)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, b := range redirectReplayBodies[p] {
+			tm, err := sendRedirectReplay(originURL, status, b, data, origins, targets, opts)
+			if err != nil {
+				stopOrigin()
+				return nil, err
+			}
+			if first == nil {
+				first = tm
+			}
+		}
+		stopOrigin()
+	}
+	return first, nil
+}
+
+// sendRedirectReplay sends one request of observeRedirectReplay, and prints both hops unless quiet.
+func sendRedirectReplay(url string, status int, b redirectReplayBody, data []byte, origins, targets chan capturedRequest, opts runOptions) (*timing, error) {
+	f, err := os.Open(opts.filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+	req, err := http.NewRequestWithContext(opts.ctx, http.MethodPut, url, b.new(f, data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	if b.getBody != nil {
+		req.GetBody = b.getBody(opts.filename)
+	}
+	req.SetBasicAuth("user", "pass")
+	req.Header.Set("Cookie", "session=observation")
+	req.Header.Set("X-Custom", "kept?")
+
+	req, tm := traceTiming(req)
+	cli := &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()}
+	defer cli.CloseIdleConnections()
+	resp, err := cli.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	tm.finish()
+
+	// servers capture requests before responding, so both hops are there once the response is read
+	origin := <-origins
+	var (
+		target   capturedRequest
+		followed bool
+	)
+	select {
+	case target = <-targets:
+		followed = true
+	default:
+	}
+	if opts.quiet {
+		return tm, nil
+	}
+
+	fmt.Fprintf(opts.out, "[%d %s, body: %s]\n", status, http.StatusText(status), b.desc)
+	fmt.Fprintf(opts.out, "  hop 1: %s %s, framing: %s, body received: %d of %d bytes\n", origin.req.Method, origin.req.Host, framingHeaders(origin.req), capturedBodyLen(origin), len(data))
+	if !followed {
+		fmt.Fprintf(opts.out, "  client: got %s without following it\n", resp.Status)
+		fmt.Fprintln(opts.out, "  => "+opts.finding(sevInfo, "the client can't replay the body without Request.GetBody, so it returned the redirect response instead of following it"))
+		return tm, nil
+	}
+	fmt.Fprintf(opts.out, "  hop 2: %s %s, framing: %s, body received: %d of %d bytes\n", target.req.Method, target.req.Host, framingHeaders(target.req), capturedBodyLen(target), len(data))
+	fmt.Fprintf(opts.out, "  client: got %s\n", resp.Status)
+	fmt.Fprintln(opts.out, "  hop 1 -> hop 2:")
+	opts.printRequestDiff(origin.raw, target.raw)
+	if capturedBodyLen(target) != int64(len(data)) || !bytes.HasPrefix(data, target.body) {
+		fmt.Fprintln(opts.out, "  => "+opts.finding(sevError, "the body re-sent to the redirect target differs from the file"))
+	}
+	return tm, nil
+}
+
+// capturedBodyLen returns the length of the body of c, including bytes not kept in memory.
+func capturedBodyLen(c capturedRequest) int64 {
+	return int64(len(c.body)) + c.bodyDropped
+}
@@ -0,0 +1,51 @@
+package observation
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// addRegistered adds the patterns registered with RegisterPattern as custom patterns.
+func (ps *patternSet) addRegistered() error {
+	for _, rp := range Patterns() {
+		rp := rp
+		c := customPattern{
+			name: rp.Name,
+			desc: "registered with RegisterPattern",
+			build: func(f *os.File, opts runOptions) (*http.Request, error) {
+				req, err := rp.Build(f)
+				if err != nil {
+					return nil, err
+				}
+				if req == nil {
+					return nil, fmt.Errorf("pattern %s built no request", rp.Name)
+				}
+				return retargetToServer(req, opts.serverURL)
+			},
+		}
+		if err := ps.add(c); err != nil {
+			return fmt.Errorf("registered pattern: %w", err)
+		}
+	}
+	return nil
+}
+
+// retargetToServer points req at the capture server at serverURL, keeping its path and query.
+// The Host header follows the server unless it was set apart from the URL host.
+func retargetToServer(req *http.Request, serverURL string) (*http.Request, error) {
+	if req.URL == nil {
+		return nil, fmt.Errorf("request has no URL")
+	}
+	srv, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, err
+	}
+	if req.Host == req.URL.Host {
+		req.Host = ""
+	}
+	req.URL.Scheme = srv.Scheme
+	req.URL.Host = srv.Host
+	return req, nil
+}
@@ -0,0 +1,57 @@
+package observation
+
+import (
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Pattern is a request pattern contributed with RegisterPattern.
+type Pattern struct {
+	Name string
+	// Build builds the request of the pattern uploading body.
+	Build func(body io.Reader) (*http.Request, error)
+}
+
+var (
+	patternsMu sync.Mutex
+	patterns   []Pattern
+)
+
+// RegisterPattern contributes a request pattern to the CLI of this module, which runs it after the built-in patterns
+// and lists it in -list. It's meant to be called from init functions of packages imported by the CLI:
+//
+//	func init() {
+//		observation.RegisterPattern("put-tee-reader", func(body io.Reader) (*http.Request, error) {
+//			return http.NewRequest(http.MethodPut, "http://example.com/upload", io.TeeReader(body, io.Discard))
+//		})
+//	}
+//
+// body is the file given with -f, an *os.File. The CLI sends the request to its capture server
+// by replacing the scheme and the host of the URL; a Host set apart from the URL host is kept.
+// RegisterPattern panics if name is empty or already registered, or build is nil.
+func RegisterPattern(name string, build func(body io.Reader) (*http.Request, error)) {
+	patternsMu.Lock()
+	defer patternsMu.Unlock()
+
+	if name == "" {
+		panic("observation: RegisterPattern with an empty name")
+	}
+	if build == nil {
+		panic("observation: RegisterPattern with a nil build func for " + name)
+	}
+	for _, p := range patterns {
+		if p.Name == name {
+			panic("observation: RegisterPattern called twice for " + name)
+		}
+	}
+	patterns = append(patterns, Pattern{Name: name, Build: build})
+}
+
+// Patterns returns the patterns registered with RegisterPattern, in the order registered.
+func Patterns() []Pattern {
+	patternsMu.Lock()
+	defer patternsMu.Unlock()
+
+	return append([]Pattern(nil), patterns...)
+}
@@ -0,0 +1,320 @@
+package observation
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// writeRepro writes a bug report for findings of pattern p into a directory under dir, and returns the directory:
+// ISSUE.md laid out as the golang/go issue template with the wire evidence, and main.go reproducing the request
+// against a local capture server, if the request is built by request rather than an experiment of its own.
+func writeRepro(dir string, p reqPattern, findings []recordedFinding, opts runOptions) (string, error) {
+	path := filepath.Join(dir, fmt.Sprintf("%02d-%s", int(p), slugify(opts.patterns.describe(p))))
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create repro directory: %w", err)
+	}
+
+	issue := reproIssue{
+		Pattern:      opts.patterns.describe(p),
+		Construction: patternDocs[p].construction,
+		Framing:      patternDocs[p].framing,
+		Findings:     findings,
+		Version:      runtime.Version(),
+		GOOS:         runtime.GOOS,
+		GOARCH:       runtime.GOARCH,
+	}
+
+	program, err := reproProgram(p, opts)
+	if err == nil {
+		issue.Program = true
+		if err := os.WriteFile(filepath.Join(path, "main.go"), program, 0o644); err != nil {
+			return "", fmt.Errorf("failed to write repro program: %w", err)
+		}
+		if issue.Wire, err = reproWire(p, opts); err != nil {
+			return "", err
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := reproIssueTmpl.Execute(&buf, issue); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(path, "ISSUE.md"), buf.Bytes(), 0o644); err != nil {
+		return "", fmt.Errorf("failed to write issue template: %w", err)
+	}
+	return path, nil
+}
+
+// slugify turns s into a lowercase file name of up to 48 characters, with runs of other than letters and digits replaced by "-".
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	slug := strings.TrimSuffix(b.String(), "-")
+	if len(slug) > 48 {
+		slug = strings.TrimSuffix(slug[:48], "-")
+	}
+	return slug
+}
+
+// reproWire sends the request of p with ObserveRequest, and returns its head as on the wire
+// followed by a summary of the body, as evidence for the report.
+func reproWire(p reqPattern, opts runOptions) (string, error) {
+	f, err := os.Open(opts.filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+	req, err := buildPatternRequest(p, f, runOptions{session: opts.session, filename: opts.filename, boundary: opts.boundary})
+	if err != nil {
+		return "", err
+	}
+	obs, err := ObserveRequest(req)
+	if err != nil {
+		return "", err
+	}
+	raw := opts.redact(obs.Raw)
+	head, body, _ := bytes.Cut(raw, []byte("\r\n\r\n"))
+	prefix := body
+	if len(prefix) > 64 {
+		prefix = prefix[:64]
+	}
+	return fmt.Sprintf("%s\n\n(%d bytes after the header section, starting with %q)", strings.ReplaceAll(string(head), "\r\n", "\n"), len(body), prefix), nil
+}
+
+// reproProgram generates a self-contained program sending a request built like the one of p, with the same body type,
+// framing fields and headers, to a local server which prints it as received on the wire.
+// Body content is replaced by filler of the same size, as framing doesn't depend on it.
+func reproProgram(p reqPattern, opts runOptions) ([]byte, error) {
+	f, err := os.Open(opts.filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+	req, err := buildPatternRequest(p, f, opts)
+	if err != nil {
+		return nil, err
+	}
+	if pr, ok := req.Body.(*io.PipeReader); ok {
+		// stop the producer of the pipe, as the request isn't sent
+		_ = pr.Close()
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	prog := reproProg{
+		Pattern:       opts.patterns.describe(p),
+		Method:        req.Method,
+		Target:        req.URL.RequestURI(),
+		Size:          stat.Size(),
+		ContentLength: req.ContentLength,
+		Imports:       []string{"bufio", "bytes", "fmt", "io", "log", "net", "net/http"},
+	}
+	if req.ContentLength > 0 {
+		prog.Size = req.ContentLength
+	}
+	for _, te := range req.TransferEncoding {
+		prog.TransferEncoding = append(prog.TransferEncoding, fmt.Sprintf("%q", te))
+	}
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		prog.Headers = append(prog.Headers, fmt.Sprintf("req.Header[%q] = %#v", name, req.Header[name]))
+	}
+
+	switch b := reqBodyOf(req).(type) {
+	case nil:
+		prog.Body = "var body io.Reader"
+	case *os.File:
+		prog.Body = `tmp, err := os.CreateTemp("", "repro-body-*")
+if err != nil {
+	log.Fatal(err)
+}
+defer os.Remove(tmp.Name())
+if _, err := tmp.Write(data); err != nil {
+	log.Fatal(err)
+}
+if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+	log.Fatal(err)
+}
+body := tmp // *os.File`
+		prog.Imports = append(prog.Imports, "os")
+	case *bytes.Buffer:
+		prog.Body = "body := bytes.NewBuffer(data)"
+		prog.Inferred = true
+	case *bytes.Reader:
+		prog.Body = "body := bytes.NewReader(data)"
+		prog.Inferred = true
+	case *strings.Reader:
+		prog.Body = "body := strings.NewReader(string(data))"
+		prog.Imports = append(prog.Imports, "strings")
+		prog.Inferred = true
+	case *io.PipeReader:
+		prog.Body = `body, pw := io.Pipe()
+go func() {
+	_, err := pw.Write(data)
+	_ = pw.CloseWithError(err)
+}()`
+	default:
+		prog.Body = fmt.Sprintf("body := struct{ io.Reader }{bytes.NewReader(data)} // %T in the observation", b)
+	}
+	sort.Strings(prog.Imports)
+
+	var buf bytes.Buffer
+	if err := reproProgramTmpl.Execute(&buf, prog); err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated repro program is invalid: %w", err)
+	}
+	return src, nil
+}
+
+// reqBodyOf returns the body as passed to http.NewRequest, which wraps readers other than io.ReadCloser with io.NopCloser.
+func reqBodyOf(req *http.Request) io.Reader {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	// the type returned by io.NopCloser isn't exported, but is a struct embedding the reader
+	if v := reflect.ValueOf(req.Body); v.Kind() == reflect.Struct && v.Type().PkgPath() == "io" && v.NumField() == 1 {
+		if r, ok := v.Field(0).Interface().(io.Reader); ok {
+			return r
+		}
+	}
+	return req.Body
+}
+
+type reproProg struct {
+	Pattern          string
+	Method           string
+	Target           string
+	Size             int64
+	Body             string
+	ContentLength    int64
+	Inferred         bool // http.NewRequest infers ContentLength from the body
+	TransferEncoding []string
+	Headers          []string
+	Imports          []string
+}
+
+var reproProgramTmpl = template.Must(template.New("main.go").Parse(`// Command repro reproduces the request pattern "{{.Pattern}}" observed by go-httpcli-req-observation.
+// It sends the request to a local server, which prints it as received on the wire.
+// The body is {{.Size}} filler bytes; framing doesn't depend on the content.
+package observation
+
+import (
+{{range .Imports}}	"{{.}}"
+{{end}})
+
+func main() {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	wire := make(chan []byte, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer conn.Close()
+		var raw bytes.Buffer
+		req, err := http.ReadRequest(bufio.NewReader(io.TeeReader(conn, &raw)))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if _, err := io.Copy(io.Discard, req.Body); err != nil {
+			log.Fatal(err)
+		}
+		wire <- raw.Bytes()
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+	}()
+
+	data := bytes.Repeat([]byte("x"), {{.Size}})
+	{{.Body}}
+	req, err := http.NewRequest("{{.Method}}", "http://"+l.Addr().String()+"{{.Target}}", body)
+	if err != nil {
+		log.Fatal(err)
+	}
+{{- if and (not .Inferred) .ContentLength}}
+	req.ContentLength = {{.ContentLength}}
+{{- end}}
+{{- if .TransferEncoding}}
+	req.TransferEncoding = []string{ {{- range $i, $te := .TransferEncoding}}{{if $i}}, {{end}}{{$te}}{{end -}} }
+{{- end}}
+{{- range .Headers}}
+	{{.}}
+{{- end}}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	resp.Body.Close()
+
+	head, rest, _ := bytes.Cut(<-wire, []byte("\r\n\r\n"))
+	fmt.Printf("%s\n\n", head)
+	prefix := rest
+	if len(prefix) > 64 {
+		prefix = prefix[:64]
+	}
+	fmt.Printf("(%d bytes after the header section, starting with %q)\n", len(rest), prefix)
+}
+`))
+
+type reproIssue struct {
+	Pattern      string
+	Construction string
+	Framing      string
+	Findings     []recordedFinding
+	Program      bool
+	Wire         string
+	Version      string
+	GOOS         string
+	GOARCH       string
+}
+
+var reproIssueTmpl = template.Must(template.New("ISSUE.md").Parse("" +
+	"<!-- Bug report template generated by go-httpcli-req-observation. Check it, fill in the blanks and file it at https://go.dev/issue/new -->\n\n" +
+	"### Go version\n\n" +
+	"go version {{.Version}} {{.GOOS}}/{{.GOARCH}}\n\n" +
+	"### Output of `go env` in your module/workspace:\n\n" +
+	"```shell\n" +
+	"GOOS='{{.GOOS}}'\nGOARCH='{{.GOARCH}}'\n" +
+	"<!-- paste the rest of the output of go env -->\n" +
+	"```\n\n" +
+	"### What did you do?\n\n" +
+	"Sent a request built as below (request pattern \"{{.Pattern}}\"):\n\n" +
+	"```go\n{{.Construction}}\n```\n\n" +
+	"{{if .Program}}The program in main.go next to this file reproduces it against a local server: `go run main.go`." +
+	"{{else}}The request is part of an experiment of its own in go-httpcli-req-observation, so no standalone program was generated; run the pattern to reproduce it.{{end}}\n\n" +
+	"### What did you see happen?\n\n" +
+	"{{range .Findings}}- {{.}}\n{{end}}" +
+	"{{if .Wire}}\nThe request on the wire:\n\n```\n{{.Wire}}\n```\n{{end}}\n" +
+	"### What did you expect to see?\n\n" +
+	"{{if .Framing}}Framing: {{.Framing}}\n\n{{end}}" +
+	"<!-- describe what you expected instead of the findings above -->\n"))
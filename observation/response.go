@@ -0,0 +1,186 @@
+package observation
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+func init() {
+	RegisterServerBehavior("canned", "reply the canned response of -response (by default a gzip-encoded chunked one with a trailer) byte-for-byte after reading the whole request, printing the response as the client received it", func() ServerBehavior {
+		return &cannedBehavior{}
+	})
+}
+
+// defaultCannedResponse returns a response exercising what the Transport does on reading: a gzip-encoded body, which it decompresses
+// transparently, in chunked framing with a trailer.
+func defaultCannedResponse() []byte {
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	_, _ = io.WriteString(zw, "Hello from the canned response of the capture server!\n")
+	_ = zw.Close()
+
+	var b bytes.Buffer
+	b.WriteString("HTTP/1.1 200 OK\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Encoding: gzip\r\n")
+	b.WriteString("Transfer-Encoding: chunked\r\n")
+	b.WriteString("Trailer: X-Canned-Trailer\r\n")
+	b.WriteString("\r\n")
+	fmt.Fprintf(&b, "%x\r\n", body.Len())
+	b.Write(body.Bytes())
+	b.WriteString("\r\n0\r\nX-Canned-Trailer: done\r\n\r\n")
+	return b.Bytes()
+}
+
+// LoadCannedResponse reads a raw HTTP response for WithCannedResponse from the file. Lines of the header section ending with LF alone are turned into CRLF,
+// so that responses can be written with any editor; the body is sent as is.
+func LoadCannedResponse(path string) ([]byte, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read canned response: %w", err)
+	}
+	if !bytes.HasPrefix(raw, []byte("HTTP/")) {
+		return nil, fmt.Errorf("canned response in %s doesn't start with a status line", path)
+	}
+	if bytes.Contains(raw, []byte("\r\n\r\n")) {
+		return raw, nil
+	}
+	head, body, found := bytes.Cut(raw, []byte("\n\n"))
+	if !found {
+		return nil, fmt.Errorf("canned response in %s has no blank line ending the header section", path)
+	}
+	head = bytes.ReplaceAll(head, []byte("\n"), []byte("\r\n"))
+	return append(append(head, "\r\n\r\n"...), body...), nil
+}
+
+// cannedResponder is a ServerBehavior replying the canned response of the session serving it (-response).
+type cannedResponder interface {
+	ServerBehavior
+	setResponse(raw []byte)
+}
+
+// cannedBehavior replies the canned response as is, closing the connection afterwards whatever it says,
+// so that responses framed by the end of the connection can be canned too.
+type cannedBehavior struct {
+	BaseBehavior
+	response []byte
+}
+
+func (b *cannedBehavior) setResponse(raw []byte) { b.response = raw }
+
+func (b *cannedBehavior) Respond(w io.Writer, _ *http.Request) (bool, error) {
+	_, err := w.Write(b.response)
+	return false, err
+}
+
+// receivedRecordingConn records all bytes read from the connection.
+type receivedRecordingConn struct {
+	net.Conn
+	rec *recorder
+}
+
+func (c *receivedRecordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.rec.write(p[:n])
+	return n, err
+}
+
+// withReceivedRecording returns a clone of tr whose connections record bytes read from them to rec.
+func withReceivedRecording(tr http.RoundTripper, rec *recorder) http.RoundTripper {
+	t := tr.(*http.Transport).Clone()
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &receivedRecordingConn{Conn: conn, rec: rec}, nil
+	}
+	return t
+}
+
+// sendReqObservingResponse sends req as sendReq does, and prints the response as the client received it unless quiet:
+// the bytes read from the connection, and how the Transport populated http.Response from them.
+// The bytes are recorded to rec if it's non-nil, for use after the request.
+func (s *session) sendReqObservingResponse(tr http.RoundTripper, req *http.Request, rec *recorder, quiet bool) error {
+	if rec == nil {
+		rec = &recorder{}
+	}
+	cli := &http.Client{Transport: withReceivedRecording(tr, rec)}
+	defer cli.CloseIdleConnections()
+	resp, err := cli.Do(req)
+	if err != nil {
+		return fmt.Errorf("HTTP request failed: %w", err)
+	}
+	body, bodyErr := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if !quiet {
+		s.printReceivedResponse(rec.bytes(), resp, len(body), bodyErr)
+	}
+	return nil
+}
+
+// printReceivedResponse prints the raw response read from the connection next to the fields of resp populated from it.
+// Trailer of resp is complete only after its body was read to the end.
+func (s *session) printReceivedResponse(raw []byte, resp *http.Response, bodyLen int, bodyErr error) {
+	line, fields, complete := parseRawHead(raw)
+	headLen := len(raw)
+	if complete {
+		headLen = bytes.Index(raw, []byte("\r\n\r\n")) + 4
+	}
+	fmt.Fprintf(s.out, "Response received by the client: %d bytes read from the connection\n", len(raw))
+	fmt.Fprintf(s.out, "  status line: %s\n", line)
+	fmt.Fprintf(s.out, "  headers (%d):\n", len(fields))
+	for _, f := range fields {
+		fmt.Fprintf(s.out, "    %s: %s\n", f.Name, s.redactValue(f.Name, f.Value))
+	}
+	fmt.Fprintf(s.out, "  header section: %d bytes, after it: %d bytes\n", headLen, len(raw)-headLen)
+
+	fmt.Fprintln(s.out, "http.Response:")
+	fmt.Fprintf(s.out, "  Status: %q, Proto: %s\n", resp.Status, resp.Proto)
+	fmt.Fprintf(s.out, "  ContentLength: %d\n", resp.ContentLength)
+	fmt.Fprintf(s.out, "  TransferEncoding: %q\n", resp.TransferEncoding)
+	fmt.Fprintf(s.out, "  Uncompressed: %v\n", resp.Uncompressed)
+	fmt.Fprintf(s.out, "  Close: %v\n", resp.Close)
+	fmt.Fprintf(s.out, "  Header (%d):\n", len(resp.Header))
+	for _, name := range sortedKeys(resp.Header) {
+		fmt.Fprintf(s.out, "    %s: %s\n", name, strings.Join(s.redactValues(name, resp.Header[name]), ", "))
+	}
+	fmt.Fprintf(s.out, "  Trailer (%d):\n", len(resp.Trailer))
+	for _, name := range sortedKeys(resp.Trailer) {
+		fmt.Fprintf(s.out, "    %s: %s\n", name, strings.Join(s.redactValues(name, resp.Trailer[name]), ", "))
+	}
+	if bodyErr != nil {
+		fmt.Fprintf(s.out, "  Body: %d bytes read, then: %v\n", bodyLen, bodyErr)
+	} else {
+		fmt.Fprintf(s.out, "  Body: %d bytes read\n", bodyLen)
+	}
+
+	var dropped []string
+	for _, f := range fields {
+		if _, ok := resp.Header[http.CanonicalHeaderKey(f.Name)]; !ok {
+			dropped = append(dropped, f.Name)
+		}
+	}
+	if len(dropped) > 0 {
+		fmt.Fprintln(s.out, "  => "+s.finding(sevInfo, fmt.Sprintf("headers received but not in Response.Header: %s", strings.Join(dropped, ", "))))
+	}
+	if resp.Uncompressed {
+		fmt.Fprintln(s.out, "  => "+s.finding(sevInfo, "the Transport decompressed the body transparently, as it asked for gzip itself; Content-Encoding and Content-Length are removed and ContentLength is -1"))
+	}
+	if bodyErr != nil {
+		fmt.Fprintln(s.out, "  => "+s.finding(sevWarn, fmt.Sprintf("reading the body failed after %d bytes: %v", bodyLen, bodyErr)))
+	} else if resp.ContentLength >= 0 && int64(bodyLen) != resp.ContentLength {
+		fmt.Fprintln(s.out, "  => "+s.finding(sevWarn, fmt.Sprintf("read %d bytes of body, but ContentLength is %d", bodyLen, resp.ContentLength)))
+	}
+}
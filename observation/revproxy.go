@@ -0,0 +1,161 @@
+package observation
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// hop-by-hop headers added to requests sent through the reverse proxy, to observe them being stripped
+var hopByHopExample = http.Header{
+	"Connection":    {"keep-alive, X-Hop-Example"},
+	"Keep-Alive":    {"timeout=5"},
+	"X-Hop-Example": {"dropped by the proxy"},
+}
+
+// readRecordingConn records all bytes read from the connection.
+type readRecordingConn struct {
+	net.Conn
+	rec *recorder
+}
+
+func (c *readRecordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.rec.write(p[:n])
+	return n, err
+}
+
+type recorder struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (r *recorder) write(p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.buf.Write(p)
+}
+
+func (r *recorder) bytes() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]byte(nil), r.buf.Bytes()...)
+}
+
+// recordingListener wraps accepted connections with readRecordingConn, sharing one recorder.
+type recordingListener struct {
+	net.Listener
+	rec *recorder
+}
+
+func (l *recordingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &readRecordingConn{Conn: conn, rec: l.rec}, nil
+}
+
+// startReverseProxy starts an httputil.ReverseProxy forwarding to backend with tr on an ephemeral port.
+// Bytes of requests the proxy received from clients are recorded to the returned recorder.
+func startReverseProxy(backend string, tr http.RoundTripper) (proxyURL string, rec *recorder, stop func(), err error) {
+	target, err := url.Parse(backend)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("invalid backend URL: %w", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to start listening: %w", err)
+	}
+	rec = &recorder{}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = tr
+	srv := &http.Server{Handler: proxy}
+	go func() { _ = srv.Serve(&recordingListener{Listener: l, rec: rec}) }()
+
+	return "http://" + l.Addr().String(), rec, func() { _ = srv.Close() }, nil
+}
+
+// observeViaReverseProxy sends the pattern through an httputil.ReverseProxy in front of the observer,
+// and diffs the request the client sent against the one the proxy forwarded.
+func observeViaReverseProxy(server *captureServer, pat reqPattern, b ServerBehavior, opts runOptions) (*timing, error) {
+	proxyURL, rec, stop, err := startReverseProxy(opts.serverURL, opts.transport)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	h := server.handoff(func(conn net.Conn, captures chan<- capturedRequest) {
+		opts.serveBehavior(conn, b, captures, opts.quiet)
+	})
+	opts.target = proxyURL
+	opts.extraHeader = hopByHopExample
+	tm, reqErr := request(pat, opts)
+
+	got := h.wait()
+	if len(got) == 0 {
+		// the proxy failed to forward the request
+		return tm, reqErr
+	}
+	forwarded := got[0]
+	if !opts.quiet {
+		fmt.Fprintln(opts.out, "Client request -> request forwarded by httputil.ReverseProxy:")
+		opts.printRequestDiff(rec.bytes(), forwarded.raw)
+	}
+	return tm, reqErr
+}
+
+// printRequestDiff prints differences of the request line, headers and body framing between two raw requests.
+func (s *session) printRequestDiff(before, after []byte) {
+	line1, fields1, _ := parseRawHead(before)
+	line2, fields2, _ := parseRawHead(after)
+	if line1 == line2 {
+		fmt.Fprintf(s.out, "  = %s\n", line1)
+	} else {
+		fmt.Fprintf(s.out, "  ~ %s -> %s\n", line1, line2)
+	}
+
+	v1, order := groupFields(fields1, nil)
+	v2, order := groupFields(fields2, order)
+	for _, name := range order {
+		a, b := strings.Join(v1[name], ", "), strings.Join(v2[name], ", ")
+		a, b = s.redactValue(name, a), s.redactValue(name, b)
+		_, in1 := v1[name]
+		_, in2 := v2[name]
+		switch {
+		case !in2:
+			fmt.Fprintf(s.out, "  - %s: %s\n", name, a)
+		case !in1:
+			fmt.Fprintf(s.out, "  + %s: %s\n", name, b)
+		case a != b:
+			fmt.Fprintf(s.out, "  ~ %s: %s -> %s\n", name, a, b)
+		default:
+			fmt.Fprintf(s.out, "  = %s: %s\n", name, a)
+		}
+	}
+}
+
+// groupFields groups header values by canonical name, appending names not in order to it.
+func groupFields(fields []HeaderField, order []string) (map[string][]string, []string) {
+	vs := make(map[string][]string)
+	seen := make(map[string]bool)
+	for _, name := range order {
+		seen[name] = true
+	}
+	for _, f := range fields {
+		name := textproto.CanonicalMIMEHeaderKey(f.Name)
+		vs[name] = append(vs[name], f.Value)
+		if !seen[name] {
+			seen[name] = true
+			order = append(order, name)
+		}
+	}
+	return vs, order
+}
@@ -0,0 +1,99 @@
+package observation
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+)
+
+// seekableBody exposes an io.ReadSeeker as a request body whose Close is a no-op,
+// so that the body survives the Transport closing it after the first attempt.
+type seekableBody struct {
+	io.ReadSeeker
+}
+
+func (seekableBody) Close() error { return nil }
+
+// single-part PUT request, passing io.ReadSeeker as body without setting GetBody
+func singlepartSeekable(serverURL string, body io.ReadSeeker) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPut, serverURL, seekableBody{body})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	return req, nil
+}
+
+// authRoundTripper resends a request with credentials after a 401 challenge.
+// Like many SDKs, it rewinds the body via io.Seeker instead of relying on Request.GetBody.
+type authRoundTripper struct {
+	base     http.RoundTripper
+	user     string
+	password string
+}
+
+func (t *authRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	seeker, ok := req.Body.(io.Seeker)
+	if !ok {
+		return resp, nil
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind request body: %w", err)
+	}
+	retry := req.Clone(req.Context())
+	retry.SetBasicAuth(t.user, t.password)
+	return t.base.RoundTrip(retry)
+}
+
+// observeRewind sends a request with io.ReadSeeker body through authRoundTripper,
+// then verifies that the body of the retried attempt matches the first one byte-for-byte.
+func observeRewind(server *captureServer, opts runOptions) (*timing, error) {
+	f, err := os.Open(opts.filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	req, err := singlepartSeekable(opts.serverURL, f)
+	if err != nil {
+		return nil, err
+	}
+	req, tm := traceTiming(req.WithContext(opts.ctx))
+	cli := &http.Client{
+		Transport: &authRoundTripper{base: opts.transport, user: "user", password: "pass"},
+	}
+	h := server.handoff(func(conn net.Conn, captures chan<- capturedRequest) {
+		opts.serveBehavior(conn, authChallengeBehavior{}, captures, opts.quiet)
+	})
+	resp, err := cli.Do(req)
+	if err != nil {
+		h.wait()
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	tm.finish()
+
+	got := h.wait()
+	if len(got) != 2 {
+		return tm, fmt.Errorf("expected the first attempt and its retry, but the server captured %d requests", len(got))
+	}
+	first, second := got[0].body, got[1].body
+	if !bytes.Equal(first, second) {
+		fmt.Fprintln(opts.out, "=> "+opts.finding(sevError, fmt.Sprintf("body of the retried attempt differs from the first one (%d bytes vs %d bytes)", len(second), len(first))))
+		return tm, nil
+	}
+	if !opts.quiet {
+		fmt.Fprintf(opts.out, "body of the retried attempt matches the first one byte-for-byte (%d bytes)\n", len(first))
+	}
+	return tm, nil
+}
@@ -0,0 +1,264 @@
+package observation
+
+import (
+	"bytes"
+	"fmt"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// messageViolation is a violation of the HTTP/1.1 message format (RFC 9112) found in a captured request.
+type messageViolation struct {
+	section string // section of RFC 9112 stating the rule, or prefixed with another RFC
+	detail  string
+}
+
+// validateRequestMessage checks the raw request against the HTTP/1.1 message format rules:
+// line endings, the request line, field syntax, Host, message framing and the chunked framing of the body.
+// Only the part of the request in raw is checked, so rules about the whole head are skipped if it's incomplete.
+func validateRequestMessage(raw []byte) []messageViolation {
+	var vs []messageViolation
+	add := func(section, format string, args ...any) {
+		vs = append(vs, messageViolation{section: section, detail: fmt.Sprintf(format, args...)})
+	}
+
+	head := raw
+	complete := false
+	if i := bytes.Index(raw, []byte("\r\n\r\n")); i >= 0 {
+		head, complete = raw[:i], true
+	}
+
+	bareLF, bareCR := -1, -1
+	nLF, nCR := 0, 0
+	for i, b := range head {
+		if b == '\n' && (i == 0 || head[i-1] != '\r') {
+			if nLF++; bareLF < 0 {
+				bareLF = i
+			}
+		}
+		if b == '\r' && i+1 < len(head) && head[i+1] != '\n' {
+			if nCR++; bareCR < 0 {
+				bareCR = i
+			}
+		}
+	}
+	if nLF > 0 {
+		add("2.2", "%d bare LF (first at offset %d); lines must end with CRLF", nLF, bareLF)
+	}
+	if nCR > 0 {
+		add("2.2", "%d bare CR (first at offset %d) outside CRLF", nCR, bareCR)
+	}
+	lines := strings.Split(string(head), "\r\n")
+	if !complete {
+		// the last line may be cut in the middle
+		lines = lines[:len(lines)-1]
+	}
+	for len(lines) > 0 && lines[0] == "" {
+		add("2.2", "empty line before the request line")
+		lines = lines[1:]
+	}
+	if len(lines) == 0 {
+		return vs
+	}
+
+	version := validateRequestLine(lines[0], add)
+
+	var (
+		hosts int
+		cls   []string
+		tes   []string
+	)
+	for _, line := range lines[1:] {
+		if line != "" && (line[0] == ' ' || line[0] == '\t') {
+			add("5.2", "obsolete line folding: %q", line)
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			add("5", "field line without a colon: %q", line)
+			continue
+		}
+		if strings.TrimRight(name, " \t") != name {
+			add("5.1", "whitespace between field name and colon: %q", name+":")
+			name = strings.TrimRight(name, " \t")
+		}
+		if !isToken(name) {
+			add("5.1", "field name is not a token: %q", name)
+		}
+		for _, c := range []byte(value) {
+			if c < 0x20 && c != '\t' || c == 0x7f {
+				add("RFC 9110 5.5", "control character 0x%02x in the value of %s", c, name)
+				break
+			}
+		}
+		value = strings.Trim(value, " \t")
+		switch textproto.CanonicalMIMEHeaderKey(name) {
+		case "Host":
+			hosts++
+		case "Content-Length":
+			cls = append(cls, value)
+		case "Transfer-Encoding":
+			tes = append(tes, value)
+		}
+	}
+
+	if complete && version == "HTTP/1.1" && hosts == 0 {
+		add("3.2", "HTTP/1.1 request without Host")
+	}
+	if hosts > 1 {
+		add("3.2", "%d Host fields; exactly one is allowed", hosts)
+	}
+	if validateFraming(version, cls, tes, add) && complete {
+		validateChunkedBody(raw[len(head)+len("\r\n\r\n"):], add)
+	}
+	return vs
+}
+
+// validateRequestLine checks the request line, returning the HTTP version in it.
+func validateRequestLine(line string, add func(section, format string, args ...any)) string {
+	parts := strings.Split(line, " ")
+	if len(parts) != 3 {
+		add("3", "request line is not exactly method, target and version separated by single spaces: %q", line)
+		return ""
+	}
+	method, target, version := parts[0], parts[1], parts[2]
+	if !isToken(method) {
+		add("3.1", "method is not a token: %q", method)
+	}
+	if target == "" {
+		add("3.2", "empty request-target")
+	}
+	for _, c := range []byte(target) {
+		if c <= 0x20 || c >= 0x7f {
+			add("3.2", "request-target contains byte 0x%02x, which must be percent-encoded", c)
+			break
+		}
+	}
+	if len(version) != len("HTTP/1.1") || !strings.HasPrefix(version, "HTTP/") ||
+		!isDigit(version[5]) || version[6] != '.' || !isDigit(version[7]) {
+		add("2.3", "malformed HTTP-version: %q", version)
+	}
+	return version
+}
+
+// validateFraming checks Content-Length and Transfer-Encoding fields of the request,
+// returning whether the body is chunked.
+func validateFraming(version string, cls, tes []string, add func(section, format string, args ...any)) bool {
+	var lengths []string
+	for _, cl := range cls {
+		for _, v := range strings.Split(cl, ",") {
+			v = strings.Trim(v, " \t")
+			if _, err := strconv.ParseUint(v, 10, 63); err != nil {
+				add("6.3", "invalid Content-Length: %q", cl)
+				continue
+			}
+			lengths = append(lengths, v)
+		}
+	}
+	for _, l := range lengths {
+		if l != lengths[0] {
+			add("6.3", "conflicting Content-Length values: %s", strings.Join(lengths, ", "))
+			break
+		}
+	}
+
+	if len(tes) == 0 {
+		return false
+	}
+	if len(cls) > 0 {
+		add("6.1", "both Transfer-Encoding and Content-Length; a sender must not send Content-Length with Transfer-Encoding")
+	}
+	if version == "HTTP/1.0" {
+		add("6.1", "Transfer-Encoding in an HTTP/1.0 request")
+	}
+	var codings []string
+	for _, te := range tes {
+		for _, c := range strings.Split(te, ",") {
+			codings = append(codings, strings.ToLower(strings.Trim(c, " \t")))
+		}
+	}
+	for i, c := range codings {
+		if c == "chunked" && i != len(codings)-1 {
+			add("6.1", "chunked is applied more than once or isn't the final transfer coding: %s", strings.Join(codings, ", "))
+			break
+		}
+	}
+	if codings[len(codings)-1] != "chunked" {
+		add("6.3", "the final transfer coding of a request isn't chunked, so its length can't be determined: %s", strings.Join(codings, ", "))
+		return false
+	}
+	return true
+}
+
+// validateChunkedBody checks the chunk-size lines and the CRLF after each chunk of a chunked body, up to the last chunk.
+// The first violation ends the check, as the rest of the body can't be framed.
+func validateChunkedBody(body []byte, add func(section, format string, args ...any)) {
+	for {
+		i := bytes.Index(body, []byte("\r\n"))
+		if i < 0 {
+			if j := bytes.IndexByte(body, '\n'); j >= 0 {
+				add("7.1", "chunk-size line doesn't end with CRLF: %q", body[:j+1])
+			}
+			return
+		}
+		line := string(body[:i])
+		if j := strings.IndexByte(line, '\n'); j >= 0 {
+			add("7.1", "chunk-size line doesn't end with CRLF: %q", line[:j+1])
+			return
+		}
+		size, _, _ := strings.Cut(line, ";")
+		size = strings.TrimRight(size, " \t") // BWS before chunk extensions
+		if size == "" || strings.Trim(size, "0123456789abcdefABCDEF") != "" {
+			add("7.1", "invalid chunk-size line: %q", line)
+			return
+		}
+		n, err := strconv.ParseUint(size, 16, 63)
+		if err != nil {
+			add("7.1", "chunk-size out of range: %q", size)
+			return
+		}
+		body = body[i+2:]
+		if n == 0 {
+			// trailer section follows the last chunk
+			return
+		}
+		if uint64(len(body)) < n+2 {
+			return
+		}
+		if !bytes.HasPrefix(body[n:], []byte("\r\n")) {
+			add("7.1", "chunk data of %d bytes isn't followed by CRLF", n)
+			return
+		}
+		body = body[n+2:]
+	}
+}
+
+// isToken reports whether s is a token (RFC 9110 Section 5.6.2).
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range []byte(s) {
+		if !(isDigit(c) || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// printMessageViolations prints violations of RFC 9112 found in the raw request, if any.
+func (s *session) printMessageViolations(raw []byte) {
+	vs := validateRequestMessage(raw)
+	if len(vs) == 0 {
+		return
+	}
+	fmt.Fprintf(s.out, "RFC 9112 violations (%d):\n", len(vs))
+	for _, v := range vs {
+		fmt.Fprintf(s.out, "  - %s\n", s.finding(sevError, fmt.Sprintf("[%s] %s", v.section, v.detail)))
+	}
+}
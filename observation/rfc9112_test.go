@@ -0,0 +1,161 @@
+package observation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateRequestMessage(t *testing.T) {
+	const chunked = "POST /upload HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n"
+	tests := []struct {
+		name string
+		raw  string
+		want []string // "[section] detail" substrings, one per violation in order; none if empty
+	}{
+		{
+			name: "Content-Length",
+			raw:  "POST /upload HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5\r\n\r\nhello",
+		},
+		{
+			name: "chunked",
+			raw:  chunked + "5\r\nhello\r\n6;ext=1\r\n world\r\n0\r\nDigest: x\r\n\r\n",
+		},
+		{
+			name: "truncated chunked body",
+			raw:  chunked + "a\r\nhel",
+		},
+
+		// CL+TE conflicts
+		{
+			name: "Content-Length with Transfer-Encoding",
+			raw:  "POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+			want: []string{"[6.1] both Transfer-Encoding and Content-Length"},
+		},
+		{
+			name: "conflicting Content-Length fields",
+			raw:  "POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5\r\nContent-Length: 6\r\n\r\nhello",
+			want: []string{"[6.3] conflicting Content-Length values: 5, 6"},
+		},
+		{
+			name: "conflicting Content-Length list",
+			raw:  "POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5, 6\r\n\r\nhello",
+			want: []string{"[6.3] conflicting Content-Length values: 5, 6"},
+		},
+		{
+			name: "repeated Content-Length of the same value",
+			raw:  "POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5, 5\r\n\r\nhello",
+		},
+		{
+			name: "invalid Content-Length",
+			raw:  "POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: -1\r\n\r\n",
+			want: []string{`[6.3] invalid Content-Length: "-1"`},
+		},
+		{
+			name: "chunked not the final coding",
+			raw:  "POST / HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked, gzip\r\n\r\n",
+			want: []string{"[6.1] chunked is applied more than once", "[6.3] the final transfer coding of a request isn't chunked"},
+		},
+		{
+			name: "Transfer-Encoding in HTTP/1.0",
+			raw:  "POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+			want: []string{"[6.1] Transfer-Encoding in an HTTP/1.0 request"},
+		},
+
+		// obs-fold
+		{
+			name: "line folded with a space",
+			raw:  "GET / HTTP/1.1\r\nHost: example.com\r\nX-Long: a\r\n b\r\n\r\n",
+			want: []string{`[5.2] obsolete line folding: " b"`},
+		},
+		{
+			name: "line folded with a tab",
+			raw:  "GET / HTTP/1.1\r\nHost: example.com\r\nX-Long: a\r\n\tb\r\n\r\n",
+			want: []string{`[5.2] obsolete line folding: "\tb"`},
+		},
+
+		// bad chunk-size lines
+		{
+			name: "chunk-size not hex",
+			raw:  chunked + "5x\r\nhello\r\n0\r\n\r\n",
+			want: []string{`[7.1] invalid chunk-size line: "5x"`},
+		},
+		{
+			name: "empty chunk-size",
+			raw:  chunked + ";ext\r\nhello\r\n0\r\n\r\n",
+			want: []string{`[7.1] invalid chunk-size line: ";ext"`},
+		},
+		{
+			name: "chunk-size with a sign",
+			raw:  chunked + "+5\r\nhello\r\n0\r\n\r\n",
+			want: []string{`[7.1] invalid chunk-size line: "+5"`},
+		},
+		{
+			name: "chunk-size overflowing",
+			raw:  chunked + "10000000000000000\r\nhello\r\n0\r\n\r\n",
+			want: []string{`[7.1] chunk-size out of range: "10000000000000000"`},
+		},
+		{
+			name: "chunk-size with whitespace before an extension",
+			raw:  chunked + "5 ;ext\r\nhello\r\n0\r\n\r\n",
+		},
+
+		// missing CRLF
+		{
+			name: "bare LF in the head",
+			raw:  "GET / HTTP/1.1\nHost: example.com\r\n\r\n",
+			want: []string{"[2.2] 1 bare LF (first at offset 14)", "[3] request line is not exactly method"},
+		},
+		{
+			name: "bare CR in the head",
+			raw:  "GET / HTTP/1.1\r\nHost: example.com\rX: y\r\n\r\n",
+			want: []string{"[2.2] 1 bare CR (first at offset 33)", "[RFC 9110 5.5] control character 0x0d in the value of Host"},
+		},
+		{
+			name: "chunk-size line ending with a bare LF",
+			raw:  chunked + "5\nhello\r\n0\r\n\r\n",
+			want: []string{`[7.1] chunk-size line doesn't end with CRLF: "5\n"`},
+		},
+		{
+			name: "last chunk ending with a bare LF",
+			raw:  chunked + "0\n\n",
+			want: []string{`[7.1] chunk-size line doesn't end with CRLF: "0\n"`},
+		},
+		{
+			name: "chunk data without CRLF",
+			raw:  chunked + "5\r\nhello0\r\n\r\n",
+			want: []string{"[7.1] chunk data of 5 bytes isn't followed by CRLF"},
+		},
+		{
+			name: "chunk data longer than its size",
+			raw:  chunked + "3\r\nhello\r\n0\r\n\r\n",
+			want: []string{"[7.1] chunk data of 3 bytes isn't followed by CRLF"},
+		},
+
+		{
+			name: "missing Host",
+			raw:  "GET / HTTP/1.1\r\n\r\n",
+			want: []string{"[3.2] HTTP/1.1 request without Host"},
+		},
+		{
+			name: "incomplete head",
+			raw:  "GET / HTTP/1.1\r\nHo",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vs := validateRequestMessage([]byte(tt.raw))
+			got := make([]string, len(vs))
+			for i, v := range vs {
+				got[i] = "[" + v.section + "] " + v.detail
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d violations %q, want %d %q", len(got), got, len(tt.want), tt.want)
+			}
+			for i := range got {
+				if !strings.Contains(got[i], tt.want[i]) {
+					t.Errorf("violation %d: got %q, want one containing %q", i+1, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,821 @@
+package observation
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"httpcli-contentlen-example/observe"
+)
+
+// session is the state of a Run shared by the code observing its patterns: where observations are printed,
+// how the capture server is reached and the findings reported. It's threaded through runOptions, so that Runs don't share it.
+type session struct {
+	ctx            context.Context // of the Run, which requests are sent with
+	out            io.Writer
+	serverURL      string            // URL of the capture server
+	transport      http.RoundTripper // used to send requests to the capture server
+	captureBytes   int64             // bytes of each request capture servers read or log, or CaptureAll
+	captureLimits  captureLimits     // of requests kept by servers with a behavior
+	readThrottle   readThrottle      // of connections accepted by servers with a behavior
+	cannedResponse []byte            // replied by the "canned" behavior
+	hexDump        bool              // print bodies of captured requests in hexdump -C style
+	headerSizes    bool              // rank headers of captured requests by on-wire size
+	observer       *observe.Observer // receives lifecycle events, dropping them if nil
+	patterns       *patternSet
+	headerRedaction
+
+	mu       sync.Mutex
+	findings []recordedFinding
+}
+
+// newSession returns a session printing to w, with the defaults of a Run, for the commands observing outside of Run.
+func newSession(w io.Writer) *session {
+	return &session{
+		ctx:            context.Background(),
+		out:            w,
+		serverURL:      "http://localhost",
+		transport:      http.DefaultTransport.(*http.Transport).Clone(),
+		captureBytes:   defaultCaptureBytes,
+		captureLimits:  defaultCaptureLimits,
+		cannedResponse: defaultCannedResponse(),
+	}
+}
+
+// runConfig is the configuration of a Run, built by applying Options over defaultRunConfig.
+type runConfig struct {
+	listener      net.Listener // capture server of the patterns using the default server
+	listenIP      net.IP       // to listen on instead of 127.0.0.1
+	listenPort    int
+	npipe         string // named pipe to listen on instead of TCP
+	unixSock      string // Unix domain socket to listen on instead of TCP
+	tls           *tlsFiles
+	pcapOut       io.Writer
+	patterns      []reqPattern
+	patternNames  []string              // given with WithPatterns, parsed into patterns by Run
+	behavior      string                // name of the server behavior, the default server if empty
+	newBehavior   func() ServerBehavior // of the behavior, or of "ok" where the default server can't be used
+	out           io.Writer
+	serverURL     string            // URL of the capture server, derived from the listener if empty
+	transport     http.RoundTripper // used to send requests to the capture server, a clone of http.DefaultTransport if nil
+	ownTransport  bool              // the transport of the session is private to the Run, so its idle connections may be closed
+	captureBytes  int64
+	captureLimits captureLimits
+	throttle      readThrottle
+	canned        []byte // response of the "canned" behavior, the default one if nil
+	redacted      []string
+	scenarioFiles []string
+	repeat        int
+	opts          runOptions // template of the options of each run
+	connectHeader http.Header
+	fanOut        int
+	viaRevProxy   bool
+	viaProxy      bool
+	trackLeaks    bool
+	budget        patternBudget
+	obsOut        io.Writer
+	harOut        io.Writer
+	curlCmds      bool
+	reproDir      string
+	dualCapture   bool
+	hexDump       bool
+	headerSizes   bool
+	http2         bool
+	h2c           bool
+	lifecycle     bool
+	dialTrace     bool
+	observer      *observe.Observer
+}
+
+func defaultRunConfig() *runConfig {
+	return &runConfig{
+		out:           os.Stdout,
+		captureBytes:  defaultCaptureBytes,
+		captureLimits: defaultCaptureLimits,
+		repeat:        1,
+		opts:          runOptions{filename: "photo.jpg", stuckAfter: 2 * time.Second},
+	}
+}
+
+// Option configures Run.
+type Option func(*runConfig)
+
+// WithListener makes the capture server accept on l, which must be TCP unless the transport is replaced accordingly with WithTransport.
+// Run listens on an ephemeral port of 127.0.0.1 by default. Run closes l when it returns, as the capture server accepts on it until then.
+func WithListener(l net.Listener) Option {
+	return func(c *runConfig) { c.listener = l }
+}
+
+// WithListenAddr makes the capture server listen on ip and port, an ephemeral one if zero (-addr, -port).
+// Requests are sent to localhost for the IPv4 loopback and unspecified addresses, and to the address itself otherwise.
+func WithListenAddr(ip net.IP, port int) Option {
+	return func(c *runConfig) { c.listenIP, c.listenPort = ip, port }
+}
+
+// WithNamedPipe makes the capture server listen on the Windows named pipe (e.g. \\.\pipe\observation, or just "observation")
+// and sends requests over it (-npipe).
+func WithNamedPipe(name string) Option {
+	return func(c *runConfig) { c.npipe = name }
+}
+
+// WithUnixSocket makes the capture server listen on the Unix domain socket at path and sends requests over it,
+// as clients of e.g. the Docker API do (-listen unix:<path>).
+func WithUnixSocket(path string) Option {
+	return func(c *runConfig) { c.unixSock = path }
+}
+
+// WithTLS terminates TLS at the capture server with the PEM certificate and key in the files, and sends requests to https://,
+// capturing the plaintext inside TLS (-tls, -tls-cert, -tls-key). A self-signed certificate for localhost is generated
+// if both files are empty.
+func WithTLS(certFile, keyFile string) Option {
+	return func(c *runConfig) { c.tls = &tlsFiles{certFile: certFile, keyFile: keyFile} }
+}
+
+// WithPcap writes bytes read and written on connections of the capture server to w as pcap with synthetic TCP/IP framing,
+// for Wireshark (-pcap). The bytes are ciphertext with WithTLS.
+func WithPcap(w io.Writer) Option {
+	return func(c *runConfig) { c.pcapOut = w }
+}
+
+// WithServerURL sends requests to url, which must reach the listener of WithListener (e.g. https:// for a TLS listener).
+// By default, it's http:// with the host and port of the listener, or http://localhost if the listener isn't TCP.
+func WithServerURL(url string) Option {
+	return func(c *runConfig) { c.serverURL = url }
+}
+
+// WithTransport sends requests to the capture server with tr, e.g. one dialing the named pipe or Unix socket the listener accepts on.
+// A clone of http.DefaultTransport by default. Idle connections of tr are left alone; the capture server closes its side instead.
+func WithTransport(tr http.RoundTripper) Option {
+	return func(c *runConfig) { c.transport = tr }
+}
+
+// WithCaptureBytes makes the default server read and log the first n bytes of each connection, and servers of WithServerBehavior
+// log the first n bytes of each request (-capture-bytes). With CaptureAll, the default server reads whole requests and replies 200.
+func WithCaptureBytes(n int64) Option {
+	return func(c *runConfig) { c.captureBytes = n }
+}
+
+// WithCaptureLimits makes servers of WithServerBehavior keep up to mem bytes of each request in memory, streaming larger requests
+// whole to a file in dir, which is left there (-capture-mem, -capture-dir). 64MiB and os.TempDir() by default.
+func WithCaptureLimits(mem int64, dir string) Option {
+	return func(c *runConfig) { c.captureLimits = captureLimits{mem: mem, dir: dir} }
+}
+
+// WithReadThrottle makes servers of WithServerBehavior back-pressure the client: the socket receive buffer of their connections
+// is set to rcvBuf bytes, and each Read call reads at most readSize bytes after pausing for interval
+// (-server-rcvbuf, -server-read-size, -server-read-interval). Zero values leave the respective aspect as is.
+func WithReadThrottle(rcvBuf, readSize int, interval time.Duration) Option {
+	return func(c *runConfig) { c.throttle = readThrottle{rcvBuf: rcvBuf, readSize: readSize, interval: interval} }
+}
+
+// WithCannedResponse makes the "canned" server behavior reply raw, a raw HTTP response, byte-for-byte (-response).
+// By default, it replies a gzip-encoded chunked response with a trailer.
+func WithCannedResponse(raw []byte) Option {
+	return func(c *runConfig) { c.canned = raw }
+}
+
+// WithRedactedHeaders masks the values of the named headers in all outputs, preserving lengths (-redact).
+func WithRedactedHeaders(names ...string) Option {
+	return func(c *runConfig) { c.redacted = names }
+}
+
+// WithScenarioFiles adds the requests described in the scenario files as patterns after the built-in ones
+// and the ones registered with RegisterPattern, in the order given (-scenario).
+func WithScenarioFiles(paths ...string) Option {
+	return func(c *runConfig) { c.scenarioFiles = paths }
+}
+
+// WithPatterns runs only the patterns given by name or numeric ID as listed by -list, in the given order.
+// All patterns are run by default, or if none are given.
+func WithPatterns(names ...string) Option {
+	return func(c *runConfig) { c.patternNames = names }
+}
+
+// WithBodySource uploads the file as the body of requests. photo.jpg by default.
+func WithBodySource(filename string) Option {
+	return func(c *runConfig) { c.opts.filename = filename }
+}
+
+// WithServerBehavior makes the capture server behave as the named behavior, one of those listed for -server (e.g. "ok" or "canned").
+// The default server, which reads the first WithCaptureBytes bytes (1KiB by default) and disconnects, is used if name is empty.
+func WithServerBehavior(name string) Option {
+	return func(c *runConfig) { c.behavior = name }
+}
+
+// WithOutput prints observations to w instead of os.Stdout.
+func WithOutput(w io.Writer) Option {
+	return func(c *runConfig) { c.out = w }
+}
+
+// WithRepeat runs each pattern n times, dumping requests only on the first run (-repeat).
+func WithRepeat(n int) Option {
+	return func(c *runConfig) { c.repeat = n }
+}
+
+// WithCloseTracking tracks when Request.Body is read to the end and closed (-track-close).
+func WithCloseTracking(enabled bool) Option {
+	return func(c *runConfig) { c.opts.trackClose = enabled }
+}
+
+// WithWriteLogging logs every Write call on the client connection (-log-writes).
+func WithWriteLogging(enabled bool) Option {
+	return func(c *runConfig) { c.opts.logWrites = enabled }
+}
+
+// WithHeaderStages diffs request headers as built, at RoundTrip and on the wire (-header-stages).
+func WithHeaderStages(enabled bool) Option {
+	return func(c *runConfig) { c.opts.hdrStages = enabled }
+}
+
+// WithBoundary fixes the multipart boundary (-boundary). Random if empty.
+func WithBoundary(boundary string) Option {
+	return func(c *runConfig) { c.opts.boundary = boundary }
+}
+
+// WithMultipartParts sets the parts of multipart bodies, in order (-f, -form). The body source is added as the first part if parts don't have it.
+func WithMultipartParts(parts []MultipartPart) Option {
+	return func(c *runConfig) { c.opts.parts = parts }
+}
+
+// WithBodyDelay holds back the body until d after headers are written (-body-delay).
+func WithBodyDelay(d time.Duration) Option {
+	return func(c *runConfig) { c.opts.bodyDelay = d }
+}
+
+// WithStuckAfter sets the time after which requests with a stuck body are reported as not rescued (-stuck-after).
+func WithStuckAfter(d time.Duration) Option {
+	return func(c *runConfig) { c.opts.stuckAfter = d }
+}
+
+// WithProxyConnectHeader sets Transport.ProxyConnectHeader of the CONNECT experiment (-proxy-connect-header).
+func WithProxyConnectHeader(h http.Header) Option {
+	return func(c *runConfig) { c.connectHeader = h }
+}
+
+// WithFanOut sends each pattern to n capture servers concurrently (-fan-out). Disabled if zero.
+func WithFanOut(n int) Option {
+	return func(c *runConfig) { c.fanOut = n }
+}
+
+// WithReverseProxy sends requests through httputil.ReverseProxy in front of the capture server (-via-reverse-proxy).
+func WithReverseProxy(enabled bool) Option {
+	return func(c *runConfig) { c.viaRevProxy = enabled }
+}
+
+// WithForwardProxy sends requests through a capturing forward proxy set as Transport.Proxy (-via-proxy).
+// The transport must be an *http.Transport dialing TCP.
+func WithForwardProxy(enabled bool) Option {
+	return func(c *runConfig) { c.viaProxy = enabled }
+}
+
+// WithLeakTracking reports goroutines and file descriptors left behind by each pattern (-track-leaks).
+func WithLeakTracking(enabled bool) Option {
+	return func(c *runConfig) { c.trackLeaks = enabled }
+}
+
+// WithBudget sets the memory and time budgets of each pattern (-mem-budget, -time-budget). Zero means unlimited.
+func WithBudget(mem int64, d time.Duration) Option {
+	return func(c *runConfig) { c.budget = patternBudget{mem: mem, time: d} }
+}
+
+// WithCurlCommands prints the closest equivalent curl command of each pattern before running it (-curl).
+func WithCurlCommands(enabled bool) Option {
+	return func(c *runConfig) { c.curlCmds = enabled }
+}
+
+// WithHAR writes a HAR document to w when Run returns, with an entry of each captured request and the response the client received
+// if the server behavior is "canned" (-har). Disabled if nil.
+func WithHAR(w io.Writer) Option {
+	return func(c *runConfig) { c.harOut = w }
+}
+
+// WithObservations writes a record of each captured request to w as JSON Lines (-observations). Disabled if nil.
+func WithObservations(w io.Writer) Option {
+	return func(c *runConfig) { c.obsOut = w }
+}
+
+// WithReproDir writes a bug report template and reproduction program for each pattern with warn or error findings
+// into a directory under dir (-repro-dir). Disabled if empty.
+func WithReproDir(dir string) Option {
+	return func(c *runConfig) { c.reproDir = dir }
+}
+
+// WithDualCapture byte-diffs the bytes the client wrote against the ones the capture server read (-dual-capture).
+// Applies to patterns sent to the capture server of the Run, not through the reverse proxy nor with fan-out.
+func WithDualCapture(enabled bool) Option {
+	return func(c *runConfig) { c.dualCapture = enabled }
+}
+
+// WithHexDump prints bodies of captured requests in hexdump -C style (-hex).
+func WithHexDump(enabled bool) Option {
+	return func(c *runConfig) { c.hexDump = enabled }
+}
+
+// WithHeaderSizes ranks the headers of each captured request by on-wire size after its dump (-header-sizes).
+func WithHeaderSizes(enabled bool) Option {
+	return func(c *runConfig) { c.headerSizes = enabled }
+}
+
+// WithHTTP2 sends patterns built by request() over HTTP/2 with TLS to a hand-rolled server,
+// reporting frames and HPACK-decoded header fields instead of HTTP/1.1 captures (-http2).
+func WithHTTP2(enabled bool) Option {
+	return func(c *runConfig) { c.http2 = enabled }
+}
+
+// WithH2C sends patterns built by request() over cleartext HTTP/2 with prior knowledge (h2c) to the hand-rolled server
+// of WithHTTP2, instead of over TLS (-h2c). The binary must be built with Go 1.24 or later.
+func WithH2C(enabled bool) Option {
+	return func(c *runConfig) { c.h2c = enabled }
+}
+
+// WithLifecycleTrace prints httptrace timestamps of each request the client sends, on the first run of each pattern (-lifecycle).
+func WithLifecycleTrace(enabled bool) Option {
+	return func(c *runConfig) { c.lifecycle = enabled }
+}
+
+// WithDialTrace prints the connect attempts of each request the client sends and the address family carrying it,
+// on the first run of each pattern (-addr).
+func WithDialTrace(enabled bool) Option {
+	return func(c *runConfig) { c.dialTrace = enabled }
+}
+
+// WithObserver delivers the lifecycle events of the run (see observe.Event) to callbacks registered on o with OnEvent.
+// Events are dropped if o is nil, the default.
+func WithObserver(o *observe.Observer) Option {
+	return func(c *runConfig) { c.observer = o }
+}
+
+// Report is the result of a Run.
+type Report struct {
+	Patterns []PatternReport // in the order run
+
+	stats runStats
+}
+
+// PatternReport is the result of the runs of a pattern.
+type PatternReport struct {
+	Pattern   string
+	Durations []time.Duration // of each run which got far enough to be timed
+	Errs      []error         // tolerated errors of runs, such as resets by the default server
+	Leaks     []string        // goroutines and file descriptors left behind, with WithLeakTracking
+	Findings  []string        // findings reported by the runs, prefixed with their severity
+	// Captured is whether a request of the pattern was captured by the capture server. Patterns running their own servers,
+	// and every pattern with WithHTTP2, WithH2C, WithForwardProxy, WithReverseProxy or WithFanOut, aren't: they get stub records
+	// written by WithObservations, and no entries in WithHAR.
+	Captured bool
+}
+
+// configure applies opts over the defaults and returns the configuration with the session built from it.
+// The session reaches the capture server as configured once the listener is set up with listen.
+func configure(ctx context.Context, opts []Option) (*runConfig, *session, error) {
+	cfg := defaultRunConfig()
+	for _, o := range opts {
+		o(cfg)
+	}
+	if cfg.repeat < 1 {
+		return nil, nil, fmt.Errorf("repeat must be positive: %d", cfg.repeat)
+	}
+	behavior := cfg.behavior
+	if behavior == "" {
+		// for custom patterns and the experiments needing a server replying, in place of the default server
+		behavior = "ok"
+	}
+	entry, ok := lookupServerBehavior(behavior)
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown server behavior: %q", cfg.behavior)
+	}
+	cfg.newBehavior = entry.new
+	if cfg.captureBytes <= 0 && cfg.captureBytes != CaptureAll {
+		return nil, nil, fmt.Errorf("capture bytes must be positive or CaptureAll: %d", cfg.captureBytes)
+	}
+	if cfg.captureLimits.mem <= 0 {
+		return nil, nil, fmt.Errorf("capture memory must be positive: %d", cfg.captureLimits.mem)
+	}
+	if t := cfg.throttle; t.rcvBuf < 0 || t.readSize < 0 || t.interval < 0 {
+		return nil, nil, fmt.Errorf("read throttle must not be negative: %+v", t)
+	}
+	if cfg.throttle.enabled() && cfg.behavior == "" {
+		return nil, nil, fmt.Errorf("read throttle needs a server behavior, as the default server reads only the first capture bytes")
+	}
+	if cfg.opts.boundary != "" {
+		if err := multipart.NewWriter(io.Discard).SetBoundary(cfg.opts.boundary); err != nil {
+			return nil, nil, fmt.Errorf("invalid boundary: %w", err)
+		}
+	}
+	if cfg.opts.stuckAfter <= 0 {
+		return nil, nil, fmt.Errorf("stuck-after must be positive: %v", cfg.opts.stuckAfter)
+	}
+	if cfg.fanOut < 0 {
+		return nil, nil, fmt.Errorf("fan-out must not be negative: %d", cfg.fanOut)
+	}
+	if cfg.h2c && cfg.http2 {
+		return nil, nil, fmt.Errorf("HTTP/2 can't be sent both over TLS and in cleartext (h2c)")
+	}
+	if cfg.h2c && !h2cSupported {
+		return nil, nil, fmt.Errorf("h2c needs a binary built with Go 1.24 or later, whose Transport can send cleartext HTTP/2")
+	}
+	if cfg.budget.mem < 0 || cfg.budget.time < 0 {
+		return nil, nil, fmt.Errorf("budgets must not be negative: %d, %v", cfg.budget.mem, cfg.budget.time)
+	}
+	if cfg.canned == nil {
+		cfg.canned = defaultCannedResponse()
+	}
+	ps, err := newPatternSet(cfg.scenarioFiles...)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg.patterns = ps.all()
+	if len(cfg.patternNames) > 0 {
+		cfg.patterns = nil
+		for _, name := range cfg.patternNames {
+			p, err := ps.parse(strings.TrimSpace(name))
+			if err != nil {
+				return nil, nil, err
+			}
+			cfg.patterns = append(cfg.patterns, p)
+		}
+	}
+	s := &session{
+		ctx:             ctx,
+		out:             cfg.out,
+		serverURL:       cfg.serverURL,
+		transport:       cfg.transport,
+		captureBytes:    cfg.captureBytes,
+		captureLimits:   cfg.captureLimits,
+		readThrottle:    cfg.throttle,
+		cannedResponse:  cfg.canned,
+		hexDump:         cfg.hexDump,
+		headerSizes:     cfg.headerSizes,
+		observer:        cfg.observer,
+		patterns:        ps,
+		headerRedaction: newHeaderRedaction(cfg.redacted...),
+	}
+	if s.transport == nil {
+		s.transport, cfg.ownTransport = http.DefaultTransport.(*http.Transport).Clone(), true
+	}
+	cfg.opts.session = s
+	return cfg, s, nil
+}
+
+// listen returns the listener of the capture server as configured, pointing the URL and transport of s at it,
+// and a function closing the pcap recording if any, to be called once the listener is closed.
+func (cfg *runConfig) listen(s *session) (l net.Listener, closePcap func() error, err error) {
+	closePcap = func() error { return nil }
+	switch {
+	case cfg.listener != nil && (cfg.listenIP != nil || cfg.npipe != "" || cfg.unixSock != ""):
+		return nil, nil, fmt.Errorf("a listener can't be given along with an address, named pipe or Unix domain socket to listen on")
+	case cfg.npipe != "" && cfg.unixSock != "":
+		return nil, nil, fmt.Errorf("a named pipe and a Unix domain socket can't be listened on together")
+	case cfg.listenIP != nil && (cfg.npipe != "" || cfg.unixSock != ""):
+		return nil, nil, fmt.Errorf("an IP address can't be listened on along with a named pipe or Unix domain socket")
+	case cfg.viaProxy && (cfg.npipe != "" || cfg.unixSock != ""):
+		// the proxy dials the capture server over TCP
+		return nil, nil, fmt.Errorf("the forward proxy can't reach a capture server on a named pipe or Unix domain socket")
+	case cfg.tls != nil && (cfg.npipe != "" || cfg.unixSock != ""):
+		return nil, nil, fmt.Errorf("TLS can't be terminated on a named pipe or Unix domain socket")
+	case cfg.tls != nil && cfg.dualCapture:
+		// the client side would record ciphertext
+		return nil, nil, fmt.Errorf("TLS can't be used with dual capture")
+	case cfg.tls != nil && cfg.behavior == "canned":
+		return nil, nil, fmt.Errorf("TLS can't be used with the canned server behavior, as the client would record the response as ciphertext")
+	case cfg.pcapOut != nil && cfg.tls == nil && len(s.headerRedaction) > 0:
+		// segments carry requests and responses split at arbitrary points, and the pcap writer doesn't track message boundaries
+		return nil, nil, fmt.Errorf("pcap can't be recorded with redaction unless with TLS: it records the bytes of connections as they are, which would leak redacted header values")
+	}
+
+	switch {
+	case cfg.listener != nil:
+		l = cfg.listener
+	case cfg.npipe != "":
+		l, err = listenNamedPipe(cfg.npipe)
+		s.transport, cfg.ownTransport = namedPipeTransport(cfg.npipe), true
+	case cfg.unixSock != "":
+		l, err = listenUnix(cfg.unixSock)
+		s.transport, cfg.ownTransport = unixSocketTransport(cfg.unixSock), true
+	default:
+		ip := cfg.listenIP
+		if ip == nil {
+			ip = net.IPv4(127, 0, 0, 1)
+		}
+		if s.serverURL == "" {
+			s.serverURL = "http://" + serverHost(ip)
+		}
+		l, err = startServer(ip, cfg.listenPort)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.serverURL == "" {
+		s.serverURL = "http://localhost"
+	}
+	if cfg.pcapOut != nil {
+		pw, err := newPcapWriter(cfg.pcapOut)
+		if err != nil {
+			l.Close()
+			return nil, nil, err
+		}
+		l, closePcap = pw.wrapListener(l), pw.Close
+	}
+	if cfg.tls != nil {
+		cert, err := loadServerCert(cfg.tls.certFile, cfg.tls.keyFile)
+		if err != nil {
+			l.Close()
+			return nil, nil, err
+		}
+		tl, tr, err := listenTLS(l, cert)
+		if err != nil {
+			l.Close()
+			return nil, nil, err
+		}
+		l, s.transport, cfg.ownTransport = tl, tr, true
+		s.serverURL = strings.Replace(s.serverURL, "http://", "https://", 1)
+	}
+	s.serverURL = listenerURL(l, s.serverURL)
+	return l, closePcap, nil
+}
+
+// Run runs the request patterns configured by opts and returns what was observed.
+// Requests are sent with ctx, so once it's done, the pattern run in progress is aborted and Run returns the report so far with ctx.Err().
+func Run(ctx context.Context, opts ...Option) (report *Report, err error) {
+	cfg, s, err := configure(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	ps := s.patterns
+	var obsWriter *observationWriter
+	if cfg.obsOut != nil {
+		if obsWriter, err = newObservationWriter(cfg.obsOut); err != nil {
+			return nil, err
+		}
+	}
+	var har *harWriter
+	if cfg.harOut != nil {
+		har = newHARWriter(cfg.harOut)
+		defer func() {
+			if herr := har.Close(); err == nil {
+				err = herr
+			}
+		}()
+	}
+	l, closePcap, err := cfg.listen(s)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if perr := closePcap(); err == nil {
+			err = perr
+		}
+	}()
+	var owned http.RoundTripper
+	if cfg.ownTransport {
+		owned = s.transport
+	}
+	server := startCaptureServer(l, owned)
+	defer server.stop()
+
+	report = &Report{stats: make(runStats)}
+	stats := report.stats
+	leaks := make(map[string][]string)
+	fdsAvailable := true
+	for _, p := range cfg.patterns {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		desc, name := ps.describe(p), ps.name(p)
+		fmt.Fprintf(s.out, "Request pattern: %s\n\n", desc)
+		if cfg.curlCmds {
+			printCurlEquivalent(p, s.serverURL, cfg.opts)
+		}
+
+		var before resourceSnapshot
+		if cfg.trackLeaks {
+			before = takeResourceSnapshot()
+		}
+		var run *budgetRun
+		if cfg.budget.enabled() {
+			run = startBudget(cfg.budget)
+		}
+
+		pr := PatternReport{Pattern: desc}
+		mark := s.findingsMark()
+		// in a function so that the budget is finished, restoring the memory limit, however the runs end
+		if err := func() error {
+			if run != nil {
+				defer run.finish(s)
+			}
+			for i := 0; i < cfg.repeat; i++ {
+				opts := cfg.opts
+				opts.quiet = i > 0
+				opts.lifecycle = cfg.lifecycle && !opts.quiet
+				opts.dialTrace = cfg.dialTrace && !opts.quiet
+
+				s.observer.Emit(&observe.PatternStarted{Pattern: desc, Run: i + 1})
+				var (
+					tm       *timing
+					err      error
+					faultErr bool // err is the client's reaction to a fault of the server, expected rather than a failure
+					captured *capturedRequest
+				)
+				switch p {
+				case reqSinglePartSeekerRewind:
+					tm, err = observeRewind(server, opts)
+				case reqSinglePartReqClose, reqSinglePartDisableKeepAlives:
+					tm, err = observeConnClose(p, opts)
+				case reqConditionalGet:
+					tm, err = observeConditionalGet(opts)
+				case reqPathNormalization:
+					tm, err = observePathNormalization(opts)
+				case reqHugeResponseHeaders:
+					tm, err = observeHugeResponseHeaders(opts)
+				case reqSinglePartWrappedBody:
+					tm, err = observeWrappedBodies(opts)
+				case reqProxyConnectHeader:
+					tm, err = observeProxyConnectHeader(cfg.connectHeader, opts)
+				case reqMTLSCertRotation:
+					tm, err = observeClientCertRotation(opts)
+				case reqH2Faults:
+					tm, err = observeH2Faults(opts)
+				case reqConditionalPut:
+					tm, err = observeConditionalPut(opts)
+				case reqAuthChange:
+					tm, err = observeAuthChange(opts)
+				case reqWriteVsWriteProxy:
+					tm, err = observeWriteVsWriteProxy(opts)
+				case reqStuckBody:
+					tm, err = observeStuckBody(opts)
+				case reqUpgradeTLS:
+					tm, err = observeUpgradeTLS(opts)
+				case reqHTTP10:
+					tm, err = observeHTTP10(opts)
+				case reqExpectContinue:
+					tm, err = observeExpectContinue(opts)
+				case reqGetWithBody:
+					tm, err = observeBodyOnMethod(http.MethodGet, opts)
+				case reqDeleteWithBody:
+					tm, err = observeBodyOnMethod(http.MethodDelete, opts)
+				case reqEmptyBody:
+					tm, err = observeEmptyBodies(opts)
+				case reqSinglePartLenTooSmall, reqSinglePartLenTooLarge:
+					tm, err = observeLenMismatch(p, opts)
+				case reqSinglePartLenReader, reqSinglePartSeekReader:
+					tm, err = observeReaderIfaces(p, opts)
+				case reqDeadConnRetry:
+					tm, err = observeDeadConnRetry(opts)
+				case reqRedirectReplay, reqGetBodyRedirect:
+					tm, err = observeRedirectReplay(p, opts)
+				case reqHeaderCanonicalization:
+					tm, err = observeHeaderCanonicalization(opts)
+				case reqHostField, reqHostHeader:
+					tm, err = observeHostOverride(p, opts)
+				case reqUADefault, reqUAOverride, reqUASuppressed:
+					tm, err = observeUserAgent(p, opts)
+				default:
+					// the proxy and fan-out need responses from servers, so default to "ok"
+					if cfg.http2 || cfg.h2c {
+						tm, err = observeOverH2(p, cfg.h2c, opts)
+						break
+					}
+					if cfg.viaRevProxy {
+						tm, err = observeViaReverseProxy(server, p, cfg.newBehavior(), opts)
+						break
+					}
+					if cfg.viaProxy {
+						tm, err = observeViaForwardProxy(server, p, cfg.newBehavior(), opts)
+						break
+					}
+					if cfg.fanOut > 0 {
+						tm, err = observeFanOut(p, cfg.fanOut, cfg.newBehavior, opts)
+						break
+					}
+					if cfg.dualCapture {
+						opts.sent = &recorder{}
+					}
+					opts.response = cfg.behavior == "canned"
+					if har != nil && opts.response {
+						opts.received = &recorder{}
+					}
+					var (
+						h *handoff
+						b ServerBehavior
+					)
+					if _, ok := ps.lookup(p); ok && cfg.behavior == "" {
+						// a custom request may end within the capture bytes, for which serve would wait forever
+						b = cfg.newBehavior()
+						h = server.handoff(func(conn net.Conn, captures chan<- capturedRequest) { s.serveBehavior(conn, b, captures, opts.quiet) })
+					} else if cfg.behavior == "" {
+						h = server.handoff(func(conn net.Conn, captures chan<- capturedRequest) { s.serve(conn, captures, opts.quiet) })
+					} else {
+						b = cfg.newBehavior()
+						h = server.handoff(func(conn net.Conn, captures chan<- capturedRequest) { s.serveBehavior(conn, b, captures, opts.quiet) })
+					}
+					tm, err = request(p, opts)
+
+					// the server has served the connections of the request once wait returns, so its log is complete
+					got := h.wait()
+					if fb, ok := b.(faultBehavior); ok {
+						if !opts.quiet {
+							s.printFaultReaction(fb, err, tm, h.conns)
+						}
+						faultErr = err != nil
+					}
+					if len(got) > 0 {
+						captured = &got[0]
+						if har != nil {
+							var received []byte
+							if opts.received != nil {
+								received = opts.received.bytes()
+							}
+							har.add(s.newHAREntry(p, *captured, tm, received))
+						}
+						if opts.sent != nil && !opts.quiet {
+							s.printDualCapture(opts.sent.bytes(), *captured)
+						}
+					}
+				}
+				if captured != nil {
+					pr.Captured = true
+				}
+				if obsWriter != nil {
+					// a stub of runs not captured, so that every run of every pattern has a record
+					o := uncapturedObservation(desc, name)
+					if captured != nil {
+						o = s.newObservation(desc, *captured)
+						o.Name = name
+					}
+					if tm != nil {
+						o.DurationNs = int64(tm.total)
+					}
+					if err != nil {
+						o.Error = err.Error()
+					}
+					if werr := obsWriter.write(o); werr != nil {
+						return werr
+					}
+				}
+				finished := &observe.PatternFinished{Pattern: desc, Run: i + 1, Err: err}
+				if tm != nil {
+					finished.Duration = tm.total
+				}
+				s.observer.Emit(finished)
+				if err != nil {
+					if cerr := ctx.Err(); cerr != nil {
+						return cerr
+					}
+					msg := err.Error()
+					if !faultErr && !strings.Contains(msg, "connection reset by peer") && !isNamedPipeClosed(err) && !isUnixSocketClosed(err) && !s.isTLSClosed(err) {
+						return fmt.Errorf("%s: %w", desc, err)
+					}
+					pr.Errs = append(pr.Errs, err)
+				}
+				if tm != nil {
+					stats.add(name, tm)
+					pr.Durations = append(pr.Durations, tm.total)
+				}
+			}
+			return nil
+		}(); err != nil {
+			return report, err
+		}
+		if har != nil && !pr.Captured {
+			har.omit(desc)
+		}
+		if cfg.repeat > 1 {
+			s.printTimingSummary(stats[name])
+		}
+		if cfg.trackLeaks {
+			after := settleResources(before)
+			fdsAvailable = after.fds != nil
+			if l := resourceLeaks(before, after); len(l) > 0 {
+				leaks[desc] = l
+			}
+			pr.Leaks = leaks[desc]
+			s.printResourceLeaks(pr.Leaks)
+		}
+		for _, f := range s.findingsSince(mark, sevInfo) {
+			pr.Findings = append(pr.Findings, f.String())
+		}
+		if fs := s.findingsSince(mark, sevWarn); cfg.reproDir != "" && len(fs) > 0 {
+			path, err := writeRepro(cfg.reproDir, p, fs, cfg.opts)
+			if err != nil {
+				return report, fmt.Errorf("failed to write repro of %s: %w", desc, err)
+			}
+			fmt.Fprintf(s.out, "repro: bug report template written to %s\n", path)
+		}
+		report.Patterns = append(report.Patterns, pr)
+		fmt.Fprintln(s.out)
+		fmt.Fprintln(s.out, "------")
+		fmt.Fprintln(s.out)
+	}
+
+	if cfg.trackLeaks {
+		s.printLeakSummary(leaks, fdsAvailable)
+	}
+	return report, nil
+}
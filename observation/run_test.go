@@ -0,0 +1,310 @@
+package observation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"httpcli-contentlen-example/observe"
+)
+
+func TestRunWithObserver(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []observe.Event
+	)
+	o := &observe.Observer{}
+	o.OnEvent(func(e observe.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, e)
+	})
+	_, err := Run(context.Background(),
+		WithPatterns("with-len"),
+		WithBodySource("../photo.jpg"),
+		WithServerBehavior("ok"),
+		WithOutput(io.Discard),
+		WithObserver(o),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) == 0 {
+		t.Fatal("no event was delivered")
+	}
+	if e, ok := events[0].(*observe.PatternStarted); !ok || e.Pattern != reqSinglePartWithLen.String() {
+		t.Errorf("first event = %#v, want PatternStarted of with-len", events[0])
+	}
+	if e, ok := events[len(events)-1].(*observe.PatternFinished); !ok || e.Err != nil {
+		t.Errorf("last event = %#v, want PatternFinished without an error", events[len(events)-1])
+	}
+	seen := make(map[string]bool)
+	for _, e := range events {
+		switch e.(type) {
+		case *observe.ConnAccepted:
+			seen["ConnAccepted"] = true
+		case *observe.HeaderParsed:
+			seen["HeaderParsed"] = true
+		case *observe.ResponseSent:
+			seen["ResponseSent"] = true
+		}
+	}
+	for _, name := range []string{"ConnAccepted", "HeaderParsed", "ResponseSent"} {
+		if !seen[name] {
+			t.Errorf("no %s event was delivered", name)
+		}
+	}
+}
+
+// TestRunConcurrentSessions checks that settings of a Run don't leak into another one running at the same time.
+func TestRunConcurrentSessions(t *testing.T) {
+	var (
+		wg   sync.WaitGroup
+		outs [2]bytes.Buffer
+		errs [2]error
+	)
+	for i, redacted := range [][]string{{"User-Agent"}, nil} {
+		i, redacted := i, redacted
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = Run(context.Background(),
+				WithPatterns("with-len"),
+				WithBodySource("../photo.jpg"),
+				WithServerBehavior("ok"),
+				WithOutput(&outs[i]),
+				WithRedactedHeaders(redacted...),
+			)
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+	}
+	if strings.Contains(outs[0].String(), "Go-http-client") {
+		t.Errorf("User-Agent isn't redacted in the run redacting it:\n%s", outs[0].String())
+	}
+	if !strings.Contains(outs[1].String(), "Go-http-client") {
+		t.Errorf("User-Agent is redacted in the run not redacting it:\n%s", outs[1].String())
+	}
+}
+
+// TestRunCanceled checks that canceling the context aborts the request in progress.
+func TestRunCanceled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	report, err := Run(ctx,
+		WithPatterns("with-len", "without-len"),
+		WithBodySource("../photo.jpg"),
+		WithServerBehavior("ok"),
+		WithBodyDelay(10*time.Second),
+		WithOutput(io.Discard),
+	)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Run returned %v after the context was done", elapsed)
+	}
+	if len(report.Patterns) != 0 {
+		t.Errorf("report has %d patterns, want none as the first one was aborted", len(report.Patterns))
+	}
+}
+
+// teapotBehavior replies 418, counting the requests whose headers it saw.
+type teapotBehavior struct {
+	BaseBehavior
+	seen *int
+}
+
+func (b teapotBehavior) OnHeaders(*http.Request) error {
+	*b.seen++
+	return nil
+}
+
+func (teapotBehavior) Respond(w io.Writer, _ *http.Request) (bool, error) {
+	return false, writeResponse(w, http.StatusTeapot, nil, false)
+}
+
+// TestRegisterServerBehavior checks that a behavior registered with RegisterServerBehavior serves the patterns of a Run selecting it.
+func TestRegisterServerBehavior(t *testing.T) {
+	var seen int
+	RegisterServerBehavior("test-teapot", "reply 418", func() ServerBehavior { return teapotBehavior{seen: &seen} })
+	t.Cleanup(func() { unregisterServerBehavior("test-teapot") })
+	if got := DescribeServerBehavior("test-teapot"); got != "reply 418" {
+		t.Errorf("description = %q, want %q", got, "reply 418")
+	}
+	var out bytes.Buffer
+	_, err := Run(context.Background(),
+		WithPatterns("with-len"),
+		WithBodySource("../photo.jpg"),
+		WithServerBehavior("test-teapot"),
+		WithOutput(&out),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen != 1 {
+		t.Errorf("the registered behavior saw headers of %d requests, want 1\n%s", seen, out.String())
+	}
+}
+
+// TestRunOverH2 checks that a pattern reaches the HTTP/2 capture server both over TLS and in cleartext (h2c),
+// with content-length decoded from its header block.
+func TestRunOverH2(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		opt   Option
+		proto string
+	}{
+		{name: "tls", opt: WithHTTP2(true), proto: "[HTTP/2 over TLS, response HTTP/2.0]"},
+		{name: "h2c", opt: WithH2C(true), proto: "[h2c (cleartext HTTP/2 with prior knowledge), response HTTP/2.0]"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.name == "h2c" && !h2cSupported {
+				t.Skip("h2c needs Go 1.24 or later")
+			}
+			var out bytes.Buffer
+			if _, err := Run(context.Background(),
+				WithPatterns("with-len"),
+				WithBodySource("../photo.jpg"),
+				WithOutput(&out),
+				tt.opt,
+			); err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range []string{tt.proto, "content-length: 102117", "END_STREAM on a DATA frame"} {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("output doesn't contain %q:\n%s", want, out.String())
+				}
+			}
+		})
+	}
+}
+
+// TestRunRecordsEveryPattern checks that a pattern not captured by the capture server gets a stub record,
+// so that there's a record for every pattern.
+func TestRunRecordsEveryPattern(t *testing.T) {
+	var records bytes.Buffer
+	report, err := Run(context.Background(),
+		WithPatterns("seeker-rewind", "with-len"),
+		WithBodySource("../photo.jpg"),
+		WithCaptureBytes(CaptureAll),
+		WithOutput(io.Discard),
+		WithObservations(&records),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []observationRecord
+	for _, line := range strings.Split(strings.TrimSpace(records.String()), "\n") {
+		var o observationRecord
+		if err := json.Unmarshal([]byte(line), &o); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, o)
+	}
+	if len(got) != 2 {
+		t.Fatalf("%d records written, want 2:\n%s", len(got), records.String())
+	}
+	if o := got[0]; o.Name != "seeker-rewind" || !o.isStub() || o.DurationNs == 0 {
+		t.Errorf("record of seeker-rewind = %+v, want a stub with the duration", o)
+	}
+	if o := got[1]; o.Name != "with-len" || o.isStub() || o.RequestLine != "PUT / HTTP/1.1" {
+		t.Errorf("record of with-len = %+v, want the captured request", o)
+	}
+	if report.Patterns[0].Captured || !report.Patterns[1].Captured {
+		t.Errorf("Captured = %v, %v, want false, true", report.Patterns[0].Captured, report.Patterns[1].Captured)
+	}
+}
+
+// TestRunRecordsModes checks that modes sending requests elsewhere than the capture server write stub records of the patterns.
+func TestRunRecordsModes(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opt  Option
+	}{
+		{name: "http2", opt: WithHTTP2(true)},
+		{name: "forward proxy", opt: WithForwardProxy(true)},
+		{name: "reverse proxy", opt: WithReverseProxy(true)},
+		{name: "fan-out", opt: WithFanOut(2)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var records bytes.Buffer
+			if _, err := Run(context.Background(),
+				WithPatterns("with-len"),
+				WithBodySource("../photo.jpg"),
+				WithOutput(io.Discard),
+				WithObservations(&records),
+				tt.opt,
+			); err != nil {
+				t.Fatal(err)
+			}
+			var o observationRecord
+			if err := json.Unmarshal(records.Bytes(), &o); err != nil {
+				t.Fatalf("%v: %q", err, records.String())
+			}
+			if o.Name != "with-len" || !o.isStub() {
+				t.Errorf("record = %+v, want a stub of with-len", o)
+			}
+		})
+	}
+}
+
+// TestRunRestoresMemoryLimit checks that the memory limit lowered for a memory budget is restored when a run fails.
+func TestRunRestoresMemoryLimit(t *testing.T) {
+	limit := debug.SetMemoryLimit(-1)
+	if _, err := Run(context.Background(),
+		WithPatterns("with-len"),
+		WithBodySource("../photo.jpg"),
+		WithOutput(io.Discard),
+		WithObservations(&failingWriter{}),
+		WithBudget(64<<20, 0),
+	); err == nil {
+		t.Fatal("Run didn't report the error writing the observation")
+	}
+	if got := debug.SetMemoryLimit(-1); got != limit {
+		t.Errorf("memory limit = %d after the run, want %d", got, limit)
+	}
+}
+
+// idleCountingTransport counts calls of CloseIdleConnections on it.
+type idleCountingTransport struct {
+	http.RoundTripper
+	closes int
+}
+
+func (t *idleCountingTransport) CloseIdleConnections() { t.closes++ }
+
+// TestRunLeavesGivenTransport checks that idle connections of a transport given with WithTransport, which may be shared
+// outside of the Run, aren't closed, while the capture server still finishes serving the connections kept alive.
+func TestRunLeavesGivenTransport(t *testing.T) {
+	tr := &idleCountingTransport{RoundTripper: http.DefaultTransport.(*http.Transport).Clone()}
+	defer tr.RoundTripper.(*http.Transport).CloseIdleConnections()
+	if _, err := Run(context.Background(),
+		WithPatterns("with-len", "without-len"),
+		WithBodySource("../photo.jpg"),
+		WithServerBehavior("ok"),
+		WithOutput(io.Discard),
+		WithTransport(tr),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if tr.closes != 0 {
+		t.Errorf("CloseIdleConnections called %d times on the given transport", tr.closes)
+	}
+}
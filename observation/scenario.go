@@ -0,0 +1,478 @@
+package observation
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// customPattern is a pattern defined outside the built-in ones, numbered from reqPatternBound in the order added.
+type customPattern struct {
+	name, desc string
+	// build builds the request of the pattern uploading f, which is opened from opts.filename.
+	build func(f *os.File, opts runOptions) (*http.Request, error)
+}
+
+// patternSet is the patterns a session can run: the built-in ones, followed by custom ones numbered from reqPatternBound in the order added.
+// A nil set has the built-in ones only.
+type patternSet struct {
+	custom []customPattern
+}
+
+// newPatternSet returns the built-in patterns, followed by the ones registered with RegisterPattern and the scenarios of the files.
+func newPatternSet(scenarioFiles ...string) (*patternSet, error) {
+	ps := &patternSet{}
+	if err := ps.addRegistered(); err != nil {
+		return nil, err
+	}
+	for _, path := range scenarioFiles {
+		if err := ps.addScenarios(path); err != nil {
+			return nil, err
+		}
+	}
+	return ps, nil
+}
+
+// lookup returns the custom pattern p, if p is one.
+func (ps *patternSet) lookup(p reqPattern) (customPattern, bool) {
+	i := int(p - reqPatternBound)
+	if ps == nil || i < 0 || i >= len(ps.custom) {
+		return customPattern{}, false
+	}
+	return ps.custom[i], true
+}
+
+// end returns the pattern following the last one, built-in or custom.
+func (ps *patternSet) end() reqPattern {
+	if ps == nil {
+		return reqPatternBound
+	}
+	return reqPatternBound + reqPattern(len(ps.custom))
+}
+
+// all returns all patterns in order.
+func (ps *patternSet) all() []reqPattern {
+	var all []reqPattern
+	for p := reqSinglePartWithLen; p < ps.end(); p++ {
+		all = append(all, p)
+	}
+	return all
+}
+
+// name returns the short name of p, used to select it from the command line.
+func (ps *patternSet) name(p reqPattern) string {
+	if c, ok := ps.lookup(p); ok {
+		return c.name
+	}
+	return p.Name()
+}
+
+// describe returns the description of p.
+func (ps *patternSet) describe(p reqPattern) string {
+	if c, ok := ps.lookup(p); ok {
+		return c.desc
+	}
+	return p.String()
+}
+
+// add adds a custom pattern, whose name must be unique among all patterns.
+func (ps *patternSet) add(c customPattern) error {
+	if c.name == "" {
+		return fmt.Errorf("pattern name is empty")
+	}
+	if strings.Trim(c.name, "0123456789") == "" {
+		return fmt.Errorf("pattern name %q is taken as an ID", c.name)
+	}
+	for p := reqSinglePartWithLen; p < ps.end(); p++ {
+		if strings.EqualFold(ps.name(p), c.name) {
+			return fmt.Errorf("pattern %q is already defined", c.name)
+		}
+	}
+	ps.custom = append(ps.custom, c)
+	return nil
+}
+
+// scenario is a request described in a scenario file (-scenario), run as a custom pattern.
+type scenario struct {
+	name, desc string
+	method     string
+	path       string
+	headers    []string // "Name: value", where {size} in values is replaced with the size of the body
+	body       string   // "file" (the body source), "none" (nil Body) or "text:<literal>"
+	reader     string   // how the body is passed: file, bytes, strings, buffer, pipe or reader (hiding the type); see validate for the default
+	contentLen string   // "auto" (as http.NewRequest infers), "known" (the size of the body), "unknown" (-1) or a number
+	chunked    bool     // Request.TransferEncoding = ["chunked"]
+	close      bool     // Request.Close
+}
+
+// loadScenarios reads scenarios from a file in a subset of TOML: [[request]] tables of key = value lines, where values are
+// basic strings, integers, booleans or arrays of strings (which may span lines), and # starts a comment.
+func loadScenarios(path string) ([]scenario, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scenario file: %w", err)
+	}
+	var (
+		scs  []scenario
+		cur  *scenario
+		line int
+	)
+	fail := func(format string, args ...any) ([]scenario, error) {
+		return nil, fmt.Errorf("%s:%d: %s", path, line, fmt.Sprintf(format, args...))
+	}
+	lines := strings.Split(string(src), "\n")
+	for i := 0; i < len(lines); i++ {
+		line = i + 1
+		l := strings.TrimSpace(stripTOMLComment(lines[i]))
+		if l == "" {
+			continue
+		}
+		if strings.HasPrefix(l, "[") && !strings.Contains(l, "=") {
+			if l != "[[request]]" {
+				return fail("unsupported table %s (only [[request]] is)", l)
+			}
+			scs = append(scs, scenario{method: http.MethodPut, path: "/", body: "file", contentLen: "auto"})
+			cur = &scs[len(scs)-1]
+			continue
+		}
+		key, value, ok := strings.Cut(l, "=")
+		if !ok {
+			return fail("expected key = value: %q", l)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		// arrays may span lines until the closing bracket
+		for strings.HasPrefix(value, "[") && !tomlArrayClosed(value) && i+1 < len(lines) {
+			i++
+			value += " " + strings.TrimSpace(stripTOMLComment(lines[i]))
+		}
+		if cur == nil {
+			return fail("%s outside of a [[request]] table", key)
+		}
+		v, err := parseTOMLValue(value)
+		if err != nil {
+			return fail("%s: %v", key, err)
+		}
+		if err := cur.set(key, v); err != nil {
+			return fail("%v", err)
+		}
+	}
+	for i := range scs {
+		if err := scs[i].validate(); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return scs, nil
+}
+
+// set sets the field of sc named key in the scenario file.
+func (sc *scenario) set(key string, v any) error {
+	str := func(dst *string) error {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s must be a string", key)
+		}
+		*dst = s
+		return nil
+	}
+	switch key {
+	case "name":
+		return str(&sc.name)
+	case "description":
+		return str(&sc.desc)
+	case "method":
+		return str(&sc.method)
+	case "path":
+		return str(&sc.path)
+	case "body":
+		return str(&sc.body)
+	case "reader":
+		return str(&sc.reader)
+	case "headers":
+		hs, ok := v.([]string)
+		if !ok {
+			return fmt.Errorf("headers must be an array of strings")
+		}
+		sc.headers = hs
+	case "content_length":
+		switch v := v.(type) {
+		case string:
+			sc.contentLen = v
+		case int64:
+			sc.contentLen = strconv.FormatInt(v, 10)
+		default:
+			return fmt.Errorf("content_length must be a string or an integer")
+		}
+	case "transfer_encoding":
+		var te string
+		if err := str(&te); err != nil {
+			return err
+		}
+		if te != "" && te != "chunked" {
+			return fmt.Errorf("transfer_encoding must be \"chunked\" or empty: %q", te)
+		}
+		sc.chunked = te == "chunked"
+	case "close":
+		b, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("close must be a boolean")
+		}
+		sc.close = b
+	default:
+		return fmt.Errorf("unknown key %q", key)
+	}
+	return nil
+}
+
+// validate checks the settings of sc, defaulting the reader to one passing the body as it comes.
+func (sc *scenario) validate() error {
+	if sc.name == "" {
+		return fmt.Errorf("a request has no name")
+	}
+	if !strings.HasPrefix(sc.path, "/") {
+		return fmt.Errorf("%s: path must start with /: %q", sc.name, sc.path)
+	}
+	for _, h := range sc.headers {
+		if name, _, ok := strings.Cut(h, ":"); !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("%s: header must be \"Name: value\": %q", sc.name, h)
+		}
+	}
+	if sc.body != "file" && sc.body != "none" && !strings.HasPrefix(sc.body, "text:") {
+		return fmt.Errorf("%s: body must be file, none or text:<literal>: %q", sc.name, sc.body)
+	}
+	if sc.reader == "" {
+		// the body is passed as it comes: the file as *os.File, others in memory
+		sc.reader = "bytes"
+		if sc.body == "file" {
+			sc.reader = "file"
+		}
+	}
+	switch sc.reader {
+	case "file":
+		if sc.body != "file" {
+			return fmt.Errorf("%s: reader = \"file\" needs body = \"file\", not %q", sc.name, sc.body)
+		}
+	case "bytes", "strings", "buffer", "pipe", "reader":
+	default:
+		return fmt.Errorf("%s: reader must be file, bytes, strings, buffer, pipe or reader: %q", sc.name, sc.reader)
+	}
+	switch sc.contentLen {
+	case "auto", "known", "unknown":
+	default:
+		if _, err := strconv.ParseInt(sc.contentLen, 10, 64); err != nil {
+			return fmt.Errorf("%s: content_length must be auto, known, unknown or a number: %q", sc.name, sc.contentLen)
+		}
+	}
+	return nil
+}
+
+// describe returns the description of sc, generated from its settings unless given.
+func (sc scenario) describe() string {
+	if sc.desc != "" {
+		return sc.desc
+	}
+	d := fmt.Sprintf("scenario: %s %s, body %s via %s reader, ContentLength %s", sc.method, sc.path, sc.body, sc.reader, sc.contentLen)
+	if sc.chunked {
+		d += ", TransferEncoding chunked"
+	}
+	return d
+}
+
+// build builds the request of sc uploading f.
+func (sc scenario) build(f *os.File, opts runOptions) (*http.Request, error) {
+	var data []byte
+	switch {
+	case sc.body == "file" && sc.reader != "file":
+		var err error
+		if data, err = io.ReadAll(f); err != nil {
+			return nil, fmt.Errorf("failed to read body: %w", err)
+		}
+	case strings.HasPrefix(sc.body, "text:"):
+		data = []byte(strings.TrimPrefix(sc.body, "text:"))
+	}
+	size := int64(len(data))
+	var body io.Reader
+	switch {
+	case sc.body == "none":
+	case sc.reader == "file":
+		stat, err := f.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat file: %w", err)
+		}
+		size, body = stat.Size(), f
+	case sc.reader == "bytes":
+		body = bytes.NewReader(data)
+	case sc.reader == "strings":
+		body = strings.NewReader(string(data))
+	case sc.reader == "buffer":
+		body = bytes.NewBuffer(data)
+	case sc.reader == "pipe":
+		pr, pw := io.Pipe()
+		go func() {
+			// in pieces of the size the pipe pattern writes, which shape the chunks on the wire
+			for len(data) > 0 {
+				n := pipeWriteSize
+				if n > len(data) {
+					n = len(data)
+				}
+				if _, err := pw.Write(data[:n]); err != nil {
+					return
+				}
+				data = data[n:]
+			}
+			_ = pw.Close()
+		}()
+		body = pr
+	case sc.reader == "reader":
+		body = struct{ io.Reader }{bytes.NewReader(data)}
+	}
+
+	req, err := http.NewRequestWithContext(opts.ctx, sc.method, strings.TrimSuffix(opts.serverURL, "/")+sc.path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	for _, h := range sc.headers {
+		name, value, _ := strings.Cut(h, ":")
+		value = strings.ReplaceAll(strings.TrimSpace(value), "{size}", strconv.FormatInt(size, 10))
+		req.Header.Add(strings.TrimSpace(name), value)
+	}
+	switch sc.contentLen {
+	case "auto":
+	case "known":
+		req.ContentLength = size
+	case "unknown":
+		req.ContentLength = -1
+	default:
+		req.ContentLength, _ = strconv.ParseInt(sc.contentLen, 10, 64)
+	}
+	if sc.chunked {
+		req.TransferEncoding = []string{"chunked"}
+	}
+	req.Close = sc.close
+	return req, nil
+}
+
+// stripTOMLComment removes a comment starting with # outside of strings from the line.
+func stripTOMLComment(line string) string {
+	inStr := false
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && inStr:
+			i++
+		case c == '"':
+			inStr = !inStr
+		case c == '#' && !inStr:
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// tomlArrayClosed reports whether the array starting value is closed in it.
+func tomlArrayClosed(value string) bool {
+	inStr := false
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '\\' && inStr:
+			i++
+		case c == '"':
+			inStr = !inStr
+		case c == ']' && !inStr:
+			return true
+		}
+	}
+	return false
+}
+
+// parseTOMLValue parses a basic string, an integer, a boolean or an array of basic strings.
+func parseTOMLValue(s string) (any, error) {
+	switch {
+	case s == "true" || s == "false":
+		return s == "true", nil
+	case strings.HasPrefix(s, `"`):
+		v, rest, err := parseTOMLString(s)
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(rest) != "" {
+			return nil, fmt.Errorf("unexpected %q after string", rest)
+		}
+		return v, nil
+	case strings.HasPrefix(s, "["):
+		vs := []string{}
+		rest := strings.TrimSpace(s[1:])
+		for !strings.HasPrefix(rest, "]") {
+			v, r, err := parseTOMLString(rest)
+			if err != nil {
+				return nil, err
+			}
+			vs = append(vs, v)
+			rest = strings.TrimSpace(r)
+			if strings.HasPrefix(rest, ",") {
+				rest = strings.TrimSpace(rest[1:])
+			} else if !strings.HasPrefix(rest, "]") {
+				return nil, fmt.Errorf("expected , or ] in array: %q", rest)
+			}
+		}
+		if strings.TrimSpace(rest[1:]) != "" {
+			return nil, fmt.Errorf("unexpected %q after array", rest[1:])
+		}
+		return vs, nil
+	}
+	n, err := strconv.ParseInt(strings.ReplaceAll(s, "_", ""), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported value %q (strings, integers, booleans and arrays of strings are)", s)
+	}
+	return n, nil
+}
+
+// parseTOMLString parses the basic string at the start of s, returning the rest of s.
+func parseTOMLString(s string) (string, string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return "", "", fmt.Errorf("expected a string: %q", s)
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return b.String(), s[i+1:], nil
+		case '\\':
+			i++
+			if i == len(s) {
+				break
+			}
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '"', '\\':
+				b.WriteByte(s[i])
+			default:
+				return "", "", fmt.Errorf("unsupported escape \\%c", s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", fmt.Errorf("unterminated string: %q", s)
+}
+
+// addScenarios adds the scenarios of the file as custom patterns.
+func (ps *patternSet) addScenarios(path string) error {
+	scs, err := loadScenarios(path)
+	if err != nil {
+		return err
+	}
+	for _, sc := range scs {
+		if err := ps.add(customPattern{name: sc.name, desc: sc.describe(), build: sc.build}); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package observation
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestScenarioExamples(t *testing.T) {
+	const content = "0123456789abcdef"
+	size := int64(len(content))
+
+	scs, err := loadScenarios("../scenarios/examples.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		method     string
+		requestURI string
+		contentLen int64
+		header     [2]string // a header expected in Request.Header, if any
+		body       string
+		close      bool
+	}{
+		"example-json-post": {
+			method: "POST", requestURI: "/api/items?draft=true", contentLen: 41,
+			header: [2]string{"Content-Type", "application/json"}, body: `{"name": "photo.jpg", "tags": ["sample"]}`,
+		},
+		"example-sized-upload": {
+			method: "PUT", requestURI: "/upload?name=photo.jpg", contentLen: size,
+			header: [2]string{"X-Upload-Size", "16"}, body: content,
+		},
+		"example-delete": {method: "DELETE", requestURI: "/api/items/1", close: true},
+	}
+	if len(scs) != len(tests) {
+		t.Errorf("got %d examples, want %d", len(scs), len(tests))
+	}
+
+	path := writeTestFile(t, "body", content)
+	ps, err := newPatternSet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sc := range scs {
+		sc := sc
+		t.Run(sc.name, func(t *testing.T) {
+			want, ok := tests[sc.name]
+			if !ok {
+				t.Fatalf("no expectation for example %s", sc.name)
+			}
+			if _, err := ps.parse(sc.name); err == nil {
+				t.Errorf("example %s has the name of a built-in pattern", sc.name)
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			s := newSession(io.Discard)
+			s.serverURL = "http://localhost"
+			req, err := sc.build(f, runOptions{session: s})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.Method != want.method || req.URL.RequestURI() != want.requestURI {
+				t.Errorf("request = %s %s, want %s %s", req.Method, req.URL.RequestURI(), want.method, want.requestURI)
+			}
+			if req.ContentLength != want.contentLen {
+				t.Errorf("ContentLength = %d, want %d", req.ContentLength, want.contentLen)
+			}
+			if want.header[0] != "" && req.Header.Get(want.header[0]) != want.header[1] {
+				t.Errorf("%s header = %q, want %q", want.header[0], req.Header.Get(want.header[0]), want.header[1])
+			}
+			if req.Close != want.close {
+				t.Errorf("Close = %v, want %v", req.Close, want.close)
+			}
+			var got []byte
+			if req.Body != nil {
+				if got, err = io.ReadAll(req.Body); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if string(got) != want.body {
+				t.Errorf("body = %q, want %q", got, want.body)
+			}
+		})
+	}
+}
+
+func TestLoadScenarios(t *testing.T) {
+	path := writeTestFile(t, "scenario.toml", `# a comment
+[[request]]
+name = "text" # trailing comment
+method = "POST"
+path = "/upload?x=1"
+headers = [
+  "X-A: 1",   # in an array
+  "X-Size: {size}",
+]
+body = "text:a \"quoted\" # body"
+content_length = 3
+transfer_encoding = "chunked"
+close = true
+
+[[request]]
+name = "none"
+body = "none"
+`)
+	scs, err := loadScenarios(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scs) != 2 {
+		t.Fatalf("got %d scenarios, want 2", len(scs))
+	}
+	sc := scs[0]
+	if sc.method != "POST" || sc.path != "/upload?x=1" || sc.contentLen != "3" || !sc.chunked || !sc.close {
+		t.Errorf("unexpected settings: %+v", sc)
+	}
+	if want := []string{"X-A: 1", "X-Size: {size}"}; strings.Join(sc.headers, "|") != strings.Join(want, "|") {
+		t.Errorf("headers = %q, want %q", sc.headers, want)
+	}
+	if want := `text:a "quoted" # body`; sc.body != want {
+		t.Errorf("body = %q, want %q", sc.body, want)
+	}
+	// the reader defaults to one passing the body as it comes
+	if sc.reader != "bytes" {
+		t.Errorf("reader of a text body = %q, want bytes", sc.reader)
+	}
+	if scs[1].reader != "bytes" || scs[1].method != "PUT" || scs[1].path != "/" || scs[1].contentLen != "auto" {
+		t.Errorf("unexpected defaults: %+v", scs[1])
+	}
+
+	req, err := sc.build(nil, runOptions{session: newSession(io.Discard)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := req.Header.Get("X-Size"); got != "17" {
+		t.Errorf("X-Size = %q, want the size of the body, 17", got)
+	}
+	if req.ContentLength != 3 || !req.Close {
+		t.Errorf("ContentLength = %d, Close = %v, want 3 and true", req.ContentLength, req.Close)
+	}
+	req, err = scs[1].build(nil, runOptions{session: newSession(io.Discard)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Body != nil {
+		t.Errorf("Body of body none is %T, want nil", req.Body)
+	}
+}
+
+func TestLoadScenariosErrors(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		// syntax errors, reported with the line
+		{"other table", "[server]\n", ":1: unsupported table [server]"},
+		{"no equals", "[[request]]\nname\n", `:2: expected key = value: "name"`},
+		{"key outside table", "name = \"x\"\n", ":1: name outside of a [[request]] table"},
+		{"unterminated string", "[[request]]\nname = \"x\n", ":2: name: unterminated string"},
+		{"bad value", "[[request]]\nname = x\n", ":2: name:"},
+		{"unknown key", "[[request]]\nnmae = \"x\"\n", `:2: unknown key "nmae"`},
+		{"wrong type", "[[request]]\nname = 1\n", ":2: name must be a string"},
+		{"headers not strings", "[[request]]\nname = \"x\"\nheaders = \"X-A: 1\"\n", ":3: headers must be an array of strings"},
+		{"bad transfer_encoding", "[[request]]\nname = \"x\"\ntransfer_encoding = \"gzip\"\n", `:3: transfer_encoding must be "chunked" or empty`},
+		{"close not bool", "[[request]]\nname = \"x\"\nclose = \"yes\"\n", ":3: close must be a boolean"},
+
+		// validation errors, reported with the name of the request
+		{"no name", "[[request]]\nmethod = \"GET\"\n", "a request has no name"},
+		{"bad path", "[[request]]\nname = \"x\"\npath = \"upload\"\n", `x: path must start with /: "upload"`},
+		{"bad header", "[[request]]\nname = \"x\"\nheaders = [\"X-A 1\"]\n", `x: header must be "Name: value": "X-A 1"`},
+		{"bad body", "[[request]]\nname = \"x\"\nbody = \"stdin\"\n", `x: body must be file, none or text:<literal>: "stdin"`},
+		{"bad reader", "[[request]]\nname = \"x\"\nreader = \"mmap\"\n", `x: reader must be file, bytes, strings, buffer, pipe or reader: "mmap"`},
+		{"file reader without file", "[[request]]\nname = \"x\"\nbody = \"none\"\nreader = \"file\"\n", `x: reader = "file" needs body = "file", not "none"`},
+		{"bad content_length", "[[request]]\nname = \"x\"\ncontent_length = \"some\"\n", `x: content_length must be auto, known, unknown or a number: "some"`},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestFile(t, "scenario.toml", tt.src)
+			_, err := loadScenarios(path)
+			if err == nil {
+				t.Fatalf("got no error, want one containing %q", tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %q, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
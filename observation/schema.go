@@ -0,0 +1,88 @@
+package observation
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// validateSchema validates v, a value decoded from JSON, against the JSON Schema schema.
+// Only keywords used in schema/*.json are supported: type, const, properties, required, items and minimum.
+// path is the location of v in the document, used in error messages.
+func validateSchema(schema map[string]any, v any, path string) error {
+	if c, ok := schema["const"]; ok && !reflect.DeepEqual(c, v) {
+		return fmt.Errorf("%s: must be %v, got %v", path, c, v)
+	}
+	if t, ok := schema["type"].(string); ok && !isJSONType(v, t) {
+		return fmt.Errorf("%s: must be %s, got %T", path, t, v)
+	}
+	if minimum, ok := schema["minimum"].(float64); ok {
+		if n, ok := v.(float64); ok && n < minimum {
+			return fmt.Errorf("%s: must be >= %v, got %v", path, minimum, n)
+		}
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		if req, ok := schema["required"].([]any); ok {
+			for _, name := range req {
+				if _, ok := v[name.(string)]; !ok {
+					return fmt.Errorf("%s: missing required property %q", path, name)
+				}
+			}
+		}
+		props, _ := schema["properties"].(map[string]any)
+		names := make([]string, 0, len(props))
+		for name := range props {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			pv, ok := v[name]
+			if !ok {
+				continue
+			}
+			if err := validateSchema(props[name].(map[string]any), pv, path+"."+name); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, iv := range v {
+				if err := validateSchema(items, iv, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// isJSONType reports whether v, a value decoded by encoding/json, is of the JSON Schema type t.
+func isJSONType(v any, t string) bool {
+	switch t {
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	case "array":
+		_, ok := v.([]any)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		n, ok := v.(float64)
+		return ok && n == math.Trunc(n)
+	case "null":
+		return v == nil
+	default:
+		return false
+	}
+}
@@ -0,0 +1,112 @@
+package observation
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestValidateSchema(t *testing.T) {
+	const schema = `{
+		"type": "object",
+		"required": ["version", "name"],
+		"properties": {
+			"version": { "const": 1 },
+			"name": { "type": "string" },
+			"size": { "type": "integer", "minimum": 0 },
+			"ratio": { "type": "number" },
+			"done": { "type": "boolean" },
+			"parent": { "type": "null" },
+			"tags": { "type": "array", "items": { "type": "string" } },
+			"headers": {
+				"type": "array",
+				"items": {
+					"type": "object",
+					"required": ["name"],
+					"properties": { "name": { "type": "string" } }
+				}
+			}
+		}
+	}`
+	tests := []struct {
+		name string
+		doc  string
+		want string // substring of the error; valid if empty
+	}{
+		{name: "required only", doc: `{"version": 1, "name": "a"}`},
+		{name: "all properties", doc: `{"version": 1, "name": "a", "size": 3, "ratio": 0.5, "done": true, "parent": null, "tags": ["x"], "headers": [{"name": "Host"}]}`},
+		{name: "unknown property", doc: `{"version": 1, "name": "a", "extra": [1]}`},
+
+		{name: "type object", doc: `[]`, want: "$: must be object, got []interface {}"},
+		{name: "type string", doc: `{"version": 1, "name": 1}`, want: "$.name: must be string, got float64"},
+		{name: "type integer", doc: `{"version": 1, "name": "a", "size": 1.5}`, want: "$.size: must be integer"},
+		{name: "type number", doc: `{"version": 1, "name": "a", "ratio": "0.5"}`, want: "$.ratio: must be number"},
+		{name: "type boolean", doc: `{"version": 1, "name": "a", "done": 1}`, want: "$.done: must be boolean"},
+		{name: "type null", doc: `{"version": 1, "name": "a", "parent": {}}`, want: "$.parent: must be null"},
+		{name: "type array", doc: `{"version": 1, "name": "a", "tags": "x"}`, want: "$.tags: must be array"},
+		{name: "const", doc: `{"version": 2, "name": "a"}`, want: "$.version: must be 1, got 2"},
+		{name: "minimum", doc: `{"version": 1, "name": "a", "size": -1}`, want: "$.size: must be >= 0, got -1"},
+		{name: "minimum boundary", doc: `{"version": 1, "name": "a", "size": 0}`},
+		{name: "required", doc: `{"version": 1}`, want: `$: missing required property "name"`},
+		{name: "items", doc: `{"version": 1, "name": "a", "tags": ["x", 2]}`, want: "$.tags[1]: must be string"},
+		{name: "required in items", doc: `{"version": 1, "name": "a", "headers": [{"name": "Host"}, {}]}`, want: `$.headers[1]: missing required property "name"`},
+		{name: "properties in items", doc: `{"version": 1, "name": "a", "headers": [{"name": false}]}`, want: "$.headers[0].name: must be string"},
+	}
+
+	var s map[string]any
+	if err := json.Unmarshal([]byte(schema), &s); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		var v any
+		if err := json.Unmarshal([]byte(tt.doc), &v); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		err := validateSchema(s, v, "$")
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		case tt.want != "" && err == nil:
+			t.Errorf("%s: no error, want %q", tt.name, tt.want)
+		case tt.want != "" && !strings.Contains(err.Error(), tt.want):
+			t.Errorf("%s: error = %q, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+// TestObservationConformsToSchema checks that a record of a chunked request with a trailer passes the embedded schema.
+func TestObservationConformsToSchema(t *testing.T) {
+	raw := "PUT / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\n" +
+		"5\r\nhello\r\n0\r\nX-Sum: 42\r\n\r\n"
+	o := newSession(nil).newObservation("chunked with a trailer", capturedRequest{raw: []byte(raw)})
+	if got, want := len(o.Chunks), 2; got != want {
+		t.Errorf("chunks = %v, want %d", o.Chunks, want)
+	}
+	if len(o.Trailers) != 1 || o.Trailers[0] != (observedHeader{Name: "X-Sum", Value: "42"}) {
+		t.Errorf("trailers = %v, want X-Sum: 42", o.Trailers)
+	}
+
+	var b strings.Builder
+	w, err := newObservationWriter(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.write(o); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestStubConformsToSchema checks that a stub record of a run the capture server didn't capture passes the embedded schema.
+func TestStubConformsToSchema(t *testing.T) {
+	o := uncapturedObservation("with-len", "with-len")
+	o.DurationNs = 1000
+	o.Error = "connection refused"
+	w, err := newObservationWriter(io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.write(o); err != nil {
+		t.Fatal(err)
+	}
+}
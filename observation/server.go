@@ -0,0 +1,371 @@
+package observation
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"httpcli-contentlen-example/observe"
+)
+
+// ServerBehavior customizes how the capture server treats requests it receives.
+// Returning an error from any method closes the connection immediately, which is useful to inject faults.
+//
+// To add a custom behavior, implement this interface (embedding BaseBehavior to inherit no-op hooks)
+// and register it with RegisterServerBehavior in an init function. It then becomes selectable with WithServerBehavior and the -server flag.
+type ServerBehavior interface {
+	// OnAccept is called right after a connection is accepted.
+	OnAccept(conn net.Conn) error
+	// OnHeaders is called once the request line and headers are read.
+	OnHeaders(req *http.Request) error
+	// OnBodyChunk is called for each piece of the request body (with chunked framing removed) as it is read.
+	OnBodyChunk(req *http.Request, chunk []byte) error
+	// Respond writes a raw HTTP response for the request to w after the whole body is read.
+	// If keepAlive is false, the server closes the connection after the response, so the response should carry "Connection: close".
+	Respond(w io.Writer, req *http.Request) (keepAlive bool, err error)
+}
+
+// loggingBehavior is a ServerBehavior logging what it observes, to the output of the session serving it.
+type loggingBehavior interface {
+	ServerBehavior
+	setLog(w io.Writer)
+}
+
+// BaseBehavior provides no-op hooks and a plain "200 OK" response closing the connection, as the "ok" behavior does.
+// Custom behaviors embed it to implement only the methods they change.
+type BaseBehavior struct{}
+
+func (BaseBehavior) OnAccept(net.Conn) error                 { return nil }
+func (BaseBehavior) OnHeaders(*http.Request) error           { return nil }
+func (BaseBehavior) OnBodyChunk(*http.Request, []byte) error { return nil }
+func (BaseBehavior) Respond(w io.Writer, _ *http.Request) (bool, error) {
+	return false, writeResponse(w, http.StatusOK, nil, false)
+}
+
+// writeResponse writes a raw HTTP/1.1 response without body.
+func writeResponse(w io.Writer, status int, header http.Header, keepAlive bool) error {
+	return writeResponseBody(w, status, header, nil, keepAlive)
+}
+
+// writeResponseBody writes a raw HTTP/1.1 response with body. Content-Length is omitted for statuses which can't have a body.
+func writeResponseBody(w io.Writer, status int, header http.Header, body []byte, keepAlive bool) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+	_ = header.Write(&b)
+	if status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified {
+		fmt.Fprintf(&b, "Content-Length: %d\r\n", len(body))
+	}
+	if !keepAlive {
+		b.WriteString("Connection: close\r\n")
+	}
+	b.WriteString("\r\n")
+	b.Write(body)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+type serverBehaviorEntry struct {
+	desc string
+	new  func() ServerBehavior
+}
+
+var (
+	serverBehaviorsMu sync.Mutex
+	serverBehaviors   = make(map[string]serverBehaviorEntry)
+)
+
+// RegisterServerBehavior makes a server behavior selectable by name with WithServerBehavior and -server, where desc is listed.
+// It's meant to be called from init functions of packages imported by the CLI, as RegisterPattern is:
+//
+//	type slowReply struct{ observation.BaseBehavior }
+//
+//	func (slowReply) Respond(w io.Writer, req *http.Request) (bool, error) {
+//		time.Sleep(time.Second)
+//		return observation.BaseBehavior{}.Respond(w, req)
+//	}
+//
+//	func init() {
+//		observation.RegisterServerBehavior("slow-reply", "read whole request and reply 200 after 1s", func() observation.ServerBehavior {
+//			return slowReply{}
+//		})
+//	}
+//
+// newBehavior is called for every pattern so that behaviors can keep per-run state.
+// RegisterServerBehavior panics if name is empty or already registered, or newBehavior is nil.
+func RegisterServerBehavior(name, desc string, newBehavior func() ServerBehavior) {
+	serverBehaviorsMu.Lock()
+	defer serverBehaviorsMu.Unlock()
+
+	if name == "" {
+		panic("observation: RegisterServerBehavior with an empty name")
+	}
+	if newBehavior == nil {
+		panic("observation: RegisterServerBehavior with a nil newBehavior func for " + name)
+	}
+	if _, dup := serverBehaviors[name]; dup {
+		panic(fmt.Sprintf("observation: server behavior %q is registered twice", name))
+	}
+	serverBehaviors[name] = serverBehaviorEntry{desc: desc, new: newBehavior}
+}
+
+// unregisterServerBehavior removes the server behavior registered with the name, for tests registering behaviors of their own.
+func unregisterServerBehavior(name string) {
+	serverBehaviorsMu.Lock()
+	defer serverBehaviorsMu.Unlock()
+	delete(serverBehaviors, name)
+}
+
+// lookupServerBehavior returns the server behavior registered with the name.
+func lookupServerBehavior(name string) (serverBehaviorEntry, bool) {
+	serverBehaviorsMu.Lock()
+	defer serverBehaviorsMu.Unlock()
+	e, ok := serverBehaviors[name]
+	return e, ok
+}
+
+// ServerBehaviors returns the names of the server behaviors selectable with WithServerBehavior, sorted.
+func ServerBehaviors() []string {
+	serverBehaviorsMu.Lock()
+	defer serverBehaviorsMu.Unlock()
+	names := make([]string, 0, len(serverBehaviors))
+	for name := range serverBehaviors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DescribeServerBehavior returns the one-line description of the named server behavior, or "" if there's no such behavior.
+func DescribeServerBehavior(name string) string {
+	e, _ := lookupServerBehavior(name)
+	return e.desc
+}
+
+func init() {
+	RegisterServerBehavior("ok", "read whole request and reply 200", func() ServerBehavior {
+		return BaseBehavior{}
+	})
+	RegisterServerBehavior("keep-alive", "read whole request and reply 200, keeping the connection open unless the client sends 'Connection: close'", func() ServerBehavior {
+		return keepAliveBehavior{}
+	})
+	RegisterServerBehavior("early-hints", "send 103 Early Hints right after reading headers, then reply 200 after reading the whole body", func() ServerBehavior {
+		return &earlyHintsBehavior{}
+	})
+	RegisterServerBehavior("auth-challenge", "reply 401 with Basic auth challenge to requests without Authorization header", func() ServerBehavior {
+		return authChallengeBehavior{}
+	})
+}
+
+// keepAliveBehavior replies 200 and keeps the connection open for subsequent requests, unless the client asks to close it.
+type keepAliveBehavior struct {
+	BaseBehavior
+}
+
+func (keepAliveBehavior) Respond(w io.Writer, req *http.Request) (bool, error) {
+	keepAlive := !req.Close
+	return keepAlive, writeResponse(w, http.StatusOK, nil, keepAlive)
+}
+
+// authChallengeBehavior replies 401 to requests without Authorization header and 200 to ones with it.
+type authChallengeBehavior struct {
+	BaseBehavior
+}
+
+func (authChallengeBehavior) Respond(w io.Writer, req *http.Request) (bool, error) {
+	if req.Header.Get("Authorization") == "" {
+		hdr := http.Header{"Www-Authenticate": {`Basic realm="observation"`}}
+		return true, writeResponse(w, http.StatusUnauthorized, hdr, true)
+	}
+	return false, writeResponse(w, http.StatusOK, nil, false)
+}
+
+// capturedRequest is a request received by the capture server.
+type capturedRequest struct {
+	raw  []byte // bytes on the wire, up to captureLimits.mem of the session
+	req  *http.Request
+	body []byte // with chunked framing removed, up to captureLimits.mem of the session
+
+	rawDropped  int64  // bytes on the wire not kept in raw
+	bodyDropped int64  // bytes of the body not kept in body
+	spilled     string // file having the whole request on the wire, if raw didn't fit in memory
+}
+
+// serveBehavior serves requests on conn accepted by the capture server with b until the connection is closed.
+// Logs first serverCaptureBytes of each request unless quiet, and sends captured requests to captures if it's non-nil.
+// Reads from the connection are throttled as configured in the session.
+func (s *session) serveBehavior(conn net.Conn, b ServerBehavior, captures chan<- capturedRequest, quiet bool) {
+	if err := s.handshakeCapture(conn, quiet); err != nil {
+		s.logServerClose(err)
+		conn.Close()
+		return
+	}
+	tc, err := s.readThrottle.apply(conn)
+	if err != nil {
+		s.logServerClose(fmt.Errorf("failed to throttle reads: %w", err))
+		conn.Close()
+		return
+	}
+	s.serveConn(tc, b, captures, quiet)
+}
+
+// serveConn serves requests on conn with b until the connection is closed. See serveBehavior.
+func (s *session) serveConn(conn net.Conn, b ServerBehavior, captures chan<- capturedRequest, quiet bool) {
+	defer conn.Close()
+	remote := conn.RemoteAddr().String()
+	s.observer.Emit(&observe.ConnAccepted{RemoteAddr: remote})
+
+	if lb, ok := b.(loggingBehavior); ok {
+		lb.setLog(s.out)
+	}
+	if cb, ok := b.(cannedResponder); ok {
+		cb.setResponse(s.cannedResponse)
+	}
+	if err := b.OnAccept(conn); err != nil {
+		s.logServerClose(err)
+		return
+	}
+
+	raw := &spillBuffer{limits: s.captureLimits, redactor: headRedactor{names: s.headerRedaction}}
+	defer raw.Reset()
+	br := bufio.NewReader(io.TeeReader(conn, raw))
+	for n := 1; ; n++ {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				s.logServerClose(err)
+			}
+			return
+		}
+		s.observer.Emit(&observe.HeaderParsed{RemoteAddr: remote, Method: req.Method, RequestURI: req.RequestURI, Header: req.Header, ContentLength: req.ContentLength})
+		if err := b.OnHeaders(req); err != nil {
+			s.dumpCapture(n, raw, quiet)
+			s.logServerClose(err)
+			return
+		}
+
+		var (
+			body    bytes.Buffer
+			bodyLen int64
+		)
+		chunk := make([]byte, 32*1024)
+		for {
+			k, err := req.Body.Read(chunk)
+			if k > 0 {
+				if room := s.captureLimits.mem - int64(body.Len()); room > 0 {
+					if int64(k) < room {
+						room = int64(k)
+					}
+					body.Write(chunk[:room])
+				}
+				bodyLen += int64(k)
+				s.observer.Emit(&observe.ChunkReceived{RemoteAddr: remote, Size: k, Total: bodyLen})
+				if err := b.OnBodyChunk(req, chunk[:k]); err != nil {
+					s.dumpCapture(n, raw, quiet)
+					s.logServerClose(err)
+					return
+				}
+			}
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				s.dumpCapture(n, raw, quiet)
+				s.logServerClose(err)
+				return
+			}
+		}
+
+		if captures != nil {
+			captures <- capturedRequest{
+				raw: append([]byte(nil), raw.Bytes()...), req: req, body: body.Bytes(),
+				rawDropped: raw.dropped(), bodyDropped: bodyLen - int64(body.Len()), spilled: raw.spilled(),
+			}
+		}
+		s.dumpCapture(n, raw, quiet)
+
+		sniffer := &statusSniffer{w: conn}
+		keepAlive, err := b.Respond(sniffer, req)
+		s.observer.Emit(&observe.ResponseSent{RemoteAddr: remote, StatusCode: sniffer.status, KeepAlive: keepAlive, Err: err})
+		if err != nil {
+			s.logServerClose(err)
+			return
+		}
+		if !keepAlive {
+			return
+		}
+	}
+}
+
+// startEphemeralServer starts a capture server dedicated to an experiment on an ephemeral port,
+// which serves every connection with a behavior created by newBehavior. Call stop to shut it down.
+func (s *session) startEphemeralServer(newBehavior func() ServerBehavior, captures chan<- capturedRequest, quiet bool) (url string, stop func(), err error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to start listening: %w", err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serveConn(conn, newBehavior(), captures, quiet)
+		}
+	}()
+	return "http://" + l.Addr().String(), func() { _ = l.Close() }, nil
+}
+
+// dumpCapture logs first serverCaptureBytes of the n-th request captured in raw, then resets raw for the next request.
+func (s *session) dumpCapture(n int, raw *spillBuffer, quiet bool) {
+	defer raw.Reset()
+	if quiet {
+		return
+	}
+	fmt.Fprintf(s.out, "[request %d]\n", n)
+	logged := raw.Bytes()
+	if s.captureBytes != CaptureAll && int64(len(logged)) > s.captureBytes {
+		logged = logged[:s.captureBytes]
+	}
+	s.printRaw(logged)
+	fmt.Fprintln(s.out)
+	fmt.Fprintln(s.out)
+	if f := raw.spilled(); f != "" {
+		fmt.Fprintf(s.out, "capture: %d bytes on the wire, the first %d kept in memory, the whole request streamed to %s\n", raw.n, len(raw.Bytes()), f)
+	}
+	if s.headerSizes {
+		s.printHeaderSizeReport(raw.Bytes())
+	}
+	s.printMessageViolations(raw.Bytes())
+	fmt.Fprintln(s.out)
+}
+
+func (s *session) logServerClose(err error) {
+	fmt.Fprintf(s.out, "server: closing connection: %v\n", err)
+}
+
+// earlyHintsBehavior sends "103 Early Hints" as soon as headers are read, before the body arrives.
+type earlyHintsBehavior struct {
+	BaseBehavior
+	conn net.Conn
+}
+
+func (b *earlyHintsBehavior) OnAccept(conn net.Conn) error {
+	b.conn = conn
+	return nil
+}
+
+func (b *earlyHintsBehavior) OnHeaders(*http.Request) error {
+	_, err := io.WriteString(b.conn, "HTTP/1.1 103 Early Hints\r\n"+
+		"Link: </style.css>; rel=preload; as=style\r\n"+
+		"Link: </script.js>; rel=preload; as=script\r\n"+
+		"\r\n")
+	return err
+}
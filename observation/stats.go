@@ -0,0 +1,296 @@
+package observation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// significance level of the comparison between two runs
+const significanceLevel = 0.05
+
+// timing holds durations from the start of a request until its lifecycle events.
+// Fields are set by the trace hooks until finish, and may be read without locking after it.
+type timing struct {
+	start        time.Time
+	wroteHeaders time.Duration
+	wroteRequest time.Duration
+	total        time.Duration
+
+	mu       sync.Mutex
+	finished bool // hooks called after finish, e.g. by the HTTP/2 transport after the response, are ignored
+}
+
+// traceTiming attaches httptrace hooks measuring timing of the request.
+// If the request is retried, the first attempt is measured. Call finish after the request is done.
+func traceTiming(req *http.Request) (*http.Request, *timing) {
+	t := &timing{start: time.Now()}
+	trace := &httptrace.ClientTrace{
+		WroteHeaders: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if !t.finished && t.wroteHeaders == 0 {
+				t.wroteHeaders = time.Since(t.start)
+			}
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if !t.finished && t.wroteRequest == 0 {
+				t.wroteRequest = time.Since(t.start)
+			}
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace)), t
+}
+
+func (t *timing) finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.total = time.Since(t.start)
+	t.finished = true
+}
+
+// metrics returns measured durations in milliseconds, keyed by metric name.
+func (t *timing) metrics() map[string]float64 {
+	m := map[string]float64{"total": msec(t.total)}
+	if t.wroteHeaders != 0 {
+		m["headers-written"] = msec(t.wroteHeaders)
+	}
+	if t.wroteRequest != 0 {
+		m["request-written"] = msec(t.wroteRequest)
+	}
+	return m
+}
+
+func msec(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// runStats holds timing samples (in milliseconds) of a whole run: pattern name (reqPattern.Name, stable across
+// changes to descriptions) -> metric name -> samples.
+type runStats map[string]map[string][]float64
+
+func (s runStats) add(pattern string, t *timing) {
+	if s[pattern] == nil {
+		s[pattern] = make(map[string][]float64)
+	}
+	for name, v := range t.metrics() {
+		s[pattern][name] = append(s[pattern][name], v)
+	}
+}
+
+// Stats is timing samples of a run saved with (*Report).SaveStats, to compare other runs against.
+type Stats struct {
+	samples runStats
+}
+
+// LoadStats loads timing samples saved with (*Report).SaveStats (-compare-stats).
+func LoadStats(filename string) (*Stats, error) {
+	s, err := loadRunStats(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &Stats{samples: s}, nil
+}
+
+// SaveStats saves the timing samples of the run to the file, for later comparison with CompareStats (-save-stats).
+func (r *Report) SaveStats(filename string) error {
+	return r.stats.save(filename)
+}
+
+// CompareStats prints a comparison of the timing samples of the run against the base ones for each pattern and metric
+// to w, flagging statistically significant differences (-compare-stats).
+func (r *Report) CompareStats(w io.Writer, base *Stats) {
+	(&session{out: w}).compareRunStats(base.samples, r.stats)
+}
+
+func (s runStats) save(filename string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode stats: %w", err)
+	}
+	if err := os.WriteFile(filename, b, 0o644); err != nil {
+		return fmt.Errorf("failed to save stats: %w", err)
+	}
+	return nil
+}
+
+func loadRunStats(filename string) (runStats, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load stats: %w", err)
+	}
+	var s runStats
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("failed to decode stats: %w", err)
+	}
+	return s, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// summary is descriptive statistics of samples.
+type summary struct {
+	n      int
+	mean   float64
+	median float64
+	p95    float64
+	stddev float64 // sample standard deviation
+}
+
+func summarize(samples []float64) summary {
+	s := summary{n: len(samples)}
+	if s.n == 0 {
+		return s
+	}
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	s.mean = sum / float64(s.n)
+	s.median = percentile(sorted, 50)
+	s.p95 = percentile(sorted, 95)
+	if s.n > 1 {
+		var sq float64
+		for _, v := range sorted {
+			sq += (v - s.mean) * (v - s.mean)
+		}
+		s.stddev = math.Sqrt(sq / float64(s.n-1))
+	}
+	return s
+}
+
+// percentile computes p-th percentile of sorted samples with linear interpolation.
+func percentile(sorted []float64, p float64) float64 {
+	pos := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
+
+func (s summary) String() string {
+	return fmt.Sprintf("mean %.3fms, median %.3fms, p95 %.3fms, stddev %.3fms (n=%d)", s.mean, s.median, s.p95, s.stddev, s.n)
+}
+
+func (s *session) printTimingSummary(metrics map[string][]float64) {
+	fmt.Fprintf(s.out, "Timing over %d runs:\n", len(metrics["total"]))
+	for _, name := range sortedKeys(metrics) {
+		fmt.Fprintf(s.out, "  %-16s %v\n", name+":", summarize(metrics[name]))
+	}
+}
+
+// compareRunStats compares timing samples of the base run and the current run for each pattern and metric with Welch's t-test,
+// and flags statistically significant differences.
+func (s *session) compareRunStats(base, cur runStats) {
+	fmt.Fprintf(s.out, "Comparison against the base run (Welch's t-test, significance level %v):\n", significanceLevel)
+	for _, pat := range sortedKeys(cur) {
+		if base[pat] == nil {
+			continue
+		}
+		fmt.Fprintf(s.out, "%s\n", pat)
+		for _, name := range sortedKeys(cur[pat]) {
+			b, c := summarize(base[pat][name]), summarize(cur[pat][name])
+			if b.n < 2 || c.n < 2 {
+				continue
+			}
+			p := welchTTest(b, c)
+			mark := ""
+			if p < significanceLevel {
+				mark = "  <- SIGNIFICANT"
+			}
+			fmt.Fprintf(s.out, "  %-16s base %.3fms -> current %.3fms (%+.1f%%), p=%.4f%s\n", name+":", b.mean, c.mean, 100*(c.mean-b.mean)/b.mean, p, mark)
+		}
+	}
+}
+
+// welchTTest returns two-tailed p-value of Welch's t-test for the difference of means.
+// It's 1 (no evidence of a difference) if either has fewer than 2 samples, whose variance is unknown.
+func welchTTest(a, b summary) float64 {
+	if a.n < 2 || b.n < 2 {
+		return 1
+	}
+	va, vb := a.stddev*a.stddev/float64(a.n), b.stddev*b.stddev/float64(b.n)
+	if va+vb == 0 {
+		if a.mean == b.mean {
+			return 1
+		}
+		return 0
+	}
+	t := (a.mean - b.mean) / math.Sqrt(va+vb)
+	df := (va + vb) * (va + vb) / (va*va/float64(a.n-1) + vb*vb/float64(b.n-1))
+	return regIncBeta(df/2, 0.5, df/(df+t*t))
+}
+
+// regIncBeta computes the regularized incomplete beta function I_x(a, b).
+func regIncBeta(a, b, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	lga, _ := math.Lgamma(a)
+	lgb, _ := math.Lgamma(b)
+	lgab, _ := math.Lgamma(a + b)
+	front := math.Exp(lgab - lga - lgb + a*math.Log(x) + b*math.Log(1-x))
+	// the continued fraction converges rapidly for x < (a+1)/(a+b+2)
+	if x < (a+1)/(a+b+2) {
+		return front * betaContFrac(a, b, x) / a
+	}
+	return 1 - front*betaContFrac(b, a, 1-x)/b
+}
+
+// betaContFrac evaluates the continued fraction for the incomplete beta function by modified Lentz's method.
+func betaContFrac(a, b, x float64) float64 {
+	const (
+		maxIter = 200
+		eps     = 1e-14
+		tiny    = 1e-300
+	)
+	c, d := 1.0, 1-(a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+	for m := 1; m <= maxIter; m++ {
+		fm := float64(m)
+		for _, num := range []float64{
+			fm * (b - fm) * x / ((a + 2*fm - 1) * (a + 2*fm)),
+			-(a + fm) * (a + b + fm) * x / ((a + 2*fm) * (a + 2*fm + 1)),
+		} {
+			d = 1 + num*d
+			if math.Abs(d) < tiny {
+				d = tiny
+			}
+			c = 1 + num/c
+			if math.Abs(c) < tiny {
+				c = tiny
+			}
+			d = 1 / d
+			h *= d * c
+		}
+		if math.Abs(d*c-1) < eps {
+			break
+		}
+	}
+	return h
+}
@@ -0,0 +1,89 @@
+package observation
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRegIncBeta(t *testing.T) {
+	tests := []struct {
+		a, b, x float64
+		want    float64
+	}{
+		{a: 2, b: 3, x: 0, want: 0},
+		{a: 2, b: 3, x: 1, want: 1},
+		{a: 2, b: 3, x: -0.5, want: 0},
+		{a: 2, b: 3, x: 1.5, want: 1},
+		// I_x(a, 1) = x^a
+		{a: 3, b: 1, x: 0.5, want: 0.125},
+		// I_x(1, b) = 1 - (1-x)^b
+		{a: 1, b: 4, x: 0.25, want: 1 - math.Pow(0.75, 4)},
+		// symmetric around 1/2
+		{a: 5, b: 5, x: 0.5, want: 0.5},
+		{a: 0.5, b: 0.5, x: 0.5, want: 0.5},
+		// the binomial sum: sum_{j=2}^{4} C(4,j) 0.4^j 0.6^(4-j)
+		{a: 2, b: 3, x: 0.4, want: 0.5248},
+		// x above (a+1)/(a+b+2), evaluated through I_x(a, b) = 1 - I_{1-x}(b, a)
+		{a: 2, b: 3, x: 0.9, want: 1 - 4*0.1*0.1*0.1*0.9 - 0.1*0.1*0.1*0.1},
+	}
+	for _, tt := range tests {
+		if got := regIncBeta(tt.a, tt.b, tt.x); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("regIncBeta(%v, %v, %v) = %v, want %v", tt.a, tt.b, tt.x, got, tt.want)
+		}
+	}
+}
+
+func TestWelchTTest(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float64
+		want float64
+	}{
+		{
+			// p-value computed by integrating the density of Student's t-distribution (t = -1.897, df = 5.882)
+			name: "different means",
+			a:    []float64{1, 2, 3, 4, 5},
+			b:    []float64{2, 4, 6, 8, 10},
+			want: 0.1075312,
+		},
+		{
+			name: "same samples",
+			a:    []float64{1, 2, 3},
+			b:    []float64{1, 2, 3},
+			want: 1,
+		},
+		{
+			name: "zero variance, same means",
+			a:    []float64{2, 2, 2},
+			b:    []float64{2, 2},
+			want: 1,
+		},
+		{
+			name: "zero variance, different means",
+			a:    []float64{2, 2, 2},
+			b:    []float64{3, 3},
+			want: 0,
+		},
+		{
+			name: "one sample",
+			a:    []float64{1},
+			b:    []float64{2, 4, 6},
+			want: 1,
+		},
+		{
+			name: "no samples",
+			a:    nil,
+			b:    []float64{2, 4, 6},
+			want: 1,
+		},
+	}
+	for _, tt := range tests {
+		a, b := summarize(tt.a), summarize(tt.b)
+		if got := welchTTest(a, b); math.Abs(got-tt.want) > 1e-6 {
+			t.Errorf("%s: welchTTest = %v, want %v", tt.name, got, tt.want)
+		}
+		if got, rev := welchTTest(a, b), welchTTest(b, a); got != rev {
+			t.Errorf("%s: welchTTest isn't symmetric: %v, reversed %v", tt.name, got, rev)
+		}
+	}
+}
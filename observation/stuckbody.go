@@ -0,0 +1,254 @@
+package observation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// bytes of the body sent before the body reader gets stuck
+	stuckBodyPrefix = 16 << 10
+	// timeouts tried to rescue stuck requests
+	stuckBodyTimeout = 500 * time.Millisecond
+)
+
+var errStuckBodyReleased = errors.New("stuck body released by the experiment")
+
+// stuckBody yields the prefix, then blocks in Read until released, ignoring Close as a reader stuck on a dead upstream would.
+type stuckBody struct {
+	prefix  []byte
+	release chan struct{}
+
+	mu        sync.Mutex
+	start     time.Time
+	blockedAt time.Duration // when Read got stuck, zero if not yet
+	closedAt  time.Duration // when Close was called, zero if not yet
+	reading   bool          // a Read call is blocked now
+}
+
+func newStuckBody(prefix []byte) *stuckBody {
+	return &stuckBody{prefix: prefix, release: make(chan struct{}), start: time.Now()}
+}
+
+func (b *stuckBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	if len(b.prefix) > 0 {
+		n := copy(p, b.prefix)
+		b.prefix = b.prefix[n:]
+		b.mu.Unlock()
+		return n, nil
+	}
+	if b.blockedAt == 0 {
+		b.blockedAt = time.Since(b.start)
+	}
+	b.reading = true
+	b.mu.Unlock()
+
+	<-b.release
+
+	b.mu.Lock()
+	b.reading = false
+	b.mu.Unlock()
+	return 0, errStuckBodyReleased
+}
+
+func (b *stuckBody) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closedAt == 0 {
+		b.closedAt = time.Since(b.start)
+	}
+	return nil
+}
+
+// stuckBodyBehavior counts body bytes received and, if idle is non-zero, gives up on a request whose body makes no progress for that long.
+type stuckBodyBehavior struct {
+	BaseBehavior
+	idle time.Duration
+	conn net.Conn
+
+	mu       sync.Mutex
+	received int
+	headers  bool
+}
+
+func (b *stuckBodyBehavior) OnAccept(conn net.Conn) error {
+	b.conn = conn
+	return nil
+}
+
+func (b *stuckBodyBehavior) OnHeaders(*http.Request) error {
+	b.mu.Lock()
+	b.headers = true
+	b.mu.Unlock()
+	if b.idle > 0 {
+		return b.conn.SetReadDeadline(time.Now().Add(b.idle))
+	}
+	return nil
+}
+
+func (b *stuckBodyBehavior) OnBodyChunk(_ *http.Request, chunk []byte) error {
+	b.mu.Lock()
+	b.received += len(chunk)
+	b.mu.Unlock()
+	if b.idle > 0 {
+		return b.conn.SetReadDeadline(time.Now().Add(b.idle))
+	}
+	return nil
+}
+
+// stuckBodyCase is a client configuration tried against a stuck body.
+type stuckBodyCase struct {
+	desc       string
+	setup      func(ctx context.Context, cli *http.Client, tr *http.Transport) (context.Context, context.CancelFunc) // deriving from ctx
+	serverIdle time.Duration                                                                                         // idle timeout of the server, none if zero
+}
+
+var stuckBodyCases = []stuckBodyCase{
+	{desc: "defaults (no timeouts)"},
+	{
+		desc: fmt.Sprintf("Transport.ResponseHeaderTimeout = %v", stuckBodyTimeout),
+		setup: func(ctx context.Context, _ *http.Client, tr *http.Transport) (context.Context, context.CancelFunc) {
+			tr.ResponseHeaderTimeout = stuckBodyTimeout
+			return context.WithCancel(ctx)
+		},
+	},
+	{
+		desc: fmt.Sprintf("Client.Timeout = %v", stuckBodyTimeout),
+		setup: func(ctx context.Context, cli *http.Client, _ *http.Transport) (context.Context, context.CancelFunc) {
+			cli.Timeout = stuckBodyTimeout
+			return context.WithCancel(ctx)
+		},
+	},
+	{
+		desc: fmt.Sprintf("context.WithTimeout(%v)", stuckBodyTimeout),
+		setup: func(ctx context.Context, _ *http.Client, _ *http.Transport) (context.Context, context.CancelFunc) {
+			return context.WithTimeout(ctx, stuckBodyTimeout)
+		},
+	},
+	{desc: fmt.Sprintf("server closes after %v without body progress", stuckBodyTimeout), serverIdle: stuckBodyTimeout},
+}
+
+// observeStuckBody uploads a body which gets stuck forever after stuckBodyPrefix bytes, under several timeout configurations.
+// A watchdog reports requests still not done after opts.stuckAfter as not rescued, then cancels them, waiting stuckBodyTimeout for Do to return.
+// Reports how long the Transport waited, the error it returned, whether it closed the body and the server-side view of the half-sent request.
+func observeStuckBody(opts runOptions) (*timing, error) {
+	data, err := os.ReadFile(opts.filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if len(data) > stuckBodyPrefix {
+		data = data[:stuckBodyPrefix]
+	}
+
+	var first *timing
+	for _, c := range stuckBodyCases {
+		behavior := &stuckBodyBehavior{idle: c.serverIdle}
+		url, stop, err := opts.startEphemeralServer(func() ServerBehavior { return behavior }, nil, true)
+		if err != nil {
+			return nil, err
+		}
+
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		cli := &http.Client{Transport: tr}
+		ctx, cancel := context.WithCancel(opts.ctx)
+		if c.setup != nil {
+			cancel()
+			ctx, cancel = c.setup(opts.ctx, cli, tr)
+		}
+
+		body := newStuckBody(data)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, body)
+		if err != nil {
+			cancel()
+			stop()
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+		req, tm := traceTiming(req)
+
+		done := make(chan error, 1)
+		go func() {
+			resp, err := cli.Do(req)
+			if err == nil {
+				_, _ = io.Copy(io.Discard, resp.Body)
+				_ = resp.Body.Close()
+				err = fmt.Errorf("unexpectedly got a response: %s", resp.Status)
+			}
+			done <- err
+		}()
+
+		var (
+			reqErr        error
+			rescued       = true
+			cancelIgnored bool // Do didn't return even after canceling, until the body was released
+		)
+		select {
+		case reqErr = <-done:
+			tm.finish()
+		case <-time.After(opts.stuckAfter):
+			rescued = false
+			tm.finish()
+			cancel()
+			select {
+			case reqErr = <-done:
+			case <-time.After(stuckBodyTimeout):
+				cancelIgnored = true
+			}
+		}
+		// let the write loop react to the request being done, before looking at the body
+		time.Sleep(50 * time.Millisecond)
+		body.mu.Lock()
+		blockedAt, closedAt, reading := body.blockedAt, body.closedAt, body.reading
+		body.mu.Unlock()
+		behavior.mu.Lock()
+		headers, received := behavior.headers, behavior.received
+		behavior.mu.Unlock()
+
+		close(body.release)
+		if cancelIgnored {
+			reqErr = <-done
+		}
+		cancel()
+		tr.CloseIdleConnections()
+		stop()
+
+		if first == nil {
+			first = tm
+		}
+		if opts.quiet {
+			continue
+		}
+
+		fmt.Fprintf(opts.out, "[%s]\n", c.desc)
+		fmt.Fprintf(opts.out, "  body got stuck at %v after %d bytes\n", blockedAt, len(data))
+		if rescued {
+			fmt.Fprintf(opts.out, "  rescued after %v: %v\n", tm.total, reqErr)
+		} else {
+			fmt.Fprintln(opts.out, "  => "+opts.finding(sevWarn, fmt.Sprintf("NOT rescued: still waiting after %v (canceled by the watchdog)", opts.stuckAfter)))
+			if cancelIgnored {
+				// the write loop of the Transport is stuck in Read, and Do waits for it, as with every case on current Go
+				fmt.Fprintln(opts.out, "  => "+opts.finding(sevInfo, fmt.Sprintf("Do didn't return within %v of canceling the context, until the body reader was unblocked", stuckBodyTimeout)))
+			}
+			fmt.Fprintf(opts.out, "  Do returned: %v\n", reqErr)
+		}
+		closed := "not closed"
+		if closedAt > 0 {
+			closed = fmt.Sprintf("closed at %v", closedAt)
+		}
+		if cancelIgnored {
+			// Do returned only once the body was released, which unblocked Read, so reading was sampled before Do returned
+			fmt.Fprintf(opts.out, "  request body: %s, Read still blocked %v after canceling, while Do hadn't returned: %v\n", closed, stuckBodyTimeout, reading)
+		} else {
+			fmt.Fprintf(opts.out, "  request body: %s, Read still blocked after Do returned: %v\n", closed, reading)
+		}
+		fmt.Fprintf(opts.out, "  server: headers received: %v, %d of the %d body bytes sent received\n", headers, received, len(data))
+	}
+	return first, nil
+}
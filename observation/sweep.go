@@ -0,0 +1,423 @@
+package observation
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sweepParam is a parameter a pattern can be swept across.
+type sweepParam struct {
+	desc string
+	// apply sets the parameter to v for a run, returning the body file and the Transport to use.
+	// The returned cleanup is called after the run.
+	apply func(v int64, filename string, tr *http.Transport) (bodyFile string, cleanup func(), err error)
+}
+
+var sweepParams = map[string]sweepParam{
+	"body-size": {
+		desc: "body size",
+		apply: func(v int64, _ string, _ *http.Transport) (string, func(), error) {
+			name, err := fillerFile(v)
+			if err != nil {
+				return "", nil, err
+			}
+			return name, func() { _ = os.Remove(name) }, nil
+		},
+	},
+	"write-buffer": {
+		desc: "Transport.WriteBufferSize",
+		apply: func(v int64, filename string, tr *http.Transport) (string, func(), error) {
+			tr.WriteBufferSize = int(v)
+			return filename, func() {}, nil
+		},
+	},
+}
+
+// SweepParams returns the names of the parameters Sweep can sweep, sorted.
+func SweepParams() []string {
+	names := make([]string, 0, len(sweepParams))
+	for name := range sweepParams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sweepSpec is a parameter range given as name=from..to, e.g. body-size=1KiB..100MiB.
+type sweepSpec struct {
+	param    string
+	from, to int64
+}
+
+func parseSweepSpec(s string) (sweepSpec, error) {
+	name, rng, ok := strings.Cut(s, "=")
+	if !ok {
+		return sweepSpec{}, fmt.Errorf("want name=from..to: %q", s)
+	}
+	if _, ok := sweepParams[name]; !ok {
+		return sweepSpec{}, fmt.Errorf("unknown parameter %q (one of %s)", name, strings.Join(SweepParams(), ", "))
+	}
+	from, to, ok := strings.Cut(rng, "..")
+	if !ok {
+		return sweepSpec{}, fmt.Errorf("want name=from..to: %q", s)
+	}
+	spec := sweepSpec{param: name}
+	var err error
+	if spec.from, err = parseSize(from); err != nil {
+		return sweepSpec{}, err
+	}
+	if spec.to, err = parseSize(to); err != nil {
+		return sweepSpec{}, err
+	}
+	if spec.from <= 0 || spec.to < spec.from {
+		return sweepSpec{}, fmt.Errorf("want 0 < from <= to: %q", rng)
+	}
+	return spec, nil
+}
+
+// values returns n values from from to to, evenly spaced on a log scale.
+func (s sweepSpec) values(n int) []int64 {
+	if n < 2 || s.from == s.to {
+		return []int64{s.from}
+	}
+	ratio := float64(s.to) / float64(s.from)
+	vs := make([]int64, 0, n)
+	for i := 0; i < n; i++ {
+		v := int64(math.Round(float64(s.from) * math.Pow(ratio, float64(i)/float64(n-1))))
+		if len(vs) == 0 || v != vs[len(vs)-1] {
+			vs = append(vs, v)
+		}
+	}
+	return vs
+}
+
+// parseSize parses a byte size with an optional binary unit: B, K, KB, KiB, M, MB, MiB, G, GB or GiB (all powers of 1024).
+func parseSize(s string) (int64, error) {
+	num := strings.TrimRight(s, "BbIiKkMmGg")
+	unit := strings.ToUpper(strings.TrimSuffix(strings.TrimSuffix(s[len(num):], "B"), "b"))
+	mult := int64(1)
+	switch strings.TrimSuffix(unit, "I") {
+	case "":
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	default:
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	return int64(n * float64(mult)), nil
+}
+
+// formatSize formats n bytes with the largest binary unit it's at least 1 of.
+func formatSize(n int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	v, i := float64(n), 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	if i == 0 || v == math.Trunc(v) {
+		return fmt.Sprintf("%.0f %s", v, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", v, units[i])
+}
+
+// fillerFile creates a temporary file of size bytes of filler, for sweeping the body size.
+func fillerFile(size int64) (string, error) {
+	f, err := os.CreateTemp("", "sweep-body-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create body file: %w", err)
+	}
+	defer f.Close()
+	filler := bytes.Repeat([]byte("x"), 32<<10)
+	for left := size; left > 0; {
+		chunk := filler
+		if left < int64(len(chunk)) {
+			chunk = chunk[:left]
+		}
+		if _, err := f.Write(chunk); err != nil {
+			_ = os.Remove(f.Name())
+			return "", fmt.Errorf("failed to write body file: %w", err)
+		}
+		left -= int64(len(chunk))
+	}
+	return f.Name(), nil
+}
+
+// countingReader counts bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// startCountingServer starts a server reading and discarding whole requests, one per connection,
+// and sending the number of bytes each request took on the wire to wire.
+// Bytes are counted on the server side, so that the client's use of sendfile isn't affected.
+func startCountingServer(wire chan<- int64) (url string, stop func(), err error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to start listening: %w", err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				cr := &countingReader{r: conn}
+				req, err := http.ReadRequest(bufio.NewReader(cr))
+				if err != nil {
+					return
+				}
+				_, _ = io.Copy(io.Discard, req.Body)
+				// the client sends nothing more before the response, so bufio hasn't read ahead of the request
+				wire <- cr.n
+				_ = writeResponse(conn, http.StatusOK, nil, false)
+			}()
+		}
+	}()
+	return "http://" + l.Addr().String(), func() { _ = l.Close() }, nil
+}
+
+// sweepPoint is the median result of the runs at a parameter value.
+type sweepPoint struct {
+	value      int64
+	payload    int64 // size of the uploaded file
+	latency    time.Duration
+	throughput float64 // payload bytes per second
+	overhead   int64   // bytes on the wire other than the payload
+}
+
+// runSweep runs the pattern, built by request(), across the values of the swept parameter, runs times each,
+// and reports latency, throughput and wire overhead as a table and ASCII charts, and as an SVG chart into svgPath if non-empty.
+func runSweep(spec sweepSpec, steps int, pat reqPattern, opts runOptions, runs int, svgPath string) error {
+	if err := checkSweepable(pat, opts); err != nil {
+		return fmt.Errorf("can't sweep %s: %w", opts.patterns.describe(pat), err)
+	}
+	param := sweepParams[spec.param]
+	wire := make(chan int64, 1)
+	target, stop, err := startCountingServer(wire)
+	if err != nil {
+		return err
+	}
+	defer stop()
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(opts.out, "Sweep of %s over %q (median of %d runs per value)\n", param.desc, opts.patterns.describe(pat), runs)
+	col := len(param.desc)
+	fmt.Fprintf(opts.out, "  %-*s  %-12s  %-14s  %-14s  %s\n", col, param.desc, "payload", "latency", "throughput", "overhead")
+	var points []sweepPoint
+	for _, v := range spec.values(steps) {
+		var (
+			latencies []time.Duration
+			p         = sweepPoint{value: v}
+		)
+		for i := 0; i < runs; i++ {
+			tr := http.DefaultTransport.(*http.Transport).Clone()
+			filename, cleanup, err := param.apply(v, opts.filename, tr)
+			if err != nil {
+				return err
+			}
+			latency, payload, onWire, err := sweepRun(pat, filename, opts, tr, u, wire)
+			cleanup()
+			tr.CloseIdleConnections()
+			if err != nil {
+				return fmt.Errorf("%s %s: %w", param.desc, formatSize(v), err)
+			}
+			latencies = append(latencies, latency)
+			p.payload, p.overhead = payload, onWire-payload
+		}
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		p.latency = latencies[len(latencies)/2]
+		p.throughput = float64(p.payload) / p.latency.Seconds()
+		points = append(points, p)
+		fmt.Fprintf(opts.out, "  %-*s  %-12s  %-14v  %-14s  %d bytes\n", col, formatSize(v), formatSize(p.payload), p.latency, formatSize(int64(p.throughput))+"/s", p.overhead)
+	}
+
+	charts := sweepCharts(points)
+	for _, c := range charts {
+		fmt.Fprintln(opts.out)
+		c.printASCII(opts.out, param.desc)
+	}
+	if svgPath != "" {
+		if err := os.WriteFile(svgPath, sweepSVG(param.desc, opts.patterns.describe(pat), charts), 0o644); err != nil {
+			return fmt.Errorf("failed to write SVG chart: %w", err)
+		}
+		fmt.Fprintf(opts.out, "\nSVG chart written to %s\n", svgPath)
+	}
+	return nil
+}
+
+// checkSweepable checks that the request of pat is built by request(), by building one.
+func checkSweepable(pat reqPattern, opts runOptions) error {
+	f, err := os.Open(opts.filename)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+	req, err := buildPatternRequest(pat, f, opts)
+	if err != nil {
+		return err
+	}
+	if pr, ok := req.Body.(*io.PipeReader); ok {
+		// stop the producer of the pipe, as the request isn't sent
+		_ = pr.Close()
+	}
+	return nil
+}
+
+// sweepRun sends the request of pat uploading filename with tr, and returns the latency from building the request until the response,
+// the size of the file and the bytes the request took on the wire.
+func sweepRun(pat reqPattern, filename string, opts runOptions, tr *http.Transport, target *url.URL, wire <-chan int64) (time.Duration, int64, int64, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to stat file: %w", err)
+	}
+	opts.filename = filename
+	start := time.Now()
+	req, err := buildPatternRequest(pat, f, opts)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	req.URL.Scheme, req.URL.Host, req.Host = target.Scheme, target.Host, target.Host
+	if err := opts.sendReq(tr, req); err != nil {
+		return 0, 0, 0, err
+	}
+	latency := time.Since(start)
+	select {
+	case n := <-wire:
+		return latency, stat.Size(), n, nil
+	case <-time.After(time.Second):
+		return 0, 0, 0, fmt.Errorf("the server didn't read the whole request")
+	}
+}
+
+// sweepChart is a metric plotted against the swept parameter.
+type sweepChart struct {
+	title  string
+	labels []string // of the parameter values
+	values []float64
+	format func(float64) string
+}
+
+func sweepCharts(points []sweepPoint) []sweepChart {
+	latency := sweepChart{title: "latency", format: func(v float64) string { return time.Duration(v).String() }}
+	throughput := sweepChart{title: "throughput", format: func(v float64) string { return formatSize(int64(v)) + "/s" }}
+	overhead := sweepChart{title: "wire overhead", format: func(v float64) string { return fmt.Sprintf("%.0f bytes", v) }}
+	for _, p := range points {
+		label := formatSize(p.value)
+		latency.labels = append(latency.labels, label)
+		latency.values = append(latency.values, float64(p.latency))
+		throughput.labels = append(throughput.labels, label)
+		throughput.values = append(throughput.values, p.throughput)
+		overhead.labels = append(overhead.labels, label)
+		overhead.values = append(overhead.values, float64(p.overhead))
+	}
+	return []sweepChart{latency, throughput, overhead}
+}
+
+func (c sweepChart) max() float64 {
+	m := 0.0
+	for _, v := range c.values {
+		if v > m {
+			m = v
+		}
+	}
+	return m
+}
+
+// printASCII prints the chart as horizontal bars, one per parameter value, scaled to the maximum.
+func (c sweepChart) printASCII(out io.Writer, param string) {
+	const width = 40
+	fmt.Fprintf(out, "%s by %s:\n", c.title, param)
+	m := c.max()
+	for i, v := range c.values {
+		n := 0
+		if m > 0 {
+			n = int(math.Round(v / m * width))
+		}
+		fmt.Fprintf(out, "  %-10s |%-*s| %s\n", c.labels[i], width, strings.Repeat("#", n), c.format(v))
+	}
+}
+
+// sweepSVG renders the charts as line charts stacked in one SVG image.
+// Parameter values are evenly spaced, i.e. on a log scale, as they are swept.
+func sweepSVG(param, pat string, charts []sweepChart) []byte {
+	const (
+		width  = 720
+		height = 220
+		left   = 110
+		right  = 30
+		top    = 50
+		plotH  = 130
+	)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="11">`+"\n", width, top+height*len(charts))
+	fmt.Fprintf(&b, `<text x="10" y="24" font-size="14">Sweep of %s over %s</text>`+"\n", svgEscape(param), svgEscape(pat))
+	for i, c := range charts {
+		y0 := top + height*i
+		m := c.max()
+		fmt.Fprintf(&b, `<text x="10" y="%d" font-size="13">%s</text>`+"\n", y0+12, svgEscape(c.title))
+		fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="black"/>`+"\n", left, y0+20, left, y0+20+plotH)
+		fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="black"/>`+"\n", left, y0+20+plotH, width-right, y0+20+plotH)
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end">%s</text>`+"\n", left-6, y0+24, svgEscape(c.format(m)))
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end">0</text>`+"\n", left-6, y0+20+plotH)
+
+		var pts []string
+		for j, v := range c.values {
+			x := float64(left)
+			if len(c.values) > 1 {
+				x += float64(width-left-right) * float64(j) / float64(len(c.values)-1)
+			}
+			y := float64(y0 + 20 + plotH)
+			if m > 0 {
+				y -= v / m * plotH
+			}
+			pts = append(pts, fmt.Sprintf("%.1f,%.1f", x, y))
+			fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="3" fill="steelblue"><title>%s: %s</title></circle>`+"\n", x, y, svgEscape(c.labels[j]), svgEscape(c.format(v)))
+			fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle">%s</text>`+"\n", x, y0+20+plotH+16, svgEscape(c.labels[j]))
+		}
+		fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="steelblue" stroke-width="2"/>`+"\n", strings.Join(pts, " "))
+	}
+	b.WriteString("</svg>\n")
+	return b.Bytes()
+}
+
+var svgEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+func svgEscape(s string) string {
+	return svgEscaper.Replace(s)
+}
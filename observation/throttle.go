@@ -0,0 +1,47 @@
+package observation
+
+import (
+	"net"
+	"time"
+)
+
+// readThrottle limits how fast the capture server reads from connections, to back-pressure clients.
+// Zero values leave the respective aspect as is.
+type readThrottle struct {
+	rcvBuf   int           // size of the socket receive buffer (SO_RCVBUF)
+	readSize int           // maximum bytes per Read call
+	interval time.Duration // pause before each Read call
+}
+
+func (t readThrottle) enabled() bool {
+	return t != readThrottle{}
+}
+
+// apply configures the receive buffer of conn and wraps it to throttle reads.
+func (t readThrottle) apply(conn net.Conn) (net.Conn, error) {
+	if t.rcvBuf > 0 {
+		if tc, ok := conn.(interface{ SetReadBuffer(bytes int) error }); ok {
+			if err := tc.SetReadBuffer(t.rcvBuf); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if t.readSize == 0 && t.interval == 0 {
+		return conn, nil
+	}
+	return &throttledConn{Conn: conn, t: t}, nil
+}
+
+// throttledConn reads at most readSize bytes per Read call, pausing for interval before each call.
+type throttledConn struct {
+	net.Conn
+	t readThrottle
+}
+
+func (c *throttledConn) Read(p []byte) (int, error) {
+	if c.t.readSize > 0 && len(p) > c.t.readSize {
+		p = p[:c.t.readSize]
+	}
+	time.Sleep(c.t.interval)
+	return c.Conn.Read(p)
+}
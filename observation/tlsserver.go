@@ -0,0 +1,132 @@
+package observation
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// tlsCaptureListener terminates TLS on connections accepted by the capture server,
+// so that requests sent over HTTPS are captured as the plaintext the client wrote inside TLS.
+type tlsCaptureListener struct {
+	net.Listener
+	config *tls.Config
+}
+
+// tlsCaptureConn is a connection accepted by tlsCaptureListener.
+type tlsCaptureConn struct {
+	*tls.Conn
+	offered []string // ALPN protocols offered by the client
+}
+
+func (l *tlsCaptureListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	tc := &tlsCaptureConn{}
+	config := l.config.Clone()
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		tc.offered = hello.SupportedProtos
+		return nil, nil
+	}
+	tc.Conn = tls.Server(conn, config)
+	return tc, nil
+}
+
+// listenTLS makes l terminate TLS with cert, offering only HTTP/1.1 with ALPN so that the capture stays readable,
+// and returns a clone of http.DefaultTransport trusting cert.
+func listenTLS(l net.Listener, cert tls.Certificate) (net.Listener, http.RoundTripper, error) {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = &tls.Config{RootCAs: pool}
+
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"http/1.1"}}
+	return &tlsCaptureListener{Listener: l, config: config}, tr, nil
+}
+
+// tlsFiles are the PEM files of the certificate and key of the capture server given with WithTLS.
+type tlsFiles struct {
+	certFile, keyFile string
+}
+
+// loadServerCert loads the certificate and key of the capture server from PEM files,
+// or generates a self-signed certificate for localhost if both are empty.
+func loadServerCert(certFile, keyFile string) (tls.Certificate, error) {
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("failed to load certificate: %w", err)
+		}
+		return cert, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		// self-signed, so it's its own CA
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// handshakeCapture completes the TLS handshake of a connection accepted by tlsCaptureListener, and prints
+// the negotiated parameters unless quiet. Other connections are left as they are.
+func (s *session) handshakeCapture(conn net.Conn, quiet bool) error {
+	tc, ok := conn.(*tlsCaptureConn)
+	if !ok {
+		return nil
+	}
+	if err := tc.Handshake(); err != nil {
+		return fmt.Errorf("TLS handshake failed: %w", err)
+	}
+	if quiet {
+		return nil
+	}
+	state := tc.ConnectionState()
+	fmt.Fprintf(s.out, "TLS: %s, %s, ALPN offered by the client: [%s], negotiated: %q\n",
+		tlsVersionName(state.Version), tls.CipherSuiteName(state.CipherSuite), strings.Join(tc.offered, " "), state.NegotiatedProtocol)
+	for _, p := range tc.offered {
+		if p == "h2" {
+			fmt.Fprintln(s.out, "=> "+s.finding(sevInfo, "the client offered h2, so it would have used HTTP/2 with a server supporting it (see -http2)"))
+		}
+	}
+	return nil
+}
+
+// isTLSClosed reports whether err means the capture server closed a TLS connection before responding.
+// Unlike over plain TCP, the client sees the close_notify alert and gets EOF instead of a reset.
+func (s *session) isTLSClosed(err error) bool {
+	return strings.HasPrefix(s.serverURL, "https://") && errors.Is(err, io.EOF)
+}
@@ -0,0 +1,192 @@
+package observation
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Transport is an http.RoundTripper sending requests to the real servers, recording the bytes each request wrote to the connection
+// as an Observation. It lets you observe requests made by third-party SDKs without redirecting them to a capture server:
+//
+//	tr, _ := observation.NewTransport(http.DefaultTransport)
+//	cli := &http.Client{Transport: tr}
+//	// ... make requests with cli, or give it to an SDK
+//	for _, obs := range tr.Observations() {
+//		fmt.Println(obs.RequestLine, obs.ContentLengthHeader, obs.TransferEncoding)
+//	}
+//
+// Connections are made by a clone of the base *http.Transport, with its proxy, dialers and protocols as they are,
+// wrapping the connections its DialContext (or Dial) returns to record writes. So only requests written in plaintext
+// HTTP/1.x onto those connections are recorded; https requests, over TLS the transport handshakes itself, aren't.
+// WithTLSPlaintext opts into recording https requests not sent through a proxy, downgrading them to HTTP/1.1.
+// Duration of observations is until the response headers arrived, as with ObserveRequest.
+type Transport struct {
+	tr *http.Transport
+
+	mu         sync.Mutex
+	recordings []*recording
+}
+
+// TransportOption configures NewTransport.
+type TransportOption func(*transportConfig)
+
+type transportConfig struct {
+	tlsPlaintext bool
+}
+
+// WithTLSPlaintext makes Transport do the TLS handshake of https requests not sent through a proxy itself to record
+// their plaintext, in place of DialTLSContext of the base. It offers HTTP/1.1 only, so requests which would go over HTTP/2
+// are sent and recorded in HTTP/1.1.
+func WithTLSPlaintext(enabled bool) TransportOption {
+	return func(c *transportConfig) { c.tlsPlaintext = enabled }
+}
+
+// NewTransport returns a Transport making connections as base does, which must be an *http.Transport,
+// or http.DefaultTransport if nil. base itself isn't modified.
+func NewTransport(base http.RoundTripper, opts ...TransportOption) (*Transport, error) {
+	var cfg transportConfig
+	for _, o := range opts {
+		o(&cfg)
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	b, ok := base.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("observation: can't record connections of %T, only of *http.Transport", base)
+	}
+	tr := b.Clone()
+	dial := tr.DialContext
+	if dial == nil && tr.Dial != nil {
+		d := tr.Dial
+		dial = func(_ context.Context, network, addr string) (net.Conn, error) { return d(network, addr) }
+	}
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	}
+
+	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &recordingConn{Conn: conn}, nil
+	}
+	if !cfg.tlsPlaintext {
+		return &Transport{tr: tr}, nil
+	}
+	tlsConfig := tr.TLSClientConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	tr.DialTLS = nil
+	tr.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		cfg := tlsConfig.Clone()
+		if cfg.ServerName == "" {
+			cfg.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		cfg.NextProtos = []string{"http/1.1"}
+		tc := tls.Client(conn, cfg)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return &recordingConn{Conn: tc}, nil
+	}
+	return &Transport{tr: tr}, nil
+}
+
+// RoundTrip sends req, recording the bytes written to the connection from when req got it until the next request does.
+// Hooks of an httptrace.ClientTrace in the context of req are still called.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := &recording{}
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			c, ok := info.Conn.(*recordingConn)
+			if !ok {
+				return
+			}
+			c.begin(rec)
+			t.mu.Lock()
+			t.recordings = append(t.recordings, rec)
+			t.mu.Unlock()
+		},
+	}
+	start := time.Now()
+	resp, err := t.tr.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	rec.mu.Lock()
+	rec.d = time.Since(start)
+	rec.mu.Unlock()
+	return resp, err
+}
+
+// Observations returns the requests recorded so far, in the order they got connections.
+// A request still being written has as much as has been written.
+func (t *Transport) Observations() []*Observation {
+	t.mu.Lock()
+	recs := append([]*recording(nil), t.recordings...)
+	t.mu.Unlock()
+
+	obs := make([]*Observation, len(recs))
+	for i, r := range recs {
+		r.mu.Lock()
+		raw, d := append([]byte(nil), r.buf.Bytes()...), r.d
+		r.mu.Unlock()
+		obs[i] = parse(raw)
+		obs[i].Duration = d
+	}
+	return obs
+}
+
+// CloseIdleConnections closes idle connections of the underlying transport.
+func (t *Transport) CloseIdleConnections() {
+	t.tr.CloseIdleConnections()
+}
+
+// recording is the bytes a request wrote to the connection.
+type recording struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	d   time.Duration // until the response headers arrived
+}
+
+// recordingConn records bytes written to the connection into the recording of the request using it.
+// HTTP/1.1 connections carry one request at a time, and the Transport flushes a request after the WroteRequest hook,
+// so writes are attributed to the request which got the connection last rather than by hooks around writing.
+type recordingConn struct {
+	net.Conn
+
+	mu  sync.Mutex
+	cur *recording // nil until a request gets the connection
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.mu.Lock()
+	r := c.cur
+	c.mu.Unlock()
+	if r != nil {
+		r.mu.Lock()
+		r.buf.Write(p[:n])
+		r.mu.Unlock()
+	}
+	return n, err
+}
+
+func (c *recordingConn) begin(r *recording) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cur = r
+}
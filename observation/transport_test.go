@@ -0,0 +1,84 @@
+package observation
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// TestTransportKeepsProxy checks that requests go through the proxy of the base transport, and are recorded as written to it.
+func TestTransportKeepsProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.RequestURI
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := &http.Transport{Proxy: http.ProxyURL(proxyURL)}
+	tr, err := NewTransport(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.CloseIdleConnections()
+
+	resp, err := (&http.Client{Transport: tr}).Post("http://origin.example/upload", "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if proxied != "http://origin.example/upload" {
+		t.Errorf("proxy got %q, want the request for the origin", proxied)
+	}
+	obs := tr.Observations()
+	if len(obs) != 1 || obs[0].RequestLine != "POST http://origin.example/upload HTTP/1.1" {
+		t.Errorf("observations = %+v, want the request to the proxy", obs)
+	}
+}
+
+// TestTransportTLS checks that https requests keep the protocol negotiated by the base transport, unrecorded,
+// unless WithTLSPlaintext records them in HTTP/1.1.
+func TestTransportTLS(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		name      string
+		opts      []TransportOption
+		proto     string
+		requested string // request line recorded, or empty if none
+	}{
+		{name: "default", proto: "HTTP/2.0"},
+		{name: "plaintext", opts: []TransportOption{WithTLSPlaintext(true)}, proto: "HTTP/1.1", requested: "GET / HTTP/1.1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, err := NewTransport(srv.Client().Transport, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tr.CloseIdleConnections()
+
+			resp, err := (&http.Client{Transport: tr}).Get(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.Proto != tt.proto {
+				t.Errorf("response over %s, want %s", resp.Proto, tt.proto)
+			}
+			obs := tr.Observations()
+			switch {
+			case tt.requested == "" && len(obs) != 0:
+				t.Errorf("observations = %+v, want none", obs)
+			case tt.requested != "" && (len(obs) != 1 || obs[0].RequestLine != tt.requested):
+				t.Errorf("observations = %+v, want %q", obs, tt.requested)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package observation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+)
+
+// listenUnix listens on the Unix domain socket at path, replacing a socket file left behind by a previous run.
+// The file is removed when the listener is closed.
+func listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start listening: %w", err)
+	}
+	return l, nil
+}
+
+// unixSocketTransport returns a Transport which sends every request over the Unix domain socket regardless of the URL,
+// like Docker clients do. The URL still decides the Host header and the request target.
+func unixSocketTransport(path string) http.RoundTripper {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", path)
+	}
+	return tr
+}
+
+// isUnixSocketClosed reports whether err means the capture server closed a Unix domain socket connection before responding.
+// Unlike TCP, closing with unread data doesn't reset the connection, so the client fails writing to it or reading the response.
+func isUnixSocketClosed(err error) bool {
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, io.EOF)
+}
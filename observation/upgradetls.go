@@ -0,0 +1,473 @@
+package observation
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// upgradeCase is a request tried against the in-band TLS upgrade server.
+type upgradeCase struct {
+	desc    string
+	method  string
+	target  string // request-target, "*" for the whole server
+	body    bool   // send the file as the body
+	upgrade bool   // ask for the upgrade with Upgrade: TLS/1.2
+}
+
+var upgradeCases = []upgradeCase{
+	{desc: "OPTIONS * asking for Upgrade: TLS/1.2 (RFC 2817 3.2)", method: http.MethodOptions, target: "*", upgrade: true},
+	{desc: "PUT of the file asking for Upgrade: TLS/1.2", method: http.MethodPut, target: "/upload", body: true, upgrade: true},
+	{desc: "PUT of the file to a server demanding the upgrade (426 Upgrade Required)", method: http.MethodPut, target: "/upload", body: true},
+}
+
+// upgradeLog is what the upgrade server saw on a connection.
+type upgradeLog struct {
+	pre         []byte // the request before the upgrade, on the wire
+	response    []byte // the response to it
+	clientHello []byte // the first TLS record after the 101 response
+	post        []byte // the request over TLS, on the wire
+	err         error
+}
+
+// upgradeServer upgrades connections to TLS in-band when asked with Upgrade: TLS/1.2 as in RFC 2817,
+// demanding it with 426 Upgrade Required otherwise, and records the bytes before and after the switch.
+type upgradeServer struct {
+	l    net.Listener
+	conf *tls.Config
+
+	mu    sync.Mutex
+	logs  []*upgradeLog
+	conns []net.Conn
+	wg    sync.WaitGroup // of connections being served
+}
+
+func startUpgradeServer(conf *tls.Config) (*upgradeServer, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start listening: %w", err)
+	}
+	s := &upgradeServer{l: l, conf: conf}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			ul := &upgradeLog{}
+			s.mu.Lock()
+			s.logs = append(s.logs, ul)
+			s.conns = append(s.conns, conn)
+			s.wg.Add(1)
+			s.mu.Unlock()
+			go func() {
+				defer s.wg.Done()
+				ul.err = s.serve(conn, ul)
+			}()
+		}
+	}()
+	return s, nil
+}
+
+func (s *upgradeServer) serve(conn net.Conn, ul *upgradeLog) error {
+	defer conn.Close()
+
+	var raw bytes.Buffer
+	br := bufio.NewReader(io.TeeReader(conn, &raw))
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.Discard, req.Body); err != nil {
+		return err
+	}
+	// only what the request consumed; the TeeReader may have read ahead into the ClientHello
+	ul.pre = append([]byte(nil), raw.Bytes()[:raw.Len()-br.Buffered()]...)
+
+	var resp bytes.Buffer
+	if !strings.Contains(req.Header.Get("Upgrade"), "TLS/1.2") || !strings.Contains(strings.ToLower(req.Header.Get("Connection")), "upgrade") {
+		hdr := http.Header{"Upgrade": {"TLS/1.2, HTTP/1.1"}, "Connection": {"Upgrade"}}
+		_ = writeResponse(&resp, http.StatusUpgradeRequired, hdr, false)
+		ul.response = resp.Bytes()
+		_, err := conn.Write(resp.Bytes())
+		return err
+	}
+	resp.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: TLS/1.2, HTTP/1.1\r\nConnection: Upgrade\r\n\r\n")
+	ul.response = resp.Bytes()
+	if _, err := conn.Write(resp.Bytes()); err != nil {
+		return err
+	}
+
+	// the first record is the ClientHello: 1 byte content type, 2 bytes version, 2 bytes length
+	hdr := make([]byte, 5)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return fmt.Errorf("failed to read the TLS record header: %w", err)
+	}
+	record := make([]byte, 5+int(binary.BigEndian.Uint16(hdr[3:])))
+	copy(record, hdr)
+	if _, err := io.ReadFull(br, record[5:]); err != nil {
+		return fmt.Errorf("failed to read the TLS record: %w", err)
+	}
+	ul.clientHello = record
+
+	// replay the record to the TLS server, then continue with the rest of the connection
+	tconn := tls.Server(&prefixConn{Conn: conn, r: io.MultiReader(bytes.NewReader(record), br)}, s.conf)
+	var post bytes.Buffer
+	tbr := bufio.NewReader(io.TeeReader(tconn, &post))
+	treq, err := http.ReadRequest(tbr)
+	if err != nil {
+		return fmt.Errorf("failed to read the request over TLS: %w", err)
+	}
+	if _, err := io.Copy(io.Discard, treq.Body); err != nil {
+		return err
+	}
+	ul.post = post.Bytes()
+	return writeResponse(tconn, http.StatusOK, nil, false)
+}
+
+func (s *upgradeServer) url() string {
+	return "http://" + s.l.Addr().String()
+}
+
+// takeLogs closes the connections accepted so far and returns their logs, once they're no longer being served.
+func (s *upgradeServer) takeLogs() []*upgradeLog {
+	s.mu.Lock()
+	logs, conns := s.logs, s.conns
+	s.logs, s.conns = nil, nil
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return logs
+}
+
+func (s *upgradeServer) close() {
+	_ = s.l.Close()
+}
+
+// prefixConn is a net.Conn reading from r instead of the connection, for bytes already consumed from it.
+type prefixConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *prefixConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// upgradedConn adapts the io.ReadWriteCloser which the Transport returns as the body of a 101 response to net.Conn,
+// for running TLS on it. It has no addresses, and deadlines aren't supported.
+type upgradedConn struct {
+	io.ReadWriteCloser
+}
+
+var errUpgradedDeadline = errors.New("deadlines aren't supported on upgraded connections")
+
+func (upgradedConn) LocalAddr() net.Addr              { return nil }
+func (upgradedConn) RemoteAddr() net.Addr             { return nil }
+func (upgradedConn) SetDeadline(time.Time) error      { return errUpgradedDeadline }
+func (upgradedConn) SetReadDeadline(time.Time) error  { return errUpgradedDeadline }
+func (upgradedConn) SetWriteDeadline(time.Time) error { return errUpgradedDeadline }
+
+// observeUpgradeTLS upgrades plaintext connections to TLS in-band with Upgrade: TLS/1.2 (RFC 2817),
+// as opportunistic-TLS gateways do. Captures the request before the upgrade, the 101 (or 426) response,
+// the ClientHello sent on the upgraded connection and the request sent over TLS, and whether the Transport reuses the connection.
+func observeUpgradeTLS(opts runOptions) (*timing, error) {
+	data, err := os.ReadFile(opts.filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	ca, err := newTestCA()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create test CA: %w", err)
+	}
+	serverCert, err := ca.issue("server", true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue server certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	srv, err := startUpgradeServer(&tls.Config{Certificates: []tls.Certificate{serverCert}})
+	if err != nil {
+		return nil, err
+	}
+	defer srv.close()
+	addr := srv.l.Addr().String()
+
+	var first *timing
+	for _, c := range upgradeCases {
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		cli := &http.Client{Transport: tr}
+
+		var body io.Reader
+		if c.body {
+			body = bytes.NewReader(data)
+		}
+		req, err := http.NewRequestWithContext(opts.ctx, c.method, srv.url()+"/", body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+		req.URL.Path = c.target
+		if c.upgrade {
+			req.Header.Set("Upgrade", "TLS/1.2")
+			req.Header.Set("Connection", "Upgrade")
+		}
+		req, tm := traceTiming(req)
+
+		resp, err := cli.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		var (
+			tlsState *tls.ConnectionState
+			postErr  error
+		)
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			rwc, ok := resp.Body.(io.ReadWriteCloser)
+			if !ok {
+				return nil, fmt.Errorf("body of the 101 response isn't writable: %T", resp.Body)
+			}
+			tconn := tls.Client(upgradedConn{rwc}, &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"})
+			postErr = sendOverUpgradedTLS(tconn, addr)
+			if postErr == nil {
+				st := tconn.ConnectionState()
+				tlsState = &st
+			}
+			_ = tconn.Close()
+		} else {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+		tm.finish()
+		if first == nil {
+			first = tm
+		}
+
+		// a plain request after the upgrade, to see whether the upgraded connection went back to the pool.
+		// The server refuses it with 426, which doesn't matter here
+		reused, reuseErr := probeReuse(opts.ctx, cli, srv.url())
+		tr.CloseIdleConnections()
+		logs := srv.takeLogs()
+
+		if opts.quiet {
+			continue
+		}
+		fmt.Fprintf(opts.out, "[%s]\n", c.desc)
+		if len(logs) == 0 {
+			fmt.Fprintln(opts.out, "  server: no connection")
+			continue
+		}
+		ul := logs[0]
+		fmt.Fprintf(opts.out, "  request before the upgrade (%d bytes):\n", len(ul.pre))
+		opts.printIndented(wireHead(ul.pre))
+		fmt.Fprintf(opts.out, "  response:\n")
+		opts.printIndented(strings.TrimRight(string(ul.response), "\r\n"))
+		fmt.Fprintf(opts.out, "  client got: %s\n", resp.Status)
+		if resp.StatusCode == http.StatusUpgradeRequired {
+			fmt.Fprintln(opts.out, "  => "+opts.finding(sevInfo, "the Transport doesn't act on 426 Upgrade Required; upgrading is left to the caller"))
+		}
+		if ul.clientHello != nil {
+			fmt.Fprintf(opts.out, "  TLS ClientHello after the 101 (%d bytes):\n", len(ul.clientHello))
+			head := ul.clientHello
+			if len(head) > 64 {
+				head = head[:64]
+			}
+			opts.printIndented(strings.TrimRight(hex.Dump(head), "\n") + "\n...")
+			opts.printIndented(describeClientHello(ul.clientHello))
+		}
+		if postErr != nil {
+			fmt.Fprintf(opts.out, "  request over TLS: failed: %v\n", postErr)
+		} else if tlsState != nil {
+			fmt.Fprintf(opts.out, "  request over TLS (%s, %s):\n", tlsVersionName(tlsState.Version), tls.CipherSuiteName(tlsState.CipherSuite))
+			opts.printIndented(wireHead(ul.post))
+		}
+		if ul.err != nil {
+			fmt.Fprintf(opts.out, "  server: %v\n", ul.err)
+		}
+		switch {
+		case reuseErr != nil:
+			fmt.Fprintf(opts.out, "  next plain request: failed: %v\n", reuseErr)
+		default:
+			fmt.Fprintf(opts.out, "  next plain request reused the connection: %v\n", reused)
+		}
+	}
+	return first, nil
+}
+
+// sendOverUpgradedTLS handshakes on the upgraded connection, then sends a GET over TLS and reads the response.
+func sendOverUpgradedTLS(tconn *tls.Conn, host string) error {
+	if err := tconn.Handshake(); err != nil {
+		return fmt.Errorf("TLS handshake failed: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodGet, "https://"+host+"/after-upgrade", nil)
+	if err != nil {
+		return err
+	}
+	if err := req.Write(tconn); err != nil {
+		return fmt.Errorf("failed to write request: %w", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(tconn), req)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+// probeReuse sends a GET with cli, reporting whether it went on a reused connection.
+func probeReuse(ctx context.Context, cli *http.Client, url string) (bool, error) {
+	var reused bool
+	trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused }}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	resp, err := cli.Do(req)
+	if err != nil {
+		return reused, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return reused, resp.Body.Close()
+}
+
+// wireHead returns the header section of a raw request and a note on the body length.
+func wireHead(raw []byte) string {
+	head, body, ok := bytes.Cut(raw, []byte("\r\n\r\n"))
+	if !ok {
+		return string(raw)
+	}
+	return fmt.Sprintf("%s\n(%d body bytes)", strings.ReplaceAll(string(head), "\r\n", "\n"), len(body))
+}
+
+func (s *session) printIndented(text string) {
+	for _, line := range strings.Split(text, "\n") {
+		fmt.Fprintf(s.out, "    %s\n", line)
+	}
+}
+
+// names of TLS extensions commonly found in ClientHellos
+var tlsExtensionNames = map[uint16]string{
+	0: "server_name", 5: "status_request", 10: "supported_groups", 11: "ec_point_formats", 13: "signature_algorithms",
+	16: "alpn", 18: "signed_certificate_timestamp", 23: "extended_master_secret", 35: "session_ticket",
+	43: "supported_versions", 45: "psk_key_exchange_modes", 50: "signature_algorithms_cert", 51: "key_share", 65281: "renegotiation_info",
+}
+
+// describeClientHello summarizes a TLS record with a ClientHello: versions, cipher suite count, extensions, SNI and ALPN.
+func describeClientHello(record []byte) string {
+	malformed := "(malformed ClientHello)"
+	if len(record) < 5+4+2+32+1 || record[0] != 0x16 || record[5] != 0x01 {
+		return malformed
+	}
+	recordVersion := binary.BigEndian.Uint16(record[1:])
+	p := record[9:]
+	legacyVersion := binary.BigEndian.Uint16(p)
+	p = p[2+32:]
+	// session id, cipher suites and compression methods
+	if len(p) < 1+int(p[0]) {
+		return malformed
+	}
+	p = p[1+int(p[0]):]
+	if len(p) < 2 {
+		return malformed
+	}
+	suites := int(binary.BigEndian.Uint16(p))
+	if len(p) < 2+suites+1 {
+		return malformed
+	}
+	p = p[2+suites:]
+	if len(p) < 1+int(p[0])+2 {
+		return malformed
+	}
+	p = p[1+int(p[0]):]
+	p = p[2:]
+
+	var exts []string
+	var sni, alpn, versions []string
+	for len(p) >= 4 {
+		typ, n := binary.BigEndian.Uint16(p), int(binary.BigEndian.Uint16(p[2:]))
+		if len(p) < 4+n {
+			return malformed
+		}
+		data := p[4 : 4+n]
+		p = p[4+n:]
+		name, ok := tlsExtensionNames[typ]
+		if !ok {
+			name = fmt.Sprintf("0x%04x", typ)
+		}
+		exts = append(exts, name)
+		switch typ {
+		case 0:
+			// list length, then entries of type (1 byte), length and name
+			for d := skipBytes(data, 2); len(d) >= 3; {
+				l := int(binary.BigEndian.Uint16(d[1:]))
+				if len(d) < 3+l {
+					break
+				}
+				sni = append(sni, string(d[3:3+l]))
+				d = d[3+l:]
+			}
+		case 16:
+			for d := skipBytes(data, 2); len(d) >= 1; {
+				l := int(d[0])
+				if len(d) < 1+l {
+					break
+				}
+				alpn = append(alpn, string(d[1:1+l]))
+				d = d[1+l:]
+			}
+		case 43:
+			for d := skipBytes(data, 1); len(d) >= 2; d = d[2:] {
+				versions = append(versions, tlsVersionName(binary.BigEndian.Uint16(d)))
+			}
+		}
+	}
+	none := func(s []string) string {
+		if len(s) == 0 {
+			return "none"
+		}
+		return strings.Join(s, ", ")
+	}
+	return fmt.Sprintf("record version: %s, legacy version: %s, supported versions: %s\n%d cipher suites, extensions: %s\nSNI: %s, ALPN: %s",
+		tlsVersionName(recordVersion), tlsVersionName(legacyVersion), none(versions), suites/2, strings.Join(exts, ", "), none(sni), none(alpn))
+}
+
+// tlsVersionName names TLS versions, as tls.VersionName isn't available in Go 1.19.
+func tlsVersionName(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	default:
+		return fmt.Sprintf("0x%04X", v)
+	}
+}
+
+// skipBytes returns b without its first n bytes, or empty if it's shorter.
+func skipBytes(b []byte, n int) []byte {
+	if len(b) < n {
+		return nil
+	}
+	return b[n:]
+}
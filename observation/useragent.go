@@ -0,0 +1,67 @@
+package observation
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// observeUserAgent sends a GET leaving User-Agent to the Transport (reqUADefault), setting it (reqUAOverride)
+// or setting it empty (reqUASuppressed), and reports the User-Agent lines on the wire.
+func observeUserAgent(p reqPattern, opts runOptions) (*timing, error) {
+	captures := make(chan capturedRequest, 1)
+	url, stop, err := opts.startEphemeralServer(func() ServerBehavior { return BaseBehavior{} }, captures, true)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	req, err := http.NewRequestWithContext(opts.ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	switch p {
+	case reqUAOverride:
+		req.Header.Set("User-Agent", "observation/1.0")
+	case reqUASuppressed:
+		req.Header.Set("User-Agent", "")
+	}
+	set, inMap := req.Header["User-Agent"]
+
+	req, tm := traceTiming(req)
+	err = opts.sendReq(http.DefaultTransport, req)
+	tm.finish()
+	if err != nil {
+		return nil, err
+	}
+	got := <-captures
+	if opts.quiet {
+		return tm, nil
+	}
+
+	_, fields, _ := parseRawHead(got.raw)
+	var onWire []string
+	for _, f := range fields {
+		if strings.EqualFold(f.Name, "User-Agent") {
+			onWire = append(onWire, f.Value)
+		}
+	}
+	if inMap {
+		fmt.Fprintf(opts.out, "%-30s%q\n", `Request.Header["User-Agent"]:`, set)
+	} else {
+		fmt.Fprintf(opts.out, "%-30s%s\n", `Request.Header["User-Agent"]:`, "not set")
+	}
+	fmt.Fprintf(opts.out, "%-30s%q\n", "User-Agent on the wire:", onWire)
+
+	switch {
+	case len(onWire) == 0:
+		fmt.Fprintln(opts.out, "  => "+opts.finding(sevInfo, "no User-Agent header was sent, as it was set to the empty string"))
+	case len(onWire) > 1:
+		fmt.Fprintln(opts.out, "  => "+opts.finding(sevWarn, fmt.Sprintf("%d User-Agent header lines were written", len(onWire))))
+	case !inMap:
+		fmt.Fprintln(opts.out, "  => "+opts.finding(sevInfo, fmt.Sprintf("the Transport filled in its default User-Agent %q", onWire[0])))
+	default:
+		fmt.Fprintln(opts.out, "  => "+opts.finding(sevInfo, fmt.Sprintf("the User-Agent set in Request.Header replaced the default: %q", onWire[0])))
+	}
+	return tm, nil
+}
@@ -0,0 +1,145 @@
+package observation
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+)
+
+// hashingReader computes the digest of everything read through it, like integrity checking readers of SDKs.
+type hashingReader struct {
+	r io.Reader
+	h hash.Hash
+}
+
+func (r *hashingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.h.Write(p[:n])
+	return n, err
+}
+
+// wrappedBodyCase is a way of wrapping the body for the wrapped body experiment.
+// build returns the request and the hash the body is fed into, if any.
+type wrappedBodyCase struct {
+	desc  string
+	build func(url string, data []byte) (*http.Request, hash.Hash, error)
+}
+
+var wrappedBodyCases = []wrappedBodyCase{
+	{
+		desc: "bare *bytes.Reader",
+		build: func(url string, data []byte) (*http.Request, hash.Hash, error) {
+			req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(data))
+			return req, nil, err
+		},
+	},
+	{
+		desc: "io.TeeReader into sha256",
+		build: func(url string, data []byte) (*http.Request, hash.Hash, error) {
+			h := sha256.New()
+			req, err := http.NewRequest(http.MethodPut, url, io.TeeReader(bytes.NewReader(data), h))
+			return req, h, err
+		},
+	},
+	{
+		desc: "hashing reader (sha256)",
+		build: func(url string, data []byte) (*http.Request, hash.Hash, error) {
+			h := sha256.New()
+			req, err := http.NewRequest(http.MethodPut, url, &hashingReader{r: bytes.NewReader(data), h: h})
+			return req, h, err
+		},
+	},
+	{
+		desc: "io.TeeReader, restoring ContentLength/GetBody",
+		build: func(url string, data []byte) (*http.Request, hash.Hash, error) {
+			h := sha256.New()
+			req, err := http.NewRequest(http.MethodPut, url, io.TeeReader(bytes.NewReader(data), h))
+			if err != nil {
+				return nil, nil, err
+			}
+			req.ContentLength = int64(len(data))
+			req.GetBody = func() (io.ReadCloser, error) {
+				h.Reset()
+				return io.NopCloser(io.TeeReader(bytes.NewReader(data), h)), nil
+			}
+			return req, h, nil
+		},
+	},
+}
+
+// observeWrappedBodies uploads the file with the body wrapped in io.TeeReader or hashing readers,
+// and reports whether ContentLength and GetBody set by http.NewRequest survive wrapping, the framing on the wire
+// and whether the digest covers the whole body, compared with the bare reader.
+func observeWrappedBodies(opts runOptions) (*timing, error) {
+	captures := make(chan capturedRequest, 1)
+	url, stop, err := opts.startEphemeralServer(func() ServerBehavior { return keepAliveBehavior{} }, captures, true)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	data, err := os.ReadFile(opts.filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	want := sha256.Sum256(data)
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	defer tr.CloseIdleConnections()
+	cli := &http.Client{Transport: tr}
+
+	var (
+		first      *timing
+		mismatches []string
+	)
+	if !opts.quiet {
+		fmt.Fprintf(opts.out, "  %-46s  %-13s  %-7s  %-28s  %-14s  %s\n", "body", "ContentLength", "GetBody", "framing headers", "received", "digest")
+	}
+	for i, c := range wrappedBodyCases {
+		req, h, err := c.build(url, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+		req = req.WithContext(opts.ctx)
+		contentLength, hasGetBody := req.ContentLength, req.GetBody != nil
+
+		req, tm := traceTiming(req)
+		resp, err := cli.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("HTTP request failed: %w", err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		tm.finish()
+
+		got := <-captures
+		if i == 0 {
+			first = tm
+		}
+		if opts.quiet {
+			continue
+		}
+
+		digest := "-"
+		if h != nil {
+			sum := h.Sum(nil)
+			digest = hex.EncodeToString(sum[:4]) + "..."
+			if bytes.Equal(sum, want[:]) {
+				digest += " (matches)"
+			} else {
+				digest += " (MISMATCH)"
+				mismatches = append(mismatches, opts.finding(sevError, fmt.Sprintf("digest computed by %s doesn't match the body sent (checksum mismatch)", c.desc)))
+			}
+		}
+		fmt.Fprintf(opts.out, "  %-46s  %-13d  %-7v  %-28s  %-14s  %s\n", c.desc, contentLength, hasGetBody, framingHeaders(got.req), fmt.Sprintf("%d bytes", len(got.body)), digest)
+	}
+	for _, m := range mismatches {
+		fmt.Fprintln(opts.out, "=> "+m)
+	}
+	return first, nil
+}
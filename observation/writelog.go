@@ -0,0 +1,305 @@
+package observation
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// writeLog records every Write call made on connections of a Transport.
+type writeLog struct {
+	mu     sync.Mutex
+	start  time.Time
+	writes []connWrite
+}
+
+type connWrite struct {
+	size     int // size of the buffer passed to Write
+	n        int // bytes actually written
+	offset   int64
+	readFrom bool          // written via ReadFrom (e.g. sendfile) rather than Write
+	at       time.Duration // since the Transport was set up, when the call was made
+	blocked  time.Duration // time spent in the call, long if the receiver back-pressures
+	err      error
+
+	headBytes int   // bytes of the request line and headers in the write
+	chunks    []int // sizes of chunks whose chunk headers are in the write, 0 for the last chunk
+}
+
+// writeLoggingConn is a net.Conn recording its Write calls to log.
+type writeLoggingConn struct {
+	net.Conn
+	log     *writeLog
+	written int64
+	framing wireFraming
+}
+
+func (c *writeLoggingConn) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := c.Conn.Write(p)
+	blocked := time.Since(start)
+
+	c.log.mu.Lock()
+	defer c.log.mu.Unlock()
+	w := connWrite{size: len(p), n: n, offset: c.written, at: start.Sub(c.log.start), blocked: blocked, err: err}
+	w.headBytes, w.chunks = c.framing.feed(p[:n])
+	c.log.writes = append(c.log.writes, w)
+	c.written += int64(n)
+	return n, err
+}
+
+// ReadFrom delegates to the underlying connection so that optimizations like sendfile aren't disabled by wrapping.
+// The whole ReadFrom is recorded as a single write.
+func (c *writeLoggingConn) ReadFrom(r io.Reader) (int64, error) {
+	var (
+		n     int64
+		err   error
+		start = time.Now()
+	)
+	if rf, ok := c.Conn.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(struct{ io.Writer }{c.Conn}, r)
+	}
+
+	c.log.mu.Lock()
+	defer c.log.mu.Unlock()
+	c.log.writes = append(c.log.writes, connWrite{size: int(n), n: int(n), offset: c.written, readFrom: true, at: start.Sub(c.log.start), blocked: time.Since(start), err: err})
+	c.written += n
+	c.framing.skip(n)
+	return n, err
+}
+
+// wireFraming follows the framing of requests written to a connection, to tell what each write carries.
+// Bytes written via ReadFrom aren't seen, so it's only used to read bodies of Content-Length framing.
+type wireFraming struct {
+	head    []byte // of the request being written, until its end is found
+	inBody  bool
+	chunked bool
+	trailer bool   // in the trailer section after the last chunk
+	left    int64  // bytes left in the current chunk data and its CRLF, or in the body with Content-Length
+	sizeBuf []byte // partial chunk header
+}
+
+// feed follows p written to the connection, returning how many bytes of it are the request head,
+// and sizes of chunks whose chunk headers are in p.
+func (f *wireFraming) feed(p []byte) (headBytes int, chunks []int) {
+	for len(p) > 0 {
+		if !f.inBody {
+			prev := len(f.head)
+			f.head = append(f.head, p...)
+			i := bytes.Index(f.head, []byte("\r\n\r\n"))
+			if i < 0 {
+				return headBytes + len(p), chunks
+			}
+			used := i + 4 - prev
+			headBytes += used
+			p = p[used:]
+			f.startBody()
+			continue
+		}
+		if !f.chunked {
+			if int64(len(p)) <= f.left {
+				f.left -= int64(len(p))
+				if f.left == 0 {
+					f.inBody = false
+				}
+				return headBytes, chunks
+			}
+			p = p[f.left:]
+			f.inBody = false
+			continue
+		}
+		if f.left > 0 {
+			k := int64(len(p))
+			if k > f.left {
+				k = f.left
+			}
+			f.left -= k
+			p = p[k:]
+			continue
+		}
+		// reading a chunk header, or a line of the trailer section
+		i := bytes.Index(p, []byte("\n"))
+		if i < 0 {
+			f.sizeBuf = append(f.sizeBuf, p...)
+			return headBytes, chunks
+		}
+		line := string(append(f.sizeBuf, p[:i]...))
+		f.sizeBuf, p = f.sizeBuf[:0], p[i+1:]
+		if f.trailer {
+			if strings.TrimSpace(line) == "" {
+				f.inBody, f.trailer = false, false
+			}
+			continue
+		}
+		hex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		n, err := strconv.ParseInt(hex, 16, 64)
+		if err != nil {
+			// lost track of the framing: give up until the next request
+			f.inBody = false
+			return headBytes, chunks
+		}
+		chunks = append(chunks, int(n))
+		if n == 0 {
+			f.trailer = true
+			continue
+		}
+		f.left = n + 2
+	}
+	return headBytes, chunks
+}
+
+// startBody sets up reading the body of the request whose head has just been written.
+func (f *wireFraming) startBody() {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(f.head)))
+	f.head = nil
+	f.inBody = err == nil
+	if err != nil {
+		return
+	}
+	f.chunked = len(req.TransferEncoding) > 0 && req.TransferEncoding[0] == "chunked"
+	f.left = req.ContentLength
+	if f.left <= 0 && !f.chunked {
+		f.inBody = false
+	}
+}
+
+// skip follows n bytes of body written without being seen.
+func (f *wireFraming) skip(n int64) {
+	if f.inBody && !f.chunked {
+		if f.left -= n; f.left <= 0 {
+			f.inBody = false
+		}
+	}
+}
+
+// withWriteLogging returns a clone of tr whose connections record their Write calls to the returned log.
+func withWriteLogging(tr http.RoundTripper) (http.RoundTripper, *writeLog) {
+	log := &writeLog{start: time.Now()}
+	t := tr.(*http.Transport).Clone()
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &writeLoggingConn{Conn: conn, log: log}, nil
+	}
+	return t, log
+}
+
+func (l *writeLog) print(out io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	fmt.Fprintf(out, "Write calls on the connection (%d calls):\n", len(l.writes))
+	var blocked time.Duration
+	for i, w := range l.writes {
+		blocked += w.blocked
+		res := ""
+		if w.readFrom {
+			res = " via ReadFrom"
+		}
+		if w.err != nil {
+			res += fmt.Sprintf(" (wrote %d bytes: %v)", w.n, w.err)
+		}
+		fmt.Fprintf(out, "  #%-3d %7d bytes at offset %8d, at %12v, %12v in the call%s%s\n", i+1, w.size, w.offset, w.at, w.blocked, res, w.contents())
+	}
+	fmt.Fprintf(out, "Time spent in Write calls: %v in total\n", blocked)
+	l.printBatching(out)
+
+	fmt.Fprintln(out, "Write size histogram:")
+	sizes := make([]int, len(l.writes))
+	for i, w := range l.writes {
+		sizes[i] = w.size
+	}
+	printSizeHistogram(out, sizes, "  ")
+}
+
+// printSizeHistogram prints to out a histogram of sizes bucketed by powers of 2, each line indented by indent.
+func printSizeHistogram(out io.Writer, sizes []int, indent string) {
+	var (
+		buckets []int // upper bounds
+		counts  = make(map[int]int)
+	)
+	for _, size := range sizes {
+		ub := 1
+		for ub < size {
+			ub *= 2
+		}
+		if counts[ub] == 0 {
+			buckets = append(buckets, ub)
+		}
+		counts[ub]++
+	}
+	sort.Ints(buckets)
+
+	for _, ub := range buckets {
+		fmt.Fprintf(out, "%s<= %7d bytes: %3d %s\n", indent, ub, counts[ub], strings.Repeat("#", counts[ub]))
+	}
+}
+
+// contents describes what the write carries: the head and the body, and chunk headers of chunked bodies.
+func (w connWrite) contents() string {
+	var parts []string
+	switch {
+	case w.readFrom:
+	case w.headBytes == w.n:
+		parts = append(parts, "head")
+	case w.headBytes > 0:
+		parts = append(parts, fmt.Sprintf("head %d bytes + body %d bytes", w.headBytes, w.n-w.headBytes))
+	}
+	if len(w.chunks) > 0 {
+		sizes := make([]string, len(w.chunks))
+		for i, c := range w.chunks {
+			sizes[i] = strconv.Itoa(c)
+			if c == 0 {
+				sizes[i] = "last chunk"
+			}
+		}
+		parts = append(parts, "chunk headers of "+strings.Join(sizes, ", "))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return ": " + strings.Join(parts, ", ")
+}
+
+// printBatching summarizes how the bufio layer of the Transport batched the head with the body, and the chunk sizes it emitted.
+func (l *writeLog) printBatching(out io.Writer) {
+	var chunks []int
+	for _, w := range l.writes {
+		chunks = append(chunks, w.chunks...)
+	}
+	for i, w := range l.writes {
+		// skip to the write the head ends in
+		if w.headBytes == 0 || (w.headBytes == w.n && i+1 < len(l.writes) && l.writes[i+1].headBytes > 0) {
+			continue
+		}
+		switch {
+		case w.headBytes < w.n:
+			fmt.Fprintf(out, "Head batching: the head (%d bytes) was written together with the first %d bytes of the body in write #%d\n", w.headBytes, w.n-w.headBytes, i+1)
+		case i+1 < len(l.writes):
+			fmt.Fprintf(out, "Head batching: the head (%d bytes) was written on its own in write #%d, before the body\n", w.headBytes, i+1)
+		default:
+			fmt.Fprintf(out, "Head batching: the head (%d bytes) was the last write\n", w.headBytes)
+		}
+		break
+	}
+	if len(chunks) > 0 {
+		fmt.Fprintf(out, "Chunks emitted: %s\n", runLengths(chunks))
+	}
+}
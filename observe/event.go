@@ -0,0 +1,119 @@
+package observe
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Event is a lifecycle event of an observation run: one of *PatternStarted, *ConnAccepted, *HeaderParsed,
+// *ChunkReceived, *FaultInjected, *ResponseSent, *FindingReported and *PatternFinished. Use a type switch to tell them apart.
+// More event types may be added later, so switches should ignore unknown ones.
+type Event interface {
+	// Time returns when the event happened.
+	Time() time.Time
+	stamp(t time.Time)
+}
+
+// eventTime carries the time of an event, set by Observer.Emit.
+type eventTime struct {
+	at time.Time
+}
+
+func (e *eventTime) Time() time.Time { return e.at }
+
+func (e *eventTime) stamp(t time.Time) {
+	if e.at.IsZero() {
+		e.at = t
+	}
+}
+
+// PatternStarted is emitted before a request pattern is run.
+type PatternStarted struct {
+	eventTime
+	Pattern string
+	Run     int // 1-based index of the run, with -repeat
+}
+
+// PatternFinished is emitted after a request pattern has run.
+type PatternFinished struct {
+	eventTime
+	Pattern  string
+	Run      int
+	Duration time.Duration // total duration of the request, zero if unknown
+	Err      error
+}
+
+// ConnAccepted is emitted when the capture server accepts a connection.
+type ConnAccepted struct {
+	eventTime
+	RemoteAddr string
+}
+
+// HeaderParsed is emitted when the capture server has read the request line and headers of a request.
+type HeaderParsed struct {
+	eventTime
+	RemoteAddr    string
+	Method        string
+	RequestURI    string
+	Header        http.Header
+	ContentLength int64 // -1 if unknown (e.g. chunked)
+}
+
+// ChunkReceived is emitted for each piece of a request body read by the capture server, with chunked framing removed.
+type ChunkReceived struct {
+	eventTime
+	RemoteAddr string
+	Size       int
+	Total      int64 // body bytes read so far, including this chunk
+}
+
+// FaultInjected is emitted when a server injects a fault (e.g. an HTTP/2 RST_STREAM) into the conversation.
+type FaultInjected struct {
+	eventTime
+	RemoteAddr string
+	Fault      string
+}
+
+// ResponseSent is emitted when the capture server has written a response.
+type ResponseSent struct {
+	eventTime
+	RemoteAddr string
+	StatusCode int // 0 if unknown, e.g. when the response was sent before the body was read
+	KeepAlive  bool
+	Err        error // error writing the response, if any
+}
+
+// FindingReported is emitted when an analysis reports a finding.
+type FindingReported struct {
+	eventTime
+	Severity string // "info", "warn" or "error"
+	Message  string
+}
+
+// Observer delivers events to callbacks registered with OnEvent. The zero value is ready to use, and a nil *Observer drops all events.
+type Observer struct {
+	mu       sync.RWMutex
+	handlers []func(Event)
+}
+
+// OnEvent registers f to be called with every event emitted afterwards.
+// f may be called from multiple goroutines concurrently, and should return quickly as it blocks the emitter.
+func (o *Observer) OnEvent(f func(Event)) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.handlers = append(o.handlers, f)
+}
+
+// Emit stamps e with the current time and delivers it to all registered callbacks.
+func (o *Observer) Emit(e Event) {
+	if o == nil {
+		return
+	}
+	e.stamp(time.Now())
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	for _, f := range o.handlers {
+		f(e)
+	}
+}
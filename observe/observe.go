@@ -0,0 +1,41 @@
+// Package observe points HTTP clients of other programs at the observation tool running in explain mode (-explain net),
+// so that requests built by real application code can be analyzed instead of canned patterns.
+//
+//	tr := observe.Transport(http.DefaultTransport.(*http.Transport))
+//	cli := &http.Client{Transport: tr}
+//
+// Then run the program with the environment variable named by EnvVar set to the address the tool listens on.
+//
+// It also defines the lifecycle events of observation runs (see Event), delivered to callbacks registered with Observer.OnEvent.
+package observe
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+)
+
+// EnvVar is the name of the environment variable holding the address of the observer (e.g. 127.0.0.1:8080).
+const EnvVar = "HTTPCLI_OBSERVER_ADDR"
+
+// Transport returns a clone of base whose connections all go to the observer, if EnvVar is set. Otherwise it returns base as is.
+//
+// Requests are sent as they would be to the original host, including the Host header and the request-target.
+// For https URLs, the TLS handshake is skipped and the request is sent in plain HTTP/1.1,
+// so the observer sees what would have been sent inside TLS. Proxy settings of base are ignored.
+func Transport(base *http.Transport) *http.Transport {
+	addr := os.Getenv(EnvVar)
+	if addr == "" {
+		return base
+	}
+
+	tr := base.Clone()
+	dial := func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	tr.Proxy = nil
+	tr.DialContext = dial
+	tr.DialTLSContext = dial
+	return tr
+}